@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Herograme/LuaNova/luanova"
+)
+
+// runBuild implements `luanova build`. Each .lunv file is written as a
+// .lua (.luau for Luau) file and a .map source map, next to the source
+// or under the -o directory. Files found in a directory argument are
+// named relative to it, which is the module root imports resolve from.
+func runBuild(args []string) error {
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	targetName := flags.String("target", "lua54", "output dialect: lua51, lua54 or luau")
+	outDir := flags.String("o", "", "output directory")
+	noMap := flags.Bool("no-map", false, "do not write source maps")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	target, err := luanova.ParseTarget(*targetName)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("luanova build: no input files")
+	}
+
+	var failed bool
+	for _, arg := range flags.Args() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			if err := buildFile(".", filepath.ToSlash(filepath.Clean(arg)), *outDir, target, !*noMap); err != nil {
+				printError(err, ".")
+				failed = true
+			}
+			continue
+		}
+		err = fs.WalkDir(os.DirFS(arg), ".", func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.HasSuffix(name, ".lunv") {
+				return err
+			}
+			out := *outDir
+			if out == "" {
+				out = arg
+			}
+			if err := buildFile(arg, name, out, target, !*noMap); err != nil {
+				printError(err, arg)
+				failed = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if failed {
+		return errors.New("luanova build: failed")
+	}
+	return nil
+}
+
+// buildFile transpiles the file name under root and writes the output
+// under outDir, or next to the source when outDir is empty.
+func buildFile(root, name, outDir string, target luanova.Target, sourceMap bool) error {
+	src, err := os.ReadFile(filepath.Join(root, name))
+	if err != nil {
+		return err
+	}
+	chunk, err := luanova.Parse(name, string(src))
+	if err != nil {
+		return err
+	}
+	out, err := luanova.Transpile(chunk, target)
+	if err != nil {
+		return err
+	}
+
+	ext := ".lua"
+	if target == luanova.TargetLuau {
+		ext = ".luau"
+	}
+	dest := filepath.Join(root, strings.TrimSuffix(name, ".lunv")+ext)
+	if outDir != "" {
+		dest = filepath.Join(outDir, strings.TrimSuffix(name, ".lunv")+ext)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	code := out.Code
+	if sourceMap {
+		code += "--# sourceMappingURL=" + filepath.Base(dest) + ".map\n"
+	}
+	if err := os.WriteFile(dest, []byte(code), 0o644); err != nil {
+		return err
+	}
+	if !sourceMap {
+		return nil
+	}
+	rel, err := filepath.Rel(filepath.Dir(dest), filepath.Join(root, name))
+	if err != nil {
+		rel = name
+	}
+	m := out.SourceMap(filepath.Base(dest), filepath.ToSlash(rel))
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dest+".map", data, 0o644)
+}
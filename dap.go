@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/Herograme/LuaNova/luanova"
+)
+
+// runDap implements `luanova dap`: it serves the Debug Adapter Protocol
+// on standard input and output, or on the connections accepted at the
+// --listen address, one session at a time.
+func runDap(args []string) error {
+	flags := flag.NewFlagSet("dap", flag.ContinueOnError)
+	listen := flags.String("listen", "", "serve clients connecting to this `address` instead of standard input")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("dap: unexpected arguments %v", flags.Args())
+	}
+	if *listen == "" {
+		return luanova.NewDebugger(nil).Serve(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout})
+	}
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	fmt.Fprintf(os.Stderr, "luanova dap: listening on %s\n", l.Addr())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		err = luanova.NewDebugger(nil).Serve(conn)
+		conn.Close()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "luanova dap:", err)
+		}
+	}
+}
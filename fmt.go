@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Herograme/LuaNova/luanova"
+	"github.com/Herograme/LuaNova/luanova/diag"
+)
+
+// runFmt implements `luanova fmt`. Without files it formats standard
+// input to standard output. With --check it only lists the files that
+// are not formatted and fails if there are any.
+func runFmt(args []string) error {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	write := flags.Bool("w", false, "write the result to the source files")
+	list := flags.Bool("l", false, "list files whose formatting differs")
+	check := flags.Bool("check", false, "list unformatted files and fail if there are any")
+	lines := flags.String("range", "", "format only the statements on lines `from:to`")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	from, to := 0, 0
+	if *lines != "" {
+		a, b, ok := strings.Cut(*lines, ":")
+		var err1, err2 error
+		from, err1 = strconv.Atoi(a)
+		to, err2 = strconv.Atoi(b)
+		if !ok || err1 != nil || err2 != nil || from < 1 || to < from {
+			return fmt.Errorf("luanova fmt: invalid range %q", *lines)
+		}
+	}
+	format := func(name string, src []byte) ([]byte, error) {
+		if from == 0 {
+			return luanova.Format(name, src)
+		}
+		edit, err := luanova.FormatRange(name, src, from, to)
+		if err != nil {
+			return nil, err
+		}
+		return diag.ApplyEdits(src, []diag.TextEdit{edit}), nil
+	}
+
+	if flags.NArg() == 0 {
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		out, err := format("<stdin>", src)
+		if err != nil {
+			return err
+		}
+		if *check {
+			if !bytes.Equal(src, out) {
+				fmt.Println("<stdin>")
+				return errors.New("luanova fmt: input is not formatted")
+			}
+			return nil
+		}
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+
+	var failed, unformatted bool
+	file := func(path string) {
+		src, err := os.ReadFile(path)
+		if err == nil {
+			var out []byte
+			if out, err = format(filepath.ToSlash(path), src); err == nil {
+				switch {
+				case bytes.Equal(src, out):
+				case *check || *list:
+					fmt.Println(path)
+					unformatted = true
+					if *write {
+						err = os.WriteFile(path, out, 0o666)
+					}
+				case *write:
+					err = os.WriteFile(path, out, 0o666)
+				}
+				if !*check && !*list && !*write {
+					_, err = os.Stdout.Write(out)
+				}
+			}
+		}
+		if err != nil {
+			printError(err, ".")
+			failed = true
+		}
+	}
+	for _, arg := range flags.Args() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			file(arg)
+			continue
+		}
+		err = filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.HasSuffix(path, ".lunv") {
+				return err
+			}
+			file(path)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	switch {
+	case failed:
+		return errors.New("luanova fmt: failed")
+	case *check && unformatted:
+		return errors.New("luanova fmt: some files are not formatted")
+	}
+	return nil
+}
@@ -1,3 +1,5 @@
 module github.com/Herograme/LuaNova
 
 go 1.23.3
+
+require github.com/yuin/gopher-lua v1.1.1
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Herograme/LuaNova/luanova"
+	"github.com/Herograme/LuaNova/luanova/diag"
+)
+
+// runLint implements `luanova lint`. It reports the diagnostics of each
+// file, syntax errors included, and fails if there are any; with --fix
+// it first applies the fixes the rules offer and reports what is left.
+func runLint(args []string) error {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	fix := flags.Bool("fix", false, "apply the suggested fixes to the files")
+	list := flags.Bool("rules", false, "list the rules and exit")
+	format := flags.String("format", "auto", "output `format`: text, color, json or sarif; auto is color on a terminal")
+	config := &luanova.LintConfig{Severity: map[string]diag.Severity{}}
+	flags.Func("severity", "set the severity of a rule, as `rule=off|info|warning|error`", func(s string) error {
+		name, level, ok := strings.Cut(s, "=")
+		if !ok {
+			return errors.New("expected rule=severity")
+		}
+		sev, err := diag.ParseSeverity(level)
+		if err != nil {
+			return err
+		}
+		config.Severity[name] = sev
+		return nil
+	})
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *list {
+		for _, r := range luanova.LintRules() {
+			fmt.Printf("%-22s %-8s %s\n", r.Name, r.Severity, r.Doc)
+		}
+		return nil
+	}
+	switch *format {
+	case "auto":
+		*format = "text"
+		if isTerminal(os.Stdout) {
+			*format = "color"
+		}
+	case "text", "color", "json", "sarif":
+	default:
+		return fmt.Errorf("luanova lint: unknown format %q", *format)
+	}
+	if flags.NArg() == 0 {
+		return errors.New("luanova lint: no input files")
+	}
+
+	var diags []diag.Diagnostic
+	sources := map[string][]byte{}
+	file := func(path string) error {
+		src, ds, err := lintFile(path, config, *fix)
+		if err != nil {
+			return err
+		}
+		sources[filepath.ToSlash(path)] = src
+		diags = append(diags, ds...)
+		return nil
+	}
+	for _, arg := range flags.Args() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			if err := file(arg); err != nil {
+				return err
+			}
+			continue
+		}
+		err = filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.HasSuffix(path, ".lunv") {
+				return err
+			}
+			return file(path)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	switch *format {
+	case "json":
+		err = diag.WriteJSON(os.Stdout, diags)
+	case "sarif":
+		tool := diag.Tool{Name: "luanova lint", Rules: []diag.Rule{{ID: "syntax", Description: "Syntax errors.", Severity: diag.SeverityError}}}
+		for _, r := range luanova.LintRules() {
+			tool.Rules = append(tool.Rules, diag.Rule{ID: r.Name, Description: r.Doc, Severity: r.Severity})
+		}
+		err = diag.WriteSARIF(os.Stdout, tool, diags)
+	default:
+		p := &diag.Printer{Color: *format == "color", Source: func(name string) []byte { return sources[name] }}
+		err = p.FprintAll(os.Stdout, diags)
+	}
+	if err != nil {
+		return err
+	}
+	switch len(diags) {
+	case 0:
+		return nil
+	case 1:
+		return errors.New("luanova lint: 1 problem found")
+	}
+	return fmt.Errorf("luanova lint: %d problems found", len(diags))
+}
+
+// lintFile lints the file at path and returns its source and
+// diagnostics, which are its syntax errors if it does not parse. With
+// fix it writes the fixed source back and lints the result.
+func lintFile(path string, config *luanova.LintConfig, fix bool) ([]byte, []diag.Diagnostic, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	name := filepath.ToSlash(path)
+	for {
+		chunk, err := luanova.Parse(name, string(src))
+		var list luanova.ErrorList
+		if errors.As(err, &list) {
+			diags := list.Diagnostics()
+			for i := range diags {
+				diags[i].Code = "syntax"
+			}
+			return src, diags, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+		diags := luanova.Lint(chunk, config)
+		edits := diag.FixAll(diags)
+		if !fix || len(edits) == 0 {
+			return src, diags, nil
+		}
+		src = diag.ApplyEdits(src, edits)
+		if err := os.WriteFile(path, src, 0o666); err != nil {
+			return nil, nil, err
+		}
+		// fixes are applied once; the next lint reports what is left
+		fix = false
+	}
+}
@@ -0,0 +1,403 @@
+package luanova
+
+import (
+	"fmt"
+	"math"
+)
+
+// maxTagLoop bounds __index and __newindex chains.
+const maxTagLoop = 2000
+
+var arithEvents = map[int]string{
+	Plus:  "__add",
+	Sub:   "__sub",
+	Multi: "__mul",
+	Div:   "__div",
+	Mod:   "__mod",
+	Po:    "__pow",
+}
+
+// varInfo formats the description of a variable for error messages.
+func varInfo(desc string) string {
+	if desc == "" {
+		return ""
+	}
+	return " (" + desc + ")"
+}
+
+func (s *State) metatable(v any) *Table {
+	switch v := v.(type) {
+	case *Table:
+		return v.meta
+	case *Userdata:
+		return v.Meta
+	case string:
+		return s.stringMeta
+	}
+	return nil
+}
+
+func (s *State) metaField(v any, event string) any {
+	if mt := s.metatable(v); mt != nil {
+		return mt.Get(event)
+	}
+	return nil
+}
+
+// first returns the first of a list of results.
+func first(vs []any) any {
+	if len(vs) == 0 {
+		return nil
+	}
+	return vs[0]
+}
+
+// index returns v[k], following __index.
+func (s *State) index(v, k any, desc string) any {
+	for loop := 0; loop < maxTagLoop; loop++ {
+		var h any
+		if t, ok := v.(*Table); ok {
+			if r := t.Get(k); r != nil || t.meta == nil {
+				return r
+			}
+			if h = t.meta.Get("__index"); h == nil {
+				return nil
+			}
+		} else if h = s.metaField(v, "__index"); h == nil {
+			s.runtimeError("attempt to index a %s value%s", luaTypeName(v), varInfo(desc))
+		}
+		if _, ok := h.(*Closure); ok {
+			return first(s.call(h, []any{v, k}))
+		}
+		v, desc = h, ""
+	}
+	s.runtimeError("'__index' chain too long; possibly a loop")
+	return nil
+}
+
+// setIndex assigns v[k] = val, following __newindex.
+func (s *State) setIndex(v, k, val any, desc string) {
+	for loop := 0; loop < maxTagLoop; loop++ {
+		var h any
+		if t, ok := v.(*Table); ok {
+			if t.meta == nil || t.Get(k) != nil {
+				s.rawSet(t, k, val)
+				return
+			}
+			if h = t.meta.Get("__newindex"); h == nil {
+				s.rawSet(t, k, val)
+				return
+			}
+		} else if h = s.metaField(v, "__newindex"); h == nil {
+			s.runtimeError("attempt to index a %s value%s", luaTypeName(v), varInfo(desc))
+		}
+		if _, ok := h.(*Closure); ok {
+			s.call(h, []any{v, k, val})
+			return
+		}
+		v, desc = h, ""
+	}
+	s.runtimeError("'__newindex' chain too long; possibly a loop")
+}
+
+func (s *State) rawSet(t *Table, k, v any) {
+	switch {
+	case k == nil:
+		s.runtimeError("table index is nil")
+	case isNaN(k):
+		s.runtimeError("table index is NaN")
+	}
+	s.tableSet(t, k, v)
+}
+
+// arith performs a binary arithmetic operation. da and db describe the
+// operands for error messages.
+func (s *State) arith(op int, a, b any, da, db string) any {
+	if x, ok := toNumber(a); ok {
+		if y, ok := toNumber(b); ok {
+			return s.arithNumbers(op, x, y)
+		}
+	}
+	if h := s.metaField(a, arithEvents[op]); h != nil {
+		return first(s.call(h, []any{a, b}))
+	}
+	if h := s.metaField(b, arithEvents[op]); h != nil {
+		return first(s.call(h, []any{a, b}))
+	}
+	bad, desc := b, db
+	if _, ok := toNumber(a); !ok {
+		bad, desc = a, da
+	}
+	s.runtimeError("attempt to perform arithmetic on a %s value%s", luaTypeName(bad), varInfo(desc))
+	return nil
+}
+
+func (s *State) arithNumbers(op int, a, b any) any {
+	x, xInt := a.(int64)
+	y, yInt := b.(int64)
+	if xInt && yInt {
+		switch op {
+		case Plus:
+			return x + y
+		case Sub:
+			return x - y
+		case Multi:
+			return x * y
+		case Mod:
+			if y == 0 {
+				s.runtimeError("attempt to perform 'n%%%%0'")
+			}
+			return intMod(x, y)
+		}
+	}
+	fx, _ := toFloat(a)
+	fy, _ := toFloat(b)
+	switch op {
+	case Plus:
+		return fx + fy
+	case Sub:
+		return fx - fy
+	case Multi:
+		return fx * fy
+	case Div:
+		return fx / fy
+	case Mod:
+		return floatMod(fx, fy)
+	case Po:
+		return math.Pow(fx, fy)
+	}
+	panic(fmt.Sprintf("luanova: bad arithmetic operator %d", op))
+}
+
+func intMod(a, b int64) int64 {
+	if b == -1 {
+		return 0 // avoids overflow of MinInt64 % -1
+	}
+	m := a % b
+	if m != 0 && (m^b) < 0 {
+		m += b
+	}
+	return m
+}
+
+func floatMod(a, b float64) float64 {
+	if math.IsInf(b, 0) && !math.IsNaN(a) && !math.IsInf(a, 0) {
+		if (a >= 0) == (b > 0) {
+			return a
+		}
+		return b
+	}
+	m := math.Mod(a, b)
+	if m != 0 && (m > 0) != (b > 0) {
+		m += b
+	}
+	return m
+}
+
+func (s *State) unm(v any, desc string) any {
+	switch n := v.(type) {
+	case int64:
+		return -n
+	case float64:
+		return -n
+	}
+	if n, ok := toNumber(v); ok {
+		return s.unm(n, desc)
+	}
+	if h := s.metaField(v, "__unm"); h != nil {
+		return first(s.call(h, []any{v, v}))
+	}
+	s.runtimeError("attempt to perform arithmetic on a %s value%s", luaTypeName(v), varInfo(desc))
+	return nil
+}
+
+func (s *State) length(v any, desc string) any {
+	switch v := v.(type) {
+	case string:
+		return int64(len(v))
+	case *Table:
+		if v.meta == nil {
+			return v.Len()
+		}
+	}
+	if h := s.metaField(v, "__len"); h != nil {
+		return first(s.call(h, []any{v}))
+	}
+	if t, ok := v.(*Table); ok {
+		return t.Len()
+	}
+	s.runtimeError("attempt to get length of a %s value%s", luaTypeName(v), varInfo(desc))
+	return nil
+}
+
+func (s *State) concat(a, b any, da, db string) any {
+	if x, ok := toStringCoerce(a); ok {
+		if y, ok := toStringCoerce(b); ok {
+			s.allocString(len(x) + len(y))
+			return x + y
+		}
+	}
+	h := s.metaField(a, "__concat")
+	if h == nil {
+		h = s.metaField(b, "__concat")
+	}
+	if h != nil {
+		return first(s.call(h, []any{a, b}))
+	}
+	bad, desc := a, da
+	if _, ok := toStringCoerce(a); ok {
+		bad, desc = b, db
+	}
+	s.runtimeError("attempt to concatenate a %s value%s", luaTypeName(bad), varInfo(desc))
+	return nil
+}
+
+func (s *State) equal(a, b any) bool {
+	if rawEqual(a, b) {
+		return true
+	}
+	switch a.(type) {
+	case *Table:
+		if _, ok := b.(*Table); !ok {
+			return false
+		}
+	case *Userdata:
+		if _, ok := b.(*Userdata); !ok {
+			return false
+		}
+	default:
+		return false
+	}
+	h := s.metaField(a, "__eq")
+	if h == nil {
+		h = s.metaField(b, "__eq")
+	}
+	if h == nil {
+		return false
+	}
+	return truthy(first(s.call(h, []any{a, b})))
+}
+
+func (s *State) lessThan(a, b any) bool {
+	if r, ok := compareNumbers(a, b); ok {
+		return r < 0
+	}
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			return x < y
+		}
+	}
+	return s.compareMeta(a, b, "__lt")
+}
+
+func (s *State) lessEqual(a, b any) bool {
+	if r, ok := compareNumbers(a, b); ok {
+		return r <= 0
+	}
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			return x <= y
+		}
+	}
+	return s.compareMeta(a, b, "__le")
+}
+
+func (s *State) compareMeta(a, b any, event string) bool {
+	h := s.metaField(a, event)
+	if h == nil {
+		h = s.metaField(b, event)
+	}
+	if h == nil {
+		ta, tb := luaTypeName(a), luaTypeName(b)
+		if ta == tb {
+			s.runtimeError("attempt to compare two %s values", ta)
+		}
+		s.runtimeError("attempt to compare %s with %s", ta, tb)
+	}
+	return truthy(first(s.call(h, []any{a, b})))
+}
+
+// compareNumbers returns -1, 0 or 1, or 2 when a NaN is involved; ok is
+// false if a or b is not a number.
+func compareNumbers(a, b any) (int, bool) {
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return cmp3(x < y, x == y), true
+		case float64:
+			return compareIntFloat(x, y), true
+		}
+	case float64:
+		switch y := b.(type) {
+		case float64:
+			if math.IsNaN(x) || math.IsNaN(y) {
+				return 2, true
+			}
+			return cmp3(x < y, x == y), true
+		case int64:
+			r := compareIntFloat(y, x)
+			if r == 2 {
+				return 2, true
+			}
+			return -r, true
+		}
+	}
+	return 0, false
+}
+
+func cmp3(less, equal bool) int {
+	switch {
+	case less:
+		return -1
+	case equal:
+		return 0
+	}
+	return 1
+}
+
+// compareIntFloat compares exactly, without rounding i to a float.
+func compareIntFloat(i int64, f float64) int {
+	switch {
+	case math.IsNaN(f):
+		return 2
+	case f >= 0x1p63:
+		return -1
+	case f < -0x1p63:
+		return 1
+	}
+	t := math.Floor(f)
+	fi := int64(t)
+	if i != fi {
+		return cmp3(i < fi, false)
+	}
+	if t != f {
+		return -1 // f has a fraction above fi
+	}
+	return 0
+}
+
+// tostring converts v to a string, honouring __tostring and __name.
+func (s *State) tostring(v any) string {
+	if h := s.metaField(v, "__tostring"); h != nil {
+		r := first(s.call(h, []any{v}))
+		str, ok := r.(string)
+		if !ok {
+			if _, isNum := r.(int64); !isNum {
+				if _, isNum := r.(float64); !isNum {
+					s.runtimeError("'__tostring' must return a string")
+				}
+			}
+			str, _ = toStringCoerce(r)
+		}
+		return str
+	}
+	if mt := s.metatable(v); mt != nil {
+		if name, ok := mt.Get("__name").(string); ok {
+			if _, isStr := v.(string); !isStr {
+				return fmt.Sprintf("%s: 0x%x", name, pointerOf(v))
+			}
+		}
+	}
+	return tostringBasic(v)
+}
@@ -0,0 +1,525 @@
+package luanova
+
+import "reflect"
+
+// Node is implemented by every node of the syntax tree.
+type Node interface {
+	Pos() Position // first character of the node
+	End() Position // first character after the node
+}
+
+// Stmt is a statement node.
+type Stmt interface {
+	Node
+	stmtNode()
+}
+
+// Expr is an expression node.
+type Expr interface {
+	Node
+	exprNode()
+}
+
+// TypeExpr is a type annotation. Annotations are checked by tools only;
+// the runtime ignores them.
+type TypeExpr interface {
+	Node
+	typeNode()
+}
+
+// Span is embedded in nodes to record their extent.
+type Span struct {
+	Start Position
+	Stop  Position
+}
+
+func (s Span) Pos() Position { return s.Start }
+func (s Span) End() Position { return s.Stop }
+
+// Chunk is a parsed source file.
+type Chunk struct {
+	Name     string
+	Block    *Block
+	Comments []Token
+}
+
+type Block struct {
+	Span
+	Stmts []Stmt
+}
+
+type Ident struct {
+	Span
+	Name string
+}
+
+// Binding is a name introduced by local, a parameter or a for loop,
+// with its optional type annotation.
+type Binding struct {
+	Span
+	Name *Ident
+	Type TypeExpr
+}
+
+// Statements
+
+type LocalStmt struct {
+	Span
+	Names  []*Binding
+	Values []Expr
+}
+
+type LocalFunctionStmt struct {
+	Span
+	Name *Ident
+	Func *FunctionExpr
+}
+
+// FunctionStmt is `function a.b.c:m() ... end`. Name is an *Ident or a
+// chain of *FieldExpr; Method is set for the colon form.
+type FunctionStmt struct {
+	Span
+	Name   Expr
+	Method *Ident
+	Func   *FunctionExpr
+}
+
+type AssignStmt struct {
+	Span
+	Targets []Expr
+	Values  []Expr
+}
+
+// CompoundAssignStmt is `x += e` and friends. Op is the binary operator
+// token (Plus, Sub, Multi, Div, Mod or Concat).
+type CompoundAssignStmt struct {
+	Span
+	Op     int
+	Target Expr
+	Value  Expr
+}
+
+type CallStmt struct {
+	Span
+	Call Expr
+}
+
+type DoStmt struct {
+	Span
+	Body *Block
+}
+
+type WhileStmt struct {
+	Span
+	Cond Expr
+	Body *Block
+}
+
+type RepeatStmt struct {
+	Span
+	Body *Block
+	Cond Expr
+}
+
+// IfStmt holds the `if` clause followed by any `elseif` clauses.
+type IfStmt struct {
+	Span
+	Clauses []*IfClause
+	Else    *Block
+}
+
+type IfClause struct {
+	Span
+	Cond Expr
+	Body *Block
+}
+
+type NumericForStmt struct {
+	Span
+	Var   *Binding
+	Start Expr
+	Limit Expr
+	Step  Expr
+	Body  *Block
+}
+
+type GenericForStmt struct {
+	Span
+	Names []*Binding
+	Exprs []Expr
+	Body  *Block
+}
+
+type ReturnStmt struct {
+	Span
+	Values []Expr
+}
+
+type BreakStmt struct {
+	Span
+}
+
+type ContinueStmt struct {
+	Span
+}
+
+// TypeStmt is `type Name = T`.
+type TypeStmt struct {
+	Span
+	Name *Ident
+	Type TypeExpr
+}
+
+// ImportStmt is `import { a, b as c } from "path"` or, when Namespace
+// is set, `import * as m from "path"`.
+type ImportStmt struct {
+	Span
+	Names     []*ImportSpec
+	Namespace *Ident
+	Path      *StringExpr
+}
+
+// ImportSpec is `name` or `name as alias` in an import or export list.
+type ImportSpec struct {
+	Span
+	Name  *Ident
+	Alias *Ident // nil when the name is kept
+}
+
+// LocalName returns the name the spec binds.
+func (s *ImportSpec) LocalName() *Ident {
+	if s.Alias != nil {
+		return s.Alias
+	}
+	return s.Name
+}
+
+// ExportStmt is `export` before a local, local function (written
+// `export function f`) or type declaration, or `export { a, b as c }`
+// for locals declared earlier. Decl is nil for the list form.
+type ExportStmt struct {
+	Span
+	Decl  Stmt
+	Names []*ImportSpec
+}
+
+// Expressions
+
+type NilExpr struct {
+	Span
+}
+
+type BoolExpr struct {
+	Span
+	Value bool
+}
+
+// NumberExpr holds an int64 or float64 Value.
+type NumberExpr struct {
+	Span
+	Raw   string
+	Value any
+}
+
+// StringExpr holds the decoded Value and the Raw text between quotes.
+type StringExpr struct {
+	Span
+	Raw   string
+	Value string
+}
+
+type VarargExpr struct {
+	Span
+}
+
+type FunctionExpr struct {
+	Span
+	Name       string // name used in stack traces, if known
+	Params     []*Binding
+	IsVararg   bool
+	VarargType TypeExpr // type of `...: T`
+	ReturnType TypeExpr
+	Body       *Block
+}
+
+type TableExpr struct {
+	Span
+	Fields []*TableField
+}
+
+// TableField is one entry of a table constructor: positional (Key is
+// nil), named (`name = v`, Key is an *Ident) or keyed (`[k] = v`).
+type TableField struct {
+	Span
+	Key   Expr
+	Named bool
+	Value Expr
+}
+
+type BinaryExpr struct {
+	Span
+	Op    int
+	OpPos Position
+	Left  Expr
+	Right Expr
+}
+
+type UnaryExpr struct {
+	Span
+	Op int
+	X  Expr
+}
+
+type ParenExpr struct {
+	Span
+	X Expr
+}
+
+// FieldExpr is `x.name`.
+type FieldExpr struct {
+	Span
+	X    Expr
+	Name *Ident
+}
+
+// IndexExpr is `x[key]`.
+type IndexExpr struct {
+	Span
+	X   Expr
+	Key Expr
+}
+
+type CallExpr struct {
+	Span
+	Fn   Expr
+	Args []Expr
+}
+
+type MethodCallExpr struct {
+	Span
+	Recv Expr
+	Name *Ident
+	Args []Expr
+}
+
+// Types
+
+// NamedType is `number`, `mod.Type` or `Array<T>`.
+type NamedType struct {
+	Span
+	Name string
+	Args []TypeExpr
+}
+
+type OptionalType struct {
+	Span
+	Inner TypeExpr
+}
+
+// FunctionType is `(A, B) -> R`; Variadic is set when the last
+// parameter is written `...T`.
+type FunctionType struct {
+	Span
+	Params   []TypeExpr
+	Variadic bool
+	Return   TypeExpr
+}
+
+type TupleType struct {
+	Span
+	Types    []TypeExpr
+	Variadic bool // the last type is written `...T`
+}
+
+// TableType is `{x: number}`, `{number}` or `{[string]: number}`.
+type TableType struct {
+	Span
+	Fields []*TableTypeField
+}
+
+type TableTypeField struct {
+	Span
+	Name  *Ident   // named field
+	Key   TypeExpr // indexer key; both Name and Key are nil for arrays
+	Value TypeExpr
+}
+
+func (*LocalStmt) stmtNode()          {}
+func (*LocalFunctionStmt) stmtNode()  {}
+func (*FunctionStmt) stmtNode()       {}
+func (*AssignStmt) stmtNode()         {}
+func (*CompoundAssignStmt) stmtNode() {}
+func (*CallStmt) stmtNode()           {}
+func (*DoStmt) stmtNode()             {}
+func (*WhileStmt) stmtNode()          {}
+func (*RepeatStmt) stmtNode()         {}
+func (*IfStmt) stmtNode()             {}
+func (*NumericForStmt) stmtNode()     {}
+func (*GenericForStmt) stmtNode()     {}
+func (*ReturnStmt) stmtNode()         {}
+func (*BreakStmt) stmtNode()          {}
+func (*ContinueStmt) stmtNode()       {}
+func (*TypeStmt) stmtNode()           {}
+func (*ImportStmt) stmtNode()         {}
+func (*ExportStmt) stmtNode()         {}
+
+func (*NilExpr) exprNode()        {}
+func (*BoolExpr) exprNode()       {}
+func (*NumberExpr) exprNode()     {}
+func (*StringExpr) exprNode()     {}
+func (*VarargExpr) exprNode()     {}
+func (*FunctionExpr) exprNode()   {}
+func (*TableExpr) exprNode()      {}
+func (*BinaryExpr) exprNode()     {}
+func (*UnaryExpr) exprNode()      {}
+func (*ParenExpr) exprNode()      {}
+func (*Ident) exprNode()          {}
+func (*FieldExpr) exprNode()      {}
+func (*IndexExpr) exprNode()      {}
+func (*CallExpr) exprNode()       {}
+func (*MethodCallExpr) exprNode() {}
+
+func (*NamedType) typeNode()    {}
+func (*OptionalType) typeNode() {}
+func (*FunctionType) typeNode() {}
+func (*TupleType) typeNode()    {}
+func (*TableType) typeNode()    {}
+
+// Inspect traverses the tree rooted at node in source order, calling f
+// for each node. If f returns false, the children of that node are
+// skipped. Types are visited too; Chunk is not a Node, pass its Block.
+func Inspect(node Node, f func(Node) bool) {
+	if isNilNode(node) || !f(node) {
+		return
+	}
+	visit := func(nodes ...Node) {
+		for _, n := range nodes {
+			Inspect(n, f)
+		}
+	}
+	switch n := node.(type) {
+	case *Block:
+		for _, s := range n.Stmts {
+			Inspect(s, f)
+		}
+	case *Binding:
+		visit(n.Name, n.Type)
+	case *LocalStmt:
+		for _, b := range n.Names {
+			Inspect(b, f)
+		}
+		visitExprs(n.Values, f)
+	case *LocalFunctionStmt:
+		visit(n.Name, n.Func)
+	case *FunctionStmt:
+		visit(n.Name, n.Method, n.Func)
+	case *AssignStmt:
+		visitExprs(n.Targets, f)
+		visitExprs(n.Values, f)
+	case *CompoundAssignStmt:
+		visit(n.Target, n.Value)
+	case *CallStmt:
+		visit(n.Call)
+	case *DoStmt:
+		visit(n.Body)
+	case *WhileStmt:
+		visit(n.Cond, n.Body)
+	case *RepeatStmt:
+		visit(n.Body, n.Cond)
+	case *IfStmt:
+		for _, c := range n.Clauses {
+			Inspect(c, f)
+		}
+		visit(n.Else)
+	case *IfClause:
+		visit(n.Cond, n.Body)
+	case *NumericForStmt:
+		visit(n.Var, n.Start, n.Limit, n.Step, n.Body)
+	case *GenericForStmt:
+		for _, b := range n.Names {
+			Inspect(b, f)
+		}
+		visitExprs(n.Exprs, f)
+		visit(n.Body)
+	case *ReturnStmt:
+		visitExprs(n.Values, f)
+	case *TypeStmt:
+		visit(n.Name, n.Type)
+	case *ImportStmt:
+		for _, s := range n.Names {
+			Inspect(s, f)
+		}
+		visit(n.Namespace, n.Path)
+	case *ImportSpec:
+		visit(n.Name, n.Alias)
+	case *ExportStmt:
+		visit(n.Decl)
+		for _, s := range n.Names {
+			Inspect(s, f)
+		}
+	case *FunctionExpr:
+		for _, b := range n.Params {
+			Inspect(b, f)
+		}
+		visit(n.VarargType, n.ReturnType, n.Body)
+	case *TableExpr:
+		for _, field := range n.Fields {
+			Inspect(field, f)
+		}
+	case *TableField:
+		visit(n.Key, n.Value)
+	case *BinaryExpr:
+		visit(n.Left, n.Right)
+	case *UnaryExpr:
+		visit(n.X)
+	case *ParenExpr:
+		visit(n.X)
+	case *FieldExpr:
+		visit(n.X, n.Name)
+	case *IndexExpr:
+		visit(n.X, n.Key)
+	case *CallExpr:
+		visit(n.Fn)
+		visitExprs(n.Args, f)
+	case *MethodCallExpr:
+		visit(n.Recv, n.Name)
+		visitExprs(n.Args, f)
+	case *NamedType:
+		for _, a := range n.Args {
+			Inspect(a, f)
+		}
+	case *OptionalType:
+		visit(n.Inner)
+	case *FunctionType:
+		for _, p := range n.Params {
+			Inspect(p, f)
+		}
+		visit(n.Return)
+	case *TupleType:
+		for _, t := range n.Types {
+			Inspect(t, f)
+		}
+	case *TableType:
+		for _, field := range n.Fields {
+			Inspect(field, f)
+		}
+	case *TableTypeField:
+		visit(n.Name, n.Key, n.Value)
+	}
+}
+
+func visitExprs(exprs []Expr, f func(Node) bool) {
+	for _, e := range exprs {
+		Inspect(e, f)
+	}
+}
+
+// isNilNode reports whether n is nil or a typed nil pointer, as found in
+// optional fields such as FunctionStmt.Method.
+func isNilNode(n Node) bool {
+	if n == nil {
+		return true
+	}
+	v := reflect.ValueOf(n)
+	return v.Kind() == reflect.Pointer && v.IsNil()
+}
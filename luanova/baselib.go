@@ -0,0 +1,337 @@
+package luanova
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func openBase(s *State) {
+	g := s.globals
+	setFuncs(g, "", map[string]GoFunction{
+		"assert":       baseAssert,
+		"error":        baseError,
+		"getmetatable": baseGetmetatable,
+		"ipairs":       baseIpairs,
+		"load":         baseLoad,
+		"pairs":        basePairs,
+		"pcall":        basePcall,
+		"print":        basePrint,
+		"rawequal":     baseRawequal,
+		"rawget":       baseRawget,
+		"rawlen":       baseRawlen,
+		"rawset":       baseRawset,
+		"select":       baseSelect,
+		"setmetatable": baseSetmetatable,
+		"tonumber":     baseTonumber,
+		"tostring":     baseTostring,
+		"type":         baseType,
+		"xpcall":       baseXpcall,
+	})
+	g.Set("next", s.globalNext())
+	g.Set("_G", g)
+	g.Set("_VERSION", "Lua 5.4")
+}
+
+func baseAssert(s *State) int {
+	if truthy(s.CheckAny(1)) {
+		return s.Top()
+	}
+	if s.Top() < 2 {
+		s.RaiseError("assertion failed!")
+	}
+	panic(&Error{Value: s.Get(2), Stack: s.stackTrace(0)})
+}
+
+func baseError(s *State) int {
+	v := s.Get(1)
+	cause := s.caughtCause(v)
+	level := s.OptInteger(2, 1)
+	if msg, ok := v.(string); ok && level > 0 {
+		v = s.where(int(level)) + msg
+	}
+	panic(&Error{Value: v, Stack: s.stackTrace(0), Cause: cause})
+}
+
+func baseGetmetatable(s *State) int {
+	mt := s.metatable(s.CheckAny(1))
+	if mt == nil {
+		s.Push(nil)
+		return 1
+	}
+	if protected := mt.Get("__metatable"); protected != nil {
+		s.Push(protected)
+		return 1
+	}
+	s.Push(mt)
+	return 1
+}
+
+func baseSetmetatable(s *State) int {
+	t := s.CheckTable(1)
+	mt, ok := s.Get(2).(*Table)
+	if !ok && s.Get(2) != nil {
+		s.TypeError(2, "nil or table")
+	}
+	if t.meta != nil && t.meta.Get("__metatable") != nil {
+		s.RaiseError("cannot change a protected metatable")
+	}
+	if ok {
+		t.meta = mt
+	} else {
+		t.meta = nil
+	}
+	s.SetTop(1)
+	return 1
+}
+
+func baseIpairs(s *State) int {
+	v := s.CheckAny(1)
+	s.Push(NewFunction("ipairs_iter", func(s *State) int {
+		i := s.CheckInteger(2) + 1
+		var v any
+		if t, ok := s.Get(1).(*Table); ok && t.meta == nil {
+			v = t.Get(i)
+		} else {
+			v = s.index(s.Get(1), i, "")
+		}
+		if v == nil {
+			s.Push(nil)
+			return 1
+		}
+		s.Push(i, v)
+		return 2
+	}), v, int64(0))
+	return 3
+}
+
+func baseNext(s *State) int {
+	t := s.CheckTable(1)
+	k, v, ok := t.Next(s.Get(2))
+	if !ok {
+		s.RaiseError("invalid key to 'next'")
+	}
+	if k == nil {
+		s.Push(nil)
+		return 1
+	}
+	s.Push(k, v)
+	return 2
+}
+
+func basePairs(s *State) int {
+	v := s.CheckAny(1)
+	if h := s.metaField(v, "__pairs"); h != nil {
+		rs := s.call(h, []any{v})
+		for i := 0; i < 3; i++ {
+			if i < len(rs) {
+				s.Push(rs[i])
+			} else {
+				s.Push(nil)
+			}
+		}
+		return 3
+	}
+	if _, ok := v.(*Table); !ok {
+		s.TypeError(1, "table")
+	}
+	s.Push(s.globalNext(), v, nil)
+	return 3
+}
+
+// globalNext returns the next function without looking it up in _G, so
+// pairs keeps working when a script overwrites next.
+func (s *State) globalNext() *Closure {
+	if s.next == nil {
+		s.next = NewFunction("next", baseNext)
+	}
+	return s.next
+}
+
+func baseLoad(s *State) int {
+	var src string
+	switch chunk := s.Get(1).(type) {
+	case string:
+		src = chunk
+	case *Closure:
+		var b strings.Builder
+		for {
+			piece := first(s.call(chunk, nil))
+			if piece == nil {
+				break
+			}
+			str, ok := piece.(string)
+			if !ok {
+				s.Push(nil, "reader function must return a string")
+				return 2
+			}
+			if str == "" {
+				break
+			}
+			b.WriteString(str)
+		}
+		src = b.String()
+	default:
+		s.TypeError(1, "string")
+	}
+	name := s.OptString(2, chunkName(src))
+	fn, err := s.Load(src, name)
+	if err != nil {
+		s.Push(nil, err.Error())
+		return 2
+	}
+	if env, ok := s.Get(4).(*Table); ok {
+		fn.env = env
+	}
+	s.Push(fn)
+	return 1
+}
+
+func basePcall(s *State) int {
+	f := s.CheckAny(1)
+	args := s.args(2)
+	var rs []any
+	if err := s.protect(func() { rs = s.call(f, args) }, nil); err != nil {
+		s.Push(false, s.errorValue(err))
+		return 2
+	}
+	s.Push(true)
+	s.Push(rs...)
+	return len(rs) + 1
+}
+
+func baseXpcall(s *State) int {
+	f := s.CheckAny(1)
+	handler := s.CheckAny(2)
+	args := s.args(3)
+	var rs []any
+	var value any
+	err := s.protect(func() { rs = s.call(f, args) }, func(e *Error) {
+		value = first(s.call(handler, []any{s.errorValue(e)}))
+	})
+	if err != nil {
+		if err.Value == "error in error handling" {
+			value = err.Value
+		}
+		s.Push(false, value)
+		return 2
+	}
+	s.Push(true)
+	s.Push(rs...)
+	return len(rs) + 1
+}
+
+func basePrint(s *State) int {
+	var b strings.Builder
+	for i := 1; i <= s.Top(); i++ {
+		if i > 1 {
+			b.WriteByte('\t')
+		}
+		b.WriteString(s.tostring(s.Get(i)))
+	}
+	b.WriteByte('\n')
+	fmt.Fprint(s.Stdout, b.String())
+	return 0
+}
+
+func baseRawequal(s *State) int {
+	s.Push(rawEqual(s.CheckAny(1), s.CheckAny(2)))
+	return 1
+}
+
+func baseRawget(s *State) int {
+	t := s.CheckTable(1)
+	s.Push(t.Get(s.CheckAny(2)))
+	return 1
+}
+
+func baseRawlen(s *State) int {
+	switch v := s.Get(1).(type) {
+	case *Table:
+		s.Push(v.Len())
+	case string:
+		s.Push(int64(len(v)))
+	default:
+		s.ArgError(1, "table or string expected")
+	}
+	return 1
+}
+
+func baseRawset(s *State) int {
+	t := s.CheckTable(1)
+	s.CheckAny(2)
+	s.CheckAny(3)
+	s.rawSet(t, s.Get(2), s.Get(3))
+	s.SetTop(1)
+	return 1
+}
+
+func baseSelect(s *State) int {
+	n := s.Top()
+	if str, ok := s.Get(1).(string); ok && str == "#" {
+		s.Push(int64(n - 1))
+		return 1
+	}
+	i := s.CheckInteger(1)
+	if i < 0 {
+		i = int64(n) + i
+	} else if i > int64(n) {
+		i = int64(n)
+	}
+	if i < 1 {
+		s.ArgError(1, "index out of range")
+	}
+	return n - int(i)
+}
+
+func baseTonumber(s *State) int {
+	if s.Get(2) == nil {
+		v := s.CheckAny(1)
+		if str, ok := v.(string); ok {
+			n, _ := parseNumber(trimSpace(str))
+			s.Push(n)
+			return 1
+		}
+		switch v.(type) {
+		case int64, float64:
+			s.Push(v)
+		default:
+			s.Push(nil)
+		}
+		return 1
+	}
+	base := s.CheckInteger(2)
+	str, ok := s.Get(1).(string)
+	if !ok {
+		s.TypeError(1, "string")
+	}
+	if base < 2 || base > 36 {
+		s.ArgError(2, "base out of range")
+	}
+	str = strings.ToLower(trimSpace(str))
+	neg := strings.HasPrefix(str, "-")
+	if neg {
+		str = str[1:]
+	}
+	n, err := strconv.ParseUint(str, int(base), 64)
+	if err != nil {
+		s.Push(nil)
+		return 1
+	}
+	if neg {
+		s.Push(-int64(n))
+	} else {
+		s.Push(int64(n))
+	}
+	return 1
+}
+
+func baseTostring(s *State) int {
+	s.Push(s.tostring(s.CheckAny(1)))
+	return 1
+}
+
+func baseType(s *State) int {
+	s.Push(luaTypeName(s.CheckAny(1)))
+	return 1
+}
@@ -0,0 +1,461 @@
+package luanova
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ReadOnly marks v so that ToValue and Bind expose it as a read-only
+// view: scripts can read fields and elements and call methods with value
+// receivers, but assignments raise an error.
+func ReadOnly(v any) any {
+	return readOnly{v}
+}
+
+type readOnly struct {
+	v any
+}
+
+// Bind returns v as userdata that scripts use in place. Struct fields and
+// methods are indexed by name, maps by key and slices and arrays by
+// 1-based index; channels have send, recv and close methods and functions
+// can be called. Assignments from scripts modify v, so structs and arrays
+// should be passed by pointer.
+func (s *State) Bind(v any) *Userdata {
+	ro := false
+	if r, ok := v.(readOnly); ok {
+		v, ro = r.v, true
+	}
+	if v == nil {
+		return nil
+	}
+	return s.bind(reflect.ValueOf(v), ro)
+}
+
+func (s *State) bind(rv reflect.Value, ro bool) *Userdata {
+	return &Userdata{Value: rv.Interface(), Meta: s.binding(rv.Type(), ro).meta}
+}
+
+// boundValue converts a field or element for scripts. Composite values
+// are bound rather than copied, so scripts see changes made by Go and
+// the other way around.
+func (s *State) boundValue(rv reflect.Value, ro bool) any {
+	switch rv.Kind() {
+	case reflect.Struct, reflect.Array:
+		if rv.CanAddr() {
+			return s.bind(rv.Addr(), ro)
+		}
+		return s.bind(rv, ro)
+	case reflect.Slice:
+		if rv.Type() == bytesType {
+			return s.toValue(rv)
+		}
+		fallthrough
+	case reflect.Map, reflect.Pointer, reflect.Chan:
+		if rv.IsNil() {
+			return nil
+		}
+		return s.bind(rv, ro)
+	case reflect.Interface:
+		if rv.IsNil() {
+			return nil
+		}
+		return s.boundValue(rv.Elem(), ro)
+	}
+	return s.toValue(rv)
+}
+
+// typeInfo is the binding metadata of a struct type, shared by all
+// states.
+type typeInfo struct {
+	fields []*fieldInfo
+	byName map[string]*fieldInfo
+}
+
+type fieldInfo struct {
+	name   string
+	index  []int
+	typ    reflect.Type
+	tagged bool
+}
+
+var typeInfos sync.Map // reflect.Type -> *typeInfo
+
+// structInfo returns the exported fields of the struct type t, named by
+// their luanova tag if they have one. Fields tagged "-" are left out.
+func structInfo(t reflect.Type) *typeInfo {
+	if info, ok := typeInfos.Load(t); ok {
+		return info.(*typeInfo)
+	}
+	info := &typeInfo{byName: make(map[string]*fieldInfo)}
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous && f.Type.Kind() == reflect.Struct {
+			continue
+		}
+		fi := &fieldInfo{name: f.Name, index: f.Index, typ: f.Type}
+		if tag, ok := f.Tag.Lookup("luanova"); ok {
+			if tag == "-" {
+				continue
+			}
+			if name, _, _ := strings.Cut(tag, ","); name != "" {
+				fi.name, fi.tagged = name, true
+			}
+		}
+		info.fields = append(info.fields, fi)
+		info.byName[fi.name] = fi
+	}
+	actual, _ := typeInfos.LoadOrStore(t, info)
+	return actual.(*typeInfo)
+}
+
+// binding holds the metatable and method functions of a bound type in
+// one state.
+type binding struct {
+	typ     reflect.Type
+	ro      bool
+	meta    *Table
+	methods map[string]*Closure
+}
+
+type bindingKey struct {
+	typ reflect.Type
+	ro  bool
+}
+
+func (s *State) binding(t reflect.Type, ro bool) *binding {
+	key := bindingKey{t, ro}
+	if b, ok := s.bindings[key]; ok {
+		return b
+	}
+	if s.bindings == nil {
+		s.bindings = make(map[bindingKey]*binding)
+	}
+	b := &binding{typ: t, ro: ro, meta: NewTable(), methods: make(map[string]*Closure)}
+	s.bindings[key] = b
+
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		if ro && t.Kind() == reflect.Pointer {
+			if _, ok := t.Elem().MethodByName(m.Name); !ok {
+				continue
+			}
+		}
+		b.methods[m.Name] = s.wrapFunc(m.Name, m.Func)
+	}
+
+	elem := t
+	if t.Kind() == reflect.Pointer {
+		elem = t.Elem()
+	}
+	if elem.Kind() == reflect.Chan {
+		b.chanMethods(elem)
+	}
+
+	mt := b.meta
+	name := t.String()
+	if ro {
+		name = "readonly " + name
+	}
+	mt.Set("__name", name)
+	mt.Set("__index", NewFunction("__index", b.index))
+	mt.Set("__newindex", NewFunction("__newindex", b.newindex))
+	mt.Set("__eq", NewFunction("__eq", bindEq))
+	switch elem.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Chan:
+		mt.Set("__len", NewFunction("__len", b.length))
+	case reflect.Func:
+		mt.Set("__call", NewFunction("__call", bindCall))
+	}
+	if elem.Kind() != reflect.Chan && elem.Kind() != reflect.Func {
+		mt.Set("__pairs", NewFunction("__pairs", b.pairs))
+	}
+	if t.Implements(stringerType) {
+		mt.Set("__tostring", NewFunction("__tostring", bindTostring))
+	}
+	return b
+}
+
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+// value returns the bound value of the userdata at index 1, following
+// a pointer.
+func (b *binding) value(s *State) reflect.Value {
+	ud, ok := s.Get(1).(*Userdata)
+	if !ok || ud.Meta != b.meta {
+		s.TypeError(1, b.meta.GetString("__name").(string))
+	}
+	rv := reflect.ValueOf(ud.Value)
+	if rv.Kind() == reflect.Pointer && rv.Type().Elem().Kind() != reflect.Func {
+		if rv.IsNil() {
+			s.RaiseError("attempt to index a nil %s", b.typ)
+		}
+		rv = rv.Elem()
+	}
+	return rv
+}
+
+func (b *binding) index(s *State) int {
+	key := s.Get(2)
+	if name, ok := key.(string); ok {
+		if m := b.methods[name]; m != nil {
+			s.Push(m)
+			return 1
+		}
+	}
+	s.Push(b.get(s, b.value(s), key))
+	return 1
+}
+
+// get returns rv[key] for scripts.
+func (b *binding) get(s *State, rv reflect.Value, key any) any {
+	switch rv.Kind() {
+	case reflect.Struct:
+		name, _ := key.(string)
+		f := structInfo(rv.Type()).byName[name]
+		if f == nil {
+			break
+		}
+		fv, err := rv.FieldByIndexErr(f.index)
+		if err != nil {
+			return nil
+		}
+		return s.boundValue(fv, b.ro)
+	case reflect.Map:
+		kv, err := s.fromValue(key, rv.Type().Key())
+		if err != nil {
+			return nil
+		}
+		ev := rv.MapIndex(kv)
+		if !ev.IsValid() {
+			return nil
+		}
+		return s.boundValue(ev, b.ro)
+	case reflect.Slice, reflect.Array:
+		i, ok := key.(int64)
+		if !ok || i < 1 || i > int64(rv.Len()) {
+			return nil
+		}
+		return s.boundValue(rv.Index(int(i-1)), b.ro)
+	}
+	s.RaiseError("%s has no field or method %s", b.typ, keyString(key))
+	return nil
+}
+
+func (b *binding) newindex(s *State) int {
+	rv := b.value(s)
+	if b.ro {
+		s.RaiseError("attempt to modify a read-only %s", b.typ)
+	}
+	key, val := s.Get(2), s.Get(3)
+	switch rv.Kind() {
+	case reflect.Struct:
+		name, _ := key.(string)
+		f := structInfo(rv.Type()).byName[name]
+		if f == nil {
+			s.RaiseError("%s has no field %s", b.typ, keyString(key))
+		}
+		if !rv.CanAddr() {
+			s.RaiseError("cannot assign to field '%s' of %s (pass a pointer)", f.name, b.typ)
+		}
+		b.assign(s, fieldAlloc(rv, f.index), val, "field '"+f.name+"'")
+	case reflect.Map:
+		kv, err := s.fromValue(key, rv.Type().Key())
+		if err != nil {
+			s.RaiseError("invalid key for %s: %s", b.typ, err.(*ConvertError).Msg)
+		}
+		if rv.IsNil() {
+			s.RaiseError("assignment to entry in nil %s", b.typ)
+		}
+		if val == nil {
+			rv.SetMapIndex(kv, reflect.Value{})
+			return 0
+		}
+		ev, err := s.fromValue(val, rv.Type().Elem())
+		if err != nil {
+			s.RaiseError("cannot assign to %s: %s", keyString(key), err.(*ConvertError).Msg)
+		}
+		rv.SetMapIndex(kv, ev)
+	case reflect.Slice, reflect.Array:
+		i, ok := key.(int64)
+		n := int64(rv.Len())
+		if ok && i == n+1 && rv.Kind() == reflect.Slice && rv.CanSet() {
+			ev, err := s.fromValue(val, rv.Type().Elem())
+			if err != nil {
+				s.RaiseError("cannot assign to [%d]: %s", i, err.(*ConvertError).Msg)
+			}
+			rv.Set(reflect.Append(rv, ev))
+			return 0
+		}
+		if !ok || i < 1 || i > n {
+			s.RaiseError("index %s out of range [1, %d]", keyString(key), n)
+		}
+		if rv.Kind() == reflect.Array && !rv.CanAddr() {
+			s.RaiseError("cannot assign to element of %s (pass a pointer)", b.typ)
+		}
+		b.assign(s, rv.Index(int(i-1)), val, fmt.Sprintf("[%d]", i))
+	default:
+		s.RaiseError("cannot assign to %s of %s", keyString(key), b.typ)
+	}
+	return 0
+}
+
+func (b *binding) assign(s *State, dst reflect.Value, val any, what string) {
+	v, err := s.fromValue(val, dst.Type())
+	if err != nil {
+		s.RaiseError("cannot assign to %s: %s", what, err.(*ConvertError).Msg)
+	}
+	dst.Set(v)
+}
+
+// fieldAlloc returns the field at index, allocating nil embedded
+// pointers on the way.
+func fieldAlloc(rv reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && rv.Kind() == reflect.Pointer {
+			if rv.IsNil() {
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv
+}
+
+func (b *binding) length(s *State) int {
+	s.Push(int64(b.value(s).Len()))
+	return 1
+}
+
+// pairs iterates over a snapshot of the fields, keys or indices of the
+// bound value. Map keys are sorted when they are strings or numbers.
+func (b *binding) pairs(s *State) int {
+	rv := b.value(s)
+	var keys []any
+	switch rv.Kind() {
+	case reflect.Struct:
+		for _, f := range structInfo(rv.Type()).fields {
+			keys = append(keys, f.name)
+		}
+	case reflect.Map:
+		for _, k := range rv.MapKeys() {
+			keys = append(keys, s.toValue(k))
+		}
+		slices.SortStableFunc(keys, compareKeys)
+	case reflect.Slice, reflect.Array:
+		for i := 1; i <= rv.Len(); i++ {
+			keys = append(keys, int64(i))
+		}
+	}
+	i := 0
+	s.Push(NewFunction("next", func(s *State) int {
+		rv := b.value(s)
+		for i < len(keys) {
+			k := keys[i]
+			i++
+			if v := b.get(s, rv, k); v != nil {
+				s.Push(k, v)
+				return 2
+			}
+		}
+		s.Push(nil)
+		return 1
+	}), s.Get(1), nil)
+	return 3
+}
+
+func compareKeys(a, b any) int {
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b)
+		}
+	case int64:
+		if b, ok := b.(int64); ok {
+			return cmp.Compare(a, b)
+		}
+	case float64:
+		if b, ok := b.(float64); ok {
+			return cmp.Compare(a, b)
+		}
+	}
+	return 0
+}
+
+// chanMethods adds send, recv and close for channel type t. Read-only
+// views can only receive.
+func (b *binding) chanMethods(t reflect.Type) {
+	if t.ChanDir()&reflect.RecvDir != 0 {
+		b.methods["recv"] = NewFunction("recv", func(s *State) int {
+			v, ok := s.chanOp(reflect.SelectRecv, b.value(s), reflect.Value{})
+			if !ok {
+				s.Push(nil, false)
+				return 2
+			}
+			s.Push(s.boundValue(v, b.ro), true)
+			return 2
+		})
+	}
+	if b.ro || t.ChanDir()&reflect.SendDir == 0 {
+		return
+	}
+	b.methods["send"] = NewFunction("send", func(s *State) int {
+		ch := b.value(s)
+		v, err := s.fromValue(s.Get(2), t.Elem())
+		if err != nil {
+			s.ArgError(2, err.(*ConvertError).Msg)
+		}
+		s.chanOp(reflect.SelectSend, ch, v)
+		return 0
+	})
+	b.methods["close"] = NewFunction("close", func(s *State) int {
+		b.value(s).Close()
+		return 0
+	})
+}
+
+// chanOp sends or receives on ch, giving up when the context of s is
+// done.
+func (s *State) chanOp(dir reflect.SelectDir, ch, v reflect.Value) (reflect.Value, bool) {
+	cases := []reflect.SelectCase{{Dir: dir, Chan: ch, Send: v}}
+	if s.ctx != nil {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(s.ctx.Done())})
+	}
+	i, r, ok := reflect.Select(cases)
+	if i == 1 {
+		s.limitError(s.ctx.Err())
+	}
+	return r, ok
+}
+
+func bindEq(s *State) int {
+	a, _ := s.Get(1).(*Userdata)
+	b, _ := s.Get(2).(*Userdata)
+	eq := a != nil && b != nil && reflect.TypeOf(a.Value) == reflect.TypeOf(b.Value) &&
+		reflect.TypeOf(a.Value).Comparable() && a.Value == b.Value
+	s.Push(eq)
+	return 1
+}
+
+func bindCall(s *State) int {
+	ud := s.Get(1).(*Userdata)
+	fn := reflect.ValueOf(ud.Value)
+	if fn.Kind() == reflect.Pointer {
+		fn = fn.Elem()
+	}
+	if fn.IsNil() {
+		s.RaiseError("attempt to call a nil %s", fn.Type())
+	}
+	rs := s.call(s.wrapFunc("", fn), s.args(2))
+	s.Push(rs...)
+	return len(rs)
+}
+
+func bindTostring(s *State) int {
+	s.Push(s.Get(1).(*Userdata).Value.(fmt.Stringer).String())
+	return 1
+}
@@ -0,0 +1,190 @@
+package luanova
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type account struct {
+	Owner   string `luanova:"owner"`
+	Balance int    `luanova:"balance"`
+	Tags    []string
+	Limits  map[string]int
+	Secret  string `luanova:"-"`
+	Address address
+	History *[]int
+	notes   string
+}
+
+type address struct {
+	City string
+}
+
+func (a *account) Deposit(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("invalid amount %d", n)
+	}
+	a.Balance += n
+	return nil
+}
+
+func (a account) Summary() string {
+	return fmt.Sprintf("%s: %d", a.Owner, a.Balance)
+}
+
+func (a address) String() string {
+	return "address in " + a.City
+}
+
+func runBound(t *testing.T, s *State, src string) string {
+	t.Helper()
+	var out strings.Builder
+	s.Stdout = &out
+	fn, err := s.Load(src, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PCall(fn); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestBindStruct(t *testing.T) {
+	history := []int{1}
+	acc := &account{Owner: "ana", Balance: 10, Tags: []string{"a"}, Limits: map[string]int{"day": 5},
+		Secret: "x", Address: address{City: "Recife"}, History: &history}
+	s := NewState()
+	s.SetGlobal("acc", acc)
+
+	out := runBound(t, s, `
+print(acc.owner, acc.balance, acc.Tags[1], #acc.Tags, acc.Limits.day)
+acc:Deposit(5)
+acc.balance = acc.balance + 1
+acc.Tags[1] = "b"
+acc.Limits.week = 20
+acc.Limits.day = nil
+acc.Address.City = "Olinda"
+acc.History[2] = 2
+print(acc:Summary(), tostring(acc.Address))
+print(pcall(acc.Deposit, acc, -1))
+print(pcall(function() return acc.Secret end))
+print(pcall(function() acc.balance = "lots" end))
+print(pcall(function() acc.Tags[5] = "z" end))
+print(acc == acc, acc.Address == acc.Address)
+local keys = {}
+for k in pairs(acc) do keys[#keys + 1] = k end
+print(table.concat(keys, " "))
+`)
+	expected := `ana	10	a	1	5
+ana: 16	address in Olinda
+false	invalid amount -1
+false	test:12: *luanova.account has no field or method "Secret"
+false	test:13: cannot assign to field 'balance': number expected, got string
+false	test:14: index 5 out of range [1, 1]
+true	true
+owner balance Tags Limits Address History
+`
+	if out != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", out, expected)
+	}
+	if acc.Balance != 16 || acc.Tags[0] != "b" || acc.Address.City != "Olinda" || len(history) != 2 {
+		t.Errorf("Go value not updated: %+v, history %v", acc, history)
+	}
+	if !reflect.DeepEqual(acc.Limits, map[string]int{"week": 20}) {
+		t.Errorf("map not updated: %v", acc.Limits)
+	}
+}
+
+func TestBindReadOnly(t *testing.T) {
+	acc := &account{Owner: "ana", Balance: 10, Tags: []string{"a"}, Limits: map[string]int{}}
+	s := NewState()
+	s.SetGlobal("acc", ReadOnly(acc))
+
+	out := runBound(t, s, `
+print(acc.owner, acc:Summary())
+print(pcall(function() return acc.Deposit end))
+print(pcall(function() acc.balance = 0 end))
+print(pcall(function() acc.Tags[1] = "b" end))
+print(pcall(function() acc.Limits.x = 1 end))
+`)
+	expected := `ana	ana: 10
+false	test:3: *luanova.account has no field or method "Deposit"
+false	test:4: attempt to modify a read-only *luanova.account
+false	test:5: attempt to modify a read-only []string
+false	test:6: attempt to modify a read-only map[string]int
+`
+	if out != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", out, expected)
+	}
+	if acc.Balance != 10 || acc.Tags[0] != "a" || len(acc.Limits) != 0 {
+		t.Errorf("read-only value modified: %+v", acc)
+	}
+}
+
+func TestBindCollections(t *testing.T) {
+	scores := map[string]int{"b": 2, "a": 1}
+	list := []string{"x", "y"}
+	ch := make(chan int, 2)
+	s := NewState()
+	s.SetGlobal("scores", s.Bind(scores))
+	s.SetGlobal("list", s.Bind(&list))
+	s.SetGlobal("ch", ch)
+	s.SetGlobal("inc", s.Bind(func(n int) int { return n + 1 }))
+
+	out := runBound(t, s, `
+for k, v in pairs(scores) do print(k, v) end
+scores.c = 3
+list[3] = "z"
+local parts = {}
+for i, v in ipairs(list) do parts[#parts + 1] = i .. v end
+print(table.concat(parts))
+print(#list, #scores, list[0], scores.missing)
+ch:send(7)
+ch:send(8)
+ch:close()
+print(#ch, ch:recv())
+print(ch:recv())
+print(ch:recv())
+print(inc(41))
+print(pcall(inc, "x"))
+`)
+	expected := `a	1
+b	2
+1x2y3z
+3	3	nil	nil
+2	7	true
+8	true
+nil	false
+42
+false	bad argument #1 to '?' (number expected, got string)
+`
+	if out != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", out, expected)
+	}
+	if scores["c"] != 3 || len(list) != 3 {
+		t.Errorf("collections not updated: %v %v", scores, list)
+	}
+}
+
+func TestStructInfoCache(t *testing.T) {
+	typ := reflect.TypeOf(account{})
+	if structInfo(typ) != structInfo(typ) {
+		t.Errorf("struct metadata is not cached")
+	}
+	s := NewState()
+	if s.Bind(&account{}).Meta != s.Bind(&account{}).Meta {
+		t.Errorf("metatables are not shared between values of a type")
+	}
+
+	tab := NewTable()
+	tab.Set("owner", "bob")
+	tab.Set("Owner", "wrong")
+	tab.Set("Secret", "s")
+	var acc account
+	if err := s.ToGo(tab, &acc); err != nil || acc.Owner != "bob" || acc.Secret != "" {
+		t.Errorf("tagged fields: got %+v, %v", acc, err)
+	}
+}
@@ -0,0 +1,438 @@
+package luanova
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Herograme/LuaNova/luanova/diag"
+)
+
+// Code action kinds of the protocol.
+const (
+	actionQuickFix        = "quickfix"
+	actionRewrite         = "refactor.rewrite"
+	actionOrganizeImports = "source.organizeImports"
+)
+
+// lspSeverity maps severities to those of the protocol.
+var lspSeverity = map[diag.Severity]int{
+	diag.SeverityError:   1,
+	diag.SeverityWarning: 2,
+	diag.SeverityInfo:    3,
+}
+
+type lspDiagnostic struct {
+	Range              lspRange         `json:"range"`
+	Severity           int              `json:"severity"`
+	Code               string           `json:"code,omitempty"`
+	Source             string           `json:"source"`
+	Message            string           `json:"message"`
+	RelatedInformation []lspRelatedInfo `json:"relatedInformation,omitempty"`
+}
+
+type lspRelatedInfo struct {
+	Location lspLocation `json:"location"`
+	Message  string      `json:"message"`
+}
+
+type lspWorkspaceEdit struct {
+	Changes map[string][]lspTextEdit `json:"changes"`
+}
+
+type lspCodeAction struct {
+	Title       string           `json:"title"`
+	Kind        string           `json:"kind"`
+	Diagnostics []lspDiagnostic  `json:"diagnostics,omitempty"`
+	IsPreferred bool             `json:"isPreferred,omitempty"`
+	Edit        lspWorkspaceEdit `json:"edit"`
+}
+
+// lint returns the diagnostics of the document: its syntax errors when
+// it does not parse, the findings of the lint rules otherwise.
+func (doc *document) lint() []diag.Diagnostic {
+	doc.analyze()
+	if doc.linted {
+		return doc.diags
+	}
+	doc.linted = true
+	var list ErrorList
+	if errors.As(doc.err, &list) {
+		for _, e := range list {
+			doc.diags = append(doc.diags, e.Diagnostic())
+		}
+	} else if doc.chunk != nil {
+		doc.diags = Lint(doc.chunk, nil)
+	}
+	return doc.diags
+}
+
+func (doc *document) diagnostics() []lspDiagnostic {
+	ds := []lspDiagnostic{}
+	for _, d := range doc.lint() {
+		ds = append(ds, doc.diagnostic(d))
+	}
+	return ds
+}
+
+func (doc *document) diagnostic(d diag.Diagnostic) lspDiagnostic {
+	ld := lspDiagnostic{
+		Range:    doc.span(d.Pos.Offset, d.End.Offset),
+		Severity: lspSeverity[d.Severity],
+		Code:     d.Code,
+		Source:   "luanova",
+		Message:  d.Message,
+	}
+	for _, n := range d.Notes {
+		ld.RelatedInformation = append(ld.RelatedInformation, lspRelatedInfo{lspLocation{doc.uri, doc.span(n.Pos.Offset, n.End.Offset)}, n.Message})
+	}
+	return ld
+}
+
+func (doc *document) span(start, end int) lspRange {
+	return lspRange{doc.position(start), doc.position(end)}
+}
+
+func (doc *document) edit(start, end int, text string) lspTextEdit {
+	return lspTextEdit{doc.span(start, end), text}
+}
+
+func (doc *document) workspaceEdit(edits ...lspTextEdit) lspWorkspaceEdit {
+	return lspWorkspaceEdit{map[string][]lspTextEdit{doc.uri: edits}}
+}
+
+// codeActions returns the fixes of the diagnostics in r, the rewrites of
+// the statements in r and the source actions of the document, keeping
+// the kinds in only when it is not empty.
+func (doc *document) codeActions(r lspRange, only []string) []lspCodeAction {
+	doc.analyze()
+	from, to := doc.offset(r.Start), doc.offset(r.End)
+	actions := []lspCodeAction{}
+	add := func(a lspCodeAction) {
+		for _, kind := range only {
+			if a.Kind == kind || strings.HasPrefix(a.Kind, kind+".") {
+				actions = append(actions, a)
+				return
+			}
+		}
+		if len(only) == 0 {
+			actions = append(actions, a)
+		}
+	}
+	quickFix := func(d diag.Diagnostic, title string, preferred bool, edits ...lspTextEdit) {
+		add(lspCodeAction{title, actionQuickFix, []lspDiagnostic{doc.diagnostic(d)}, preferred, doc.workspaceEdit(edits...)})
+	}
+	for _, d := range doc.lint() {
+		if d.End.Offset < from || d.Pos.Offset > to {
+			continue
+		}
+		if e, ok := doc.missingEnd(d); ok {
+			quickFix(d, "insert the missing end", true, e)
+		}
+		for i, fix := range d.Fixes {
+			var edits []lspTextEdit
+			for _, e := range fix.Edits {
+				edits = append(edits, doc.edit(e.Start.Offset, e.End.Offset, e.NewText))
+			}
+			quickFix(d, fix.Message, i == 0, edits...)
+		}
+		if d.Code == "unused-local" {
+			if title, e, ok := doc.removeUnused(d); ok {
+				quickFix(d, title, false, e)
+			}
+		}
+	}
+	if doc.chunk == nil {
+		return actions
+	}
+	Inspect(doc.chunk.Block, func(n Node) bool {
+		if n.End().Offset < from || n.Pos().Offset > to {
+			return false
+		}
+		switch n := n.(type) {
+		case *AssignStmt:
+			if title, e, ok := doc.compoundAssign(n); ok {
+				add(lspCodeAction{Title: title, Kind: actionRewrite, Edit: doc.workspaceEdit(e)})
+			}
+		case *LocalStmt:
+			for i, b := range n.Names {
+				if b.Type != nil || i < len(n.Values) && isFunction(n.Values[i]) {
+					continue
+				}
+				if v := doc.res.VariableOf(b.Name); v != nil {
+					if t := typeString(doc.types.varType(v)); t != "" {
+						e := doc.edit(b.Name.End().Offset, b.Name.End().Offset, ": "+t)
+						add(lspCodeAction{Title: fmt.Sprintf("annotate %s as %s", b.Name.Name, t), Kind: actionRewrite, Edit: doc.workspaceEdit(e)})
+					}
+				}
+			}
+		}
+		return true
+	})
+	if edits := doc.organizeImports(); len(edits) > 0 {
+		add(lspCodeAction{Title: "organize imports", Kind: actionOrganizeImports, Edit: doc.workspaceEdit(edits...)})
+	}
+	return actions
+}
+
+// line returns the text of line i, counted from 0, without its newline.
+func (doc *document) line(i int) string {
+	end := len(doc.text)
+	if i+1 < len(doc.lines) {
+		end = doc.lines[i+1] - 1
+	}
+	return strings.TrimSuffix(doc.text[doc.lines[i]:end], "\r")
+}
+
+func indentation(s string) string {
+	return s[:len(s)-len(strings.TrimLeft(s, " \t"))]
+}
+
+// missingEnd returns the insertion of the end the syntax error d asks
+// for. The block it closes is guessed from the indentation: the first
+// line after the opening one that is followed by a less indented line
+// ends the block opened by the last line before it that is less
+// indented. Inserting it before an error is tried too. Every guess is
+// checked by parsing the result; the first that parses wins, otherwise
+// the first that leaves fewer errors.
+func (doc *document) missingEnd(d diag.Diagnostic) (lspTextEdit, bool) {
+	if !strings.HasPrefix(d.Message, "'end' expected") || len(d.Notes) == 0 {
+		return lspTextEdit{}, false
+	}
+	type guess struct {
+		at   int
+		text string
+	}
+	var guesses []guess
+	open := d.Notes[0].Pos.Line - 1
+	last := doc.position(d.Pos.Offset).Line
+	for i := open; i <= last && i < len(doc.lines); i++ {
+		text := doc.line(i)
+		if strings.TrimSpace(text) == "" || doc.lines[i]+len(text) > d.Pos.Offset {
+			continue
+		}
+		next := ""
+		for j := i + 1; j < len(doc.lines); j++ {
+			if t := doc.line(j); strings.TrimSpace(t) != "" {
+				next = indentation(t)
+				break
+			}
+		}
+		indent := indentation(text)
+		if len(next) >= len(indent) {
+			continue
+		}
+		opener := ""
+		for j := i - 1; j >= open; j-- {
+			if t := doc.line(j); strings.TrimSpace(t) != "" && len(indentation(t)) < len(indent) {
+				opener = indentation(t)
+				break
+			}
+		}
+		guesses = append(guesses, guess{doc.lines[i] + len(text), "\n" + opener + "end"})
+	}
+	// before an earlier error, as in f(function() g()), or before this one
+	var at []int
+	for _, e := range doc.diags {
+		if e.Pos.Offset > d.Notes[0].End.Offset && e.Pos.Offset < d.Pos.Offset {
+			at = append(at, e.Pos.Offset)
+		}
+	}
+	for _, offset := range append(at, d.Pos.Offset) {
+		before := len(strings.TrimRight(doc.text[:offset], " \t\r\n"))
+		if offset < len(doc.text) && doc.position(before).Line == doc.position(offset).Line {
+			guesses = append(guesses, guess{before, " end"})
+		} else {
+			guesses = append(guesses, guess{before, "\n" + indentation(doc.line(open)) + "end"})
+		}
+	}
+
+	best := -1
+	for i, g := range guesses {
+		_, err := Parse(doc.uri, doc.text[:g.at]+g.text+doc.text[g.at:])
+		var list ErrorList
+		if !errors.As(err, &list) {
+			best = i
+			break
+		}
+		if best < 0 && len(list) < len(doc.diags) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return lspTextEdit{}, false
+	}
+	g := guesses[best]
+	return doc.edit(g.at, g.at, g.text), true
+}
+
+// removeUnused returns the removal of the declaration the unused-local
+// diagnostic d is about, when it has no other effect.
+func (doc *document) removeUnused(d diag.Diagnostic) (string, lspTextEdit, bool) {
+	if doc.chunk == nil {
+		return "", lspTextEdit{}, false
+	}
+	id := doc.identAt(doc.position(d.Pos.Offset))
+	v := doc.res.VariableOf(id)
+	if v == nil || v.Name != id || len(v.Writes) > 0 {
+		return "", lspTextEdit{}, false
+	}
+	nodes := doc.nodesAt(id.Pos().Offset)
+	for i := len(nodes) - 1; i >= 0; i-- {
+		switch s := nodes[i].(type) {
+		case *LocalStmt:
+			if len(s.Names) != 1 {
+				return "", lspTextEdit{}, false
+			}
+			for _, e := range s.Values {
+				if !pure(e) {
+					return "", lspTextEdit{}, false
+				}
+			}
+			return fmt.Sprintf("remove the unused local '%s'", id.Name), doc.removal(s), true
+		case *LocalFunctionStmt:
+			return fmt.Sprintf("remove the unused function '%s'", id.Name), doc.removal(s), true
+		}
+	}
+	return "", lspTextEdit{}, false
+}
+
+// removal deletes the source of n, with its lines when it is alone on
+// them.
+func (doc *document) removal(n Node) lspTextEdit {
+	start, end := n.Pos().Offset, n.End().Offset
+	lineStart := strings.LastIndexByte(doc.text[:start], '\n') + 1
+	lineEnd := len(doc.text)
+	if i := strings.IndexByte(doc.text[end:], '\n'); i >= 0 {
+		lineEnd = end + i + 1
+	}
+	if strings.TrimSpace(doc.text[lineStart:start]) == "" && strings.TrimSpace(doc.text[end:lineEnd]) == "" {
+		start, end = lineStart, lineEnd
+	}
+	return doc.edit(start, end, "")
+}
+
+// compoundAssign returns the rewrite of x = x op e as x op= e.
+func (doc *document) compoundAssign(s *AssignStmt) (string, lspTextEdit, bool) {
+	if len(s.Targets) != 1 || len(s.Values) != 1 {
+		return "", lspTextEdit{}, false
+	}
+	e, ok := s.Values[0].(*BinaryExpr)
+	if !ok || !sameExpr(s.Targets[0], e.Left) {
+		return "", lspTextEdit{}, false
+	}
+	switch e.Op {
+	case Plus, Sub, Multi, Div, Mod, Concat:
+	default:
+		return "", lspTextEdit{}, false
+	}
+	op := binaryOps[e.Op] + "="
+	return "use " + op, doc.edit(s.Targets[0].End().Offset, e.Right.Pos().Offset, " "+op+" "), true
+}
+
+// importLine is an import statement or a local bound to a require call.
+type importLine struct {
+	stmt Stmt
+	path string
+	text string // "" when all its names are unused
+}
+
+// organizeImports sorts each run of imports on consecutive lines by
+// path, imports first, sorts the names they import and drops those never
+// used. Runs with comments are left alone.
+func (doc *document) organizeImports() []lspTextEdit {
+	var edits []lspTextEdit
+	used := func(id *Ident) bool {
+		v := doc.res.VariableOf(id)
+		return v == nil || !unused(v)
+	}
+	var run []importLine
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		start, end := run[0].stmt.Pos().Offset, run[len(run)-1].stmt.End().Offset
+		for _, c := range doc.chunk.Comments {
+			if c.Pos.Offset >= start && c.Pos.Offset < end {
+				run = nil
+				return
+			}
+		}
+		sort.SliceStable(run, func(i, j int) bool {
+			_, ri := run[i].stmt.(*LocalStmt)
+			_, rj := run[j].stmt.(*LocalStmt)
+			if ri != rj {
+				return rj
+			}
+			return run[i].path < run[j].path
+		})
+		var lines []string
+		for _, l := range run {
+			if l.text != "" {
+				lines = append(lines, l.text)
+			}
+		}
+		text := strings.Join(lines, "\n"+indentation(doc.line(run[0].stmt.Pos().Line-1)))
+		if text == "" {
+			edits = append(edits, doc.removal(Span{run[0].stmt.Pos(), run[len(run)-1].stmt.End()}))
+		} else if text != doc.text[start:end] {
+			edits = append(edits, doc.edit(start, end, text))
+		}
+		run = nil
+	}
+	for _, s := range doc.chunk.Block.Stmts {
+		l, ok := doc.importLine(s, used)
+		if !ok || len(run) > 0 && s.Pos().Line != run[len(run)-1].stmt.End().Line+1 {
+			flush()
+		}
+		if ok {
+			run = append(run, l)
+		}
+	}
+	flush()
+	return edits
+}
+
+func (doc *document) importLine(s Stmt, used func(*Ident) bool) (importLine, bool) {
+	switch s := s.(type) {
+	case *ImportStmt:
+		l := importLine{stmt: s, path: s.Path.Value}
+		if s.Namespace != nil {
+			if used(s.Namespace) {
+				l.text = doc.text[s.Pos().Offset:s.End().Offset]
+			}
+			return l, true
+		}
+		var specs []*ImportSpec
+		for _, spec := range s.Names {
+			if used(spec.LocalName()) {
+				specs = append(specs, spec)
+			}
+		}
+		sort.SliceStable(specs, func(i, j int) bool { return specs[i].Name.Name < specs[j].Name.Name })
+		if len(specs) > 0 {
+			l.text = "import " + importList(specs) + " from " + doc.text[s.Path.Pos().Offset:s.Path.End().Offset]
+		}
+		return l, true
+	case *LocalStmt:
+		if len(s.Names) != 1 || len(s.Values) != 1 {
+			break
+		}
+		call, ok := s.Values[0].(*CallExpr)
+		if !ok || len(call.Args) != 1 {
+			break
+		}
+		fn, ok := call.Fn.(*Ident)
+		path, isString := call.Args[0].(*StringExpr)
+		if !ok || fn.Name != "require" || doc.res.VariableOf(fn) != nil || !isString {
+			break
+		}
+		l := importLine{stmt: s, path: path.Value}
+		if used(s.Names[0].Name) {
+			l.text = doc.text[s.Pos().Offset:s.End().Offset]
+		}
+		return l, true
+	}
+	return importLine{}, false
+}
@@ -0,0 +1,121 @@
+package luanova
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// applyEdits applies protocol edits that do not overlap to text.
+func applyEdits(text string, edits []lspTextEdit) string {
+	doc := newDocument("", 0, text)
+	sort.Slice(edits, func(i, j int) bool { return doc.offset(edits[i].Range.Start) > doc.offset(edits[j].Range.Start) })
+	for _, e := range edits {
+		text = text[:doc.offset(e.Range.Start)] + e.NewText + text[doc.offset(e.Range.End):]
+	}
+	return text
+}
+
+// TestCodeActions applies the action with the given title at a position
+// of testdata/codeaction/NAME.before.lunv and compares the result with
+// NAME.after.lunv.
+func TestCodeActions(t *testing.T) {
+	tests := []struct {
+		name       string
+		line, char int // 1-based
+		title      string
+	}{
+		{"missing_end", 5, 1, "insert the missing end"},
+		{"missing_end_nested", 9, 1, "insert the missing end"},
+		{"missing_end_inline", 2, 1, "insert the missing end"},
+		{"compound_assign", 3, 2, "use +="},
+		{"compound_concat", 2, 3, "use ..="},
+		{"add_local", 2, 2, "declare it local"},
+		{"remove_unused", 2, 7, "remove the unused local 'cache'"},
+		{"remove_unused_function", 1, 16, "remove the unused function 'helper'"},
+		{"annotate", 1, 7, "annotate point as {x: number, y: number}"},
+		{"organize_imports", 1, 1, "organize imports"},
+	}
+	c := startLSP(t)
+	for _, tt := range tests {
+		before, err := os.ReadFile(filepath.Join("testdata", "codeaction", tt.name+".before.lunv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		after, err := os.ReadFile(filepath.Join("testdata", "codeaction", tt.name+".after.lunv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		uri := "file:///" + tt.name + ".lunv"
+		c.open(uri, string(before))
+		at := lspPosition{tt.line - 1, tt.char - 1}
+		var actions []lspCodeAction
+		c.request("textDocument/codeAction", map[string]any{
+			"textDocument": map[string]any{"uri": uri},
+			"range":        lspRange{at, at},
+			"context":      map[string]any{"diagnostics": []any{}},
+		}, &actions)
+		var action *lspCodeAction
+		var titles []string
+		for i, a := range actions {
+			if a.Title == tt.title {
+				action = &actions[i]
+			}
+			titles = append(titles, a.Title)
+		}
+		if action == nil {
+			t.Errorf("%s: no action %q in %q", tt.name, tt.title, titles)
+			continue
+		}
+		if got := applyEdits(string(before), action.Edit.Changes[uri]); got != string(after) {
+			t.Errorf("%s: %s gives\n%s\nwant\n%s", tt.name, tt.title, got, after)
+		}
+	}
+}
+
+func TestCodeActionKinds(t *testing.T) {
+	doc := newDocument("file:///kinds.lunv", 1, "import { b, a } from \"m\"\nlocal x = 1\nx = x + a * b\n")
+	kinds := func(only ...string) string {
+		var ks []string
+		for _, a := range doc.codeActions(lspRange{lspPosition{2, 0}, lspPosition{2, 0}}, only) {
+			ks = append(ks, a.Kind+" "+a.Title)
+		}
+		return strings.Join(ks, "|")
+	}
+	if got, want := kinds(), "refactor.rewrite use +=|source.organizeImports organize imports"; got != want {
+		t.Errorf("actions = %s, want %s", got, want)
+	}
+	if got, want := kinds("source"), "source.organizeImports organize imports"; got != want {
+		t.Errorf("source actions = %s, want %s", got, want)
+	}
+	if got := kinds("quickfix"); got != "" {
+		t.Errorf("quick fixes = %s, want none", got)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"local x = 1", "0:6-0:7 2 unused-local local 'x' is never used"},
+		{"if x then\n", "1:0-1:0 1  'end' expected (to close 'if' at line 1) near <eof> [0:0-0:2 'if' opened here]"},
+		{"print(1)", ""},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, d := range newDocument("file:///d.lunv", 1, tt.input).diagnostics() {
+			s := fmt.Sprintf("%d:%d-%d:%d %d %s %s", d.Range.Start.Line, d.Range.Start.Character, d.Range.End.Line, d.Range.End.Character, d.Severity, d.Code, d.Message)
+			for _, r := range d.RelatedInformation {
+				s += fmt.Sprintf(" [%d:%d-%d:%d %s]", r.Location.Range.Start.Line, r.Location.Range.Start.Character, r.Location.Range.End.Line, r.Location.Range.End.Character, r.Message)
+			}
+			got = append(got, s)
+		}
+		if strings.Join(got, "|") != tt.expected {
+			t.Errorf("diagnostics of %q = %s, want %s", tt.input, strings.Join(got, "|"), tt.expected)
+		}
+	}
+}
@@ -0,0 +1,1243 @@
+package luanova
+
+import (
+	"fmt"
+	"math"
+)
+
+// The compiler turns the syntax tree into a tree of Go closures. Locals
+// live in frame slots; a local captured by an inner function is boxed in
+// a cell so that the closure and the frame share it.
+
+type (
+	evalFn  func(fr *frame) any
+	multiFn func(fr *frame) []any
+	execFn  func(fr *frame) flow
+)
+
+// flow tells enclosing statements how a statement completed.
+type flow int
+
+const (
+	flowNormal flow = iota
+	flowBreak
+	flowContinue
+	flowReturn
+)
+
+type funcProto struct {
+	name     string
+	source   string
+	pos      Position
+	line     int
+	lastLine int
+	main     bool
+	params   []*localVar
+	isVararg bool
+	nslots   int
+	upvals   []upvalDesc
+	body     func(fr *frame)
+}
+
+// upvalDesc tells a new closure where to find an upvalue: a local of
+// the enclosing function (slot) or one of its upvalues (index).
+type upvalDesc struct {
+	name      string
+	fromLocal bool
+	index     int
+}
+
+type localVar struct {
+	name     string
+	slot     int
+	captured bool
+}
+
+type blockScope struct {
+	parent  *blockScope
+	nparent int // number of parent vars in scope when the block opened
+	vars    []*localVar
+}
+
+type funcState struct {
+	parent *funcState
+	proto  *funcProto
+	block  *blockScope
+	nslots int
+	loops  int
+	outer  []string // names visible from the main function of an eval chunk
+}
+
+type compiler struct {
+	source  string
+	fs      *funcState
+	errors  ErrorList
+	module  bool
+	exports []exportVar
+	cover   *FileCoverage // nil unless coverage is counted
+}
+
+// exportVar is a local exported under name, or a type when lv is nil.
+// Exports are collected into the module table when the chunk finishes.
+type exportVar struct {
+	name string
+	lv   *localVar
+}
+
+// compileChunk compiles chunk, instrumented for coverage when cover is
+// not nil.
+func compileChunk(chunk *Chunk, cover *FileCoverage) (*funcProto, error) {
+	c := &compiler{source: chunk.Name, module: isModule(chunk), cover: cover}
+	p := &funcProto{source: chunk.Name, pos: Position{Line: 1, Column: 1}, main: true, isVararg: true}
+	c.openFunction(p)
+	body := c.compileBlock(chunk.Block)
+	c.closeFunction()
+	p.body = func(fr *frame) { body(fr) }
+	if c.module {
+		exports := c.exports
+		p.body = func(fr *frame) {
+			if body(fr) == flowReturn {
+				return
+			}
+			mod := NewTable()
+			declared := make(map[string]bool, len(exports))
+			for _, e := range exports {
+				if e.lv == nil {
+					if _, ok := declared[e.name]; !ok {
+						declared[e.name] = true
+					}
+					continue
+				}
+				declared[e.name] = false
+				mod.Set(e.name, getLocal(fr, e.lv))
+			}
+			fr.s.declareExports(mod, declared)
+			fr.ret = []any{mod}
+		}
+	}
+	return p, c.errors.Err()
+}
+
+// isModule reports whether chunk has export declarations; such a chunk
+// returns the table of its exports.
+func isModule(chunk *Chunk) bool {
+	for _, stmt := range chunk.Block.Stmts {
+		if _, ok := stmt.(*ExportStmt); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// atTopLevel reports whether the compiler is in the outermost block of
+// the main chunk.
+func (c *compiler) atTopLevel() bool {
+	b := c.fs.block
+	return c.fs.parent == nil && b.parent != nil && b.parent.parent == nil
+}
+
+func (c *compiler) errorAt(pos Position, format string, args ...any) {
+	c.errors = append(c.errors, &SyntaxError{Source: c.source, Pos: pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (c *compiler) openFunction(p *funcProto) {
+	c.fs = &funcState{parent: c.fs, proto: p, block: &blockScope{}}
+}
+
+func (c *compiler) closeFunction() {
+	c.fs = c.fs.parent
+}
+
+func (c *compiler) openBlock() {
+	c.fs.block = &blockScope{parent: c.fs.block, nparent: len(c.fs.block.vars)}
+}
+
+func (c *compiler) closeBlock() {
+	fs := c.fs
+	fs.nslots -= len(fs.block.vars)
+	fs.block = fs.block.parent
+}
+
+func (c *compiler) declare(name string) *localVar {
+	fs := c.fs
+	lv := &localVar{name: name, slot: fs.nslots}
+	fs.nslots++
+	fs.proto.nslots = max(fs.proto.nslots, fs.nslots)
+	fs.block.vars = append(fs.block.vars, lv)
+	return lv
+}
+
+func (fs *funcState) findLocal(name string) *localVar {
+	for b := fs.block; b != nil; b = b.parent {
+		for i := len(b.vars) - 1; i >= 0; i-- {
+			if b.vars[i].name == name {
+				return b.vars[i]
+			}
+		}
+	}
+	return nil
+}
+
+// findUpvalue returns the index of the upvalue name, adding it to the
+// function if needed, or -1 for a global.
+func (fs *funcState) findUpvalue(name string) int {
+	for i, u := range fs.proto.upvals {
+		if u.name == name {
+			return i
+		}
+	}
+	if fs.parent == nil {
+		for i, o := range fs.outer {
+			if o == name {
+				fs.proto.upvals = append(fs.proto.upvals, upvalDesc{name: name, index: i})
+				return len(fs.proto.upvals) - 1
+			}
+		}
+		return -1
+	}
+	if lv := fs.parent.findLocal(name); lv != nil {
+		lv.captured = true
+		fs.proto.upvals = append(fs.proto.upvals, upvalDesc{name: name, fromLocal: true, index: lv.slot})
+		return len(fs.proto.upvals) - 1
+	}
+	i := fs.parent.findUpvalue(name)
+	if i < 0 {
+		return -1
+	}
+	fs.proto.upvals = append(fs.proto.upvals, upvalDesc{name: name, index: i})
+	return len(fs.proto.upvals) - 1
+}
+
+func newLocal(fr *frame, lv *localVar, v any) {
+	if lv.captured {
+		fr.slots[lv.slot] = &cell{v}
+	} else {
+		fr.slots[lv.slot] = v
+	}
+}
+
+func getLocal(fr *frame, lv *localVar) any {
+	v := fr.slots[lv.slot]
+	if lv.captured {
+		return v.(*cell).v
+	}
+	return v
+}
+
+func setLocal(fr *frame, lv *localVar, v any) {
+	if lv.captured {
+		fr.slots[lv.slot].(*cell).v = v
+	} else {
+		fr.slots[lv.slot] = v
+	}
+}
+
+// Statements
+
+func (c *compiler) compileBlock(b *Block) execFn {
+	c.openBlock()
+	body := c.compileStmts(b.Stmts)
+	c.closeBlock()
+	return body
+}
+
+func (c *compiler) compileStmts(stmts []Stmt) execFn {
+	var execs []execFn
+	for _, stmt := range stmts {
+		if exec := c.compileStmt(stmt); exec != nil {
+			execs = append(execs, exec)
+		}
+	}
+	switch len(execs) {
+	case 0:
+		return func(*frame) flow { return flowNormal }
+	case 1:
+		return execs[0]
+	}
+	return func(fr *frame) flow {
+		for _, exec := range execs {
+			if f := exec(fr); f != flowNormal {
+				return f
+			}
+		}
+		return flowNormal
+	}
+}
+
+func (c *compiler) compileStmt(stmt Stmt) execFn {
+	line := stmt.Pos().Line
+	at := &stmtInfo{line: line, pos: stmt.Pos(), block: c.fs.block, nvars: len(c.fs.block.vars)}
+	exec := c.compileStmtBody(stmt)
+	if exec == nil {
+		return nil
+	}
+	if c.cover != nil {
+		exec = c.cover.countStmt(stmt, exec)
+	}
+	return func(fr *frame) flow {
+		prev := fr.at
+		fr.line, fr.at = line, at
+		if h := fr.s.hooks; h != nil {
+			h.statement(fr, prev)
+		}
+		return exec(fr)
+	}
+}
+
+func (c *compiler) compileStmtBody(stmt Stmt) execFn {
+	switch stmt := stmt.(type) {
+	case *LocalStmt:
+		return c.compileLocal(stmt)
+	case *LocalFunctionStmt:
+		lv := c.declare(stmt.Name.Name)
+		fn := c.compileFunction(stmt.Func)
+		return func(fr *frame) flow {
+			newLocal(fr, lv, nil)
+			setLocal(fr, lv, fn(fr))
+			return flowNormal
+		}
+	case *FunctionStmt:
+		target := stmt.Name
+		if stmt.Method != nil {
+			target = &FieldExpr{Span: stmt.Span, X: stmt.Name, Name: stmt.Method}
+		}
+		store := c.compileTarget(target)
+		fn := c.compileFunction(stmt.Func)
+		return func(fr *frame) flow {
+			obj, key := store.prepare(fr)
+			store.store(fr, obj, key, fn(fr))
+			return flowNormal
+		}
+	case *AssignStmt:
+		return c.compileAssign(stmt)
+	case *CompoundAssignStmt:
+		return c.compileCompoundAssign(stmt)
+	case *CallStmt:
+		call := c.compileMulti(stmt.Call)
+		return func(fr *frame) flow {
+			call(fr)
+			return flowNormal
+		}
+	case *DoStmt:
+		return c.compileBlock(stmt.Body)
+	case *WhileStmt:
+		cond := c.compileExpr(stmt.Cond)
+		body := c.compileLoopBody(stmt, stmt.Body)
+		return func(fr *frame) flow {
+			for truthy(cond(fr)) {
+				fr.s.step()
+				switch body(fr) {
+				case flowBreak:
+					return flowNormal
+				case flowReturn:
+					return flowReturn
+				}
+			}
+			return flowNormal
+		}
+	case *RepeatStmt:
+		// the condition sees the locals of the body
+		c.openBlock()
+		c.fs.loops++
+		body := c.compileStmts(stmt.Body.Stmts)
+		c.fs.loops--
+		cond := c.compileExpr(stmt.Cond)
+		c.closeBlock()
+		if c.cover != nil {
+			cond = c.cover.countRepeat(stmt, cond)
+		}
+		return func(fr *frame) flow {
+			for {
+				fr.s.step()
+				switch body(fr) {
+				case flowBreak:
+					return flowNormal
+				case flowReturn:
+					return flowReturn
+				}
+				if truthy(cond(fr)) {
+					return flowNormal
+				}
+			}
+		}
+	case *IfStmt:
+		return c.compileIf(stmt)
+	case *NumericForStmt:
+		return c.compileNumericFor(stmt)
+	case *GenericForStmt:
+		return c.compileGenericFor(stmt)
+	case *ReturnStmt:
+		if len(stmt.Values) > 0 && c.fs.parent == nil && c.module {
+			c.errorAt(stmt.Pos(), "a module with exports cannot return values")
+		}
+		values := c.compileExprList(stmt.Values)
+		return func(fr *frame) flow {
+			fr.ret = values(fr)
+			return flowReturn
+		}
+	case *BreakStmt:
+		if c.fs.loops == 0 {
+			c.errorAt(stmt.Pos(), "break outside a loop")
+		}
+		return func(*frame) flow { return flowBreak }
+	case *ContinueStmt:
+		if c.fs.loops == 0 {
+			c.errorAt(stmt.Pos(), "continue outside a loop")
+		}
+		return func(*frame) flow { return flowContinue }
+	case *TypeStmt:
+		return nil
+	case *ImportStmt:
+		return c.compileImport(stmt)
+	case *ExportStmt:
+		return c.compileExport(stmt)
+	}
+	panic(fmt.Sprintf("luanova: unexpected statement %T", stmt))
+}
+
+func (c *compiler) compileLoopBody(loop Stmt, b *Block) execFn {
+	c.fs.loops++
+	body := c.compileBlock(b)
+	c.fs.loops--
+	if c.cover != nil {
+		body = c.cover.countLoop(loop, b, body)
+	}
+	return body
+}
+
+func (c *compiler) compileLocal(stmt *LocalStmt) execFn {
+	if len(stmt.Names) == 1 && len(stmt.Values) <= 1 {
+		var value evalFn
+		if len(stmt.Values) == 1 {
+			value = c.compileExpr(stmt.Values[0])
+		}
+		lv := c.declare(stmt.Names[0].Name.Name)
+		if value == nil {
+			return func(fr *frame) flow {
+				newLocal(fr, lv, nil)
+				return flowNormal
+			}
+		}
+		return func(fr *frame) flow {
+			newLocal(fr, lv, value(fr))
+			return flowNormal
+		}
+	}
+	values := c.compileExprList(stmt.Values)
+	lvs := make([]*localVar, len(stmt.Names))
+	for i, name := range stmt.Names {
+		lvs[i] = c.declare(name.Name.Name)
+	}
+	return func(fr *frame) flow {
+		vs := values(fr)
+		for i, lv := range lvs {
+			var v any
+			if i < len(vs) {
+				v = vs[i]
+			}
+			newLocal(fr, lv, v)
+		}
+		return flowNormal
+	}
+}
+
+// target is the left-hand side of an assignment. prepare evaluates the
+// table and key of indexed targets before the right-hand side.
+type target struct {
+	prepare func(fr *frame) (obj, key any)
+	load    func(fr *frame, obj, key any) any
+	store   func(fr *frame, obj, key, v any)
+}
+
+func noPrepare(*frame) (any, any) { return nil, nil }
+
+func (c *compiler) compileTarget(e Expr) target {
+	switch e := e.(type) {
+	case *Ident:
+		name := e.Name
+		if lv := c.fs.findLocal(name); lv != nil {
+			return target{
+				prepare: noPrepare,
+				load:    func(fr *frame, _, _ any) any { return getLocal(fr, lv) },
+				store:   func(fr *frame, _, _, v any) { setLocal(fr, lv, v) },
+			}
+		}
+		if i := c.fs.findUpvalue(name); i >= 0 {
+			return target{
+				prepare: noPrepare,
+				load:    func(fr *frame, _, _ any) any { return fr.fn.upvals[i].v },
+				store:   func(fr *frame, _, _, v any) { fr.fn.upvals[i].v = v },
+			}
+		}
+		return target{
+			prepare: noPrepare,
+			load:    func(fr *frame, _, _ any) any { return fr.s.index(fr.fn.env, name, "") },
+			store:   func(fr *frame, _, _, v any) { fr.s.setIndex(fr.fn.env, name, v, "") },
+		}
+	case *FieldExpr, *IndexExpr:
+		var x evalFn
+		var key evalFn
+		var desc string
+		if f, ok := e.(*FieldExpr); ok {
+			x, desc = c.compileExpr(f.X), c.describe(f.X)
+			name := f.Name.Name
+			key = func(*frame) any { return name }
+		} else {
+			ix := e.(*IndexExpr)
+			x, desc, key = c.compileExpr(ix.X), c.describe(ix.X), c.compileExpr(ix.Key)
+		}
+		line := e.Pos().Line
+		return target{
+			prepare: func(fr *frame) (any, any) { return x(fr), key(fr) },
+			load: func(fr *frame, obj, k any) any {
+				fr.line = line
+				return fr.s.index(obj, k, desc)
+			},
+			store: func(fr *frame, obj, k, v any) {
+				fr.line = line
+				if t, ok := obj.(*Table); ok && t.meta == nil && k != nil && !isNaN(k) {
+					fr.s.tableSet(t, k, v)
+					return
+				}
+				fr.s.setIndex(obj, k, v, desc)
+			},
+		}
+	}
+	c.errorAt(e.Pos(), "cannot assign to this expression")
+	return target{prepare: noPrepare, store: func(*frame, any, any, any) {}}
+}
+
+func (c *compiler) compileAssign(stmt *AssignStmt) execFn {
+	if len(stmt.Targets) == 1 && len(stmt.Values) == 1 {
+		t := c.compileTarget(stmt.Targets[0])
+		value := c.compileExpr(stmt.Values[0])
+		return func(fr *frame) flow {
+			obj, key := t.prepare(fr)
+			t.store(fr, obj, key, value(fr))
+			return flowNormal
+		}
+	}
+	targets := make([]target, len(stmt.Targets))
+	for i, e := range stmt.Targets {
+		targets[i] = c.compileTarget(e)
+	}
+	values := c.compileExprList(stmt.Values)
+	return func(fr *frame) flow {
+		objs := make([]any, 2*len(targets))
+		for i, t := range targets {
+			objs[2*i], objs[2*i+1] = t.prepare(fr)
+		}
+		vs := values(fr)
+		for i, t := range targets {
+			var v any
+			if i < len(vs) {
+				v = vs[i]
+			}
+			t.store(fr, objs[2*i], objs[2*i+1], v)
+		}
+		return flowNormal
+	}
+}
+
+func (c *compiler) compileCompoundAssign(stmt *CompoundAssignStmt) execFn {
+	t := c.compileTarget(stmt.Target)
+	value := c.compileExpr(stmt.Value)
+	op := stmt.Op
+	da, db := c.describe(stmt.Target), c.describe(stmt.Value)
+	return func(fr *frame) flow {
+		obj, key := t.prepare(fr)
+		a := t.load(fr, obj, key)
+		b := value(fr)
+		var r any
+		if op == Concat {
+			r = fr.s.concat(a, b, da, db)
+		} else {
+			r = fr.s.arith(op, a, b, da, db)
+		}
+		t.store(fr, obj, key, r)
+		return flowNormal
+	}
+}
+
+func (c *compiler) compileIf(stmt *IfStmt) execFn {
+	conds := make([]evalFn, len(stmt.Clauses))
+	bodies := make([]execFn, len(stmt.Clauses))
+	for i, clause := range stmt.Clauses {
+		conds[i] = c.compileExpr(clause.Cond)
+		bodies[i] = c.compileBlock(clause.Body)
+	}
+	var elseBody execFn
+	if stmt.Else != nil {
+		elseBody = c.compileBlock(stmt.Else)
+	}
+	if c.cover != nil {
+		elseBody = c.cover.countIf(stmt, bodies, elseBody)
+	}
+	return func(fr *frame) flow {
+		for i, cond := range conds {
+			if truthy(cond(fr)) {
+				return bodies[i](fr)
+			}
+		}
+		if elseBody != nil {
+			return elseBody(fr)
+		}
+		return flowNormal
+	}
+}
+
+func (c *compiler) compileNumericFor(stmt *NumericForStmt) execFn {
+	start := c.compileExpr(stmt.Start)
+	limit := c.compileExpr(stmt.Limit)
+	step := func(*frame) any { return int64(1) }
+	if stmt.Step != nil {
+		step = c.compileExpr(stmt.Step)
+	}
+	c.openBlock()
+	lv := c.declare(stmt.Var.Name.Name)
+	body := c.compileLoopBody(stmt, stmt.Body)
+	c.closeBlock()
+	run := func(fr *frame, v any) (done bool, f flow) {
+		fr.s.step()
+		newLocal(fr, lv, v)
+		switch f := body(fr); f {
+		case flowBreak:
+			return true, flowNormal
+		case flowReturn:
+			return true, flowReturn
+		}
+		return false, flowNormal
+	}
+	return func(fr *frame) flow {
+		s := fr.s
+		v0, v1, v2 := start(fr), limit(fr), step(fr)
+		i0, ok0 := v0.(int64)
+		st, ok2 := v2.(int64)
+		if ok0 && ok2 {
+			if st == 0 {
+				s.runtimeError("'for' step is zero")
+			}
+			lim, skip := forLimit(s, v1, st)
+			if skip || (st > 0 && i0 > lim) || (st < 0 && i0 < lim) {
+				return flowNormal
+			}
+			// iteration count computed in unsigned arithmetic, as in Lua
+			var count uint64
+			if st > 0 {
+				count = (uint64(lim) - uint64(i0)) / uint64(st)
+			} else {
+				count = (uint64(i0) - uint64(lim)) / (uint64(-(st + 1)) + 1)
+			}
+			i := i0
+			for {
+				if done, f := run(fr, i); done {
+					return f
+				}
+				if count == 0 {
+					return flowNormal
+				}
+				count--
+				i += st
+			}
+		}
+		f0, ok := toForNumber(v0)
+		if !ok {
+			s.runtimeError("'for' initial value must be a number")
+		}
+		f1, ok := toForNumber(v1)
+		if !ok {
+			s.runtimeError("'for' limit must be a number")
+		}
+		f2, ok := toForNumber(v2)
+		if !ok {
+			s.runtimeError("'for' step must be a number")
+		}
+		if f2 == 0 {
+			s.runtimeError("'for' step is zero")
+		}
+		for x := f0; (f2 > 0 && x <= f1) || (f2 < 0 && x >= f1); x += f2 {
+			if done, f := run(fr, x); done {
+				return f
+			}
+		}
+		return flowNormal
+	}
+}
+
+func toForNumber(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// forLimit converts the limit of an integer loop, clipping floats to the
+// integer range; skip reports a loop that runs zero times.
+func forLimit(s *State, v any, step int64) (lim int64, skip bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, false
+	case float64:
+		if math.IsNaN(v) {
+			return 0, true
+		}
+		var f float64
+		if step > 0 {
+			f = math.Floor(v)
+		} else {
+			f = math.Ceil(v)
+		}
+		switch {
+		case f >= 0x1p63:
+			if step < 0 {
+				return 0, true
+			}
+			return math.MaxInt64, false
+		case f < -0x1p63:
+			if step > 0 {
+				return 0, true
+			}
+			return math.MinInt64, false
+		}
+		return int64(f), false
+	}
+	s.runtimeError("'for' limit must be a number")
+	return 0, true
+}
+
+func (c *compiler) compileGenericFor(stmt *GenericForStmt) execFn {
+	exprs := c.compileExprList(stmt.Exprs)
+	c.openBlock()
+	lvs := make([]*localVar, len(stmt.Names))
+	for i, name := range stmt.Names {
+		lvs[i] = c.declare(name.Name.Name)
+	}
+	body := c.compileLoopBody(stmt, stmt.Body)
+	c.closeBlock()
+	return func(fr *frame) flow {
+		s := fr.s
+		vs := exprs(fr)
+		var f, state, control any
+		if len(vs) > 0 {
+			f = vs[0]
+		}
+		if len(vs) > 1 {
+			state = vs[1]
+		}
+		if len(vs) > 2 {
+			control = vs[2]
+		}
+		isNext := f == any(s.next)
+		t, isTable := state.(*Table)
+		for {
+			s.step()
+			var rs []any
+			if isNext && isTable {
+				// fast path for pairs over a plain table
+				k, v, ok := t.Next(control)
+				if !ok {
+					s.runtimeError("invalid key to 'next'")
+				}
+				rs = []any{k, v}
+			} else {
+				rs = s.call(f, []any{state, control})
+			}
+			if len(rs) == 0 || rs[0] == nil {
+				return flowNormal
+			}
+			control = rs[0]
+			for i, lv := range lvs {
+				var v any
+				if i < len(rs) {
+					v = rs[i]
+				}
+				newLocal(fr, lv, v)
+			}
+			switch body(fr) {
+			case flowBreak:
+				return flowNormal
+			case flowReturn:
+				return flowReturn
+			}
+		}
+	}
+}
+
+// Modules
+
+func (c *compiler) compileImport(stmt *ImportStmt) execFn {
+	if !c.atTopLevel() {
+		c.errorAt(stmt.Pos(), "import must be at the top level of a chunk")
+	}
+	from, path := c.source, stmt.Path.Value
+	if stmt.Namespace != nil {
+		lv := c.declare(stmt.Namespace.Name)
+		return func(fr *frame) flow {
+			newLocal(fr, lv, fr.s.importModule(from, path))
+			return flowNormal
+		}
+	}
+	names := make([]string, len(stmt.Names))
+	lvs := make([]*localVar, len(stmt.Names))
+	for i, spec := range stmt.Names {
+		names[i] = spec.Name.Name
+		lvs[i] = c.declare(spec.LocalName().Name)
+	}
+	return func(fr *frame) flow {
+		mod := fr.s.importModule(from, path)
+		for i, lv := range lvs {
+			newLocal(fr, lv, fr.s.importName(mod, path, names[i]))
+		}
+		return flowNormal
+	}
+}
+
+func (c *compiler) compileExport(stmt *ExportStmt) execFn {
+	if !c.atTopLevel() {
+		c.errorAt(stmt.Pos(), "export must be at the top level of a chunk")
+	}
+	if stmt.Decl == nil {
+		for _, spec := range stmt.Names {
+			lv := c.fs.findLocal(spec.Name.Name)
+			if lv == nil {
+				c.errorAt(spec.Name.Pos(), "cannot export '%s': not a local", spec.Name.Name)
+				continue
+			}
+			c.addExport(spec.LocalName().Name, lv, spec.Pos())
+		}
+		return nil
+	}
+	exec := c.compileStmtBody(stmt.Decl)
+	switch decl := stmt.Decl.(type) {
+	case *LocalStmt:
+		for _, b := range decl.Names {
+			c.addExport(b.Name.Name, c.fs.findLocal(b.Name.Name), b.Pos())
+		}
+	case *LocalFunctionStmt:
+		c.addExport(decl.Name.Name, c.fs.findLocal(decl.Name.Name), decl.Name.Pos())
+	case *TypeStmt:
+		c.addExport(decl.Name.Name, nil, decl.Name.Pos())
+	default:
+		c.errorAt(stmt.Decl.Pos(), "only local, function and type declarations can be exported")
+	}
+	return exec
+}
+
+func (c *compiler) addExport(name string, lv *localVar, pos Position) {
+	for _, e := range c.exports {
+		// a type and a value may share a name
+		if e.name == name && (e.lv == nil) == (lv == nil) {
+			c.errorAt(pos, "duplicate export '%s'", name)
+			return
+		}
+	}
+	c.exports = append(c.exports, exportVar{name, lv})
+}
+
+// Functions
+
+func (c *compiler) compileFunction(e *FunctionExpr) evalFn {
+	p := &funcProto{
+		name:     e.Name,
+		source:   c.source,
+		pos:      e.Pos(),
+		line:     e.Pos().Line,
+		lastLine: e.End().Line,
+		isVararg: e.IsVararg,
+	}
+	c.openFunction(p)
+	for _, param := range e.Params {
+		p.params = append(p.params, c.declare(param.Name.Name))
+	}
+	body := c.compileStmts(e.Body.Stmts)
+	c.closeFunction()
+	p.body = func(fr *frame) { body(fr) }
+	return func(fr *frame) any {
+		fr.s.alloc(closureSize)
+		fn := &Closure{proto: p, env: fr.fn.env}
+		if len(p.upvals) > 0 {
+			fn.upvals = make([]*cell, len(p.upvals))
+			for i, u := range p.upvals {
+				if u.fromLocal {
+					fn.upvals[i] = fr.slots[u.index].(*cell)
+				} else {
+					fn.upvals[i] = fr.fn.upvals[u.index]
+				}
+			}
+		}
+		return fn
+	}
+}
+
+// Expressions
+
+// describe names an expression for error messages, like "local 'x'".
+func (c *compiler) describe(e Expr) string {
+	switch e := e.(type) {
+	case *Ident:
+		if c.fs.findLocal(e.Name) != nil {
+			return "local '" + e.Name + "'"
+		}
+		for _, u := range c.fs.proto.upvals {
+			if u.name == e.Name {
+				return "upvalue '" + e.Name + "'"
+			}
+		}
+		if c.fs.parent != nil && c.isOuterLocal(e.Name) {
+			return "upvalue '" + e.Name + "'"
+		}
+		return "global '" + e.Name + "'"
+	case *FieldExpr:
+		return "field '" + e.Name.Name + "'"
+	case *IndexExpr:
+		if k, ok := e.Key.(*StringExpr); ok {
+			return "field '" + k.Value + "'"
+		}
+	case *MethodCallExpr:
+		return "method '" + e.Name.Name + "'"
+	case *StringExpr:
+		return "constant '" + e.Value + "'"
+	}
+	return ""
+}
+
+func (c *compiler) isOuterLocal(name string) bool {
+	for fs := c.fs.parent; fs != nil; fs = fs.parent {
+		if fs.findLocal(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// compileMulti compiles an expression that can produce several values.
+func (c *compiler) compileMulti(e Expr) multiFn {
+	switch e := e.(type) {
+	case *CallExpr:
+		fn := c.compileExpr(e.Fn)
+		args := c.compileExprList(e.Args)
+		desc := c.describe(e.Fn)
+		line := e.Fn.End().Line
+		return func(fr *frame) []any {
+			f := fn(fr)
+			a := args(fr)
+			fr.line = line
+			return fr.s.callDesc(f, a, desc)
+		}
+	case *MethodCallExpr:
+		recv := c.compileExpr(e.Recv)
+		args := c.compileExprList(e.Args)
+		name := e.Name.Name
+		rdesc, desc := c.describe(e.Recv), c.describe(e)
+		line := e.Name.Pos().Line
+		return func(fr *frame) []any {
+			r := recv(fr)
+			fr.line = line
+			f := fr.s.index(r, name, rdesc)
+			a := args(fr)
+			full := make([]any, len(a)+1)
+			full[0] = r
+			copy(full[1:], a)
+			fr.line = line
+			return fr.s.callDesc(f, full, desc)
+		}
+	case *VarargExpr:
+		return func(fr *frame) []any { return fr.varargs }
+	}
+	return nil
+}
+
+func isMulti(e Expr) bool {
+	switch e.(type) {
+	case *CallExpr, *MethodCallExpr, *VarargExpr:
+		return true
+	}
+	return false
+}
+
+// compileExprList compiles a list whose last expression is expanded.
+func (c *compiler) compileExprList(list []Expr) multiFn {
+	if len(list) == 0 {
+		return func(*frame) []any { return nil }
+	}
+	last := list[len(list)-1]
+	if len(list) == 1 && isMulti(last) {
+		return c.compileMulti(last)
+	}
+	fixed := list
+	var tail multiFn
+	if isMulti(last) {
+		fixed = list[:len(list)-1]
+		tail = c.compileMulti(last)
+	}
+	evals := make([]evalFn, len(fixed))
+	for i, e := range fixed {
+		evals[i] = c.compileExpr(e)
+	}
+	return func(fr *frame) []any {
+		vs := make([]any, len(evals), len(evals)+4)
+		for i, eval := range evals {
+			vs[i] = eval(fr)
+		}
+		if tail != nil {
+			vs = append(vs, tail(fr)...)
+		}
+		return vs
+	}
+}
+
+func (c *compiler) compileExpr(e Expr) evalFn {
+	switch e := e.(type) {
+	case *NilExpr:
+		return func(*frame) any { return nil }
+	case *BoolExpr:
+		v := e.Value
+		return func(*frame) any { return v }
+	case *NumberExpr:
+		v := e.Value
+		return func(*frame) any { return v }
+	case *StringExpr:
+		v := e.Value
+		return func(*frame) any { return v }
+	case *VarargExpr:
+		return func(fr *frame) any { return first(fr.varargs) }
+	case *FunctionExpr:
+		return c.compileFunction(e)
+	case *TableExpr:
+		return c.compileTable(e)
+	case *ParenExpr:
+		return c.compileExpr(e.X)
+	case *Ident:
+		return c.compileIdent(e)
+	case *FieldExpr:
+		x := c.compileExpr(e.X)
+		name := e.Name.Name
+		desc := c.describe(e.X)
+		line := e.Pos().Line
+		return func(fr *frame) any {
+			obj := x(fr)
+			if t, ok := obj.(*Table); ok {
+				if v := t.Get(name); v != nil || t.meta == nil {
+					return v
+				}
+			}
+			fr.line = line
+			return fr.s.index(obj, name, desc)
+		}
+	case *IndexExpr:
+		x := c.compileExpr(e.X)
+		key := c.compileExpr(e.Key)
+		desc := c.describe(e.X)
+		return func(fr *frame) any {
+			obj, k := x(fr), key(fr)
+			if t, ok := obj.(*Table); ok {
+				if v := t.Get(k); v != nil || t.meta == nil {
+					return v
+				}
+			}
+			return fr.s.index(obj, k, desc)
+		}
+	case *CallExpr, *MethodCallExpr:
+		call := c.compileMulti(e)
+		return func(fr *frame) any { return first(call(fr)) }
+	case *UnaryExpr:
+		return c.compileUnary(e)
+	case *BinaryExpr:
+		return c.compileBinary(e)
+	}
+	panic(fmt.Sprintf("luanova: unexpected expression %T", e))
+}
+
+func (c *compiler) compileIdent(e *Ident) evalFn {
+	name := e.Name
+	if lv := c.fs.findLocal(name); lv != nil {
+		return func(fr *frame) any { return getLocal(fr, lv) }
+	}
+	if i := c.fs.findUpvalue(name); i >= 0 {
+		return func(fr *frame) any { return fr.fn.upvals[i].v }
+	}
+	return func(fr *frame) any {
+		env := fr.fn.env
+		if v := env.Get(name); v != nil || env.meta == nil {
+			return v
+		}
+		return fr.s.index(env, name, "")
+	}
+}
+
+func (c *compiler) compileTable(e *TableExpr) evalFn {
+	type field struct {
+		key   evalFn
+		value evalFn
+		pos   int64 // positional index, 0 for keyed fields
+	}
+	var fields []field
+	var tail multiFn
+	var npos, nhash int
+	for i, f := range e.Fields {
+		switch {
+		case f.Key == nil && i == len(e.Fields)-1 && isMulti(f.Value):
+			tail = c.compileMulti(f.Value)
+		case f.Key == nil:
+			npos++
+			fields = append(fields, field{value: c.compileExpr(f.Value), pos: int64(npos)})
+		case f.Named:
+			name := f.Key.(*Ident).Name
+			nhash++
+			fields = append(fields, field{key: func(*frame) any { return name }, value: c.compileExpr(f.Value)})
+		default:
+			nhash++
+			fields = append(fields, field{key: c.compileExpr(f.Key), value: c.compileExpr(f.Value)})
+		}
+	}
+	return func(fr *frame) any {
+		fr.s.alloc(tableSize + slotSize*(npos+nhash))
+		t := newTableSize(npos, nhash)
+		for _, f := range fields {
+			if f.pos > 0 {
+				t.Set(f.pos, f.value(fr))
+				continue
+			}
+			k := f.key(fr)
+			fr.s.rawSet(t, k, f.value(fr))
+		}
+		if tail != nil {
+			for i, v := range tail(fr) {
+				fr.s.tableSet(t, int64(npos+i+1), v)
+			}
+		}
+		return t
+	}
+}
+
+func (c *compiler) compileUnary(e *UnaryExpr) evalFn {
+	x := c.compileExpr(e.X)
+	desc := c.describe(e.X)
+	switch e.Op {
+	case Not:
+		return func(fr *frame) any { return !truthy(x(fr)) }
+	case Sub:
+		return func(fr *frame) any {
+			switch v := x(fr).(type) {
+			case int64:
+				return -v
+			case float64:
+				return -v
+			default:
+				return fr.s.unm(v, desc)
+			}
+		}
+	case Len:
+		return func(fr *frame) any {
+			v := x(fr)
+			if s, ok := v.(string); ok {
+				return int64(len(s))
+			}
+			return fr.s.length(v, desc)
+		}
+	}
+	panic(fmt.Sprintf("luanova: unexpected unary operator %d", e.Op))
+}
+
+func (c *compiler) compileBinary(e *BinaryExpr) evalFn {
+	a := c.compileExpr(e.Left)
+	b := c.compileExpr(e.Right)
+	da, db := c.describe(e.Left), c.describe(e.Right)
+	line := e.OpPos.Line
+	if c.cover != nil && (e.Op == And || e.Op == Or) {
+		return c.cover.countLogical(e, a, b)
+	}
+	switch op := e.Op; op {
+	case And:
+		return func(fr *frame) any {
+			if v := a(fr); !truthy(v) {
+				return v
+			}
+			return b(fr)
+		}
+	case Or:
+		return func(fr *frame) any {
+			if v := a(fr); truthy(v) {
+				return v
+			}
+			return b(fr)
+		}
+	case Plus, Sub, Multi:
+		return func(fr *frame) any {
+			x, y := a(fr), b(fr)
+			if i, ok := x.(int64); ok {
+				if j, ok := y.(int64); ok {
+					switch op {
+					case Plus:
+						return i + j
+					case Sub:
+						return i - j
+					}
+					return i * j
+				}
+			}
+			fr.line = line
+			return fr.s.arith(op, x, y, da, db)
+		}
+	case Div, Mod, Po:
+		return func(fr *frame) any {
+			x, y := a(fr), b(fr)
+			fr.line = line
+			return fr.s.arith(op, x, y, da, db)
+		}
+	case Concat:
+		return func(fr *frame) any {
+			x, y := a(fr), b(fr)
+			fr.line = line
+			if s, ok := x.(string); ok {
+				if t, ok := y.(string); ok {
+					fr.s.allocString(len(s) + len(t))
+					return s + t
+				}
+			}
+			return fr.s.concat(x, y, da, db)
+		}
+	case Equal:
+		return func(fr *frame) any { return fr.s.equal(a(fr), b(fr)) }
+	case NotEqual:
+		return func(fr *frame) any { return !fr.s.equal(a(fr), b(fr)) }
+	case Less:
+		return func(fr *frame) any {
+			x, y := a(fr), b(fr)
+			if i, ok := x.(int64); ok {
+				if j, ok := y.(int64); ok {
+					return i < j
+				}
+			}
+			fr.line = line
+			return fr.s.lessThan(x, y)
+		}
+	case LessEqual:
+		return func(fr *frame) any {
+			x, y := a(fr), b(fr)
+			fr.line = line
+			return fr.s.lessEqual(x, y)
+		}
+	case Greater:
+		return func(fr *frame) any {
+			x, y := a(fr), b(fr)
+			if i, ok := x.(int64); ok {
+				if j, ok := y.(int64); ok {
+					return i > j
+				}
+			}
+			fr.line = line
+			return fr.s.lessThan(y, x)
+		}
+	case GreaterEqual:
+		return func(fr *frame) any {
+			x, y := a(fr), b(fr)
+			fr.line = line
+			return fr.s.lessEqual(y, x)
+		}
+	}
+	panic(fmt.Sprintf("luanova: unexpected binary operator %d", e.Op))
+}
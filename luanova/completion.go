@@ -0,0 +1,771 @@
+package luanova
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Completion item kinds of the protocol.
+const (
+	completionMethod   = 2
+	completionFunction = 3
+	completionField    = 5
+	completionVariable = 6
+	completionClass    = 7
+	completionModule   = 9
+	completionKeyword  = 14
+	completionSnippet  = 15
+)
+
+// snippetFormat is the insert text format of snippets.
+const snippetFormat = 2
+
+type lspCompletionItem struct {
+	Label            string      `json:"label"`
+	Kind             int         `json:"kind"`
+	Detail           string      `json:"detail,omitempty"`
+	Documentation    *lspMarkup  `json:"documentation,omitempty"`
+	SortText         string      `json:"sortText"`
+	InsertTextFormat int         `json:"insertTextFormat,omitempty"`
+	TextEdit         lspTextEdit `json:"textEdit"`
+}
+
+type lspCompletionList struct {
+	IsIncomplete bool                `json:"isIncomplete"`
+	Items        []lspCompletionItem `json:"items"`
+}
+
+// completionSnippets are offered with the names where a statement can
+// start.
+var completionSnippets = []struct{ label, detail, body string }{
+	{"for", "for i = 1, n do ... end", "for ${1:i} = ${2:1}, ${3:n} do\n\t$0\nend"},
+	{"for in", "for k, v in pairs(t) do ... end", "for ${1:k}, ${2:v} in pairs(${3:t}) do\n\t$0\nend"},
+	{"if", "if cond then ... end", "if ${1:cond} then\n\t$0\nend"},
+	{"function", "function name() ... end", "function ${1:name}(${2})\n\t$0\nend"},
+}
+
+// Ranks of the completion items, the first part of their sort text.
+const (
+	rankLocal = iota
+	rankGlobal
+	rankStandard
+	rankKeyword
+)
+
+// completer gathers the completion items of the word ending at offset.
+type completer struct {
+	doc    *document
+	index  *workspaceIndex
+	chunk  *Chunk
+	res    *Resolution
+	inf    *inference
+	offset int
+	start  int // of the word
+	prefix string
+
+	scopes [][]*Variable // visible at offset, outermost first
+	fn     *FunctionExpr // holding offset, nil in the main chunk
+	self   string        // path of the table of the method holding offset
+
+	uses     map[string]int // of the paths of fields
+	bindings map[*Ident]moduleBinding
+	items    []lspCompletionItem
+	seen     map[string]bool
+}
+
+// completion returns the items completing the word at p. The context
+// is found with the lexer, so that it works in incomplete code: a
+// member after a dot, a type or a method after a colon, otherwise a
+// visible name, a keyword or a snippet. The names are those of the
+// chunk the parser recovers when the text does not parse. Items are
+// ranked by the distance of their scope, then by their uses.
+func (doc *document) completion(p lspPosition, index *workspaceIndex) lspCompletionList {
+	list := lspCompletionList{Items: []lspCompletionItem{}}
+	c := &completer{doc: doc, index: index, offset: doc.offset(p), seen: map[string]bool{}}
+	toks, ok := tokensBefore(doc.text, c.offset)
+	if !ok {
+		return list // in a string or a comment
+	}
+	c.start = c.offset
+	if n := len(toks); n > 0 && toks[n-1].End.Offset == c.offset && isWordToken(toks[n-1]) {
+		c.start, c.prefix = toks[n-1].Pos.Offset, toks[n-1].Literal
+		toks = toks[:n-1]
+	}
+	doc.analyze()
+	c.chunk, c.res, c.inf = doc.chunk, doc.res, doc.types
+	if c.chunk == nil {
+		c.chunk, _ = Parse(doc.uri, doc.text) // recovered from the errors
+		c.res = Resolve(c.chunk)
+		c.inf = inferTypes(doc.text, c.chunk, c.res)
+	}
+	c.block(c.chunk.Block.Stmts)
+
+	var last Token
+	if len(toks) > 0 {
+		last = toks[len(toks)-1]
+	}
+	switch {
+	case last.Type == Dot:
+		c.members(receiverPath(toks[:len(toks)-1]), false)
+	case last.Type == Colom && annotates(toks), last.Type == Arrow:
+		c.types()
+	case last.Type == Colom:
+		c.members(receiverPath(toks[:len(toks)-1]), true)
+	case last.Type == Local:
+		c.add("function", completionKeyword, "", rankKeyword, 0, 0)
+	case last.Type == Function:
+		// the name of a new function
+	default:
+		c.names()
+		c.keywords()
+	}
+	sort.SliceStable(c.items, func(i, j int) bool {
+		if c.items[i].SortText != c.items[j].SortText {
+			return c.items[i].SortText < c.items[j].SortText
+		}
+		return c.items[i].Label < c.items[j].Label
+	})
+	list.Items = append(list.Items, c.items...)
+	return list
+}
+
+// tokensBefore returns the tokens of src before offset without the
+// comments, or false if offset is in a comment or a string.
+func tokensBefore(src string, offset int) ([]Token, bool) {
+	var toks []Token
+	l := NewLexer(src)
+	for tok := l.NextToken(); tok.Type != EOF && tok.Pos.Offset < offset; tok = l.NextToken() {
+		switch {
+		case tok.Type == Comment && offset <= tok.End.Offset,
+			tok.Type == CommentBlock && offset < tok.End.Offset:
+			return nil, false
+		case tok.Type == StringDelim && offset < tok.End.Offset:
+			return nil, false
+		case tok.Type == StringDelim && offset == tok.End.Offset:
+			if s := src[tok.Pos.Offset:tok.End.Offset]; len(s) < 2 || s[len(s)-1] != s[0] {
+				return nil, false // not closed yet
+			}
+		case tok.Type == Comment || tok.Type == CommentBlock:
+			continue
+		}
+		toks = append(toks, tok)
+	}
+	return toks, true
+}
+
+// isWordToken reports whether t is a name or a keyword, which the
+// completion replaces when it ends at the cursor.
+func isWordToken(t Token) bool {
+	return isIdentToken(t) || keywords[t.Literal] == t.Type && t.Type != Illegal
+}
+
+// receiverPath returns "a.b" for the names and dots ending toks, or "".
+func receiverPath(toks []Token) string {
+	var parts []string
+	for i := len(toks) - 1; i >= 0 && isIdentToken(toks[i]); i -= 2 {
+		parts = append([]string{toks[i].Literal}, parts...)
+		if i == 0 || toks[i-1].Type != Dot {
+			break
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// annotates reports whether the colon ending toks starts a type rather
+// than a method name: after the name of a local, a loop variable, a
+// parameter or a field of a table type, or after the parameters of a
+// function.
+func annotates(toks []Token) bool {
+	n := len(toks) - 1
+	if n > 0 && toks[n-1].Type == RParen {
+		depth := 0
+		for i := n - 1; i >= 0; i-- {
+			switch toks[i].Type {
+			case RParen:
+				depth++
+			case LParen:
+				if depth--; depth == 0 {
+					return declaresFunction(toks, i)
+				}
+			}
+		}
+		return false
+	}
+	if n == 0 || !isIdentToken(toks[n-1]) {
+		return false
+	}
+	depth := 0
+	for i := n - 2; i >= 0; i-- {
+		switch t := toks[i].Type; {
+		case t == RParen || t == RBrace || t == RBrack:
+			depth++
+		case depth > 0:
+			if t == LParen || t == LBrace || t == LBrack {
+				depth--
+			}
+		case t == LParen:
+			return declaresFunction(toks, i)
+		case t == Local || t == For:
+			return true
+		case isIdentToken(toks[i]), t == Comma, t == Colom, t == Dot, t == Question,
+			t == Arrow, t == Less, t == Greater, t == LBrace:
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// declaresFunction reports whether the parenthesis at toks[i] opens the
+// parameters of a function.
+func declaresFunction(toks []Token, i int) bool {
+	j := i - 1
+	if j >= 0 && toks[j].Type == Greater { // type parameters
+		for j >= 0 && toks[j].Type != Less {
+			j--
+		}
+		j--
+	}
+	for j >= 0 && isIdentToken(toks[j]) {
+		if j == 0 || toks[j-1].Type != Dot && toks[j-1].Type != Colom {
+			j--
+			break
+		}
+		j -= 2
+	}
+	return j >= 0 && toks[j].Type == Function
+}
+
+// inside reports whether offset is in n or at its end.
+func (c *completer) inside(n Node) bool {
+	return n.Pos().Offset < c.offset && c.offset <= n.End().Offset
+}
+
+// block records the locals of stmts visible at offset and descends into
+// the statement holding it, as the resolver scopes them.
+func (c *completer) block(stmts []Stmt) {
+	c.scopes = append(c.scopes, nil)
+	for _, s := range stmts {
+		switch {
+		case s.Pos().Offset >= c.offset:
+			return
+		case s.End().Offset < c.offset:
+			c.declared(s)
+		default:
+			c.enter(s)
+			return
+		}
+	}
+}
+
+func (c *completer) declare(id *Ident) {
+	if v := c.res.VariableOf(id); v != nil {
+		top := len(c.scopes) - 1
+		c.scopes[top] = append(c.scopes[top], v)
+	}
+}
+
+// declared records the locals a statement before offset declares.
+func (c *completer) declared(s Stmt) {
+	switch s := s.(type) {
+	case *LocalStmt:
+		for _, b := range s.Names {
+			c.declare(b.Name)
+		}
+	case *LocalFunctionStmt:
+		c.declare(s.Name)
+	case *ImportStmt:
+		if s.Namespace != nil {
+			c.declare(s.Namespace)
+		}
+		for _, spec := range s.Names {
+			c.declare(spec.LocalName())
+		}
+	case *ExportStmt:
+		if s.Decl != nil {
+			c.declared(s.Decl)
+		}
+	}
+}
+
+// enter descends into the statement holding offset.
+func (c *completer) enter(s Stmt) {
+	switch s := s.(type) {
+	case *LocalStmt:
+		c.exprs(s.Values...) // the names are not visible in the values
+	case *LocalFunctionStmt:
+		c.declare(s.Name)
+		c.function(s.Func)
+	case *FunctionStmt:
+		if s.Method != nil {
+			c.self = exprPath(s.Name)
+		}
+		c.function(s.Func)
+	case *ExportStmt:
+		if s.Decl != nil {
+			c.enter(s.Decl)
+		}
+	case *AssignStmt:
+		c.exprs(s.Targets...)
+		c.exprs(s.Values...)
+	case *CompoundAssignStmt:
+		c.exprs(s.Target, s.Value)
+	case *CallStmt:
+		c.exprs(s.Call)
+	case *ReturnStmt:
+		c.exprs(s.Values...)
+	case *DoStmt:
+		c.block(s.Body.Stmts)
+	case *WhileStmt:
+		if c.offset <= s.Cond.End().Offset {
+			c.exprs(s.Cond)
+		} else {
+			c.block(s.Body.Stmts)
+		}
+	case *RepeatStmt:
+		// the condition sees the locals of the body
+		c.block(s.Body.Stmts)
+		if s.Cond != nil {
+			c.exprs(s.Cond)
+		}
+	case *IfStmt:
+		for i, cl := range s.Clauses {
+			if i+1 < len(s.Clauses) && c.offset >= s.Clauses[i+1].Pos().Offset {
+				continue
+			}
+			if i+1 == len(s.Clauses) && s.Else != nil && c.afterElse(cl.End().Offset, s.Else.Pos().Offset) {
+				c.block(s.Else.Stmts)
+			} else if c.offset <= cl.Cond.End().Offset {
+				c.exprs(cl.Cond)
+			} else {
+				c.block(cl.Body.Stmts)
+			}
+			return
+		}
+	case *NumericForStmt:
+		header := []Expr{s.Start, s.Limit}
+		if s.Step != nil {
+			header = append(header, s.Step)
+		}
+		if c.offset <= header[len(header)-1].End().Offset {
+			c.exprs(header...)
+			return
+		}
+		c.scopes = append(c.scopes, nil)
+		c.declare(s.Var.Name)
+		c.block(s.Body.Stmts)
+	case *GenericForStmt:
+		if n := len(s.Exprs); n > 0 && c.offset <= s.Exprs[n-1].End().Offset {
+			c.exprs(s.Exprs...)
+			return
+		}
+		c.scopes = append(c.scopes, nil)
+		for _, b := range s.Names {
+			c.declare(b.Name)
+		}
+		c.block(s.Body.Stmts)
+	}
+}
+
+// afterElse reports whether offset follows the else keyword between
+// from and to.
+func (c *completer) afterElse(from, to int) bool {
+	l := NewLexer(c.doc.text[from:to])
+	for tok := l.NextToken(); tok.Type != EOF; tok = l.NextToken() {
+		if tok.Type == Else {
+			return c.offset >= from+tok.End.Offset
+		}
+	}
+	return false
+}
+
+// exprs descends into the function expression holding offset, if any.
+func (c *completer) exprs(exprs ...Expr) {
+	for _, e := range exprs {
+		if e == nil || !c.inside(e) {
+			continue
+		}
+		Inspect(e, func(n Node) bool {
+			if fn, ok := n.(*FunctionExpr); ok && c.inside(fn) {
+				c.function(fn)
+				return false
+			}
+			return c.inside(n)
+		})
+		return
+	}
+}
+
+func (c *completer) function(fn *FunctionExpr) {
+	c.fn = fn
+	c.scopes = append(c.scopes, nil)
+	for _, p := range fn.Params {
+		c.declare(p.Name)
+	}
+	c.block(fn.Body.Stmts)
+}
+
+// add adds an item unless one with that label was added before or it
+// does not match the word; the sort text orders by rank, then distance,
+// then uses.
+func (c *completer) add(label string, kind int, detail string, rank, distance, uses int) *lspCompletionItem {
+	if c.seen[label] {
+		return nil
+	}
+	if _, ok := matchName(c.prefix, label); !ok {
+		return nil
+	}
+	c.seen[label] = true
+	c.items = append(c.items, lspCompletionItem{
+		Label:    label,
+		Kind:     kind,
+		Detail:   detail,
+		SortText: fmt.Sprintf("%d%02d%04d", rank, min(distance, 99), 9999-min(uses, 9999)),
+		TextEdit: c.doc.edit(c.start, c.offset, label),
+	})
+	return &c.items[len(c.items)-1]
+}
+
+// local returns the visible local named name and the distance of its
+// scope, or nil.
+func (c *completer) local(name string) (*Variable, int) {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		vars := c.scopes[i]
+		for j := len(vars) - 1; j >= 0; j-- {
+			if vars[j].Name.Name == name {
+				return vars[j], len(c.scopes) - 1 - i
+			}
+		}
+	}
+	return nil, 0
+}
+
+// names adds the visible locals, innermost first, then the globals of
+// the chunk and the standard ones.
+func (c *completer) names() {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		vars := c.scopes[i]
+		for j := len(vars) - 1; j >= 0; j-- {
+			v := vars[j]
+			kind := completionVariable
+			switch {
+			case c.inf.funcs[v] != nil:
+				kind = completionFunction
+			default:
+				if b, ok := c.binding(v); ok && b.namespace {
+					kind = completionModule
+				}
+			}
+			detail := c.inf.describe(v)
+			if v.Func != c.fn {
+				detail += " (upvalue)"
+			}
+			if item := c.add(v.Name.Name, kind, detail, rankLocal, len(c.scopes)-1-i, len(v.Reads)+len(v.Writes)); item != nil && c.inf.docs[v] != "" {
+				item.Documentation = &lspMarkup{"markdown", c.inf.docs[v]}
+			}
+		}
+	}
+	uses := map[string]int{}
+	for _, g := range c.res.Globals {
+		if g.Name.Pos().Offset != c.start {
+			uses[g.Name.Name]++
+		}
+	}
+	std := standardGlobals()
+	for _, name := range sortedKeys(uses) {
+		if _, ok := std[name]; ok {
+			continue
+		}
+		kind, detail := completionVariable, "global "+name
+		if d := c.inf.paths[name]; d != nil {
+			kind = completionFunction
+			detail, _ = d.signature(c.inf, false)
+			detail = "function " + detail
+		}
+		c.add(name, kind, detail, rankGlobal, 0, uses[name])
+	}
+	for _, name := range sortedKeys(std) {
+		kind := completionVariable
+		switch std[name] {
+		case semNamespace:
+			kind = completionModule
+		case semFunction:
+			kind = completionFunction
+		}
+		c.add(name, kind, "", rankStandard, 0, uses[name])
+	}
+}
+
+// keywords adds the keywords and the snippets.
+func (c *completer) keywords() {
+	for _, s := range completionSnippets {
+		label := s.label + " … end"
+		if _, ok := matchName(c.prefix, s.label); !ok || c.seen[label] {
+			continue
+		}
+		c.seen[label] = true
+		c.items = append(c.items, lspCompletionItem{
+			Label:            label,
+			Kind:             completionSnippet,
+			Detail:           s.detail,
+			SortText:         fmt.Sprintf("%d%s", rankKeyword, s.label),
+			InsertTextFormat: snippetFormat,
+			TextEdit:         c.doc.edit(c.start, c.offset, s.body),
+		})
+	}
+	for _, kw := range sortedKeys(keywords) {
+		c.add(kw, completionKeyword, "", rankKeyword, 0, 0)
+	}
+	for _, kw := range []string{"export", "import"} {
+		c.add(kw, completionKeyword, "", rankKeyword, 0, 0)
+	}
+}
+
+// types adds the type names: those declared in the chunk and imported,
+// then the builtin ones.
+func (c *completer) types() {
+	for _, name := range sortedKeys(c.inf.aliases) {
+		s := c.inf.aliases[name]
+		c.add(name, completionClass, "type "+name+" = "+typeString(s.Type), rankLocal, 0, 0)
+	}
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		for _, v := range c.scopes[i] {
+			if v.Kind != VarImport {
+				continue
+			}
+			for _, sym := range c.moduleMembers(v) {
+				if b, _ := c.binding(v); sym.kind == symbolClass && !b.namespace {
+					c.add(v.Name.Name, completionClass, "type "+sym.name+" = "+sym.detail, rankLocal, 0, 0)
+				}
+			}
+		}
+	}
+	for _, name := range sortedKeys(builtinTypes) {
+		c.add(name, completionKeyword, "", rankStandard, 0, 0)
+	}
+}
+
+// members adds the fields of path after a dot, or its methods after a
+// colon: those of its inferred type, the functions declared and the
+// fields assigned on it, the members of the module it names and those
+// of the standard library it names.
+func (c *completer) members(path string, colon bool) {
+	if path == "" {
+		return
+	}
+	first, rest, _ := strings.Cut(path, ".")
+	v, _ := c.local(first)
+	if v != nil && v.Kind == VarSelf && c.self != "" {
+		path = c.self
+		if rest != "" {
+			path += "." + rest
+		}
+		first, rest, _ = strings.Cut(path, ".")
+		v, _ = c.local(first)
+	}
+	switch {
+	case v != nil:
+		t := c.inf.varType(v)
+		for _, name := range strings.Split(rest, ".") {
+			if name != "" {
+				t = c.field(t, name)
+			}
+		}
+		switch t := c.inf.underlying(t).(type) {
+		case *TableType:
+			for _, f := range t.Fields {
+				if f.Name == nil {
+					continue
+				}
+				_, isFunc := f.Value.(*FunctionType)
+				switch {
+				case isFunc:
+					c.add(f.Name.Name, completionMethod, typeString(f.Value), rankLocal, 0, c.fieldUses(path+"."+f.Name.Name))
+				case !colon:
+					c.add(f.Name.Name, completionField, typeString(f.Value), rankLocal, 0, c.fieldUses(path+"."+f.Name.Name))
+				}
+			}
+		case *NamedType:
+			if t.Name == "string" && colon {
+				c.library("string", true)
+			}
+		}
+		if rest == "" {
+			for _, sym := range c.moduleMembers(v) {
+				kind := completionFunction
+				switch sym.kind {
+				case symbolMethod:
+					kind = completionMethod
+				case symbolClass:
+					kind = completionClass
+				case symbolVariable:
+					kind = completionField
+				}
+				if !colon || kind == completionMethod {
+					c.add(sym.name, kind, sym.detail, rankLocal, 0, c.fieldUses(path+"."+sym.name))
+				}
+			}
+		}
+	case rest == "" && standardMembers(first) != nil:
+		c.library(first, colon)
+	}
+	c.declaredMembers(path, colon)
+}
+
+// field returns the type of the field name of a value of type t.
+func (c *completer) field(t TypeExpr, name string) TypeExpr {
+	if t, ok := c.inf.underlying(t).(*TableType); ok {
+		for _, f := range t.Fields {
+			if f.Name != nil && f.Name.Name == name {
+				return f.Value
+			}
+		}
+	}
+	return nil
+}
+
+// library adds the members of a standard library, only its functions
+// after a colon.
+func (c *completer) library(name string, colon bool) {
+	members := standardMembers(name)
+	for _, m := range sortedKeys(members) {
+		switch {
+		case members[m] == semFunction:
+			c.add(m, completionFunction, "", rankStandard, 0, c.fieldUses(name+"."+m))
+		case !colon:
+			c.add(m, completionField, "", rankStandard, 0, c.fieldUses(name+"."+m))
+		}
+	}
+}
+
+// declaredMembers adds the functions declared as function path.name or
+// path:name, only the methods after a colon, and the fields assigned
+// as path.name = value.
+func (c *completer) declaredMembers(path string, colon bool) {
+	for _, p := range sortedKeys(c.inf.paths) {
+		name, ok := strings.CutPrefix(p, path+".")
+		if d := c.inf.paths[p]; ok && !strings.Contains(name, ".") && (d.method || !colon) {
+			sig, _ := d.signature(c.inf, d.method)
+			kind := completionFunction
+			if d.method {
+				kind = completionMethod
+			}
+			c.add(name, kind, "function "+sig, rankLocal, 0, c.fieldUses(p))
+		}
+	}
+	if colon {
+		return
+	}
+	Inspect(c.chunk.Block, func(n Node) bool {
+		if s, ok := n.(*AssignStmt); ok {
+			for i, t := range s.Targets {
+				f, ok := t.(*FieldExpr)
+				if !ok || exprPath(f.X) != path {
+					continue
+				}
+				var detail string
+				if i < len(s.Values) {
+					detail = typeString(c.inf.exprType(s.Values[i]))
+				}
+				c.add(f.Name.Name, completionField, detail, rankLocal, 0, c.fieldUses(path+"."+f.Name.Name))
+			}
+		}
+		return true
+	})
+}
+
+// fieldUses counts the uses of a field by its path, the name under the
+// cursor aside.
+func (c *completer) fieldUses(path string) int {
+	if c.uses == nil {
+		c.uses = map[string]int{}
+		Inspect(c.chunk.Block, func(n Node) bool {
+			switch n := n.(type) {
+			case *FieldExpr:
+				if p := exprPath(n); p != "" && n.Name.Pos().Offset != c.start {
+					c.uses[p]++
+				}
+			case *MethodCallExpr:
+				if p := exprPath(n.Recv); p != "" && n.Name.Pos().Offset != c.start {
+					c.uses[p+"."+n.Name.Name]++
+				}
+			}
+			return true
+		})
+	}
+	return c.uses[path]
+}
+
+// moduleBinding is how a local is bound to a module.
+type moduleBinding struct {
+	module    string
+	name      string // exported under, for a name imported from it
+	namespace bool   // import * as v or local v = require("module")
+}
+
+// binding returns how v is bound to a module by an import or by local
+// v = require("module"), if it is.
+func (c *completer) binding(v *Variable) (moduleBinding, bool) {
+	if c.bindings == nil {
+		c.bindings = map[*Ident]moduleBinding{}
+		Inspect(c.chunk.Block, func(n Node) bool {
+			switch s := n.(type) {
+			case *ImportStmt:
+				if s.Namespace != nil {
+					c.bindings[s.Namespace] = moduleBinding{s.Path.Value, "", true}
+				}
+				for _, spec := range s.Names {
+					c.bindings[spec.LocalName()] = moduleBinding{s.Path.Value, spec.Name.Name, false}
+				}
+			case *LocalStmt:
+				for i, b := range s.Names {
+					if i >= len(s.Values) {
+						break
+					}
+					call, ok := s.Values[i].(*CallExpr)
+					if !ok || len(call.Args) != 1 {
+						continue
+					}
+					fn, _ := call.Fn.(*Ident)
+					arg, _ := call.Args[0].(*StringExpr)
+					if fn != nil && fn.Name == "require" && c.res.VariableOf(fn) == nil && arg != nil {
+						c.bindings[b.Name] = moduleBinding{arg.Value, "", true}
+					}
+				}
+			}
+			return true
+		})
+	}
+	b, ok := c.bindings[v.Name]
+	return b, ok
+}
+
+// moduleMembers returns the members of the module v is bound to, or
+// the declaration of the name v imports from it.
+func (c *completer) moduleMembers(v *Variable) []*indexSymbol {
+	b, ok := c.binding(v)
+	if !ok || c.index == nil {
+		return nil
+	}
+	syms := c.index.members(c.doc.uri, b.module, v.Kind == VarImport)
+	if b.namespace {
+		return syms
+	}
+	var named []*indexSymbol
+	for _, sym := range syms {
+		if sym.name == b.name {
+			named = append(named, sym)
+		}
+	}
+	return named
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,124 @@
+package luanova
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// completionAt returns the labels of the completion at the | of input.
+func completionAt(input string, index *workspaceIndex, uri string) []lspCompletionItem {
+	i := strings.Index(input, "|")
+	doc := newDocument(uri, 1, input[:i]+input[i+1:])
+	return doc.completion(doc.position(i), index).Items
+}
+
+func labels(items []lspCompletionItem) string {
+	var s []string
+	for _, it := range items {
+		s = append(s, it.Label)
+	}
+	return strings.Join(s, " ")
+}
+
+func TestCompletion(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string // the first labels
+	}{
+		{"locals by distance", "local count = 1\nlocal function f(a)\n\tlocal b = a\n\t|\nend", "b a count f _G"},
+		{"locals by uses", "local x, y = 1, 2\nprint(y, y)\n|", "y x print _G"},
+		{"not in its own value", "local total = 1\nlocal other = t|", "total"},
+		{"loop variables", "for i, v in ipairs(list) do\n\tprint(v)\n\t|\nend", "v i list"},
+		{"not after the block", "do\n\tlocal hidden = 1\nend\nlocal shown = 2\n|", "shown _G"},
+		{"else branch", "if ok then\n\tlocal yes = 1\nelse\n\tlocal no = 2\n\t|\nend", "no ok"},
+		{"prefix", "local value, other = 1, 2\nprint(va|)", "value"},
+		{"fields", "local dict = {name = \"x\", size = 2}\ndict.extra = true\nprint(dict.|)", "extra name size"},
+		{"nested fields", "local cfg = {window = {width = 1, height = 2}}\nprint(cfg.window.|)", "height width"},
+		{"declared functions", "local M = {}\nfunction M.area(r) end\nfunction M:describe() end\nM.|", "area describe"},
+		{"methods", "local M = {}\nfunction M.area(r) end\nfunction M:describe() return self:| end", "describe"},
+		{"table methods", "local obj = {count = 1, greet = function(self) end}\nobj:|", "greet"},
+		{"string methods", "local s = \"abc\"\nprint(s:up|)", "upper"},
+		{"standard library", "local n = math.fl|", "floor"},
+		{"types", "type Point = {x: number}\nlocal p: |", "Point any boolean"},
+		{"parameter types", "local function f(a: |", "any boolean"},
+		{"return types", "type Id = number\nlocal function f(): |", "Id any"},
+		{"method call on a local", "local p = {}\nlocal q = p:|", ""},
+		{"incomplete code", "local function f(a)\n\tlocal b = a\n\tif b then\n\t\tprint(|", "b a f"},
+		{"incomplete member", "local t = {x = 1}\nlocal function g()\n\treturn t.|", "x"},
+		{"after local", "local |", "function"},
+		{"in a string", "print(\"a.|\")", ""},
+		{"in a comment", "-- t.|", ""},
+	}
+	for _, tt := range tests {
+		got := labels(completionAt(tt.input, nil, "file:///c.lunv"))
+		if !strings.HasPrefix(got+" ", tt.expected+" ") && got != tt.expected {
+			t.Errorf("%s: completion = %q, want it to start with %q", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestCompletionItems(t *testing.T) {
+	items := completionAt("local fooCount = 1\nlocal function f()\n\tfo|\nend", nil, "file:///c.lunv")
+	var keyword, snippet, upvalue *lspCompletionItem
+	for i, it := range items {
+		switch {
+		case it.Label == "for" && it.Kind == completionKeyword:
+			keyword = &items[i]
+		case it.Label == "for … end":
+			snippet = &items[i]
+		case it.Label == "fooCount":
+			upvalue = &items[i]
+		}
+	}
+	if keyword == nil || keyword.TextEdit.Range != (lspRange{lspPosition{2, 1}, lspPosition{2, 3}}) {
+		t.Errorf("keyword for = %+v, want it replacing fo", keyword)
+	}
+	if snippet == nil || snippet.InsertTextFormat != snippetFormat || !strings.HasPrefix(snippet.TextEdit.NewText, "for ${1:i} = ") {
+		t.Errorf("snippet for = %+v", snippet)
+	}
+	if upvalue == nil || upvalue.Detail != "local fooCount: number (upvalue)" {
+		t.Errorf("fooCount = %+v, want an upvalue", upvalue)
+	}
+}
+
+func TestCompletionModules(t *testing.T) {
+	dir := t.TempDir()
+	for name, text := range indexFiles {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := startLSPWith(t, map[string]any{"capabilities": map[string]any{}, "rootUri": pathToURI(dir)})
+	c.waitSymbols("", 8)
+
+	uri := pathToURI(filepath.Join(dir, "app.lunv"))
+	text := "import * as geo from \"geometry\"\nimport { Point } from \"./geometry\"\nlocal shapes = require(\"shapes\")\n"
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"shapes.", "area describe"},
+		{"shapes:", "describe"},
+		{"geo.", "Point distance"},
+		{"local p: ", "Point any"},
+	}
+	for i, tt := range tests {
+		c.open(uri, text+tt.line)
+		var list lspCompletionList
+		c.request("textDocument/completion", map[string]any{
+			"textDocument": map[string]any{"uri": uri},
+			"position":     lspPosition{3, len(tt.line)},
+		}, &list)
+		got := labels(list.Items)
+		if !strings.HasPrefix(got+" ", tt.expected+" ") {
+			t.Errorf("%d: completion after %q = %q, want it to start with %q", i, tt.line, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,522 @@
+package luanova
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ConvertError reports a script value that cannot be converted to a Go
+// type.
+type ConvertError struct {
+	Value any          // the script value
+	Type  reflect.Type // the Go type it was converted to
+	Msg   string       // what went wrong, e.g. "number expected, got string"
+}
+
+func (e *ConvertError) Error() string {
+	return fmt.Sprintf("cannot convert %s to %s: %s", luaTypeName(e.Value), e.Type, e.Msg)
+}
+
+var (
+	stateType   = reflect.TypeOf((*State)(nil))
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	bytesType   = reflect.TypeOf([]byte(nil))
+	tableType   = reflect.TypeOf((*Table)(nil))
+	closureType = reflect.TypeOf((*Closure)(nil))
+)
+
+// ToValue converts a Go value to a script value. Numbers become integers
+// or floats, slices, arrays and maps are copied into tables and functions
+// are wrapped with NewFunc. Other values, such as structs, pointers and
+// channels, are bound with Bind, as are values wrapped with ReadOnly.
+func (s *State) ToValue(v any) any {
+	switch v := v.(type) {
+	case nil, bool, int64, float64, string, *Table, *Closure, *Userdata:
+		return v
+	case int:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case []byte:
+		return string(v)
+	case GoFunction:
+		return NewFunction("", v)
+	case func(*State) int:
+		return NewFunction("", v)
+	case readOnly:
+		return s.Bind(v)
+	}
+	return s.toValue(reflect.ValueOf(v))
+}
+
+func (s *State) toValue(rv reflect.Value) any {
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.String:
+		return rv.String()
+	case reflect.Slice:
+		if rv.IsNil() {
+			return nil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return string(rv.Bytes())
+		}
+		fallthrough
+	case reflect.Array:
+		t := newTableSize(rv.Len(), 0)
+		for i := 0; i < rv.Len(); i++ {
+			t.Set(int64(i+1), s.toValue(rv.Index(i)))
+		}
+		return t
+	case reflect.Map:
+		if rv.IsNil() {
+			return nil
+		}
+		t := newTableSize(0, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			k := s.toValue(iter.Key())
+			if k == nil || isNaN(k) {
+				continue
+			}
+			t.Set(k, s.toValue(iter.Value()))
+		}
+		return t
+	case reflect.Func:
+		if rv.IsNil() {
+			return nil
+		}
+		return s.wrapFunc("", rv)
+	case reflect.Pointer, reflect.Interface, reflect.Chan:
+		if rv.IsNil() {
+			return nil
+		}
+		if rv.Kind() == reflect.Interface {
+			return s.ToValue(rv.Elem().Interface())
+		}
+	}
+	if !rv.CanInterface() {
+		return nil
+	}
+	return s.bind(rv, false)
+}
+
+// ToGo converts the script value v and stores it in the Go variable ptr
+// points to. Tables convert to slices, arrays, maps and structs, script
+// functions to Go functions and userdata to the value it holds. A struct
+// field Name is read from the key "Name", or "name" if that is absent,
+// unless a luanova tag names it.
+func (s *State) ToGo(v any, ptr any) error {
+	rv := reflect.ValueOf(ptr)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("luanova: ToGo of non-pointer %T", ptr)
+	}
+	out, err := s.fromValue(v, rv.Type().Elem())
+	if err != nil {
+		return err
+	}
+	rv.Elem().Set(out)
+	return nil
+}
+
+// NewFunc wraps a Go function of any signature as a script function.
+// Arguments are converted with ToGo and results with ToValue; a leading
+// *State parameter receives the calling state and a non-nil trailing
+// error result is raised as a script error.
+func (s *State) NewFunc(name string, fn any) *Closure {
+	if f := goFunction(fn); f != nil {
+		return NewFunction(name, f)
+	}
+	rv := reflect.ValueOf(fn)
+	if rv.Kind() != reflect.Func || rv.IsNil() {
+		panic(fmt.Sprintf("luanova: NewFunc of non-function %T", fn))
+	}
+	return s.wrapFunc(name, rv)
+}
+
+// goFunction returns fn as a GoFunction if it already has that shape.
+func goFunction(fn any) GoFunction {
+	switch fn := fn.(type) {
+	case GoFunction:
+		return fn
+	case func(*State) int:
+		return fn
+	}
+	return nil
+}
+
+func (s *State) wrapFunc(name string, fn reflect.Value) *Closure {
+	t := fn.Type()
+	first := 0
+	if t.NumIn() > 0 && t.In(0) == stateType {
+		first = 1
+	}
+	nout := t.NumOut()
+	withErr := nout > 0 && t.Out(nout-1) == errorType
+	if withErr {
+		nout--
+	}
+	return NewFunction(name, func(s *State) int {
+		var in []reflect.Value
+		if first == 1 {
+			in = append(in, reflect.ValueOf(s))
+		}
+		nparams := t.NumIn() - first
+		if t.IsVariadic() {
+			nparams--
+		}
+		for i := 1; i <= nparams; i++ {
+			in = append(in, s.checkGo(i, t.In(first+i-1)))
+		}
+		if t.IsVariadic() {
+			elem := t.In(t.NumIn() - 1).Elem()
+			for i := nparams + 1; i <= s.Top(); i++ {
+				in = append(in, s.checkGo(i, elem))
+			}
+		}
+		out := fn.Call(in)
+		if withErr {
+			if err, _ := out[nout].Interface().(error); err != nil {
+				s.Raise(err)
+			}
+		}
+		for _, r := range out[:nout] {
+			s.Push(s.toValue(r))
+		}
+		return nout
+	})
+}
+
+// checkGo converts argument i to t, raising an argument error if it
+// cannot be converted.
+func (s *State) checkGo(i int, t reflect.Type) reflect.Value {
+	v, err := s.fromValue(s.Get(i), t)
+	if err != nil {
+		if i > s.Top() {
+			s.TypeError(i, expectedName(t))
+		}
+		s.ArgError(i, err.(*ConvertError).Msg)
+	}
+	return v
+}
+
+// expectedName is the script type name that converts to t.
+func expectedName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice:
+		if t == bytesType {
+			return "string"
+		}
+		return "table"
+	case reflect.Array, reflect.Map, reflect.Struct:
+		return "table"
+	case reflect.Func:
+		return "function"
+	case reflect.Pointer:
+		switch t {
+		case tableType:
+			return "table"
+		case closureType:
+			return "function"
+		}
+		if t.Elem().Kind() == reflect.Struct {
+			return "table"
+		}
+	}
+	return t.String()
+}
+
+func (s *State) fromValue(v any, t reflect.Type) (reflect.Value, error) {
+	fail := func(msg string) (reflect.Value, error) {
+		return reflect.Value{}, &ConvertError{Value: v, Type: t, Msg: msg}
+	}
+	mismatch := func() (reflect.Value, error) {
+		return fail(fmt.Sprintf("%s expected, got %s", expectedName(t), luaTypeName(v)))
+	}
+
+	if ud, ok := v.(*Userdata); ok && t != reflect.TypeOf(ud) {
+		if ud.Value != nil && reflect.TypeOf(ud.Value).AssignableTo(t) {
+			return reflect.ValueOf(ud.Value), nil
+		}
+		if t.Kind() != reflect.Interface {
+			return mismatch()
+		}
+	}
+	if v == nil {
+		switch t.Kind() {
+		case reflect.Interface, reflect.Pointer, reflect.Slice, reflect.Map, reflect.Func, reflect.Chan:
+			return reflect.Zero(t), nil
+		}
+		return mismatch()
+	}
+	if rv := reflect.ValueOf(v); rv.Type().AssignableTo(t) && t.Kind() != reflect.Interface {
+		return rv, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		if ud, ok := v.(*Userdata); ok {
+			if v = ud.Value; v == nil {
+				return reflect.Zero(t), nil
+			}
+		}
+		if t.NumMethod() == 0 || reflect.TypeOf(v).Implements(t) {
+			rv := reflect.New(t).Elem()
+			rv.Set(reflect.ValueOf(v))
+			return rv, nil
+		}
+		return mismatch()
+
+	case reflect.Bool:
+		b, ok := v.(bool)
+		if !ok {
+			return mismatch()
+		}
+		return reflect.ValueOf(b).Convert(t), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := integerArg(v)
+		if err != "" {
+			return fail(err)
+		}
+		rv := reflect.New(t).Elem()
+		if rv.OverflowInt(n) {
+			return fail("number out of range for " + t.String())
+		}
+		rv.SetInt(n)
+		return rv, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := integerArg(v)
+		if err != "" {
+			return fail(err)
+		}
+		rv := reflect.New(t).Elem()
+		if n < 0 || rv.OverflowUint(uint64(n)) {
+			return fail("number out of range for " + t.String())
+		}
+		rv.SetUint(uint64(n))
+		return rv, nil
+
+	case reflect.Float32, reflect.Float64:
+		f, ok := toFloat(v)
+		if !ok {
+			return mismatch()
+		}
+		rv := reflect.New(t).Elem()
+		if rv.OverflowFloat(f) {
+			return fail("number out of range for " + t.String())
+		}
+		rv.SetFloat(f)
+		return rv, nil
+
+	case reflect.String:
+		str, ok := toStringCoerce(v)
+		if !ok {
+			return mismatch()
+		}
+		return reflect.ValueOf(str).Convert(t), nil
+
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			if str, ok := v.(string); ok {
+				return reflect.ValueOf([]byte(str)).Convert(t), nil
+			}
+		}
+		tab, ok := v.(*Table)
+		if !ok {
+			return mismatch()
+		}
+		n := int(tab.Len())
+		rv := reflect.MakeSlice(t, n, n)
+		for i := 0; i < n; i++ {
+			ev, err := s.fromValue(tab.Get(int64(i+1)), t.Elem())
+			if err != nil {
+				return fail(nested(fmt.Sprintf("[%d]", i+1), err))
+			}
+			rv.Index(i).Set(ev)
+		}
+		return rv, nil
+
+	case reflect.Array:
+		tab, ok := v.(*Table)
+		if !ok {
+			return mismatch()
+		}
+		if n := int(tab.Len()); n > t.Len() {
+			return fail(fmt.Sprintf("table has %d elements, array has %d", n, t.Len()))
+		}
+		rv := reflect.New(t).Elem()
+		for i := 0; i < int(tab.Len()); i++ {
+			ev, err := s.fromValue(tab.Get(int64(i+1)), t.Elem())
+			if err != nil {
+				return fail(nested(fmt.Sprintf("[%d]", i+1), err))
+			}
+			rv.Index(i).Set(ev)
+		}
+		return rv, nil
+
+	case reflect.Map:
+		tab, ok := v.(*Table)
+		if !ok {
+			return mismatch()
+		}
+		rv := reflect.MakeMap(t)
+		var ferr error
+		tab.ForEach(func(k, ev any) {
+			if ferr != nil {
+				return
+			}
+			gk, err := s.fromValue(k, t.Key())
+			if err != nil {
+				ferr = &ConvertError{Value: v, Type: t, Msg: nested("key "+keyString(k), err)}
+				return
+			}
+			gv, err := s.fromValue(ev, t.Elem())
+			if err != nil {
+				ferr = &ConvertError{Value: v, Type: t, Msg: nested("["+keyString(k)+"]", err)}
+				return
+			}
+			rv.SetMapIndex(gk, gv)
+		})
+		if ferr != nil {
+			return reflect.Value{}, ferr
+		}
+		return rv, nil
+
+	case reflect.Struct:
+		tab, ok := v.(*Table)
+		if !ok {
+			return mismatch()
+		}
+		rv := reflect.New(t).Elem()
+		for _, f := range structInfo(t).fields {
+			fv := tab.GetString(f.name)
+			if fv == nil && !f.tagged {
+				fv = tab.GetString(strings.ToLower(f.name[:1]) + f.name[1:])
+			}
+			if fv == nil {
+				continue
+			}
+			gv, err := s.fromValue(fv, f.typ)
+			if err != nil {
+				return fail(nested("field '"+f.name+"'", err))
+			}
+			fieldAlloc(rv, f.index).Set(gv)
+		}
+		return rv, nil
+
+	case reflect.Pointer:
+		if _, ok := v.(*Table); ok && t.Elem().Kind() == reflect.Struct {
+			ev, err := s.fromValue(v, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			rv := reflect.New(t.Elem())
+			rv.Elem().Set(ev)
+			return rv, nil
+		}
+		return mismatch()
+
+	case reflect.Func:
+		if _, ok := v.(*Closure); !ok {
+			return mismatch()
+		}
+		return s.makeFunc(v, t), nil
+	}
+	return mismatch()
+}
+
+// integerArg converts v to an integer, returning a message if it cannot.
+func integerArg(v any) (int64, string) {
+	n, ok := toInteger(v)
+	if !ok {
+		if _, isNum := toNumber(v); isNum {
+			return 0, "number has no integer representation"
+		}
+		return 0, "number expected, got " + luaTypeName(v)
+	}
+	return n, ""
+}
+
+// nested prefixes the message of a conversion error of an element.
+func nested(where string, err error) string {
+	return where + ": " + err.(*ConvertError).Msg
+}
+
+func keyString(k any) string {
+	if str, ok := k.(string); ok {
+		return strconv.Quote(str)
+	}
+	return tostringBasic(k)
+}
+
+// makeFunc returns a Go function of type t that calls the script
+// function fn. Errors are returned through a trailing error result or,
+// when t has none, raised as a panic.
+func (s *State) makeFunc(fn any, t reflect.Type) reflect.Value {
+	nout := t.NumOut()
+	withErr := nout > 0 && t.Out(nout-1) == errorType
+	if withErr {
+		nout--
+	}
+	return reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		if t.IsVariadic() {
+			last := in[len(in)-1]
+			in = in[:len(in)-1]
+			for i := 0; i < last.Len(); i++ {
+				in = append(in, last.Index(i))
+			}
+		}
+		args := make([]any, len(in))
+		for i, a := range in {
+			args[i] = s.toValue(a)
+		}
+		out := make([]reflect.Value, t.NumOut())
+		rs, err := s.PCall(fn, args...)
+		for i := 0; i < nout && err == nil; i++ {
+			var r any
+			if i < len(rs) {
+				r = rs[i]
+			}
+			out[i], err = s.fromValue(r, t.Out(i))
+		}
+		if err != nil {
+			if !withErr {
+				if _, ok := err.(*Error); !ok {
+					err = &Error{Value: err.Error(), Cause: err}
+				}
+				panic(err)
+			}
+			for i := 0; i < nout; i++ {
+				out[i] = reflect.Zero(t.Out(i))
+			}
+		}
+		if withErr {
+			out[nout] = reflect.Zero(errorType)
+			if err != nil {
+				out[nout] = reflect.ValueOf(&err).Elem()
+			}
+		}
+		return out
+	})
+}
@@ -0,0 +1,203 @@
+package luanova
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestToValue(t *testing.T) {
+	s := NewState()
+	tests := []struct {
+		input    any
+		expected any
+	}{
+		{nil, nil},
+		{true, true},
+		{42, int64(42)},
+		{int8(-3), int64(-3)},
+		{uint16(7), int64(7)},
+		{float32(1.5), 1.5},
+		{"str", "str"},
+		{[]byte("raw"), "raw"},
+		{(*int)(nil), nil},
+		{[]int(nil), nil},
+	}
+
+	for _, tt := range tests {
+		if got := s.ToValue(tt.input); got != tt.expected {
+			t.Errorf("ToValue(%#v): got %#v, expected %#v", tt.input, got, tt.expected)
+		}
+	}
+
+	tab := s.ToValue([]string{"a", "b"}).(*Table)
+	if tab.Len() != 2 || tab.Get(int64(2)) != "b" {
+		t.Errorf("slice: got %v", tab)
+	}
+	tab = s.ToValue(map[string]any{"x": 1, "y": []int{1}}).(*Table)
+	if tab.GetString("x") != int64(1) || tab.GetString("y").(*Table).Len() != 1 {
+		t.Errorf("map: got %v", tab)
+	}
+	type point struct{ X, Y int }
+	ud, ok := s.ToValue(&point{1, 2}).(*Userdata)
+	if !ok || ud.Value.(*point).Y != 2 {
+		t.Errorf("pointer: got %#v", ud)
+	}
+}
+
+func TestToGo(t *testing.T) {
+	s := NewState()
+	if err := s.DoString(`
+t = {name = "box", size = 3, tags = {"a", "b"}, dims = {w = 1.5, h = 2}}
+n = 7
+f = 7.5
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err := s.ToGo(s.GetGlobal("n"), &n); err != nil || n != 7 {
+		t.Errorf("int: got %d, %v", n, err)
+	}
+	var f32 float32
+	if err := s.ToGo(s.GetGlobal("f"), &f32); err != nil || f32 != 7.5 {
+		t.Errorf("float32: got %v, %v", f32, err)
+	}
+	var str string
+	if err := s.ToGo(s.GetGlobal("n"), &str); err != nil || str != "7" {
+		t.Errorf("string: got %q, %v", str, err)
+	}
+
+	type item struct {
+		Name string
+		Size uint8
+		Tags []string
+		Dims map[string]float64
+		skip int
+	}
+	var it item
+	if err := s.ToGo(s.GetGlobal("t"), &it); err != nil {
+		t.Fatal(err)
+	}
+	expected := item{Name: "box", Size: 3, Tags: []string{"a", "b"}, Dims: map[string]float64{"w": 1.5, "h": 2}}
+	if !reflect.DeepEqual(it, expected) {
+		t.Errorf("struct: got %+v", it)
+	}
+	var pit *item
+	if err := s.ToGo(s.GetGlobal("t"), &pit); err != nil || pit.Name != "box" {
+		t.Errorf("struct pointer: got %+v, %v", pit, err)
+	}
+	var arr [2]string
+	if err := s.ToGo(s.GetGlobal("t").(*Table).GetString("tags"), &arr); err != nil || arr[1] != "b" {
+		t.Errorf("array: got %v, %v", arr, err)
+	}
+	var anyv any
+	if err := s.ToGo(s.GetGlobal("t"), &anyv); err != nil || anyv != s.GetGlobal("t") {
+		t.Errorf("any: got %v, %v", anyv, err)
+	}
+}
+
+func TestToGoErrors(t *testing.T) {
+	s := NewState()
+	tab := NewTable()
+	tab.Set("Size", int64(300))
+	tab.Set("Tags", s.ToValue([]any{"a", true}))
+
+	tests := []struct {
+		value    any
+		ptr      any
+		expected string
+	}{
+		{"x", new(int), "cannot convert string to int: number expected, got string"},
+		{1.5, new(int), "cannot convert number to int: number has no integer representation"},
+		{int64(-1), new(uint), "cannot convert number to uint: number out of range for uint"},
+		{nil, new(bool), "cannot convert nil to bool: boolean expected, got nil"},
+		{tab, new(struct{ Size int8 }), "cannot convert table to struct { Size int8 }: field 'Size': number out of range for int8"},
+		{tab, new(struct{ Tags []string }), "cannot convert table to struct { Tags []string }: field 'Tags': [2]: string expected, got boolean"},
+		{int64(1), new(map[string]int), "cannot convert number to map[string]int: table expected, got number"},
+	}
+
+	for _, tt := range tests {
+		err := s.ToGo(tt.value, tt.ptr)
+		var cerr *ConvertError
+		if !errors.As(err, &cerr) {
+			t.Errorf("%v: expected *ConvertError, got %v", tt.value, err)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("got %q, expected %q", err.Error(), tt.expected)
+		}
+	}
+	if err := s.ToGo(1, 0); err == nil {
+		t.Errorf("ToGo into a non-pointer should fail")
+	}
+}
+
+func TestRegisterGoFunc(t *testing.T) {
+	var out strings.Builder
+	s := NewState()
+	s.Stdout = &out
+	s.Register("add", func(a, b int) int { return a + b })
+	s.Register("join", func(sep string, parts ...string) string { return strings.Join(parts, sep) })
+	s.Register("div", func(a, b float64) (float64, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a / b, nil
+	})
+	s.Register("top", func(s *State, n int) int { return s.Top() + n })
+	s.Register("pair", func() (string, []int) { return "p", []int{1, 2} })
+	s.Register("apply", func(f func(int) int, x int) int { return f(x) })
+
+	err := s.DoString(`
+print(add(1, 2), join("-", "a", "b", "c"), div(1, 4), top(10))
+local p, t = pair()
+print(p, #t, t[2])
+print(apply(function(x) return x * 3 end, 5))
+print(pcall(div, 1, 0))
+print(pcall(add, 1))
+print(pcall(add, 1, "x"))
+print(pcall(add, 1.5, 1))
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `3	a-b-c	0.25	11
+p	2	2
+15
+false	division by zero
+false	bad argument #2 to 'add' (number expected, got no value)
+false	bad argument #2 to 'add' (number expected, got string)
+false	bad argument #1 to 'add' (number has no integer representation)
+`
+	if out.String() != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
+
+func TestScriptCallback(t *testing.T) {
+	s := NewState()
+	if err := s.DoString(`
+function greet(name) return "hi " .. name, #name end
+function fail() error("nope") end
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	var greet func(string) (string, int)
+	if err := s.ToGo(s.GetGlobal("greet"), &greet); err != nil {
+		t.Fatal(err)
+	}
+	if msg, n := greet("bob"); msg != "hi bob" || n != 3 {
+		t.Errorf("greet: got %q, %d", msg, n)
+	}
+
+	var fail func() error
+	if err := s.ToGo(s.GetGlobal("fail"), &fail); err != nil {
+		t.Fatal(err)
+	}
+	if err := fail(); err == nil || !strings.HasSuffix(err.Error(), "nope") {
+		t.Errorf("fail: got %v", err)
+	}
+}
@@ -0,0 +1,260 @@
+package luanova
+
+import "sort"
+
+// Coverage counts how often the statements and branches of the chunks a
+// State loads run. Branches are the arms of if statements, whether loop
+// bodies run and whether the right operand of and/or is evaluated.
+//
+// Chunks are instrumented when they are loaded, so only chunks loaded
+// after SetCoverage are covered. A chunk loaded again with the same name
+// and source, by another State for instance, adds to the same counts.
+type Coverage struct {
+	// Include selects the chunks to cover by name; nil covers all of
+	// them.
+	Include func(name string) bool
+
+	files map[string]*FileCoverage
+	names []string
+}
+
+// FileCoverage is the coverage of a chunk.
+type FileCoverage struct {
+	Name     string
+	Source   string
+	Stmts    []*StmtCoverage   // in source order
+	Branches []*BranchCoverage // in source order
+
+	stmts    map[Position]*StmtCoverage
+	branches map[branchKey]*BranchCoverage
+	sorted   bool
+}
+
+// StmtCoverage is the number of runs of a statement.
+type StmtCoverage struct {
+	Pos, End Position
+	Count    int64
+}
+
+// BranchCoverage is a point where execution takes one of several arms.
+// Kind is "if", "while", "for", "repeat", "and" or "or"; the arms are
+//
+//	if           "then", one "elseif" per clause, "else"
+//	while, for   "body" for each iteration, "skip" when there is none
+//	repeat       "again" and "exit", after the condition
+//	and, or      "right" when the right operand is evaluated, "short"
+//
+// An if without an else has an implicit "else" arm with a zero position.
+type BranchCoverage struct {
+	Kind string
+	Pos  Position
+	Arms []BranchArm
+}
+
+// BranchArm is an arm of a branch and the times it was taken.
+type BranchArm struct {
+	Label    string
+	Pos, End Position // zero for arms without source
+	Count    int64
+}
+
+type branchKey struct {
+	kind string
+	pos  Position
+}
+
+// NewCoverage returns an empty Coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{files: map[string]*FileCoverage{}}
+}
+
+// SetCoverage makes s count the coverage of the chunks it loads from now
+// on in c; nil stops counting. Chunks loaded before keep counting in the
+// Coverage they were loaded with.
+func (s *State) SetCoverage(c *Coverage) {
+	s.coverage = c
+}
+
+// Files returns the covered chunks in the order they were first loaded.
+func (c *Coverage) Files() []*FileCoverage {
+	files := make([]*FileCoverage, len(c.names))
+	for i, name := range c.names {
+		files[i] = c.files[name]
+		files[i].sort()
+	}
+	return files
+}
+
+// File returns the coverage of the chunk called name, or nil.
+func (c *Coverage) File(name string) *FileCoverage {
+	f := c.files[name]
+	if f != nil {
+		f.sort()
+	}
+	return f
+}
+
+// file returns the coverage to instrument the chunk name with, or nil
+// when it is not covered. A different source starts the counts over.
+func (c *Coverage) file(name, src string) *FileCoverage {
+	if c == nil || c.Include != nil && !c.Include(name) {
+		return nil
+	}
+	f := c.files[name]
+	if f == nil {
+		c.names = append(c.names, name)
+	}
+	if f == nil || f.Source != src {
+		f = &FileCoverage{
+			Name:     name,
+			Source:   src,
+			stmts:    map[Position]*StmtCoverage{},
+			branches: map[branchKey]*BranchCoverage{},
+		}
+		c.files[name] = f
+	}
+	return f
+}
+
+func (f *FileCoverage) sort() {
+	if f.sorted {
+		return
+	}
+	sort.Slice(f.Stmts, func(i, j int) bool { return f.Stmts[i].Pos.Offset < f.Stmts[j].Pos.Offset })
+	sort.SliceStable(f.Branches, func(i, j int) bool { return f.Branches[i].Pos.Offset < f.Branches[j].Pos.Offset })
+	f.sorted = true
+}
+
+func (f *FileCoverage) stmt(stmt Stmt) *StmtCoverage {
+	sc := f.stmts[stmt.Pos()]
+	if sc == nil {
+		sc = &StmtCoverage{Pos: stmt.Pos(), End: stmt.End()}
+		f.stmts[sc.Pos] = sc
+		f.Stmts = append(f.Stmts, sc)
+		f.sorted = false
+	}
+	return sc
+}
+
+func (f *FileCoverage) branch(kind string, pos Position, arms []BranchArm) *BranchCoverage {
+	k := branchKey{kind, pos}
+	b := f.branches[k]
+	if b == nil {
+		b = &BranchCoverage{Kind: kind, Pos: pos, Arms: arms}
+		f.branches[k] = b
+		f.Branches = append(f.Branches, b)
+		f.sorted = false
+	}
+	return b
+}
+
+// countStmt counts the runs of stmt, and the runs of a loop that skip
+// its body.
+func (f *FileCoverage) countStmt(stmt Stmt, exec execFn) execFn {
+	n := &f.stmt(stmt).Count
+	var kind string
+	switch stmt.(type) {
+	case *WhileStmt:
+		kind = "while"
+	case *NumericForStmt, *GenericForStmt:
+		kind = "for"
+	}
+	if b := f.branches[branchKey{kind, stmt.Pos()}]; b != nil {
+		body, skip := &b.Arms[0].Count, &b.Arms[1].Count
+		return func(fr *frame) flow {
+			*n++
+			was := *body
+			fl := exec(fr)
+			if *body == was {
+				*skip++
+			}
+			return fl
+		}
+	}
+	return func(fr *frame) flow {
+		*n++
+		return exec(fr)
+	}
+}
+
+// countLoop counts the iterations of the body of loop.
+func (f *FileCoverage) countLoop(loop Stmt, b *Block, body execFn) execFn {
+	kind := "for"
+	if _, ok := loop.(*WhileStmt); ok {
+		kind = "while"
+	}
+	br := f.branch(kind, loop.Pos(), []BranchArm{
+		{Label: "body", Pos: b.Pos(), End: b.End()},
+		{Label: "skip"},
+	})
+	return countArm(&br.Arms[0].Count, body)
+}
+
+// countIf counts the arms of an if statement. elseBody may be nil.
+func (f *FileCoverage) countIf(stmt *IfStmt, bodies []execFn, elseBody execFn) execFn {
+	arms := make([]BranchArm, len(stmt.Clauses)+1)
+	for i, clause := range stmt.Clauses {
+		arms[i] = BranchArm{Label: "elseif", Pos: clause.Pos(), End: clause.End()}
+	}
+	arms[0].Label = "then"
+	arms[len(arms)-1].Label = "else"
+	if stmt.Else != nil {
+		arms[len(arms)-1].Pos, arms[len(arms)-1].End = stmt.Else.Pos(), stmt.Else.End()
+	}
+	br := f.branch("if", stmt.Pos(), arms)
+	for i := range bodies {
+		bodies[i] = countArm(&br.Arms[i].Count, bodies[i])
+	}
+	if elseBody == nil {
+		elseBody = func(*frame) flow { return flowNormal }
+	}
+	return countArm(&br.Arms[len(arms)-1].Count, elseBody)
+}
+
+// countRepeat counts whether the condition of a repeat loop ends it.
+func (f *FileCoverage) countRepeat(stmt *RepeatStmt, cond evalFn) evalFn {
+	br := f.branch("repeat", stmt.Pos(), []BranchArm{
+		{Label: "again", Pos: stmt.Body.Pos(), End: stmt.Body.End()},
+		{Label: "exit", Pos: stmt.Cond.Pos(), End: stmt.Cond.End()},
+	})
+	again, exit := &br.Arms[0].Count, &br.Arms[1].Count
+	return func(fr *frame) any {
+		v := cond(fr)
+		if truthy(v) {
+			*exit++
+		} else {
+			*again++
+		}
+		return v
+	}
+}
+
+// countLogical evaluates an and/or expression counting whether its right
+// operand is evaluated.
+func (f *FileCoverage) countLogical(e *BinaryExpr, a, b evalFn) evalFn {
+	kind := "and"
+	if e.Op == Or {
+		kind = "or"
+	}
+	br := f.branch(kind, e.OpPos, []BranchArm{
+		{Label: "right", Pos: e.Right.Pos(), End: e.Right.End()},
+		{Label: "short"},
+	})
+	right, short := &br.Arms[0].Count, &br.Arms[1].Count
+	return func(fr *frame) any {
+		v := a(fr)
+		if truthy(v) == (kind == "or") {
+			*short++
+			return v
+		}
+		*right++
+		return b(fr)
+	}
+}
+
+func countArm(n *int64, exec execFn) execFn {
+	return func(fr *frame) flow {
+		*n++
+		return exec(fr)
+	}
+}
@@ -0,0 +1,137 @@
+package luanova
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const coveredScript = `local function sign(n)
+	if n > 0 then
+		return 1
+	elseif n < 0 then
+		return -1
+	end
+	return 0
+end
+local function sum(t)
+	local s = 0
+	for _, v in ipairs(t) do s = s + v end
+	return s
+end
+local n = 0
+while n < 3 do n = n + 1 end
+repeat n = n - 1 until n == 0
+local x = sign(5) + sign(0) + sum({}) + sum({1, 2})
+local ok = x > 0 or error("negative")
+local big = x > 100 and "big"
+return ok, big
+`
+
+func runCovered(t *testing.T, c *Coverage, runs int) {
+	t.Helper()
+	for i := 0; i < runs; i++ {
+		s := NewState()
+		s.SetCoverage(c)
+		fn, err := s.Load(coveredScript, "cover.lunv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.PCall(fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	c := NewCoverage()
+	runCovered(t, c, 2)
+	f := c.File("cover.lunv")
+	if f == nil {
+		t.Fatal("no coverage of cover.lunv")
+	}
+
+	var branches []string
+	for _, b := range f.Branches {
+		var arms []string
+		for _, a := range b.Arms {
+			arms = append(arms, fmt.Sprintf("%s=%d", a.Label, a.Count))
+		}
+		branches = append(branches, fmt.Sprintf("%d %s %s", b.Pos.Line, b.Kind, strings.Join(arms, " ")))
+	}
+	want := []string{
+		"2 if then=2 elseif=0 else=2",
+		"11 for body=4 skip=2",
+		"15 while body=6 skip=0",
+		"16 repeat again=4 exit=2",
+		"18 or right=0 short=2",
+		"19 and right=0 short=2",
+	}
+	if got := strings.Join(branches, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("branches:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+
+	var text bytes.Buffer
+	if err := c.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	wantText := `cover.lunv: 94.7% of statements (18/19), 69.2% of branches (9/13)
+	not run: 5
+	not taken: 2:2 if elseif, 15:1 while skip, 18:18 or right, 19:21 and right
+total: 94.7% of statements (18/19), 69.2% of branches (9/13)
+`
+	if text.String() != wantText {
+		t.Errorf("text report:\n%s\nwant:\n%s", text.String(), wantText)
+	}
+
+	var lcov bytes.Buffer
+	if err := c.WriteLCOV(&lcov); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"SF:cover.lunv\n", "BRDA:2,0,1,0\n", "BRDA:11,1,0,4\n", "DA:5,0\n", "DA:15,6\n", "LF:16\nLH:15\n", "BRF:13\nBRH:9\n"} {
+		if !strings.Contains(lcov.String(), want) {
+			t.Errorf("LCOV report has no %q:\n%s", want, lcov.String())
+		}
+	}
+
+	var page bytes.Buffer
+	if err := c.WriteHTML(&page); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<tr class="miss"><td class="n">5</td><td class="c">0</td><td>		<span class="kw">return</span> -<span class="num">1</span></td></tr>`,
+		`<tr class="part" title="not taken: if elseif">`,
+		`<tr class="hit"><td class="n">3</td>`,
+		`<td class="n">8</td><td class="c"></td>`,
+	} {
+		if !strings.Contains(page.String(), want) {
+			t.Errorf("HTML report has no %q", want)
+		}
+	}
+}
+
+func TestCoverageInclude(t *testing.T) {
+	c := NewCoverage()
+	c.Include = func(name string) bool { return name != "cover.lunv" }
+	runCovered(t, c, 1)
+	if len(c.Files()) != 0 {
+		t.Errorf("excluded chunk covered")
+	}
+}
+
+func TestLineRanges(t *testing.T) {
+	tests := []struct {
+		lines    []int
+		expected string
+	}{
+		{nil, ""},
+		{[]int{3}, "3"},
+		{[]int{3, 4, 5, 9, 11, 12}, "3-5, 9, 11-12"},
+	}
+	for _, tt := range tests {
+		if got := lineRanges(tt.lines); got != tt.expected {
+			t.Errorf("lineRanges(%v) = %q, want %q", tt.lines, got, tt.expected)
+		}
+	}
+}
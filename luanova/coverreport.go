@@ -0,0 +1,343 @@
+package luanova
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// coverCounts sums up the coverage of one or more chunks.
+type coverCounts struct {
+	stmts, stmtsHit int
+	arms, armsHit   int
+}
+
+func (n *coverCounts) add(m coverCounts) {
+	n.stmts += m.stmts
+	n.stmtsHit += m.stmtsHit
+	n.arms += m.arms
+	n.armsHit += m.armsHit
+}
+
+func (n coverCounts) String() string {
+	return fmt.Sprintf("%s of statements (%d/%d), %s of branches (%d/%d)",
+		percent(n.stmtsHit, n.stmts), n.stmtsHit, n.stmts, percent(n.armsHit, n.arms), n.armsHit, n.arms)
+}
+
+func percent(n, total int) string {
+	if total == 0 {
+		return "100.0%"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+}
+
+func (f *FileCoverage) counts() coverCounts {
+	var n coverCounts
+	for _, s := range f.Stmts {
+		n.stmts++
+		if s.Count > 0 {
+			n.stmtsHit++
+		}
+	}
+	for _, b := range f.Branches {
+		for _, a := range b.Arms {
+			n.arms++
+			if a.Count > 0 {
+				n.armsHit++
+			}
+		}
+	}
+	return n
+}
+
+// lines returns the lines statements start on, in order, and the runs of
+// the most run statement of each.
+func (f *FileCoverage) lines() ([]int, map[int]int64) {
+	var lines []int
+	hits := map[int]int64{}
+	for _, s := range f.Stmts {
+		n, ok := hits[s.Pos.Line]
+		if !ok {
+			lines = append(lines, s.Pos.Line)
+		}
+		if !ok || s.Count > n {
+			hits[s.Pos.Line] = s.Count
+		}
+	}
+	return lines, hits
+}
+
+// reached reports whether execution got to the branch.
+func (b *BranchCoverage) reached() bool {
+	for _, a := range b.Arms {
+		if a.Count > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// missed returns the arms of b that were not taken, as "if else".
+func (b *BranchCoverage) missed() []string {
+	var arms []string
+	for _, a := range b.Arms {
+		if a.Count == 0 {
+			arms = append(arms, b.Kind+" "+a.Label)
+		}
+	}
+	return arms
+}
+
+// WriteText writes a summary of the coverage of each chunk and of all of
+// them, with the lines that did not run and the arms of the branches
+// reached that were not taken.
+func (c *Coverage) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	var total coverCounts
+	for _, f := range c.Files() {
+		n := f.counts()
+		total.add(n)
+		fmt.Fprintf(bw, "%s: %v\n", f.Name, n)
+		lines, hits := f.lines()
+		var missed []int
+		for _, l := range lines {
+			if hits[l] == 0 {
+				missed = append(missed, l)
+			}
+		}
+		if len(missed) > 0 {
+			fmt.Fprintf(bw, "\tnot run: %s\n", lineRanges(missed))
+		}
+		var arms []string
+		for _, b := range f.Branches {
+			if !b.reached() {
+				continue
+			}
+			for _, a := range b.missed() {
+				arms = append(arms, fmt.Sprintf("%d:%d %s", b.Pos.Line, b.Pos.Column, a))
+			}
+		}
+		if len(arms) > 0 {
+			fmt.Fprintf(bw, "\tnot taken: %s\n", strings.Join(arms, ", "))
+		}
+	}
+	fmt.Fprintf(bw, "total: %v\n", total)
+	return bw.Flush()
+}
+
+// lineRanges renders sorted line numbers as "3, 7-9".
+func lineRanges(lines []int) string {
+	var b strings.Builder
+	for i := 0; i < len(lines); {
+		j := i
+		for j+1 < len(lines) && lines[j+1] <= lines[j]+1 {
+			j++
+		}
+		if b.Len() > 0 {
+			b.WriteString(", ")
+		}
+		if i == j {
+			fmt.Fprint(&b, lines[i])
+		} else {
+			fmt.Fprintf(&b, "%d-%d", lines[i], lines[j])
+		}
+		i = j + 1
+	}
+	return b.String()
+}
+
+// WriteLCOV writes the coverage in the LCOV tracefile format read by
+// genhtml and most coverage services. Each branch is a block whose
+// branches are its arms.
+func (c *Coverage) WriteLCOV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range c.Files() {
+		fmt.Fprintf(bw, "TN:\nSF:%s\n", f.Name)
+		n := f.counts()
+		for i, b := range f.Branches {
+			for j, a := range b.Arms {
+				taken := "-"
+				if b.reached() {
+					taken = fmt.Sprint(a.Count)
+				}
+				fmt.Fprintf(bw, "BRDA:%d,%d,%d,%s\n", b.Pos.Line, i, j, taken)
+			}
+		}
+		fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", n.arms, n.armsHit)
+		lines, hits := f.lines()
+		lh := 0
+		for _, l := range lines {
+			fmt.Fprintf(bw, "DA:%d,%d\n", l, hits[l])
+			if hits[l] > 0 {
+				lh++
+			}
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", len(lines), lh)
+	}
+	return bw.Flush()
+}
+
+type htmlCoverFile struct {
+	Name    string
+	Summary string
+	Lines   []htmlCoverLine
+}
+
+type htmlCoverLine struct {
+	N     int
+	Class string // hit, part, miss or empty for lines without statements
+	Count string
+	Title string
+	Code  template.HTML
+}
+
+// WriteHTML writes a page showing the source of each chunk with its
+// syntax highlighted and its lines colored by coverage: lines that ran,
+// lines with branch arms not taken and lines that did not run.
+func (c *Coverage) WriteHTML(w io.Writer) error {
+	var files []htmlCoverFile
+	for _, f := range c.Files() {
+		lines, hits := f.lines()
+		missed := map[int][]string{}
+		for _, b := range f.Branches {
+			if b.reached() {
+				missed[b.Pos.Line] = append(missed[b.Pos.Line], b.missed()...)
+			}
+		}
+		hf := htmlCoverFile{Name: f.Name, Summary: f.counts().String()}
+		for i, code := range highlightLines(f.Source) {
+			hf.Lines = append(hf.Lines, htmlCoverLine{N: i + 1, Code: template.HTML(code)})
+		}
+		for _, l := range lines {
+			if l > len(hf.Lines) {
+				continue
+			}
+			hl := &hf.Lines[l-1]
+			hl.Count = fmt.Sprint(hits[l])
+			switch {
+			case hits[l] == 0:
+				hl.Class = "miss"
+			case len(missed[l]) > 0:
+				hl.Class = "part"
+				hl.Title = "not taken: " + strings.Join(missed[l], ", ")
+			default:
+				hl.Class = "hit"
+			}
+		}
+		files = append(files, hf)
+	}
+	return coverTemplate.Execute(w, files)
+}
+
+// highlightLines returns the lines of src as HTML with the tokens wrapped
+// in spans classed kw, str, num and com.
+func highlightLines(src string) []string {
+	var lines []string
+	var b strings.Builder
+	emit := func(text, class string) {
+		for i, part := range strings.Split(text, "\n") {
+			if i > 0 {
+				lines = append(lines, strings.TrimSuffix(b.String(), "\r"))
+				b.Reset()
+			}
+			if part == "" {
+				continue
+			}
+			if class == "" {
+				b.WriteString(html.EscapeString(part))
+			} else {
+				fmt.Fprintf(&b, `<span class="%s">%s</span>`, class, html.EscapeString(part))
+			}
+		}
+	}
+	l := NewLexer(src)
+	offset := 0
+	for {
+		tok := l.NextToken()
+		if tok.Type == EOF || tok.End.Offset <= offset || tok.End.Offset > len(src) {
+			break
+		}
+		emit(src[offset:tok.Pos.Offset], "")
+		emit(src[tok.Pos.Offset:tok.End.Offset], tokenClass(tok))
+		offset = tok.End.Offset
+	}
+	emit(src[offset:], "")
+	if b.Len() > 0 {
+		lines = append(lines, b.String())
+	}
+	return lines
+}
+
+func tokenClass(tok Token) string {
+	switch {
+	case tok.Type == Comment || tok.Type == CommentBlock:
+		return "com"
+	case tok.Type == StringDelim:
+		return "str"
+	case tok.Type == Literal && isDigit(tok.Literal[0]):
+		return "num"
+	case tok.Type != Literal && isLetter(tok.Literal[0]):
+		return "kw"
+	}
+	return ""
+}
+
+var coverTemplate = template.Must(template.New("cover").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>LuaNova coverage</title>
+<style>
+body { background: #fff; color: #222; font-family: sans-serif; margin: 0; }
+#topbar { background: #eee; padding: 8px 12px; position: sticky; top: 0; }
+#legend span { margin-left: 12px; padding: 0 4px; }
+table { border-collapse: collapse; font-family: monospace; font-size: 13px; }
+td { padding: 0 8px; white-space: pre; vertical-align: top; }
+td.n, td.c { color: #999; text-align: right; user-select: none; }
+.hit { background: #d9f2d9; }
+.part { background: #fbefc5; }
+.miss { background: #f7d4d4; }
+.kw { color: #8839b5; font-weight: bold; }
+.str { color: #1a7f37; }
+.num { color: #0550ae; }
+.com { color: #6e7781; font-style: italic; }
+.file { display: none; }
+</style>
+</head>
+<body>
+<div id="topbar">
+<select id="files">
+{{- range $i, $f := .}}
+<option value="file{{$i}}">{{$f.Name}} ({{$f.Summary}})</option>
+{{- end}}
+</select>
+<span id="legend"><span class="miss">not run</span><span class="part">branch not taken</span><span class="hit">run</span></span>
+</div>
+{{- range $i, $f := .}}
+<div class="file" id="file{{$i}}">
+<table>
+{{- range $f.Lines}}
+<tr{{if .Class}} class="{{.Class}}"{{end}}{{if .Title}} title="{{.Title}}"{{end}}><td class="n">{{.N}}</td><td class="c">{{.Count}}</td><td>{{.Code}}</td></tr>
+{{- end}}
+</table>
+</div>
+{{- end}}
+<script>
+(function() {
+	var files = document.getElementById("files");
+	var shown = null;
+	function show() {
+		if (shown) shown.style.display = "none";
+		shown = document.getElementById(files.value);
+		if (shown) shown.style.display = "block";
+	}
+	files.addEventListener("change", show);
+	show();
+})();
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,687 @@
+package luanova
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Debugger is a debug adapter speaking the Debug Adapter Protocol. It
+// runs scripts with launch requests, or debugs the State given to
+// NewDebugger with attach requests, and supports line and conditional
+// breakpoints, stepping, pausing, and inspecting and evaluating in
+// stack frames. Scripts run in a single thread with id 1.
+type Debugger struct {
+	attach  *State
+	state   *State // debugged State, set by launch or attach
+	run     func() // starts a launched script
+	started bool
+	cancel  context.CancelFunc
+	done    chan struct{} // closed when a launched script ends
+
+	wmu    sync.Mutex
+	w      io.Writer
+	seq    int
+	closed bool
+
+	bps    atomic.Pointer[map[string]map[int]string] // conditions by line by source
+	active atomic.Bool
+	pause  atomic.Bool
+
+	// owned by the goroutine running the script
+	step    stepMode
+	depth   int // calls at the last stop
+	sources map[string]string
+	refs    []*varRef
+
+	mu      sync.Mutex
+	stopped bool
+	work    chan func()
+	resume  chan stepMode
+}
+
+type stepMode int
+
+const (
+	stepNone stepMode = iota
+	stepEntry
+	stepIn
+	stepOver
+	stepOut
+)
+
+// varRef is a container of variables the client can expand.
+type varRef struct {
+	fr     *frame // frame evaluations for the container run in
+	values func() []namedValue
+}
+
+type namedValue struct {
+	name string
+	v    any
+	set  func(v any)
+}
+
+var errNotStopped = errors.New("the script is not stopped")
+
+// NewDebugger returns a debugger. If s is not nil, attach requests debug
+// it while the embedder runs scripts in it. NewDebugger replaces the
+// hook of s, so it must be called before s runs.
+func NewDebugger(s *State) *Debugger {
+	d := &Debugger{attach: s, work: make(chan func()), resume: make(chan stepMode), sources: map[string]string{}}
+	d.bps.Store(&map[string]map[int]string{})
+	if s != nil {
+		s.SetHook(HookLine, d.hook)
+	}
+	return d
+}
+
+// Serve answers the requests read from rw until the client disconnects
+// or closes the stream. Closing the stream is a disconnect.
+func (d *Debugger) Serve(rw io.ReadWriter) error {
+	d.w = rw
+	r := bufio.NewReader(rw)
+	for {
+		req, err := readDAPMessage(r)
+		if err != nil {
+			d.detach()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		body, err := d.handle(req)
+		if err != nil {
+			d.send(&dapResponse{Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: err.Error()})
+			continue
+		}
+		d.send(&dapResponse{Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body})
+		switch req.Command {
+		case "initialize":
+			d.event("initialized", nil)
+		case "configurationDone":
+			if d.run != nil && !d.started {
+				d.started = true
+				go d.run()
+			}
+		case "disconnect":
+			d.detach()
+			return nil
+		}
+	}
+}
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int    `json:"seq"`
+	Type       string `json:"type"`
+	RequestSeq int    `json:"request_seq"`
+	Success    bool   `json:"success"`
+	Command    string `json:"command"`
+	Message    string `json:"message,omitempty"`
+	Body       any    `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int    `json:"seq"`
+	Type  string `json:"type"`
+	Event string `json:"event"`
+	Body  any    `json:"body,omitempty"`
+}
+
+// readDAPMessage reads a message framed by a Content-Length header.
+func readDAPMessage(r *bufio.Reader) (*dapRequest, error) {
+	buf, err := readFrame(r, "dap")
+	if err != nil {
+		return nil, err
+	}
+	req := new(dapRequest)
+	if err := json.Unmarshal(buf, req); err != nil {
+		return nil, fmt.Errorf("dap: %v", err)
+	}
+	return req, nil
+}
+
+// readFrame reads the content of a message framed by a Content-Length
+// header, as the debug adapter and language server protocols frame
+// them. proto prefixes errors.
+func readFrame(r *bufio.Reader, proto string) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line != "" {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if v, ok := strings.CutPrefix(line, "Content-Length:"); ok {
+			if length, err = strconv.Atoi(strings.TrimSpace(v)); err != nil {
+				return nil, fmt.Errorf("%s: bad Content-Length %q", proto, v)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("%s: missing Content-Length", proto)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// send writes a response or event, numbering it.
+func (d *Debugger) send(msg any) {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	if d.closed {
+		return
+	}
+	d.seq++
+	switch m := msg.(type) {
+	case *dapResponse:
+		m.Seq = d.seq
+	case *dapEvent:
+		m.Seq = d.seq
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(d.w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+func (d *Debugger) event(name string, body any) {
+	d.send(&dapEvent{Type: "event", Event: name, Body: body})
+}
+
+// dapOutput sends what is written to it as output events.
+type dapOutput struct {
+	d        *Debugger
+	category string
+}
+
+func (o dapOutput) Write(p []byte) (int, error) {
+	o.d.event("output", map[string]any{"category": o.category, "output": string(p)})
+	return len(p), nil
+}
+
+func (d *Debugger) handle(req *dapRequest) (any, error) {
+	var args struct {
+		// launch
+		Program     string `json:"program"`
+		Cwd         string `json:"cwd"`
+		StopOnEntry bool   `json:"stopOnEntry"`
+		// setBreakpoints
+		Source struct {
+			Path string `json:"path"`
+		} `json:"source"`
+		Breakpoints []sourceBreakpoint `json:"breakpoints"`
+		// stackTrace, scopes, variables, setVariable, evaluate
+		FrameID            int    `json:"frameId"`
+		StartFrame         int    `json:"startFrame"`
+		Levels             int    `json:"levels"`
+		VariablesReference int    `json:"variablesReference"`
+		Name               string `json:"name"`
+		Value              string `json:"value"`
+		Expression         string `json:"expression"`
+	}
+	if len(req.Arguments) > 0 {
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+	}
+
+	switch req.Command {
+	case "initialize":
+		return map[string]any{
+			"supportsConfigurationDoneRequest": true,
+			"supportsConditionalBreakpoints":   true,
+			"supportsEvaluateForHovers":        true,
+			"supportsSetVariable":              true,
+		}, nil
+	case "launch":
+		return nil, d.launch(args.Program, args.Cwd, args.StopOnEntry)
+	case "attach":
+		if d.attach == nil {
+			return nil, errors.New("there is no state to attach to; use launch")
+		}
+		d.state = d.attach
+		return nil, nil
+	case "setBreakpoints":
+		return d.setBreakpoints(args.Source.Path, args.Breakpoints)
+	case "configurationDone":
+		d.active.Store(true)
+		return nil, nil
+	case "setExceptionBreakpoints", "disconnect":
+		return nil, nil
+	case "threads":
+		return map[string]any{"threads": []any{map[string]any{"id": 1, "name": "main"}}}, nil
+	case "stackTrace":
+		var body any
+		err := d.onScript(func() { body = d.stackTrace(args.StartFrame, args.Levels) })
+		return body, err
+	case "scopes":
+		var body any
+		err := d.onScript(func() { body = d.scopes(args.FrameID) })
+		return body, err
+	case "variables":
+		var body any
+		err := d.onScript(func() { body = d.variables(args.VariablesReference) })
+		return body, err
+	case "setVariable":
+		var body any
+		var err error
+		if serr := d.onScript(func() { body, err = d.setVariable(args.VariablesReference, args.Name, args.Value) }); serr != nil {
+			return nil, serr
+		}
+		return body, err
+	case "evaluate":
+		var body any
+		var err error
+		if serr := d.onScript(func() { body, err = d.evaluate(args.FrameID, args.Expression) }); serr != nil {
+			return nil, serr
+		}
+		return body, err
+	case "continue":
+		return map[string]any{"allThreadsContinued": true}, d.resumeWith(stepNone)
+	case "next":
+		return nil, d.resumeWith(stepOver)
+	case "stepIn":
+		return nil, d.resumeWith(stepIn)
+	case "stepOut":
+		return nil, d.resumeWith(stepOut)
+	case "pause":
+		d.pause.Store(true)
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported request %q", req.Command)
+}
+
+// launch prepares a new State to run program once the configuration is
+// done.
+func (d *Debugger) launch(program, cwd string, stopOnEntry bool) error {
+	if program == "" {
+		return errors.New("launch: no program given")
+	}
+	if cwd != "" && !filepath.IsAbs(program) {
+		program = filepath.Join(cwd, program)
+	}
+	program, err := filepath.Abs(program)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(program); err != nil {
+		return err
+	}
+	s := NewState()
+	s.Stdout = dapOutput{d, "stdout"}
+	s.SetDir(filepath.Dir(program))
+	ctx, cancel := context.WithCancel(context.Background())
+	s.SetContext(ctx)
+	s.SetHook(HookLine, d.hook)
+	d.state, d.cancel, d.done = s, cancel, make(chan struct{})
+	if stopOnEntry {
+		d.step = stepEntry
+	}
+	d.run = func() {
+		defer close(d.done)
+		code := 0
+		if err := s.DoFile(program); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			dapOutput{d, "stderr"}.Write([]byte(err.Error() + "\n"))
+			code = 1
+		}
+		d.event("exited", map[string]any{"exitCode": code})
+		d.event("terminated", nil)
+	}
+	return nil
+}
+
+// detach stops debugging: a launched script is stopped, an attached
+// State keeps running without the debugger.
+func (d *Debugger) detach() {
+	d.active.Store(false)
+	if d.cancel != nil {
+		d.cancel()
+	}
+	d.resumeWith(stepNone)
+	if d.started {
+		<-d.done
+	}
+	d.wmu.Lock()
+	d.closed = true
+	d.wmu.Unlock()
+}
+
+type sourceBreakpoint struct {
+	Line      int    `json:"line"`
+	Condition string `json:"condition"`
+}
+
+func (d *Debugger) setBreakpoints(path string, bps []sourceBreakpoint) (any, error) {
+	key, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	old := *d.bps.Load()
+	all := make(map[string]map[int]string, len(old)+1)
+	for k, v := range old {
+		all[k] = v
+	}
+	lines := map[int]string{}
+	var verified []any
+	for _, bp := range bps {
+		lines[bp.Line] = bp.Condition
+		verified = append(verified, map[string]any{"verified": true, "line": bp.Line})
+	}
+	all[key] = lines
+	d.bps.Store(&all)
+	if verified == nil {
+		verified = []any{}
+	}
+	return map[string]any{"breakpoints": verified}, nil
+}
+
+// hook runs when the debugged State enters a new line and stops there
+// when asked to.
+func (d *Debugger) hook(s *State, _ HookEvent) {
+	if !d.active.Load() {
+		return
+	}
+	fr := s.calls[len(s.calls)-1].fr
+	depth := len(fr.s.calls)
+	switch {
+	case d.pause.CompareAndSwap(true, false):
+		d.stop(fr.s, "pause")
+	case d.step == stepEntry:
+		d.stop(fr.s, "entry")
+	case d.step == stepIn, d.step == stepOver && depth <= d.depth, d.step == stepOut && depth < d.depth:
+		d.stop(fr.s, "step")
+	case d.hit(fr):
+		d.stop(fr.s, "breakpoint")
+	}
+}
+
+// hit reports whether a breakpoint is set at the statement of fr and its
+// condition holds. A condition that fails to evaluate stops.
+func (d *Debugger) hit(fr *frame) bool {
+	source := fr.fn.proto.source
+	key, ok := d.sources[source]
+	if !ok {
+		key, _ = filepath.Abs(source)
+		d.sources[source] = key
+	}
+	cond, ok := (*d.bps.Load())[key][fr.line]
+	if !ok {
+		return false
+	}
+	if cond == "" {
+		return true
+	}
+	rs, err := fr.s.evalIn(fr, cond)
+	if err != nil {
+		dapOutput{d, "stderr"}.Write([]byte("breakpoint condition: " + err.Error() + "\n"))
+		return true
+	}
+	return len(rs) > 0 && truthy(rs[0])
+}
+
+// stop reports a stop to the client and runs its requests until it
+// resumes the script.
+func (d *Debugger) stop(s *State, reason string) {
+	d.mu.Lock()
+	if !d.active.Load() {
+		// detached meanwhile
+		d.mu.Unlock()
+		return
+	}
+	d.stopped = true
+	d.mu.Unlock()
+	d.event("stopped", map[string]any{"reason": reason, "threadId": 1, "allThreadsStopped": true})
+	for {
+		select {
+		case f := <-d.work:
+			f()
+		case step := <-d.resume:
+			d.step, d.depth, d.refs = step, len(s.calls), nil
+			return
+		}
+	}
+}
+
+// onScript runs f on the goroutine of the stopped script.
+func (d *Debugger) onScript(f func()) error {
+	d.mu.Lock()
+	stopped := d.stopped
+	d.mu.Unlock()
+	if !stopped {
+		return errNotStopped
+	}
+	done := make(chan struct{})
+	d.work <- func() {
+		defer close(done)
+		f()
+	}
+	<-done
+	return nil
+}
+
+// resumeWith resumes the stopped script.
+func (d *Debugger) resumeWith(step stepMode) error {
+	d.mu.Lock()
+	stopped := d.stopped
+	d.stopped = false
+	d.mu.Unlock()
+	if !stopped {
+		return errNotStopped
+	}
+	d.resume <- step
+	return nil
+}
+
+// Frames are numbered by their index in the calls of the State plus one.
+
+func (d *Debugger) stackTrace(start, levels int) any {
+	calls := d.state.calls
+	var frames []any
+	for i := len(calls) - 1 - start; i >= 0 && (levels <= 0 || len(frames) < levels); i-- {
+		sf := calls[i].frame()
+		f := map[string]any{"id": i + 1, "name": sf.funcName(), "line": sf.Line, "column": 1}
+		if sf.What == "Go" {
+			f["presentationHint"] = "subtle"
+		}
+		if sf.Source != "" {
+			path, _ := filepath.Abs(sf.Source)
+			f["source"] = map[string]any{"name": filepath.Base(sf.Source), "path": path}
+		}
+		frames = append(frames, f)
+	}
+	if frames == nil {
+		frames = []any{}
+	}
+	return map[string]any{"stackFrames": frames, "totalFrames": len(calls)}
+}
+
+// frame returns the Lua frame numbered id, or the innermost one for 0.
+func (d *Debugger) frame(id int) *frame {
+	calls := d.state.calls
+	if id == 0 {
+		for i := len(calls) - 1; i >= 0; i-- {
+			if calls[i].fr != nil {
+				return calls[i].fr
+			}
+		}
+	}
+	if id < 1 || id > len(calls) {
+		return nil
+	}
+	return calls[id-1].fr
+}
+
+func (d *Debugger) ref(r *varRef) int {
+	d.refs = append(d.refs, r)
+	return len(d.refs)
+}
+
+func (d *Debugger) scopes(id int) any {
+	scopes := []any{}
+	fr := d.frame(id)
+	if fr == nil {
+		return map[string]any{"scopes": scopes}
+	}
+	scope := func(name, hint string, vars []frameVar) {
+		ref := d.ref(&varRef{fr: fr, values: func() []namedValue {
+			values := make([]namedValue, len(vars))
+			for i, v := range vars {
+				values[i] = namedValue{v.name, v.get(fr), func(x any) { v.set(fr, x) }}
+			}
+			return values
+		}})
+		scopes = append(scopes, map[string]any{"name": name, "presentationHint": hint, "variablesReference": ref, "expensive": false})
+	}
+	scope("Locals", "locals", frameLocals(fr))
+	if len(fr.fn.upvals) > 0 {
+		scope("Upvalues", "", frameUpvalues(fr))
+	}
+	if fr.fn.env != nil {
+		scopes = append(scopes, map[string]any{"name": "Globals", "variablesReference": d.tableRef(fr, fr.fn.env), "expensive": true})
+	}
+	return map[string]any{"scopes": scopes}
+}
+
+// tableRef returns a reference to the fields of t, the array part first.
+func (d *Debugger) tableRef(fr *frame, t *Table) int {
+	return d.ref(&varRef{fr: fr, values: func() []namedValue {
+		var keys []any
+		t.ForEach(func(k, _ any) { keys = append(keys, k) })
+		sort.SliceStable(keys, func(i, j int) bool {
+			a, aint := keys[i].(int64)
+			b, bint := keys[j].(int64)
+			if aint || bint {
+				return aint && (!bint || a < b)
+			}
+			return keyName(keys[i]) < keyName(keys[j])
+		})
+		values := make([]namedValue, len(keys))
+		for i, k := range keys {
+			values[i] = namedValue{keyName(k), t.Get(k), func(v any) { t.Set(k, v) }}
+		}
+		return values
+	}})
+}
+
+func keyName(k any) string {
+	if s, ok := k.(string); ok && isIdentifier(s) {
+		return s
+	}
+	v, _ := dapValue(k)
+	return "[" + v + "]"
+}
+
+func isIdentifier(s string) bool {
+	if s == "" || !isLetter(s[0]) || LookupIdent(s) != Literal {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isLetter(s[i]) && !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// dapValue formats v for the client without calling metamethods.
+func dapValue(v any) (value, typ string) {
+	if s, ok := v.(string); ok {
+		return strconv.Quote(s), "string"
+	}
+	return tostringBasic(v), luaTypeName(v)
+}
+
+// variable describes v to the client; tables can be expanded.
+func (d *Debugger) variable(fr *frame, name string, v any) map[string]any {
+	value, typ := dapValue(v)
+	ref := 0
+	if t, ok := v.(*Table); ok {
+		ref = d.tableRef(fr, t)
+	}
+	return map[string]any{"name": name, "value": value, "type": typ, "variablesReference": ref}
+}
+
+func (d *Debugger) variables(ref int) any {
+	vars := []any{}
+	if ref >= 1 && ref <= len(d.refs) {
+		r := d.refs[ref-1]
+		for _, nv := range r.values() {
+			vars = append(vars, d.variable(r.fr, nv.name, nv.v))
+		}
+	}
+	return map[string]any{"variables": vars}
+}
+
+func (d *Debugger) setVariable(ref int, name, value string) (any, error) {
+	if ref < 1 || ref > len(d.refs) {
+		return nil, errors.New("unknown variables reference")
+	}
+	r := d.refs[ref-1]
+	for _, nv := range r.values() {
+		if nv.name != name {
+			continue
+		}
+		rs, err := d.state.evalIn(r.fr, value)
+		if err != nil {
+			return nil, err
+		}
+		var v any
+		if len(rs) > 0 {
+			v = rs[0]
+		}
+		nv.set(v)
+		return d.variable(r.fr, name, v), nil
+	}
+	return nil, fmt.Errorf("no variable %q", name)
+}
+
+func (d *Debugger) evaluate(id int, expr string) (any, error) {
+	fr := d.frame(id)
+	if fr == nil {
+		return nil, errors.New("no Lua frame to evaluate in")
+	}
+	rs, err := d.state.evalIn(fr, expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(rs) == 1 {
+		v := d.variable(fr, "", rs[0])
+		return map[string]any{"result": v["value"], "type": v["type"], "variablesReference": v["variablesReference"]}, nil
+	}
+	values := make([]string, len(rs))
+	for i, v := range rs {
+		values[i], _ = dapValue(v)
+	}
+	return map[string]any{"result": strings.Join(values, ", "), "variablesReference": 0}, nil
+}
@@ -0,0 +1,464 @@
+package luanova
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// dapMessage is any message sent by the debugger.
+type dapMessage struct {
+	Type       string          `json:"type"`
+	Event      string          `json:"event"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Command    string          `json:"command"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// dapClient drives a Debugger like an editor would.
+type dapClient struct {
+	t        *testing.T
+	w        io.WriteCloser
+	messages chan *dapMessage
+	events   []*dapMessage // received while waiting for a response
+	seq      int
+	done     chan error
+}
+
+func startDAP(t *testing.T, d *Debugger) *dapClient {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &dapClient{t: t, w: inW, messages: make(chan *dapMessage, 100), done: make(chan error, 1)}
+	go func() {
+		c.done <- d.Serve(struct {
+			io.Reader
+			io.Writer
+		}{inR, outW})
+		outW.Close()
+	}()
+	go func() {
+		defer close(c.messages)
+		r := bufio.NewReader(outR)
+		for {
+			var length int
+			if _, err := fmt.Fscanf(r, "Content-Length: %d\r\n\r\n", &length); err != nil {
+				return
+			}
+			buf := make([]byte, length)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return
+			}
+			m := new(dapMessage)
+			if err := json.Unmarshal(buf, m); err != nil {
+				t.Errorf("bad message %s: %v", buf, err)
+				return
+			}
+			c.messages <- m
+		}
+	}()
+	t.Cleanup(func() { inW.Close() })
+	return c
+}
+
+func (c *dapClient) next() *dapMessage {
+	c.t.Helper()
+	select {
+	case m, ok := <-c.messages:
+		if !ok {
+			c.t.Fatal("debugger closed the stream")
+		}
+		return m
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timeout waiting for the debugger")
+	}
+	return nil
+}
+
+// call sends a request and decodes the body of its response into body,
+// which may be nil. It fails the test if the request fails.
+func (c *dapClient) call(command string, args, body any) {
+	c.t.Helper()
+	if m := c.request(command, args); !m.Success {
+		c.t.Fatalf("%s: %s", command, m.Message)
+	} else if body != nil {
+		if err := json.Unmarshal(m.Body, body); err != nil {
+			c.t.Fatalf("%s: %v", command, err)
+		}
+	}
+}
+
+func (c *dapClient) request(command string, args any) *dapMessage {
+	c.t.Helper()
+	c.seq++
+	data, _ := json.Marshal(map[string]any{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(data), data); err != nil {
+		c.t.Fatal(err)
+	}
+	for {
+		m := c.next()
+		if m.Type == "response" && m.RequestSeq == c.seq {
+			return m
+		}
+		c.events = append(c.events, m)
+	}
+}
+
+// event waits for the named event, skipping output events, and decodes
+// its body into body.
+func (c *dapClient) event(name string, body any) {
+	c.t.Helper()
+	for {
+		var m *dapMessage
+		if len(c.events) > 0 {
+			m, c.events = c.events[0], c.events[1:]
+		} else {
+			m = c.next()
+		}
+		if m.Type != "event" || m.Event == "output" && name != "output" {
+			continue
+		}
+		if m.Event != name {
+			c.t.Fatalf("got event %s %s, want %s", m.Event, m.Body, name)
+		}
+		if body != nil {
+			if err := json.Unmarshal(m.Body, body); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return
+	}
+}
+
+func (c *dapClient) disconnect() {
+	c.t.Helper()
+	c.call("disconnect", nil, nil)
+	select {
+	case err := <-c.done:
+		if err != nil {
+			c.t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timeout waiting for the debugger to stop")
+	}
+}
+
+type dapStop struct {
+	Reason string `json:"reason"`
+}
+
+type dapFrame struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Line   int    `json:"line"`
+	Source struct {
+		Path string `json:"path"`
+	} `json:"source"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+func (c *dapClient) stopped(reason string) {
+	c.t.Helper()
+	var stop dapStop
+	c.event("stopped", &stop)
+	if stop.Reason != reason {
+		c.t.Fatalf("stopped for %s, want %s", stop.Reason, reason)
+	}
+}
+
+func (c *dapClient) frames() []dapFrame {
+	c.t.Helper()
+	var body struct{ StackFrames []dapFrame }
+	c.call("stackTrace", map[string]any{"threadId": 1}, &body)
+	return body.StackFrames
+}
+
+// where returns the name and line of the innermost frame.
+func (c *dapClient) where() string {
+	c.t.Helper()
+	f := c.frames()[0]
+	return fmt.Sprintf("%s:%d", f.Name, f.Line)
+}
+
+// scope returns the variables of the named scope of frame id as
+// name=value strings, and the reference of the scope.
+func (c *dapClient) scope(id int, name string) (map[string]string, int) {
+	c.t.Helper()
+	var scopes struct {
+		Scopes []struct {
+			Name               string
+			VariablesReference int
+		}
+	}
+	c.call("scopes", map[string]any{"frameId": id}, &scopes)
+	for _, s := range scopes.Scopes {
+		if s.Name == name {
+			return c.variables(s.VariablesReference), s.VariablesReference
+		}
+	}
+	c.t.Fatalf("no scope %s", name)
+	return nil, 0
+}
+
+func (c *dapClient) variables(ref int) map[string]string {
+	c.t.Helper()
+	var body struct{ Variables []dapVariable }
+	c.call("variables", map[string]any{"variablesReference": ref}, &body)
+	vars := map[string]string{}
+	for _, v := range body.Variables {
+		vars[v.Name] = v.Value
+	}
+	return vars
+}
+
+func (c *dapClient) evaluate(id int, expr string) dapVariable {
+	c.t.Helper()
+	var body struct {
+		Result             string
+		VariablesReference int
+	}
+	c.call("evaluate", map[string]any{"frameId": id, "expression": expr}, &body)
+	return dapVariable{Value: body.Result, VariablesReference: body.VariablesReference}
+}
+
+func writeScript(t *testing.T, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "main.lunv")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDAPLaunch(t *testing.T) {
+	path := writeScript(t, `local function add(a, b)
+	local sum = a + b
+	return sum
+end
+local total = 0
+for i = 1, 3 do
+	total = add(total, i)
+end
+print(total)
+`)
+	c := startDAP(t, NewDebugger(nil))
+	c.call("initialize", map[string]any{"adapterID": "luanova"}, nil)
+	c.event("initialized", nil)
+	c.call("launch", map[string]any{"program": path}, nil)
+	var bps struct{ Breakpoints []struct{ Verified bool } }
+	c.call("setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": path},
+		"breakpoints": []any{map[string]any{"line": 7, "condition": "i == 2"}},
+	}, &bps)
+	if len(bps.Breakpoints) != 1 || !bps.Breakpoints[0].Verified {
+		t.Fatalf("breakpoints = %+v", bps)
+	}
+	c.call("configurationDone", nil, nil)
+
+	c.stopped("breakpoint")
+	frames := c.frames()
+	if f := frames[0]; f.Name != "main chunk" || f.Line != 7 || f.Source.Path != path {
+		t.Fatalf("top frame = %+v", f)
+	}
+	locals, _ := c.scope(frames[0].ID, "Locals")
+	if locals["total"] != "1" || locals["i"] != "2" || locals["add"] == "" {
+		t.Errorf("locals = %v", locals)
+	}
+	if v := c.evaluate(0, "total + i"); v.Value != "3" {
+		t.Errorf("total + i = %s", v.Value)
+	}
+	if m := c.request("evaluate", map[string]any{"expression": "nil + 1"}); m.Success {
+		t.Errorf("evaluating nil + 1 succeeded")
+	}
+
+	c.call("stepIn", map[string]any{"threadId": 1}, nil)
+	c.stopped("step")
+	if w := c.where(); w != "add:2" {
+		t.Errorf("after stepIn at %s", w)
+	}
+	locals, _ = c.scope(c.frames()[0].ID, "Locals")
+	if locals["a"] != "1" || locals["b"] != "2" || locals["sum"] != "" {
+		t.Errorf("locals of add = %v", locals)
+	}
+	c.call("next", map[string]any{"threadId": 1}, nil)
+	c.stopped("step")
+	if w := c.where(); w != "add:3" {
+		t.Errorf("after next at %s", w)
+	}
+	c.call("stepOut", map[string]any{"threadId": 1}, nil)
+	c.stopped("step")
+	if w := c.where(); w != "main chunk:7" {
+		t.Errorf("after stepOut at %s", w)
+	}
+
+	_, ref := c.scope(c.frames()[0].ID, "Locals")
+	var set struct{ Value string }
+	c.call("setVariable", map[string]any{"variablesReference": ref, "name": "total", "value": "100"}, &set)
+	if set.Value != "100" {
+		t.Errorf("setVariable = %s", set.Value)
+	}
+	c.call("continue", map[string]any{"threadId": 1}, nil)
+	var out struct{ Output string }
+	c.event("output", &out)
+	if out.Output != "103\n" {
+		t.Errorf("output = %q", out.Output)
+	}
+	var exited struct{ ExitCode int }
+	c.event("exited", &exited)
+	if exited.ExitCode != 0 {
+		t.Errorf("exit code %d", exited.ExitCode)
+	}
+	c.event("terminated", nil)
+	c.disconnect()
+}
+
+func TestDAPLaunchImports(t *testing.T) {
+	path := writeScript(t, `import { greet } from "./lib/greet"
+print(greet())
+`)
+	dir := filepath.Dir(path)
+	files := map[string]string{
+		"lib/greet.lunv": "import { name } from \"../name\"\nexport function greet()\n\treturn \"hello \" .. name\nend\n",
+		"name.lunv":      `export local name = "world"`,
+	}
+	for name, src := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := startDAP(t, NewDebugger(nil))
+	c.call("initialize", nil, nil)
+	c.event("initialized", nil)
+	c.call("launch", map[string]any{"program": path}, nil)
+	greet := filepath.Join(dir, "lib", "greet.lunv")
+	c.call("setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": greet},
+		"breakpoints": []any{map[string]any{"line": 3}},
+	}, nil)
+	c.call("configurationDone", nil, nil)
+
+	c.stopped("breakpoint")
+	if f := c.frames()[0]; f.Name != "greet" || f.Line != 3 || f.Source.Path != greet {
+		t.Fatalf("top frame = %+v", f)
+	}
+	c.call("continue", map[string]any{"threadId": 1}, nil)
+	var out struct{ Output string }
+	c.event("output", &out)
+	if out.Output != "hello world\n" {
+		t.Errorf("output = %q", out.Output)
+	}
+	c.event("exited", nil)
+	c.event("terminated", nil)
+	c.disconnect()
+}
+
+func TestDAPStopOnEntryAndPause(t *testing.T) {
+	path := writeScript(t, `local n = 0
+while true do
+	n = n + 1
+end
+`)
+	c := startDAP(t, NewDebugger(nil))
+	c.call("initialize", nil, nil)
+	c.event("initialized", nil)
+	c.call("launch", map[string]any{"program": path, "stopOnEntry": true}, nil)
+	c.call("configurationDone", nil, nil)
+	c.stopped("entry")
+	if w := c.where(); w != "main chunk:1" {
+		t.Errorf("entry at %s", w)
+	}
+	c.call("continue", map[string]any{"threadId": 1}, nil)
+	if m := c.request("evaluate", map[string]any{"expression": "n"}); m.Success {
+		t.Errorf("evaluate succeeded while running")
+	}
+	c.call("pause", map[string]any{"threadId": 1}, nil)
+	c.stopped("pause")
+	if v := c.evaluate(0, "n > 0"); v.Value != "true" {
+		t.Errorf("n > 0 = %s", v.Value)
+	}
+	c.disconnect()
+}
+
+func TestDAPAttach(t *testing.T) {
+	s := NewState()
+	d := NewDebugger(s)
+	fn, err := s.Load(`local cfg = {depth = 2, "a"}
+local count = 10
+local function bump()
+	count = count + 1
+end
+bump()
+return count, cfg.depth
+`, "attach.lunv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := startDAP(t, d)
+	c.call("initialize", nil, nil)
+	c.event("initialized", nil)
+	c.call("attach", nil, nil)
+	c.call("setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": "attach.lunv"},
+		"breakpoints": []any{map[string]any{"line": 4}},
+	}, nil)
+	c.call("configurationDone", nil, nil)
+
+	results := make(chan []any, 1)
+	go func() {
+		rs, err := s.PCall(fn)
+		if err != nil {
+			t.Error(err)
+		}
+		results <- rs
+	}()
+
+	c.stopped("breakpoint")
+	frames := c.frames()
+	if len(frames) != 2 || frames[0].Name != "bump" || frames[1].Line != 6 {
+		t.Fatalf("frames = %+v", frames)
+	}
+	if up, _ := c.scope(frames[0].ID, "Upvalues"); up["count"] != "10" {
+		t.Errorf("upvalues = %v", up)
+	}
+	if globals, _ := c.scope(frames[0].ID, "Globals"); globals["print"] == "" {
+		t.Errorf("globals lack print")
+	}
+	if v := c.evaluate(frames[0].ID, "count * 2"); v.Value != "20" {
+		t.Errorf("count * 2 = %s", v.Value)
+	}
+	c.evaluate(frames[0].ID, "count = 5")
+	cfg := c.evaluate(frames[1].ID, "cfg")
+	if cfg.VariablesReference == 0 {
+		t.Fatal("cfg is not expandable")
+	}
+	fields := c.variables(cfg.VariablesReference)
+	if fields["[1]"] != `"a"` || fields["depth"] != "2" {
+		t.Errorf("cfg = %v", fields)
+	}
+	c.disconnect()
+
+	select {
+	case rs := <-results:
+		if len(rs) != 2 || rs[0] != int64(6) || rs[1] != int64(2) {
+			t.Errorf("results = %v", rs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the script did not resume")
+	}
+}
@@ -0,0 +1,172 @@
+package luanova
+
+// stmtInfo describes a statement for debuggers: its line and the locals
+// in scope when it starts.
+type stmtInfo struct {
+	line  int
+	pos   Position
+	block *blockScope
+	nvars int // vars of block declared before the statement
+}
+
+// newLine reports whether running at after prev in the same frame
+// enters a new line: the line changes or the frame jumps back, as
+// loops do.
+func (at *stmtInfo) newLine(prev *stmtInfo) bool {
+	return prev == nil || prev.line != at.line || prev.pos.Offset >= at.pos.Offset
+}
+
+// locals returns the locals in scope, outermost first.
+func (at *stmtInfo) locals() []*localVar {
+	var vars []*localVar
+	for b, n := at.block, at.nvars; b != nil; b, n = b.parent, b.nparent {
+		vars = append(append([]*localVar(nil), b.vars[:n]...), vars...)
+	}
+	return vars
+}
+
+// locals returns the active locals of fr, outermost first; before the
+// first statement those are the parameters.
+func (fr *frame) locals() []*localVar {
+	if fr.at == nil {
+		return fr.fn.proto.params
+	}
+	return fr.at.locals()
+}
+
+// localAt returns the nth local of fr numbered like debug.getlocal does:
+// active locals from 1, varargs from -1.
+func localAt(fr *frame, n int) (name string, v any, ok bool) {
+	if n < 0 {
+		if -n > len(fr.varargs) {
+			return "", nil, false
+		}
+		return "(vararg)", fr.varargs[-n-1], true
+	}
+	lvs := fr.locals()
+	if n < 1 || n > len(lvs) {
+		return "", nil, false
+	}
+	return lvs[n-1].name, getLocal(fr, lvs[n-1]), true
+}
+
+// setLocalAt assigns v to the nth local of fr, numbered like localAt.
+func setLocalAt(fr *frame, n int, v any) (name string, ok bool) {
+	if n < 0 {
+		if -n > len(fr.varargs) {
+			return "", false
+		}
+		fr.varargs[-n-1] = v
+		return "(vararg)", true
+	}
+	lvs := fr.locals()
+	if n < 1 || n > len(lvs) {
+		return "", false
+	}
+	setLocal(fr, lvs[n-1], v)
+	return lvs[n-1].name, true
+}
+
+// frameVar is a variable visible from a frame.
+type frameVar struct {
+	name string
+	lv   *localVar // nil for an upvalue
+	up   int       // index in the upvalues of the frame's function
+}
+
+func (v frameVar) get(fr *frame) any {
+	if v.lv != nil {
+		return getLocal(fr, v.lv)
+	}
+	return fr.fn.upvals[v.up].v
+}
+
+func (v frameVar) set(fr *frame, x any) {
+	if v.lv != nil {
+		setLocal(fr, v.lv, x)
+	} else {
+		fr.fn.upvals[v.up].v = x
+	}
+}
+
+// frameLocals returns the locals of fr in scope at its running
+// statement, outermost first; a local hidden by a later one of the same
+// name is left out.
+func frameLocals(fr *frame) []frameVar {
+	lvs := fr.locals()
+	var vars []frameVar
+	for i, lv := range lvs {
+		hidden := false
+		for _, later := range lvs[i+1:] {
+			hidden = hidden || later.name == lv.name
+		}
+		if !hidden {
+			vars = append(vars, frameVar{name: lv.name, lv: lv})
+		}
+	}
+	return vars
+}
+
+// frameUpvalues returns the upvalues of the function running in fr.
+func frameUpvalues(fr *frame) []frameVar {
+	vars := make([]frameVar, len(fr.fn.proto.upvals))
+	for i, u := range fr.fn.proto.upvals {
+		vars[i] = frameVar{name: u.name, up: i}
+	}
+	return vars
+}
+
+// evalIn runs src in the scope of fr: it sees the locals and upvalues of
+// fr, may assign to them, and gets the varargs of fr as its own. src is
+// an expression or, failing that, a block of statements. It is meant to
+// run in hooks, so no events are reported for it.
+func (s *State) evalIn(fr *frame, src string) ([]any, error) {
+	chunk, err := Parse("eval", "return "+src)
+	if err != nil {
+		var serr error
+		if chunk, serr = Parse("eval", src); serr != nil {
+			return nil, err
+		}
+	}
+
+	// the variables of fr become upvalues of the chunk; locals that are
+	// not captured get a cell of their own, copied back afterwards
+	vars := append(frameLocals(fr), frameUpvalues(fr)...)
+	names := make([]string, len(vars))
+	cells := make([]*cell, len(vars))
+	for i := range vars {
+		v := vars[len(vars)-1-i] // innermost first
+		names[i] = v.name
+		switch {
+		case v.lv == nil:
+			cells[i] = fr.fn.upvals[v.up]
+		case v.lv.captured:
+			cells[i] = fr.slots[v.lv.slot].(*cell)
+		default:
+			cells[i] = &cell{fr.slots[v.lv.slot]}
+		}
+	}
+
+	c := &compiler{source: chunk.Name}
+	p := &funcProto{source: chunk.Name, main: true, isVararg: true}
+	c.openFunction(p)
+	c.fs.outer = names
+	body := c.compileBlock(chunk.Block)
+	c.closeFunction()
+	if err := c.errors.Err(); err != nil {
+		return nil, err
+	}
+	p.body = func(fr *frame) { body(fr) }
+	fn := &Closure{proto: p, env: fr.fn.env, upvals: make([]*cell, len(p.upvals))}
+	for i, u := range p.upvals {
+		fn.upvals[i] = cells[u.index]
+	}
+
+	rs, err := s.PCall(fn, fr.varargs...)
+	for i, v := range vars {
+		if v.lv != nil && !v.lv.captured {
+			fr.slots[v.lv.slot] = cells[len(vars)-1-i].v
+		}
+	}
+	return rs, err
+}
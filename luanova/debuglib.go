@@ -0,0 +1,207 @@
+package luanova
+
+func openDebug(s *State) {
+	lib := NewTable()
+	setFuncs(lib, "debug.", map[string]GoFunction{
+		"getinfo":    dbgGetinfo,
+		"getlocal":   dbgGetlocal,
+		"setlocal":   dbgSetlocal,
+		"getupvalue": dbgGetupvalue,
+		"sethook":    dbgSethook,
+		"traceback":  dbgTraceback,
+	})
+	s.globals.Set("debug", lib)
+}
+
+// callAt returns the call at level, where level 0 is the running Go
+// function, or nil if there is none.
+func (s *State) callAt(level int64) *callInfo {
+	i := int64(len(s.calls)) - 1 - level
+	if level < 0 || i < 0 {
+		return nil
+	}
+	return s.calls[i]
+}
+
+// dbgGetinfo implements debug.getinfo(f [, what]) where f is a function
+// or a stack level. what selects fields like in Lua: S for the source,
+// l for the current line, n for the name, u for upvalues and
+// parameters, f for the function.
+func dbgGetinfo(s *State) int {
+	var ci *callInfo
+	fn, ok := s.CheckAny(1).(*Closure)
+	if !ok {
+		if ci = s.callAt(s.CheckInteger(1)); ci == nil {
+			s.Push(nil)
+			return 1
+		}
+		fn = ci.fn
+	}
+	info := NewTable()
+	for _, c := range s.OptString(2, "flnSu") {
+		switch c {
+		case 'S':
+			if p := fn.proto; p != nil {
+				what := "Lua"
+				if p.main {
+					what = "main"
+				}
+				info.Set("source", p.source)
+				info.Set("short_src", p.source)
+				info.Set("what", what)
+				info.Set("linedefined", int64(p.line))
+				info.Set("lastlinedefined", int64(p.lastLine))
+			} else {
+				info.Set("source", "=[Go]")
+				info.Set("short_src", "[Go]")
+				info.Set("what", "Go")
+				info.Set("linedefined", int64(-1))
+				info.Set("lastlinedefined", int64(-1))
+			}
+		case 'l':
+			line := int64(-1)
+			if ci != nil && ci.fr != nil {
+				line = int64(ci.fr.line)
+			}
+			info.Set("currentline", line)
+		case 'n':
+			if ci != nil && fn.Name() != "" {
+				info.Set("name", fn.Name())
+			}
+		case 'u':
+			if p := fn.proto; p != nil {
+				info.Set("nups", int64(len(fn.upvals)))
+				info.Set("nparams", int64(len(p.params)))
+				info.Set("isvararg", p.isVararg)
+			} else {
+				info.Set("nups", int64(0))
+				info.Set("nparams", int64(0))
+				info.Set("isvararg", true)
+			}
+		case 'f':
+			info.Set("func", fn)
+		default:
+			s.ArgError(2, "invalid option")
+		}
+	}
+	s.Push(info)
+	return 1
+}
+
+// luaFrameAt returns the frame at level for getlocal and setlocal, or
+// nil for a Go function.
+func (s *State) luaFrameAt(level int64) *frame {
+	ci := s.callAt(level)
+	if ci == nil {
+		s.ArgError(1, "level out of range")
+	}
+	return ci.fr
+}
+
+// dbgGetlocal implements debug.getlocal(level, n), which returns the
+// name and value of a local, and debug.getlocal(f, n), which returns the
+// name of a parameter.
+func dbgGetlocal(s *State) int {
+	n := int(s.CheckInteger(2))
+	if fn, ok := s.Get(1).(*Closure); ok {
+		if fn.proto == nil || n < 1 || n > len(fn.proto.params) {
+			s.Push(nil)
+			return 1
+		}
+		s.Push(fn.proto.params[n-1].name)
+		return 1
+	}
+	fr := s.luaFrameAt(s.CheckInteger(1))
+	if fr == nil {
+		s.Push(nil)
+		return 1
+	}
+	name, v, ok := localAt(fr, n)
+	if !ok {
+		s.Push(nil)
+		return 1
+	}
+	s.Push(name, v)
+	return 2
+}
+
+// dbgSetlocal implements debug.setlocal(level, n, value).
+func dbgSetlocal(s *State) int {
+	n := int(s.CheckInteger(2))
+	v := s.CheckAny(3)
+	fr := s.luaFrameAt(s.CheckInteger(1))
+	if fr == nil {
+		s.Push(nil)
+		return 1
+	}
+	name, ok := setLocalAt(fr, n, v)
+	if !ok {
+		s.Push(nil)
+		return 1
+	}
+	s.Push(name)
+	return 1
+}
+
+// dbgGetupvalue implements debug.getupvalue(f, n).
+func dbgGetupvalue(s *State) int {
+	fn := s.CheckFunction(1)
+	n := int(s.CheckInteger(2))
+	if fn.proto == nil || n < 1 || n > len(fn.upvals) {
+		return 0
+	}
+	s.Push(fn.proto.upvals[n-1].name, fn.upvals[n-1].v)
+	return 2
+}
+
+// dbgSethook implements debug.sethook([f, mask [, count]]). mask holds
+// c, r and l for call, return and line events; a count above zero adds
+// count events every count statements. Without arguments the hook is
+// removed. The hook is called with the event name and, for line
+// events, the line.
+func dbgSethook(s *State) int {
+	if s.Top() == 0 || s.Get(1) == nil {
+		s.SetHook(0, nil)
+		return 0
+	}
+	f := s.CheckFunction(1)
+	var mask HookMask
+	for _, c := range s.OptString(2, "") {
+		switch c {
+		case 'c':
+			mask |= HookCall
+		case 'r':
+			mask |= HookReturn
+		case 'l':
+			mask |= HookLine
+		}
+	}
+	if count := s.OptInteger(3, 0); count > 0 {
+		mask |= HookCount
+		s.SetHookCount(int(count))
+	}
+	s.SetHook(mask, func(s *State, ev HookEvent) {
+		args := []any{ev.Kind.name()}
+		if ev.Kind == HookLine {
+			args = append(args, int64(ev.Pos.Line))
+		}
+		s.call(f, args)
+	})
+	return 0
+}
+
+// dbgTraceback implements debug.traceback([message [, level]]).
+func dbgTraceback(s *State) int {
+	msg := s.Get(1)
+	if _, ok := msg.(string); !ok && msg != nil {
+		s.Push(msg)
+		return 1
+	}
+	level := s.OptInteger(2, 1)
+	tb := formatTraceback(s.stackTrace(int(level)))
+	if msg != nil {
+		tb = msg.(string) + "\n" + tb
+	}
+	s.Push(tb)
+	return 1
+}
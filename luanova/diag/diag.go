@@ -0,0 +1,149 @@
+// Package diag describes the problems found in LuaNova sources, from
+// syntax errors to lint warnings, and renders them for people and tools:
+// as text with the offending source line underlined, optionally
+// coloured, as JSON or as SARIF 2.1.0 for code scanning services.
+package diag
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Position is a location in a source. Line and Column are 1-based;
+// Column counts bytes. A zero Column stands for the whole line.
+type Position struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Severity is the level of a diagnostic. SeverityOff is used in
+// configurations to turn a check off.
+type Severity int
+
+const (
+	SeverityOff Severity = iota
+	SeverityInfo
+	SeverityWarning
+	SeverityError
+)
+
+var severityNames = [...]string{"off", "info", "warning", "error"}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+	return severityNames[s]
+}
+
+// ParseSeverity returns the severity named s.
+func ParseSeverity(s string) (Severity, error) {
+	for i, name := range severityNames {
+		if s == name {
+			return Severity(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown severity %q", s)
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Severity) UnmarshalText(text []byte) error {
+	v, err := ParseSeverity(string(text))
+	*s = v
+	return err
+}
+
+// TextEdit replaces the source between Start and End with NewText.
+type TextEdit struct {
+	Start   Position `json:"start"`
+	End     Position `json:"end"`
+	NewText string   `json:"newText"`
+}
+
+// Fix is a change that resolves a diagnostic.
+type Fix struct {
+	Message string     `json:"message"`
+	Edits   []TextEdit `json:"edits"`
+}
+
+// Note adds information to a diagnostic, about another place in the
+// same source when Pos is set.
+type Note struct {
+	Pos     Position `json:"pos"`
+	End     Position `json:"end"`
+	Message string   `json:"message"`
+}
+
+// Diagnostic is a problem in a source. Code names the check that found
+// it, such as a lint rule; it is empty for syntax errors.
+type Diagnostic struct {
+	Source   string   `json:"source"`
+	Pos      Position `json:"pos"`
+	End      Position `json:"end"`
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code,omitempty"`
+	Message  string   `json:"message"`
+	Notes    []Note   `json:"notes,omitempty"`
+	Fixes    []Fix    `json:"fixes,omitempty"`
+}
+
+func (d Diagnostic) String() string {
+	s := fmt.Sprintf("%s:%d:%d: %s", d.Source, d.Pos.Line, d.Pos.Column, d.Message)
+	if d.Code != "" {
+		s += " (" + d.Code + ")"
+	}
+	return s
+}
+
+// ApplyEdits applies non-overlapping edits to src.
+func ApplyEdits(src []byte, edits []TextEdit) []byte {
+	edits = append([]TextEdit(nil), edits...)
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].Start.Offset < edits[j].Start.Offset })
+	var b bytes.Buffer
+	last := 0
+	for _, e := range edits {
+		b.Write(src[last:e.Start.Offset])
+		b.WriteString(e.NewText)
+		last = e.End.Offset
+	}
+	b.Write(src[last:])
+	return b.Bytes()
+}
+
+// FixAll returns the edits of the first fix of each diagnostic, leaving
+// out fixes that overlap one already taken.
+func FixAll(diags []Diagnostic) []TextEdit {
+	var edits []TextEdit
+	overlaps := func(e TextEdit) bool {
+		for _, prev := range edits {
+			if e.Start.Offset < prev.End.Offset && prev.Start.Offset < e.End.Offset ||
+				e.Start.Offset == prev.Start.Offset {
+				return true
+			}
+		}
+		return false
+	}
+	for _, d := range diags {
+		if len(d.Fixes) == 0 {
+			continue
+		}
+		fix := d.Fixes[0].Edits
+		ok := true
+		for _, e := range fix {
+			ok = ok && !overlaps(e)
+		}
+		if ok {
+			edits = append(edits, fix...)
+		}
+	}
+	return edits
+}
@@ -0,0 +1,153 @@
+package diag
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var src = []byte("local t = {\n\tx = 1, x = 2,\n}\nprint(t)\n")
+
+func pos(offset, line, column int) Position {
+	return Position{Offset: offset, Line: line, Column: column}
+}
+
+var dup = Diagnostic{
+	Source:   "test.lunv",
+	Pos:      pos(19, 2, 9),
+	End:      pos(24, 2, 14),
+	Severity: SeverityWarning,
+	Code:     "duplicate-key",
+	Message:  "duplicate key \"x\" in table",
+	Notes: []Note{
+		{Pos: pos(12, 2, 2), End: pos(17, 2, 7), Message: "first set here"},
+		{Message: "the last value wins"},
+	},
+	Fixes: []Fix{{Message: "remove the first field", Edits: []TextEdit{{Start: pos(12, 2, 2), End: pos(19, 2, 9)}}}},
+}
+
+func TestPrinter(t *testing.T) {
+	p := &Printer{Source: func(string) []byte { return src }}
+	var b bytes.Buffer
+	if err := p.FprintAll(&b, []Diagnostic{dup, {Source: "test.lunv", Pos: pos(0, 4, 0), Severity: SeverityError, Message: "boom"}}); err != nil {
+		t.Fatal(err)
+	}
+	expected := `warning[duplicate-key]: duplicate key "x" in table
+ --> test.lunv:2:9
+  |
+2 |     x = 1, x = 2,
+  |            ^^^^^
+note: first set here
+ --> test.lunv:2:2
+  |
+2 |     x = 1, x = 2,
+  |     ^^^^^
+  = note: the last value wins
+  = help: remove the first field
+
+error: boom
+ --> test.lunv:4
+  |
+4 | print(t)
+`
+	if b.String() != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", b.String(), expected)
+	}
+
+	// without the source only the positions are shown
+	b.Reset()
+	(&Printer{Color: true}).Fprint(&b, dup)
+	if got := b.String(); !strings.Contains(got, yellow+"warning[duplicate-key]"+reset) || strings.Contains(got, "x = 1") {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteJSON(&b, []Diagnostic{dup}); err != nil {
+		t.Fatal(err)
+	}
+	var got []Diagnostic
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []Diagnostic{dup}) {
+		t.Errorf("got %+v", got)
+	}
+	if !strings.Contains(b.String(), `"severity": "warning"`) {
+		t.Errorf("severity not written by name:\n%s", b.String())
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	var b bytes.Buffer
+	tool := Tool{Name: "luanova lint", Rules: []Rule{{ID: "duplicate-key", Description: "Duplicate keys.", Severity: SeverityWarning}}}
+	if err := WriteSARIF(&b, tool, []Diagnostic{dup}); err != nil {
+		t.Fatal(err)
+	}
+	var log struct {
+		Version string
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Name  string
+					Rules []struct{ ID string }
+				}
+			}
+			Results []struct {
+				RuleID    string
+				Level     string
+				Message   struct{ Text string }
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct{ URI string }
+						Region           struct{ StartLine, StartColumn, EndLine, EndColumn int }
+					}
+				}
+				RelatedLocations []struct{ Message struct{ Text string } }
+				Fixes            []struct {
+					ArtifactChanges []struct {
+						Replacements []struct {
+							DeletedRegion struct{ StartColumn, EndColumn int }
+						}
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(b.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 || log.Runs[0].Tool.Driver.Rules[0].ID != "duplicate-key" {
+		t.Fatalf("bad log:\n%s", b.String())
+	}
+	res := log.Runs[0].Results[0]
+	loc := res.Locations[0].PhysicalLocation
+	if res.RuleID != "duplicate-key" || res.Level != "warning" || loc.ArtifactLocation.URI != "test.lunv" ||
+		loc.Region.StartLine != 2 || loc.Region.StartColumn != 9 || loc.Region.EndColumn != 14 {
+		t.Errorf("bad result:\n%s", b.String())
+	}
+	if res.Message.Text != "duplicate key \"x\" in table\nthe last value wins" || res.RelatedLocations[0].Message.Text != "first set here" {
+		t.Errorf("bad messages:\n%s", b.String())
+	}
+	if r := res.Fixes[0].ArtifactChanges[0].Replacements[0]; r.DeletedRegion.StartColumn != 2 || r.DeletedRegion.EndColumn != 9 {
+		t.Errorf("bad fix:\n%s", b.String())
+	}
+}
+
+func TestFixAll(t *testing.T) {
+	insert := func(offset int, text string) Fix {
+		return Fix{Edits: []TextEdit{{Start: pos(offset, 1, offset+1), End: pos(offset, 1, offset+1), NewText: text}}}
+	}
+	diags := []Diagnostic{
+		{Fixes: []Fix{insert(0, "local ")}},
+		{},
+		{Fixes: []Fix{insert(0, "_")}}, // overlaps the first one
+		{Fixes: []Fix{{Edits: []TextEdit{{Start: pos(4, 1, 5), End: pos(5, 1, 6), NewText: "2"}}}}},
+	}
+	if got := string(ApplyEdits([]byte("x = 1"), FixAll(diags))); got != "local x = 2" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,190 @@
+package diag
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// WriteJSON writes diags to w as a JSON array.
+func WriteJSON(w io.Writer, diags []Diagnostic) error {
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(diags)
+}
+
+// Tool describes the program reporting diagnostics in a SARIF log.
+type Tool struct {
+	Name           string
+	Version        string
+	InformationURI string
+	Rules          []Rule
+}
+
+// Rule describes a check, named by the Code of its diagnostics.
+type Rule struct {
+	ID          string
+	Description string
+	Severity    Severity // default severity
+}
+
+// SARIF 2.1.0 objects, limited to what is written here.
+type (
+	sarifLog struct {
+		Version string     `json:"version"`
+		Schema  string     `json:"$schema"`
+		Runs    []sarifRun `json:"runs"`
+	}
+	sarifRun struct {
+		Tool       sarifTool     `json:"tool"`
+		ColumnKind string        `json:"columnKind"`
+		Results    []sarifResult `json:"results"`
+	}
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+	sarifDriver struct {
+		Name           string      `json:"name"`
+		Version        string      `json:"version,omitempty"`
+		InformationURI string      `json:"informationUri,omitempty"`
+		Rules          []sarifRule `json:"rules,omitempty"`
+	}
+	sarifRule struct {
+		ID                   string       `json:"id"`
+		ShortDescription     sarifMessage `json:"shortDescription"`
+		DefaultConfiguration struct {
+			Level string `json:"level"`
+		} `json:"defaultConfiguration"`
+	}
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+	sarifResult struct {
+		RuleID           string          `json:"ruleId,omitempty"`
+		Level            string          `json:"level"`
+		Message          sarifMessage    `json:"message"`
+		Locations        []sarifLocation `json:"locations"`
+		RelatedLocations []sarifLocation `json:"relatedLocations,omitempty"`
+		Fixes            []sarifFix      `json:"fixes,omitempty"`
+	}
+	sarifLocation struct {
+		ID               int                   `json:"id,omitempty"`
+		PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+		Message          *sarifMessage         `json:"message,omitempty"`
+	}
+	sarifPhysicalLocation struct {
+		ArtifactLocation sarifArtifact `json:"artifactLocation"`
+		Region           sarifRegion   `json:"region"`
+	}
+	sarifArtifact struct {
+		URI string `json:"uri"`
+	}
+	sarifRegion struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn,omitempty"`
+		EndLine     int `json:"endLine,omitempty"`
+		EndColumn   int `json:"endColumn,omitempty"`
+	}
+	sarifFix struct {
+		Description     sarifMessage          `json:"description"`
+		ArtifactChanges []sarifArtifactChange `json:"artifactChanges"`
+	}
+	sarifArtifactChange struct {
+		ArtifactLocation sarifArtifact      `json:"artifactLocation"`
+		Replacements     []sarifReplacement `json:"replacements"`
+	}
+	sarifReplacement struct {
+		DeletedRegion   sarifRegion   `json:"deletedRegion"`
+		InsertedContent *sarifMessage `json:"insertedContent,omitempty"`
+	}
+)
+
+// sarifLevel maps a severity to a SARIF result level.
+func sarifLevel(s Severity) string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityOff:
+		return "none"
+	}
+	return "note"
+}
+
+// sourceURI returns the relative URI reference of a source name.
+func sourceURI(source string) string {
+	u := url.URL{Path: filepath.ToSlash(source)}
+	return strings.TrimPrefix(u.String(), "./")
+}
+
+func region(pos, end Position) sarifRegion {
+	r := sarifRegion{StartLine: pos.Line, StartColumn: pos.Column}
+	if end.Line >= pos.Line && end.Column > 0 && (end.Line > pos.Line || end.Column > pos.Column) {
+		r.EndLine, r.EndColumn = end.Line, end.Column
+	}
+	return r
+}
+
+// WriteSARIF writes diags to w as a SARIF 2.1.0 log of a single run of
+// tool, the format read by code scanning services to annotate changes.
+// Columns count bytes, which is the same for ASCII sources.
+func WriteSARIF(w io.Writer, tool Tool, diags []Diagnostic) error {
+	driver := sarifDriver{Name: tool.Name, Version: tool.Version, InformationURI: tool.InformationURI}
+	for _, r := range tool.Rules {
+		sr := sarifRule{ID: r.ID, ShortDescription: sarifMessage{r.Description}}
+		sr.DefaultConfiguration.Level = sarifLevel(r.Severity)
+		driver.Rules = append(driver.Rules, sr)
+	}
+	results := []sarifResult{}
+	for _, d := range diags {
+		artifact := sarifArtifact{URI: sourceURI(d.Source)}
+		res := sarifResult{
+			RuleID:  d.Code,
+			Level:   sarifLevel(d.Severity),
+			Message: sarifMessage{d.Message},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{artifact, region(d.Pos, d.End)},
+			}},
+		}
+		for _, n := range d.Notes {
+			if n.Pos.Line > 0 {
+				res.RelatedLocations = append(res.RelatedLocations, sarifLocation{
+					ID:               len(res.RelatedLocations) + 1,
+					PhysicalLocation: sarifPhysicalLocation{artifact, region(n.Pos, n.End)},
+					Message:          &sarifMessage{n.Message},
+				})
+			} else {
+				res.Message.Text += "\n" + n.Message
+			}
+		}
+		for _, f := range d.Fixes {
+			change := sarifArtifactChange{ArtifactLocation: artifact}
+			for _, e := range f.Edits {
+				rep := sarifReplacement{DeletedRegion: sarifRegion{
+					StartLine: e.Start.Line, StartColumn: e.Start.Column,
+					EndLine: e.End.Line, EndColumn: e.End.Column,
+				}}
+				if e.NewText != "" {
+					rep.InsertedContent = &sarifMessage{e.NewText}
+				}
+				change.Replacements = append(change.Replacements, rep)
+			}
+			res.Fixes = append(res.Fixes, sarifFix{sarifMessage{f.Message}, []sarifArtifactChange{change}})
+		}
+		results = append(results, res)
+	}
+	log := sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{{Tool: sarifTool{driver}, ColumnKind: "unicodeCodePoints", Results: results}},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
@@ -0,0 +1,161 @@
+package diag
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const tabWidth = 4
+
+// ANSI escapes used when Printer.Color is set.
+const (
+	reset  = "\x1b[0m"
+	bold   = "\x1b[1m"
+	red    = "\x1b[1;31m"
+	yellow = "\x1b[1;33m"
+	cyan   = "\x1b[1;36m"
+	blue   = "\x1b[1;34m"
+)
+
+// Printer renders diagnostics as text in the style of rustc: a headline,
+// the position, the source line with the span underlined, then notes and
+// suggested fixes.
+type Printer struct {
+	Color bool
+	// Source returns the text of a source, or nil when it is not
+	// available; the snippets are then left out. It may be nil.
+	Source func(name string) []byte
+}
+
+// Fprint writes d to w.
+func (p *Printer) Fprint(w io.Writer, d Diagnostic) error {
+	var b bytes.Buffer
+	var src []byte
+	if p.Source != nil {
+		src = p.Source(d.Source)
+	}
+	color := p.severityColor(d.Severity)
+	head := d.Severity.String()
+	if d.Code != "" {
+		head += "[" + d.Code + "]"
+	}
+	fmt.Fprintf(&b, "%s%s: %s%s\n", p.style(color, head), p.style(bold, ""), d.Message, p.style(reset, ""))
+
+	// the gutter fits the largest line number shown
+	width := len(strconv.Itoa(d.Pos.Line))
+	for _, n := range d.Notes {
+		width = max(width, len(strconv.Itoa(n.Pos.Line)))
+	}
+	p.snippet(&b, src, d.Source, d.Pos, d.End, width, color)
+	for _, n := range d.Notes {
+		if n.Pos.Line > 0 {
+			fmt.Fprintf(&b, "%s: %s\n", p.style(cyan, "note"), n.Message)
+			p.snippet(&b, src, d.Source, n.Pos, n.End, width, cyan)
+		}
+	}
+	for _, n := range d.Notes {
+		if n.Pos.Line == 0 {
+			fmt.Fprintf(&b, "%s %s %s\n", strings.Repeat(" ", width), p.style(blue, "="), p.style(bold, "note: ")+n.Message)
+		}
+	}
+	for _, f := range d.Fixes {
+		fmt.Fprintf(&b, "%s %s %s\n", strings.Repeat(" ", width), p.style(blue, "="), p.style(bold, "help: ")+f.Message)
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// FprintAll writes diags to w separated by blank lines.
+func (p *Printer) FprintAll(w io.Writer, diags []Diagnostic) error {
+	for i, d := range diags {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		if err := p.Fprint(w, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Printer) severityColor(s Severity) string {
+	switch s {
+	case SeverityError:
+		return red
+	case SeverityWarning:
+		return yellow
+	}
+	return cyan
+}
+
+// style returns s in the given style, or s alone without colours. An
+// empty s returns just the escape.
+func (p *Printer) style(esc, s string) string {
+	if !p.Color {
+		return s
+	}
+	if s == "" {
+		return esc
+	}
+	return esc + s + reset
+}
+
+// snippet writes the location of pos and the source line it is on with
+// the span up to end underlined.
+func (p *Printer) snippet(b *bytes.Buffer, src []byte, source string, pos, end Position, width int, color string) {
+	pad := strings.Repeat(" ", width)
+	loc := fmt.Sprintf("%s:%d", source, pos.Line)
+	if pos.Column > 0 {
+		loc += ":" + strconv.Itoa(pos.Column)
+	}
+	fmt.Fprintf(b, "%s%s %s\n", pad, p.style(blue, "-->"), loc)
+	line, ok := sourceLine(src, pos.Line)
+	if !ok {
+		return
+	}
+	bar := p.style(blue, "|")
+	fmt.Fprintf(b, "%s %s\n", pad, bar)
+	fmt.Fprintf(b, "%s %s %s\n", p.style(blue, fmt.Sprintf("%*d", width, pos.Line)), bar, expandTabs(line))
+	if pos.Column > 0 {
+		from := min(pos.Column-1, len(line))
+		to := len(line)
+		if end.Line == pos.Line {
+			to = min(end.Column-1, len(line))
+		}
+		start, stop := displayWidth(line[:from]), displayWidth(line[:max(to, from)])
+		carets := strings.Repeat("^", max(stop-start, 1))
+		fmt.Fprintf(b, "%s %s %s%s\n", pad, bar, strings.Repeat(" ", start), p.style(color, carets))
+	}
+}
+
+// sourceLine returns line n of src without its line break.
+func sourceLine(src []byte, n int) (string, bool) {
+	if src == nil || n < 1 {
+		return "", false
+	}
+	for i := 1; i < n; i++ {
+		j := bytes.IndexByte(src, '\n')
+		if j < 0 {
+			return "", false
+		}
+		src = src[j+1:]
+	}
+	if j := bytes.IndexByte(src, '\n'); j >= 0 {
+		src = src[:j]
+	}
+	return strings.TrimRight(string(src), "\r"), true
+}
+
+func expandTabs(s string) string {
+	return strings.ReplaceAll(s, "\t", strings.Repeat(" ", tabWidth))
+}
+
+func displayWidth(s string) int {
+	return utf8.RuneCountInString(expandTabs(s))
+}
@@ -0,0 +1,237 @@
+package luanova
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StackFrame describes one active function in a stack trace.
+type StackFrame struct {
+	Name    string // function name, empty if unknown
+	Source  string // chunk name; empty for Go functions
+	Line    int    // current line, 0 if unknown
+	DefLine int    // line where the function was defined
+	What    string // "Lua", "Go" or "main"
+}
+
+func (f StackFrame) String() string {
+	switch f.What {
+	case "Go":
+		if f.Name == "" {
+			return "[Go]: in ?"
+		}
+		return fmt.Sprintf("[Go]: in function '%s'", f.Name)
+	case "main":
+		return fmt.Sprintf("%s:%d: in main chunk", f.Source, f.Line)
+	}
+	if f.Name == "" {
+		return fmt.Sprintf("%s:%d: in function <%s:%d>", f.Source, f.Line, f.Source, f.DefLine)
+	}
+	return fmt.Sprintf("%s:%d: in function '%s'", f.Source, f.Line, f.Name)
+}
+
+// funcName names the function of f for debuggers and profilers.
+func (f StackFrame) funcName() string {
+	switch {
+	case f.What == "main":
+		return "main chunk"
+	case f.Name != "":
+		return f.Name
+	case f.What == "Go":
+		return "?"
+	}
+	return fmt.Sprintf("function <%s:%d>", f.Source, f.DefLine)
+}
+
+// Error is a runtime error raised by a script or by a Go function called
+// from a script. Value is the error value as seen by pcall, Stack the
+// script stack at the point of the error and Cause the Go error the
+// error wraps, if any.
+type Error struct {
+	Value any
+	Stack []StackFrame
+	Cause error
+}
+
+func (e *Error) Error() string {
+	switch v := e.Value.(type) {
+	case string:
+		return v
+	case int64, float64:
+		return tostringBasic(v)
+	case nil:
+		if e.Cause != nil {
+			return e.Cause.Error()
+		}
+	}
+	return fmt.Sprintf("(error object is a %s value)", luaTypeName(e.Value))
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Traceback renders the stack like Lua's debug.traceback.
+func (e *Error) Traceback() string {
+	return formatTraceback(e.Stack)
+}
+
+func formatTraceback(stack []StackFrame) string {
+	var b strings.Builder
+	b.WriteString("stack traceback:")
+	for _, f := range stack {
+		b.WriteString("\n\t")
+		b.WriteString(f.String())
+	}
+	return b.String()
+}
+
+// stackTrace returns the active frames starting at level, where level 0
+// is the running function.
+func (s *State) stackTrace(level int) []StackFrame {
+	var stack []StackFrame
+	for i := len(s.calls) - 1 - level; i >= 0; i-- {
+		stack = append(stack, s.calls[i].frame())
+	}
+	return stack
+}
+
+func (ci *callInfo) frame() StackFrame {
+	fn := ci.fn
+	if fn.proto == nil {
+		return StackFrame{Name: fn.name, What: "Go"}
+	}
+	p := fn.proto
+	f := StackFrame{Name: p.name, Source: p.source, Line: ci.fr.line, DefLine: p.line, What: "Lua"}
+	if p.main {
+		f.What = "main"
+	}
+	return f
+}
+
+// where returns the "source:line: " prefix for the function at level.
+func (s *State) where(level int) string {
+	i := len(s.calls) - 1 - level
+	if i < 0 || i >= len(s.calls) {
+		return ""
+	}
+	ci := s.calls[i]
+	if ci.fr == nil || ci.fr.line <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d: ", ci.fn.proto.source, ci.fr.line)
+}
+
+// runtimeError raises an error at the running Lua function.
+func (s *State) runtimeError(format string, args ...any) {
+	panic(&Error{Value: s.where(0) + fmt.Sprintf(format, args...), Stack: s.stackTrace(0)})
+}
+
+// RaiseError raises an error from a Go function. The message is prefixed
+// with the position of the calling script.
+func (s *State) RaiseError(format string, args ...any) {
+	panic(&Error{Value: s.where(1) + fmt.Sprintf(format, args...), Stack: s.stackTrace(0)})
+}
+
+// Raise raises err from a Go function. The script sees the message of
+// err; on the Go side the resulting *Error unwraps to err.
+func (s *State) Raise(err error) {
+	if e, ok := err.(*Error); ok {
+		panic(e)
+	}
+	panic(&Error{Value: s.where(1) + err.Error(), Stack: s.stackTrace(0), Cause: err})
+}
+
+// ArgError raises the standard error for a bad argument.
+func (s *State) ArgError(arg int, msg string) {
+	s.RaiseError("bad argument #%d to '%s' (%s)", arg, s.funcName(), msg)
+}
+
+// TypeError raises the error for an argument of the wrong type.
+func (s *State) TypeError(arg int, expected string) {
+	got := "no value"
+	if arg <= s.Top() {
+		got = luaTypeName(s.Get(arg))
+	}
+	s.ArgError(arg, fmt.Sprintf("%s expected, got %s", expected, got))
+}
+
+// funcName returns the short name of the running Go function.
+func (s *State) funcName() string {
+	if len(s.calls) == 0 {
+		return "?"
+	}
+	name := s.calls[len(s.calls)-1].fn.Name()
+	if i := strings.LastIndexAny(name, ".:"); i >= 0 {
+		name = name[i+1:]
+	}
+	if name == "" {
+		return "?"
+	}
+	return name
+}
+
+// maxCaughtCauses is the number of caught Go errors whose cause error
+// can still raise again.
+const maxCaughtCauses = 16
+
+// caughtCause is the Go cause of an error a script caught, by message.
+type caughtCause struct {
+	msg   string
+	cause error
+}
+
+// errorValue returns the value pcall gives the script for e, its error
+// value. When e wraps a Go error, the cause is kept by message so that
+// error(e) raises it again with its cause.
+func (s *State) errorValue(e *Error) any {
+	if msg, ok := e.Value.(string); ok && e.Cause != nil {
+		if len(s.causes) == maxCaughtCauses {
+			s.causes = append(s.causes[:0], s.causes[1:]...)
+		}
+		s.causes = append(s.causes, caughtCause{msg, e.Cause})
+	}
+	return e.Value
+}
+
+// caughtCause returns the Go cause of the caught error with message v.
+func (s *State) caughtCause(v any) error {
+	if msg, ok := v.(string); ok {
+		for i := len(s.causes) - 1; i >= 0; i-- {
+			if s.causes[i].msg == msg {
+				return s.causes[i].cause
+			}
+		}
+	}
+	return nil
+}
+
+// protect calls f and returns the error it raised, if any, restoring
+// the call stack. handler, when not nil, is called with the error before
+// the stack is unwound, like the message handler of xpcall.
+func (s *State) protect(f func(), handler func(e *Error)) (err *Error) {
+	ncalls, top, base := len(s.calls), len(s.stack), s.base
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		e, ok := r.(*Error)
+		if !ok {
+			panic(r)
+		}
+		if handler != nil {
+			if herr := s.protect(func() { handler(e) }, nil); herr != nil {
+				e = &Error{Value: "error in error handling", Stack: e.Stack, Cause: e.Cause}
+			}
+		}
+		clear(s.calls[ncalls:])
+		s.calls = s.calls[:ncalls]
+		clear(s.stack[top:])
+		s.stack = s.stack[:top]
+		s.base = base
+		err = e
+	}()
+	f()
+	return nil
+}
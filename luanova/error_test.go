@@ -0,0 +1,260 @@
+package luanova
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestErrorMessages(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`error("boom")`, `test:1: boom`},
+		{`error("boom", 0)`, `boom`},
+		{"local function f()\n  error(\"x\", 2)\nend\nf()", "test:4: x"},
+		{`error({code = 1})`, `(error object is a table value)`},
+		{`error(42)`, `42`},
+		{`local x; x.y = 1`, `test:1: attempt to index a nil value (local 'x')`},
+		{`return a.b.c`, `test:1: attempt to index a nil value (global 'a')`},
+		{`local t = {}; return t.x.y`, `test:1: attempt to index a nil value (field 'x')`},
+		{`undefined()`, `test:1: attempt to call a nil value (global 'undefined')`},
+		{`local t = {}; t:nope()`, `test:1: attempt to call a nil value (method 'nope')`},
+		{`return 1 + {}`, `test:1: attempt to perform arithmetic on a table value`},
+		{`local s = "abc"; return s * 2`, `test:1: attempt to perform arithmetic on a string value (local 's')`},
+		{"local x = 1\nreturn x .. nil", `test:2: attempt to concatenate a nil value`},
+		{`return #nil`, `test:1: attempt to get length of a nil value`},
+		{`return 1 < "2"`, `test:1: attempt to compare number with string`},
+		{`return {} < {}`, `test:1: attempt to compare two table values`},
+		{`return 1 % 0`, `test:1: attempt to perform 'n%%0'`},
+		{`local t = {}; t[nil] = 1`, `test:1: table index is nil`},
+		{`for i = 1, 10, 0 do end`, `test:1: 'for' step is zero`},
+		{`for i = "a", 2 do end`, `test:1: 'for' initial value must be a number`},
+		{`string.rep()`, `test:1: bad argument #1 to 'rep' (string expected, got no value)`},
+		{`("x"):rep({})`, `test:1: bad argument #2 to 'rep' (number expected, got table)`},
+		{`setmetatable(1, {})`, `test:1: bad argument #1 to 'setmetatable' (table expected, got number)`},
+		{`assert(false)`, `test:1: assertion failed!`},
+		{`assert(nil, "custom")`, `custom`},
+		{`local function f() return f() + 1 end f()`, `test:1: stack overflow`},
+	}
+
+	for _, tt := range tests {
+		s := NewState()
+		fn, err := s.Load(tt.input, "test")
+		if err != nil {
+			t.Errorf("%q: %v", tt.input, err)
+			continue
+		}
+		_, err = s.PCall(fn)
+		if err == nil {
+			t.Errorf("%q: expected an error", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("%q: got %q, expected %q", tt.input, err.Error(), tt.expected)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break", "test:1:1: break outside a loop"},
+		{"continue", "test:1:1: continue outside a loop"},
+		{"while true do local f = function() break end end", "test:1:36: break outside a loop"},
+	}
+
+	for _, tt := range tests {
+		_, err := NewState().Load(tt.input, "test")
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: got %v, expected %q", tt.input, err, tt.expected)
+		}
+	}
+}
+
+func TestPcall(t *testing.T) {
+	out := runScript(t, `
+print(pcall(function(a, b) return a + b end, 1, 2))
+print(pcall(error, "msg", 0))
+print(pcall(error))
+local ok, e = pcall(error, {code = 7})
+print(ok, e.code)
+print(select("#", pcall(function() end)))
+print(pcall(pcall, error, "nested"))
+local depth = 0
+local function rec() depth = depth + 1; rec() end
+local ok, e = pcall(rec)
+print(ok, e, depth > 100)
+print(pcall(function() return "after overflow" end))
+`)
+	expected := `true	3
+false	msg
+false	nil
+false	7
+1
+true	false	nested
+false	test:10: stack overflow	true
+true	after overflow
+`
+	if out != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", out, expected)
+	}
+}
+
+func TestXpcall(t *testing.T) {
+	out := runScript(t, `
+local function handler(m) return "handled: " .. m end
+print(xpcall(function() error("bad") end, handler))
+print(xpcall(function(x) return x * 2 end, handler, 21))
+print(xpcall(error, function() error("again") end))
+
+local function inner() error("deep") end
+local function outer() inner() end
+local ok, tb = xpcall(outer, debug.traceback)
+print(ok)
+print(tb)
+`)
+	expected := `false	handled: test:3: bad
+true	42
+false	error in error handling
+false
+test:7: deep
+stack traceback:
+	[Go]: in function 'error'
+	test:7: in function 'inner'
+	test:8: in function 'outer'
+	[Go]: in function 'xpcall'
+	test:9: in main chunk
+`
+	if out != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", out, expected)
+	}
+}
+
+func TestTraceback(t *testing.T) {
+	out := runScript(t, `
+local t = {}
+function t.method()
+  print(debug.traceback("here"))
+  print(debug.traceback("level 2", 2))
+end
+local anon = function() t.method() end
+;(function() anon() end)()
+print(debug.traceback({}))
+`)
+	expected := `here
+stack traceback:
+	test:4: in function 't.method'
+	test:7: in function 'anon'
+	test:8: in function <test:8>
+	test:8: in main chunk
+level 2
+stack traceback:
+	test:7: in function 'anon'
+	test:8: in function <test:8>
+	test:8: in main chunk
+`
+	if !strings.HasPrefix(out, expected) {
+		t.Errorf("got:\n%s\nexpected:\n%s", out, expected)
+	}
+	if last := out[len(expected):]; !strings.HasPrefix(last, "table: 0x") {
+		t.Errorf("traceback of a table should return the table, got %q", last)
+	}
+}
+
+func TestErrorStack(t *testing.T) {
+	s := NewState()
+	fn, err := s.Load("local function f()\n  local x = nil\n  return x.y\nend\nf()", "test.lunv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.PCall(fn)
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("expected *Error, got %T", err)
+	}
+	expected := []StackFrame{
+		{Name: "f", Source: "test.lunv", Line: 3, DefLine: 1, What: "Lua"},
+		{Source: "test.lunv", Line: 5, What: "main"},
+	}
+	if len(e.Stack) != len(expected) {
+		t.Fatalf("got stack %+v", e.Stack)
+	}
+	for i := range expected {
+		if e.Stack[i] != expected[i] {
+			t.Errorf("frame %d: got %+v, expected %+v", i, e.Stack[i], expected[i])
+		}
+	}
+	tb := "stack traceback:\n\ttest.lunv:3: in function 'f'\n\ttest.lunv:5: in main chunk"
+	if e.Traceback() != tb {
+		t.Errorf("got traceback %q", e.Traceback())
+	}
+}
+
+func TestGoErrorWrapping(t *testing.T) {
+	s := NewState()
+	s.Register("open", func(s *State) int {
+		s.Raise(&fs.PathError{Op: "open", Path: s.CheckString(1), Err: fs.ErrNotExist})
+		return 0
+	})
+
+	err := s.DoString(`open("missing.txt")`)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("errors.Is(err, fs.ErrNotExist) = false for %v", err)
+	}
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != "missing.txt" {
+		t.Errorf("errors.As(err, *fs.PathError) failed for %v", err)
+	}
+	if want := `[string "open("missing.txt")"]:1: open missing.txt: file does not exist`; err.Error() != want {
+		t.Errorf("got %q, expected %q", err.Error(), want)
+	}
+
+	// scripts see the message; pcall(open, ...) has no script position
+	// since open is called from Go
+	var out strings.Builder
+	s.Stdout = &out
+	if err := s.DoString(`print(pcall(open, "a"))`); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "false\topen a: file does not exist\n" {
+		t.Errorf("got %q", out.String())
+	}
+}
+
+func TestGoErrorRethrow(t *testing.T) {
+	s := NewState()
+	s.Register("open", func(s *State) int {
+		s.Raise(&fs.PathError{Op: "open", Path: s.CheckString(1), Err: fs.ErrNotExist})
+		return 0
+	})
+	var out strings.Builder
+	s.Stdout = &out
+	err := s.DoString(`
+local ok, e = pcall(open, "a")
+print(ok, tostring(e), "caught: " .. e)
+assert(type(e) == "string" and e == "open a: file does not exist")
+assert(e:match("^open (%w+)") == "a" and string.upper(e) == "OPEN A: FILE DOES NOT EXIST")
+local ok2, e2 = xpcall(open, function(e) return e end, "b")
+print(ok2, tostring(e2))
+error(e, 0)`)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("errors.Is(err, fs.ErrNotExist) = false for %v", err)
+	}
+	if err == nil || err.Error() != "open a: file does not exist" {
+		t.Errorf("got %v, expected the message of the cause", err)
+	}
+	if want := "false\topen a: file does not exist\tcaught: open a: file does not exist\nfalse\topen b: file does not exist\n"; out.String() != want {
+		t.Errorf("got %q, expected %q", out.String(), want)
+	}
+
+	// a rethrow with a position keeps the cause too
+	err = s.DoString(`local ok, e = pcall(open, "c") error(e)`)
+	if !errors.Is(err, fs.ErrNotExist) || err.Error() != `[string "local ok, e = pcall(open, "c") error(e)"]:1: open c: file does not exist` {
+		t.Errorf("got %v, expected the cause with a position", err)
+	}
+}
@@ -0,0 +1,93 @@
+package luanova
+
+// Folding range kinds of the protocol.
+const foldComment = "comment"
+
+type lspFoldingRange struct {
+	StartLine int    `json:"startLine"`
+	EndLine   int    `json:"endLine"`
+	Kind      string `json:"kind,omitempty"`
+}
+
+type lspSelectionRange struct {
+	Range  lspRange           `json:"range"`
+	Parent *lspSelectionRange `json:"parent,omitempty"`
+}
+
+// foldingRanges folds blocks from the line of their keyword to the line
+// before their end, elseif, else or until, and block comments whole.
+// They are found with the lexer so that they work in text that does
+// not parse.
+func (doc *document) foldingRanges() []lspFoldingRange {
+	type opener struct {
+		typ   int
+		line  int  // 1-based
+		needs bool // a while or for loop before its do
+	}
+	folds := []lspFoldingRange{}
+	fold := func(start, end int, kind string) {
+		if end > start {
+			folds = append(folds, lspFoldingRange{start - 1, end - 1, kind})
+		}
+	}
+	var stack []opener
+	l := NewLexer(doc.text)
+	for tok := l.NextToken(); tok.Type != EOF; tok = l.NextToken() {
+		top := len(stack) - 1
+		switch tok.Type {
+		case CommentBlock:
+			fold(tok.Pos.Line, tok.End.Line, foldComment)
+		case Function, If, Repeat:
+			stack = append(stack, opener{tok.Type, tok.Pos.Line, false})
+		case While, For:
+			stack = append(stack, opener{tok.Type, tok.Pos.Line, true})
+		case Do:
+			if top >= 0 && stack[top].needs {
+				stack[top].needs = false
+			} else {
+				stack = append(stack, opener{Do, tok.Pos.Line, false})
+			}
+		case ElseIf, Else:
+			if top >= 0 && stack[top].typ == If {
+				fold(stack[top].line, tok.Pos.Line-1, "")
+				stack[top].line = tok.Pos.Line
+			}
+		case End, Until:
+			if top >= 0 {
+				fold(stack[top].line, tok.Pos.Line-1, "")
+				stack = stack[:top]
+			}
+		}
+	}
+	return folds
+}
+
+// selectionRanges returns for each position the ranges of the nodes
+// around it, innermost first, up to the whole text.
+func (doc *document) selectionRanges(positions []lspPosition) []*lspSelectionRange {
+	doc.analyze()
+	chunk := doc.chunk
+	if chunk == nil {
+		chunk, _ = Parse(doc.uri, doc.text) // recovered from the errors
+	}
+	ranges := []*lspSelectionRange{}
+	for _, p := range positions {
+		offset := doc.offset(p)
+		sel := &lspSelectionRange{Range: doc.span(0, len(doc.text))}
+		Inspect(chunk.Block, func(n Node) bool {
+			if n.Pos().Offset > offset || n.End().Offset < offset {
+				return false
+			}
+			r := doc.span(n.Pos().Offset, n.End().Offset)
+			if !contains(sel.Range, r.Start) || !contains(sel.Range, r.End) {
+				return false // a sibling ending at the position
+			}
+			if r != sel.Range {
+				sel = &lspSelectionRange{r, sel}
+			}
+			return true
+		})
+		ranges = append(ranges, sel)
+	}
+	return ranges
+}
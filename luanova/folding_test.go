@@ -0,0 +1,63 @@
+package luanova
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFoldingRanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string // 1-based start-end lines
+	}{
+		{"function", "local function f()\n\tprint(1)\n\tprint(2)\nend", "1-3"},
+		{"if chain", "if a then\n\tx()\nelseif b then\n\ty()\nelse\n\tz()\nend", "1-2 3-4 5-6"},
+		{"nested loops", "for i = 1, 3 do\n\twhile true do\n\t\tbreak\n\tend\nend", "2-3 1-4"},
+		{"do and repeat", "do\n\tlocal x = 1\nend\nrepeat\n\tx()\nuntil done", "1-2 4-5"},
+		{"block comment", "-*\n\tNotes.\n*-\nlocal x = 1", "1-3 comment"},
+		{"one line", "local f = function() return 1 end\nif x then y() end", ""},
+		{"function expression", "pcall(function()\n\tx()\nend)", "1-2"},
+		{"does not parse", "local function f()\n\tif x then\n\t\ty()\n\tend\n", "2-3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, f := range newDocument("file:///f.lunv", 1, tt.input).foldingRanges() {
+				s := fmt.Sprintf("%d-%d", f.StartLine+1, f.EndLine+1)
+				if f.Kind != "" {
+					s += " " + f.Kind
+				}
+				got = append(got, s)
+			}
+			if strings.Join(got, " ") != tt.expected {
+				t.Errorf("folding ranges = %s, want %s", strings.Join(got, " "), tt.expected)
+			}
+		})
+	}
+}
+
+func TestSelectionRanges(t *testing.T) {
+	tests := []struct {
+		input    string
+		line     int // 1-based
+		char     int
+		expected string // the texts of the ranges, innermost first
+	}{
+		{"local x = f(a + b)", 1, 14, "a|a + b|f(a + b)|local x = f(a + b)"},
+		{"if ok then\n\treturn t.name\nend", 2, 12, "name|t.name|return t.name|if ok then\n\treturn t.name|if ok then\n\treturn t.name\nend"},
+		{"local a = 1\nprint(x", 1, 7, "a|local a = 1|local a = 1\nprint(x"}, // recovered
+	}
+	for _, tt := range tests {
+		doc := newDocument("file:///s.lunv", 1, tt.input)
+		sels := doc.selectionRanges([]lspPosition{{tt.line - 1, tt.char - 1}})
+		var got []string
+		for sel := sels[0]; sel != nil; sel = sel.Parent {
+			got = append(got, doc.text[doc.offset(sel.Range.Start):doc.offset(sel.Range.End)])
+		}
+		if strings.Join(got, "|") != tt.expected {
+			t.Errorf("selection ranges of %q at %d:%d = %q, want %q", tt.input, tt.line, tt.char, strings.Join(got, "|"), tt.expected)
+		}
+	}
+}
@@ -0,0 +1,639 @@
+package luanova
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// string.format follows C printf as used by Lua 5.4, which differs from
+// Go's fmt in several places (%c is a byte, %x of a negative integer is
+// unsigned, %g defaults to precision 6, inf prints as "inf", ...). The
+// formatter therefore parses each directive itself and only hands the
+// well-understood pieces to strconv.
+
+const (
+	fmtFlagsFloat = "-+ #0"
+	fmtFlagsHex   = "-#0"
+	fmtFlagsInt   = "-+ 0"
+	fmtFlagsUint  = "-0"
+	fmtFlagsChar  = "-"
+
+	fmtMaxSpec = 22
+)
+
+// formatSpec is one parsed conversion such as "%-10.3f".
+type formatSpec struct {
+	form      string
+	verb      byte
+	minus     bool
+	plus      bool
+	space     bool
+	sharp     bool
+	zero      bool
+	width     int
+	prec      int
+	hasPrec   bool
+	modifiers bool
+}
+
+// formatArgError reports a problem with argument n (1-based, counting the
+// format string itself as argument 1, as Lua does).
+type formatArgError struct {
+	Arg int
+	Msg string
+}
+
+func (e *formatArgError) Error() string {
+	return fmt.Sprintf("bad argument #%d to 'format' (%s)", e.Arg, e.Msg)
+}
+
+var errNoIntegerRep = errors.New("number has no integer representation")
+
+// formatString implements string.format. Arguments are nil, bool, int64,
+// float64, string or any other value; tostring converts values for %s and
+// may be nil, in which case a plain conversion is used.
+func formatString(format string, args []any, tostring func(any) (string, error)) (string, error) {
+	if tostring == nil {
+		tostring = func(v any) (string, error) { return tostringBasic(v), nil }
+	}
+	var b strings.Builder
+	arg := 0
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i < len(format) && format[i] == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		if arg >= len(args) {
+			return "", &formatArgError{arg + 2, "no value"}
+		}
+		v := args[arg]
+		arg++
+
+		spec, next, err := parseFormatSpec(format, i)
+		if err != nil {
+			return "", err
+		}
+		i = next
+
+		switch spec.verb {
+		case 'c':
+			if err := spec.check(fmtFlagsChar, false); err != nil {
+				return "", err
+			}
+			n, err := formatInteger(v, arg+1)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(spec.pad(string([]byte{byte(n)}), false))
+		case 'd', 'i', 'u', 'o', 'x', 'X':
+			n, err := formatInteger(v, arg+1)
+			if err != nil {
+				return "", err
+			}
+			flags := fmtFlagsHex
+			switch spec.verb {
+			case 'd', 'i':
+				flags = fmtFlagsInt
+			case 'u':
+				flags = fmtFlagsUint
+			}
+			if err := spec.check(flags, true); err != nil {
+				return "", err
+			}
+			b.WriteString(spec.formatInt(n))
+		case 'a', 'A', 'e', 'E', 'f', 'F', 'g', 'G':
+			f, err := formatNumber(v, arg+1)
+			if err != nil {
+				return "", err
+			}
+			if err := spec.check(fmtFlagsFloat, true); err != nil {
+				return "", err
+			}
+			b.WriteString(spec.formatFloat(f))
+		case 'p':
+			if err := spec.check(fmtFlagsChar, false); err != nil {
+				return "", err
+			}
+			if p := pointerOf(v); p != 0 {
+				b.WriteString(spec.pad(fmt.Sprintf("0x%x", p), false))
+			} else {
+				b.WriteString(spec.pad("(null)", false))
+			}
+		case 'q':
+			if spec.modifiers {
+				return "", errors.New("specifier '%q' cannot have modifiers")
+			}
+			q, err := quoteLiteral(v)
+			if err != nil {
+				return "", &formatArgError{arg + 1, err.Error()}
+			}
+			b.WriteString(q)
+		case 's':
+			s, err := tostring(v)
+			if err != nil {
+				return "", err
+			}
+			if !spec.modifiers {
+				b.WriteString(s)
+				break
+			}
+			if strings.IndexByte(s, 0) >= 0 {
+				return "", &formatArgError{arg + 1, "string contains zeros"}
+			}
+			if err := spec.check(fmtFlagsChar, true); err != nil {
+				return "", err
+			}
+			if spec.hasPrec && spec.prec < len(s) {
+				s = s[:spec.prec]
+			}
+			b.WriteString(spec.pad(s, false))
+		default:
+			return "", fmt.Errorf("invalid conversion '%s' to 'format'", spec.form)
+		}
+	}
+	return b.String(), nil
+}
+
+// parseFormatSpec reads the directive starting after the '%' at i and
+// returns it together with the index of its conversion character.
+func parseFormatSpec(format string, i int) (formatSpec, int, error) {
+	start := i
+	for i < len(format) && strings.IndexByte(fmtFlagsFloat+"123456789.", format[i]) >= 0 {
+		i++
+	}
+	if i-start+1 >= fmtMaxSpec {
+		return formatSpec{}, 0, errors.New("invalid format string to 'format'")
+	}
+	spec := formatSpec{modifiers: i > start}
+	if i < len(format) {
+		spec.verb = format[i]
+		spec.form = "%" + format[start:i+1]
+	} else {
+		spec.form = "%" + format[start:i]
+		i--
+	}
+	return spec, i, nil
+}
+
+// check validates the directive against the flags its conversion accepts
+// and fills in flags, width and precision, like checkformat in lstrlib.c.
+func (spec *formatSpec) check(flags string, precision bool) error {
+	s := spec.form[1:]
+	for len(s) > 0 && strings.IndexByte(flags, s[0]) >= 0 {
+		switch s[0] {
+		case '-':
+			spec.minus = true
+		case '+':
+			spec.plus = true
+		case ' ':
+			spec.space = true
+		case '#':
+			spec.sharp = true
+		case '0':
+			spec.zero = true
+		}
+		s = s[1:]
+	}
+	if len(s) > 0 && s[0] != '0' {
+		spec.width, s = twoDigits(s)
+		if len(s) > 0 && s[0] == '.' && precision {
+			spec.hasPrec = true
+			spec.prec, s = twoDigits(s[1:])
+		}
+	}
+	if len(s) == 0 || !isalpha(s[0]) {
+		return fmt.Errorf("invalid conversion specification: '%s'", spec.form)
+	}
+	return nil
+}
+
+func twoDigits(s string) (int, string) {
+	n := 0
+	for i := 0; i < 2 && len(s) > 0 && isdigitc(s[0]); i++ {
+		n = n*10 + int(s[0]-'0')
+		s = s[1:]
+	}
+	return n, s
+}
+
+// pad applies width and the '-' flag. When zeros is set and the '0' flag
+// is present, padding goes between the sign/prefix and the digits.
+func (spec *formatSpec) pad(s string, zeros bool) string {
+	if len(s) >= spec.width {
+		return s
+	}
+	fill := spec.width - len(s)
+	switch {
+	case spec.minus:
+		return s + strings.Repeat(" ", fill)
+	case zeros && spec.zero:
+		prefix := 0
+		if len(s) > 0 && (s[0] == '-' || s[0] == '+' || s[0] == ' ') {
+			prefix = 1
+		}
+		if len(s) > prefix+1 && s[prefix] == '0' && (s[prefix+1]|0x20) == 'x' {
+			prefix += 2
+		}
+		return s[:prefix] + strings.Repeat("0", fill) + s[prefix:]
+	default:
+		return strings.Repeat(" ", fill) + s
+	}
+}
+
+func (spec *formatSpec) formatInt(n int64) string {
+	var digits, sign, prefix string
+	switch spec.verb {
+	case 'd', 'i':
+		u := uint64(n)
+		if n < 0 {
+			sign, u = "-", -u
+		} else if spec.plus {
+			sign = "+"
+		} else if spec.space {
+			sign = " "
+		}
+		digits = strconv.FormatUint(u, 10)
+	case 'u':
+		digits = strconv.FormatUint(uint64(n), 10)
+	case 'o':
+		digits = strconv.FormatUint(uint64(n), 8)
+	case 'x', 'X':
+		digits = strconv.FormatUint(uint64(n), 16)
+		if spec.sharp && n != 0 {
+			prefix = "0x"
+		}
+		if spec.verb == 'X' {
+			digits, prefix = strings.ToUpper(digits), strings.ToUpper(prefix)
+		}
+	}
+	if spec.hasPrec {
+		if spec.prec == 0 && n == 0 {
+			digits = ""
+		} else if len(digits) < spec.prec {
+			digits = strings.Repeat("0", spec.prec-len(digits)) + digits
+		}
+	}
+	if spec.verb == 'o' && spec.sharp && (len(digits) == 0 || digits[0] != '0') {
+		digits = "0" + digits
+	}
+	s := sign + prefix + digits
+	// C ignores the '0' flag when a precision is given.
+	return spec.pad(s, !spec.hasPrec)
+}
+
+func (spec *formatSpec) formatFloat(f float64) string {
+	upper := spec.verb >= 'A' && spec.verb <= 'Z'
+	sign := ""
+	if math.Signbit(f) && !math.IsNaN(f) {
+		sign = "-"
+	} else if spec.plus {
+		sign = "+"
+	} else if spec.space {
+		sign = " "
+	}
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		s := "inf"
+		if math.IsNaN(f) {
+			s = "nan"
+			if math.Signbit(f) {
+				sign = "-"
+			}
+		}
+		if upper {
+			s = strings.ToUpper(s)
+		}
+		return spec.pad(sign+s, false)
+	}
+
+	abs := math.Abs(f)
+	var body string
+	switch spec.verb | 0x20 {
+	case 'a':
+		prec := -1
+		if spec.hasPrec {
+			prec = spec.prec
+		}
+		body = hexFloat(abs, prec, spec.sharp)
+	case 'e', 'f', 'g':
+		prec := 6
+		if spec.hasPrec {
+			prec = spec.prec
+		}
+		verb := "%"
+		if spec.sharp {
+			verb += "#"
+		}
+		body = fmt.Sprintf(verb+".*"+string(spec.verb|0x20), prec, abs)
+	}
+	if upper {
+		body = strings.ToUpper(body)
+	}
+	return spec.pad(sign+body, true)
+}
+
+// hexFloat formats a non-negative finite f like C's %a. A negative prec
+// means the shortest exact representation.
+func hexFloat(f float64, prec int, sharp bool) string {
+	if f == 0 {
+		s := "0x0"
+		if prec > 0 {
+			s += "." + strings.Repeat("0", prec)
+		} else if sharp {
+			s += "."
+		}
+		return s + "p+0"
+	}
+	bits := math.Float64bits(f)
+	exp := int(bits>>52) & 0x7ff
+	mant := bits & (1<<52 - 1)
+	lead := uint64(1)
+	if exp == 0 {
+		lead = 0
+		exp = -1022
+	} else {
+		exp -= 1023
+	}
+	if prec >= 0 && prec < 13 {
+		// round the 52-bit fraction to prec hex digits, half to even
+		shift := uint(52 - 4*prec)
+		full := lead<<52 | mant
+		rem := full & (1<<shift - 1)
+		full >>= shift
+		half := uint64(1) << (shift - 1)
+		if rem > half || rem == half && full&1 == 1 {
+			full++
+		}
+		lead = full >> (4 * uint(prec))
+		mant = (full & (1<<(4*uint(prec)) - 1)) << shift
+	}
+	frac := fmt.Sprintf("%013x", mant)
+	switch {
+	case prec < 0:
+		frac = strings.TrimRight(frac, "0")
+	case prec <= 13:
+		frac = frac[:prec]
+	default:
+		frac += strings.Repeat("0", prec-13)
+	}
+	s := "0x" + strconv.FormatUint(lead, 16)
+	if frac != "" || sharp {
+		s += "." + frac
+	}
+	expSign := "+"
+	if exp < 0 {
+		expSign, exp = "-", -exp
+	}
+	return s + "p" + expSign + strconv.Itoa(exp)
+}
+
+// quoteLiteral implements %q: the result reads back as the same value.
+func quoteLiteral(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return quoteString(v), nil
+	case int64:
+		if v == math.MinInt64 {
+			return "0x8000000000000000", nil
+		}
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		switch {
+		case math.IsInf(v, 1):
+			return "1e9999", nil
+		case math.IsInf(v, -1):
+			return "-1e9999", nil
+		case math.IsNaN(v):
+			return "(0/0)", nil
+		}
+		s := hexFloat(math.Abs(v), -1, false)
+		if math.Signbit(v) {
+			s = "-" + s
+		}
+		return s, nil
+	case nil, bool:
+		return tostringBasic(v), nil
+	}
+	return "", errors.New("value has no literal form")
+}
+
+func quoteString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\' || c == '\n':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case iscntrl(c):
+			if i+1 < len(s) && isdigitc(s[i+1]) {
+				fmt.Fprintf(&b, "\\%03d", c)
+			} else {
+				fmt.Fprintf(&b, "\\%d", c)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func formatInteger(v any, arg int) (int64, error) {
+	switch n := v.(type) {
+	case int64:
+		return n, nil
+	case float64:
+		if i, ok := floatToInteger(n); ok {
+			return i, nil
+		}
+		return 0, &formatArgError{arg, errNoIntegerRep.Error()}
+	case string:
+		if num, ok := parseNumber(n); ok {
+			return formatInteger(num, arg)
+		}
+	}
+	return 0, &formatArgError{arg, "number expected, got " + luaTypeName(v)}
+}
+
+func formatNumber(v any, arg int) (float64, error) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	case string:
+		if num, ok := parseNumber(n); ok {
+			return formatNumber(num, arg)
+		}
+	}
+	return 0, &formatArgError{arg, "number expected, got " + luaTypeName(v)}
+}
+
+func floatToInteger(f float64) (int64, bool) {
+	if f != math.Floor(f) || f < -(1<<63) || f >= 1<<63 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// parseNumber converts a numeric string the way Lua does when coercing
+// strings: decimal or hex integers (hex wraps around), otherwise floats.
+func parseNumber(s string) (any, bool) {
+	s = strings.TrimSpace(s)
+	if n, ok := parseInteger(s); ok {
+		return n, true
+	}
+	if f, ok := parseFloat(s); ok {
+		return f, true
+	}
+	return nil, false
+}
+
+func parseInteger(s string) (int64, bool) {
+	neg := false
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	var u uint64
+	if len(s) > 2 && s[0] == '0' && (s[1]|0x20) == 'x' {
+		for i := 2; i < len(s); i++ {
+			if !isxdigit(s[i]) {
+				return 0, false
+			}
+			u = u<<4 | uint64(hexValue(s[i]))
+		}
+	} else {
+		if s == "" {
+			return 0, false
+		}
+		for i := 0; i < len(s); i++ {
+			if !isdigitc(s[i]) {
+				return 0, false
+			}
+			d := uint64(s[i] - '0')
+			if u > (math.MaxInt64-d)/10 {
+				// decimal integers that overflow are read as floats
+				return 0, false
+			}
+			u = u*10 + d
+		}
+	}
+	if neg {
+		return -int64(u), true
+	}
+	return int64(u), true
+}
+
+func parseFloat(s string) (float64, bool) {
+	if s == "" || strings.ContainsAny(s, "nN_") {
+		return 0, false
+	}
+	body := strings.TrimLeft(s, "+-")
+	if len(body) > 1 && body[0] == '0' && (body[1]|0x20) == 'x' && !strings.ContainsAny(body, "pP") {
+		s += "p0"
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return 0, false
+	}
+	return f, true
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case isdigitc(c):
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// numberToString converts a float the way Lua's tostring does ("%.14g",
+// with ".0" appended to integral values).
+func numberToString(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		if math.Signbit(f) {
+			return "-nan"
+		}
+		return "nan"
+	}
+	s := strconv.FormatFloat(f, 'g', 14, 64)
+	if strings.Trim(s, "-0123456789") == "" {
+		s += ".0"
+	}
+	return s
+}
+
+func tostringBasic(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return numberToString(v)
+	case string:
+		return v
+	}
+	return fmt.Sprintf("%s: 0x%x", luaTypeName(v), pointerOf(v))
+}
+
+func luaTypeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case int64, float64:
+		return "number"
+	case string:
+		return "string"
+	case *Table:
+		return "table"
+	case *Closure:
+		return "function"
+	}
+	return "userdata"
+}
+
+// pointerOf returns the address used by %p, or 0 for values that are not
+// objects.
+func pointerOf(v any) uintptr {
+	if s, ok := v.(string); ok {
+		if s == "" {
+			return 0
+		}
+		return uintptr(unsafe.Pointer(unsafe.StringData(s)))
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Func, reflect.Chan, reflect.Slice, reflect.UnsafePointer:
+		return rv.Pointer()
+	}
+	return 0
+}
@@ -0,0 +1,242 @@
+package luanova
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// Expected outputs for the printf-compatible directives were produced by
+// glibc's printf with the same directive (using ll for integers).
+func TestFormatDirectives(t *testing.T) {
+	tests := []struct {
+		format   string
+		arg      any
+		expected string
+	}{
+		{"%5.2f", float64(3.14159), " 3.14"},
+		{"%-10s|", "abc", "abc       |"},
+		{"%10s|", "abc", "       abc|"},
+		{"%.2s", "abcdef", "ab"},
+		{"%x", int64(255), "ff"},
+		{"%X", int64(255), "FF"},
+		{"%#x", int64(255), "0xff"},
+		{"%#X", int64(255), "0XFF"},
+		{"%#x", int64(0), "0"},
+		{"%o", int64(8), "10"},
+		{"%#o", int64(8), "010"},
+		{"%#o", int64(0), "0"},
+		{"%x", int64(-1), "ffffffffffffffff"},
+		{"%d", int64(-42), "-42"},
+		{"%+d", int64(42), "+42"},
+		{"% d", int64(42), " 42"},
+		{"%05d", int64(-42), "-0042"},
+		{"%-5d|", int64(42), "42   |"},
+		{"%.3d", int64(7), "007"},
+		{"%8.3d", int64(-7), "    -007"},
+		{"%08.3d", int64(7), "     007"},
+		{"%.0d", int64(0), ""},
+		{"%i", int64(12), "12"},
+		{"%u", int64(42), "42"},
+		{"%05x", int64(255), "000ff"},
+		{"%#08x", int64(255), "0x0000ff"},
+		{"%e", float64(12345.6789), "1.234568e+04"},
+		{"%.3E", float64(0.000123), "1.230E-04"},
+		{"%g", float64(0.0001234567), "0.000123457"},
+		{"%g", float64(123456789.0), "1.23457e+08"},
+		{"%G", float64(1e-10), "1E-10"},
+		{"%#g", float64(1.0), "1.00000"},
+		{"%.0f", float64(2.5), "2"},
+		{"%.0f", float64(3.5), "4"},
+		{"%+.1f", float64(2.25), "+2.2"},
+		{"% .3f", float64(1.0), " 1.000"},
+		{"%010.3f", float64(-3.14159), "-00003.142"},
+		{"%-10.1f|", float64(3.0), "3.0       |"},
+		{"%f", float64(1e20), "100000000000000000000.000000"},
+		{"%.14g", float64(0.1), "0.1"},
+		{"%.20f", float64(0.1), "0.10000000000000000555"},
+		{"%.99f", 1.0 / 3, "0.333333333333333314829616256247390992939472198486328125000000000000000000000000000000000000000000000"},
+		{"%f", math.Inf(1), "inf"},
+		{"%F", math.Inf(1), "INF"},
+		{"%5.1f", math.Inf(-1), " -inf"},
+		{"%05f", math.Inf(1), "  inf"},
+		{"%e", math.Copysign(0, -1), "-0.000000e+00"},
+		{"%g", float64(100.0), "100"},
+		{"%a", float64(1.0), "0x1p+0"},
+		{"%a", float64(0.5), "0x1p-1"},
+		{"%a", float64(3.0), "0x1.8p+1"},
+		{"%a", float64(0.1), "0x1.999999999999ap-4"},
+		{"%a", float64(-2.5), "-0x1.4p+1"},
+		{"%A", float64(255.5), "0X1.FFP+7"},
+		{"%.3a", float64(1.9999), "0x2.000p+0"},
+		{"%.0a", float64(1.5), "0x2p+0"},
+		{"%.1a", float64(1.0), "0x1.0p+0"},
+		{"%#a", float64(1.0), "0x1.p+0"},
+		{"%a", float64(0.0), "0x0p+0"},
+		{"%a", float64(4.9406564584124654e-324), "0x0.0000000000001p-1022"},
+		{"%a", float64(1.7976931348623157e308), "0x1.fffffffffffffp+1023"},
+		{"%12a|", float64(1.0), "      0x1p+0|"},
+		{"%-12a|", float64(1.0), "0x1p+0      |"},
+		{"%012a", float64(1.0), "0x0000001p+0"},
+		{"%+a", float64(1.0), "+0x1p+0"},
+		{"%.20a", float64(1.0), "0x1.00000000000000000000p+0"},
+		{"%5c|", int64('x'), "    x|"},
+		{"%-5c|", int64('x'), "x    |"},
+		{"%c", int64(65), "A"},
+		{"%d", 3.0, "3"},
+		{"%d", "10", "10"},
+		{"%x", "0x10", "10"},
+		{"%5.1s|", "hello", "    h|"},
+		{"%s", int64(10), "10"},
+		{"%s", 1.5, "1.5"},
+		{"%s", 2.0, "2.0"},
+		{"%s", true, "true"},
+		{"%s", nil, "nil"},
+		{"%10.4f", 1e15, "1000000000000000.0000"},
+		{"%.14g", 2.0, "2"},
+		{"%g", math.NaN(), "nan"},
+		{"%5s", strings.Repeat("x", 3), "  xxx"},
+	}
+
+	for i, tt := range tests {
+		got, err := formatString(tt.format, []any{tt.arg}, nil)
+		if err != nil {
+			t.Fatalf("tests[%d] - format(%q, %v) unexpected error: %v", i, tt.format, tt.arg, err)
+		}
+		if got != tt.expected {
+			t.Errorf("tests[%d] - format(%q, %v) wrong. expected=%q, got=%q",
+				i, tt.format, tt.arg, tt.expected, got)
+		}
+	}
+}
+
+func TestFormatMultipleArgs(t *testing.T) {
+	got, err := formatString("%5.2f %-10s %q %x %%", []any{3.14159, "name", "a\"b", int64(255)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ` 3.14 name       "a\"b" ff %`
+	if got != expected {
+		t.Errorf("format wrong. expected=%q, got=%q", expected, got)
+	}
+}
+
+func TestFormatQuoted(t *testing.T) {
+	tests := []struct {
+		arg      any
+		expected string
+	}{
+		{"hello", `"hello"`},
+		{"a\"b\\c", `"a\"b\\c"`},
+		{"line\nbreak", "\"line\\\nbreak\""},
+		{"\r\x00", `"\13\0"`},
+		{"\x001", `"\0001"`},
+		{"\x7f", `"\127"`},
+		{"caf\xc3\xa9", "\"caf\xc3\xa9\""},
+		{int64(42), "42"},
+		{int64(-7), "-7"},
+		{int64(math.MinInt64), "0x8000000000000000"},
+		{int64(math.MaxInt64), "9223372036854775807"},
+		{1.0, "0x1p+0"},
+		{0.1, "0x1.999999999999ap-4"},
+		{-2.5, "-0x1.4p+1"},
+		{math.Inf(1), "1e9999"},
+		{math.Inf(-1), "-1e9999"},
+		{math.NaN(), "(0/0)"},
+		{nil, "nil"},
+		{false, "false"},
+	}
+
+	for i, tt := range tests {
+		got, err := formatString("%q", []any{tt.arg}, nil)
+		if err != nil {
+			t.Fatalf("tests[%d] - unexpected error: %v", i, err)
+		}
+		if got != tt.expected {
+			t.Errorf("tests[%d] - %%q of %v wrong. expected=%q, got=%q", i, tt.arg, tt.expected, got)
+		}
+	}
+}
+
+func TestFormatQuotedRoundTrip(t *testing.T) {
+	for _, f := range []float64{0.1, 1.0 / 3, -1e-300, 5e-324, math.MaxFloat64, 123.456} {
+		q, err := formatString("%q", []any{f}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		back, ok := parseNumber(q)
+		if !ok || back != f {
+			t.Errorf("%%q of %v does not round-trip. got=%q -> %v", f, q, back)
+		}
+	}
+	for _, n := range []int64{0, -1, math.MaxInt64, math.MinInt64} {
+		q, err := formatString("%q", []any{n}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		back, ok := parseNumber(q)
+		if !ok || back != n {
+			t.Errorf("%%q of %d does not round-trip. got=%q -> %v", n, q, back)
+		}
+	}
+}
+
+func TestFormatTostring(t *testing.T) {
+	type object struct{ name string }
+	tostring := func(v any) (string, error) {
+		if o, ok := v.(*object); ok {
+			return "object " + o.name, nil
+		}
+		return tostringBasic(v), nil
+	}
+	got, err := formatString("[%s] [%12s]", []any{&object{"a"}, &object{"b"}}, tostring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[object a] [    object b]"; got != expected {
+		t.Errorf("format wrong. expected=%q, got=%q", expected, got)
+	}
+}
+
+func TestFormatPointer(t *testing.T) {
+	type object struct{}
+	got, err := formatString("%p", []any{&object{}}, nil)
+	if err != nil || !strings.HasPrefix(got, "0x") {
+		t.Errorf("expected pointer, got=%q err=%v", got, err)
+	}
+	got, err = formatString("%p", []any{int64(1)}, nil)
+	if err != nil || got != "(null)" {
+		t.Errorf("expected (null), got=%q err=%v", got, err)
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	tests := []struct {
+		format   string
+		args     []any
+		expected string
+	}{
+		{"%d", nil, "bad argument #2 to 'format' (no value)"},
+		{"%d %d", []any{int64(1)}, "bad argument #3 to 'format' (no value)"},
+		{"%d", []any{1.5}, "bad argument #2 to 'format' (number has no integer representation)"},
+		{"%d", []any{"x"}, "bad argument #2 to 'format' (number expected, got string)"},
+		{"%f", []any{true}, "bad argument #2 to 'format' (number expected, got boolean)"},
+		{"%10q", []any{"x"}, "specifier '%q' cannot have modifiers"},
+		{"%q", []any{[]int{1}}, "bad argument #2 to 'format' (value has no literal form)"},
+		{"%y", []any{int64(1)}, "invalid conversion '%y' to 'format'"},
+		{"%", []any{int64(1)}, "invalid conversion '%' to 'format'"},
+		{"%123d", []any{int64(1)}, "invalid conversion specification: '%123d'"},
+		{"%#d", []any{int64(1)}, "invalid conversion specification: '%#d'"},
+		{"%.3c", []any{int64(65)}, "invalid conversion specification: '%.3c'"},
+		{"%+x", []any{int64(1)}, "invalid conversion specification: '%+x'"},
+		{"%10.3s", []any{"a\x00b"}, "bad argument #2 to 'format' (string contains zeros)"},
+		{"%" + strings.Repeat("-", 30) + "d", []any{int64(1)}, "invalid format string to 'format'"},
+	}
+
+	for i, tt := range tests {
+		_, err := formatString(tt.format, tt.args, nil)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("tests[%d] - format(%q) error wrong. expected=%q, got=%v", i, tt.format, tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,660 @@
+package luanova
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/Herograme/LuaNova/luanova/diag"
+)
+
+// The formatter prints a chunk in the canonical style: tab indentation,
+// one statement per line, single spaces around binary operators and
+// after commas, at most one blank line between statements, and
+// argument lists and table constructors broken one item per line when
+// they do not fit in maxWidth columns. A block whose statement was
+// written on a single line is kept on one line when it is short and
+// simple enough. All comments are kept; a comment inside an expression
+// that has no line of its own moves before its statement.
+
+const (
+	maxWidth = 100
+	tabWidth = 4
+)
+
+// Format returns src formatted in the canonical style. Formatting is
+// idempotent. src must parse without errors; name is the chunk name used
+// in the errors.
+func Format(name string, src []byte) ([]byte, error) {
+	chunk, err := Parse(name, string(src))
+	if err != nil {
+		return nil, err
+	}
+	f := &formatter{src: src, comments: chunk.Comments}
+	text := strings.TrimPrefix(f.block(chunk.Block.Stmts, 0, len(src)), "\n")
+	if text == "" {
+		return nil, nil
+	}
+	return []byte(text + "\n"), nil
+}
+
+// FormatRange formats the statements overlapping lines from to to
+// (1-based, inclusive), as an editor does for a selection, and returns
+// the edit to apply to src. The statements are those of the innermost
+// block holding the whole range.
+func FormatRange(name string, src []byte, from, to int) (diag.TextEdit, error) {
+	chunk, err := Parse(name, string(src))
+	if err != nil {
+		return diag.TextEdit{}, err
+	}
+	stmts, depth := chunk.Block.Stmts, 0
+	var first, last int
+	for {
+		first, last = -1, -1
+		for i, s := range stmts {
+			if s.End().Line >= from && s.Pos().Line <= to {
+				if first < 0 {
+					first = i
+				}
+				last = i
+			}
+		}
+		if first < 0 {
+			return diag.TextEdit{}, errors.New("no statement in the range")
+		}
+		inner := innerBlock(stmts[first], from, to)
+		if first != last || inner == nil {
+			break
+		}
+		stmts, depth = inner.Stmts, depth+1
+	}
+
+	startPos, endPos := stmts[first].Pos(), stmts[last].End()
+	lineStart := bytes.LastIndexByte(src[:startPos.Offset], '\n') + 1
+	prefix := ""
+	if len(bytes.TrimSpace(src[lineStart:startPos.Offset])) == 0 {
+		startPos = Position{Offset: lineStart, Line: startPos.Line, Column: 1}
+		prefix = strings.Repeat("\t", depth)
+	}
+	var comments []Token
+	for _, c := range chunk.Comments {
+		if c.Pos.Offset >= stmts[first].Pos().Offset && c.Pos.Offset < endPos.Offset {
+			comments = append(comments, c)
+		} else if c.Pos.Offset >= endPos.Offset && c.Pos.Line == endPos.Line {
+			// the trailing comment of the last statement
+			comments = append(comments, c)
+			endPos = c.End
+		}
+	}
+	f := &formatter{src: src, comments: comments}
+	text := strings.TrimPrefix(f.block(stmts[first:last+1], depth, endPos.Offset), "\n")
+	return diag.TextEdit{Start: startPos, End: endPos, NewText: prefix + strings.TrimLeft(text, "\t")}, nil
+}
+
+// innerBlock returns the block of s that holds lines from to to.
+func innerBlock(s Stmt, from, to int) *Block {
+	var found *Block
+	Inspect(s, func(n Node) bool {
+		if b, ok := n.(*Block); ok && found == nil && len(b.Stmts) > 0 &&
+			b.Stmts[0].Pos().Line <= from && b.Stmts[len(b.Stmts)-1].End().Line >= to {
+			found = b
+		}
+		return found == nil
+	})
+	return found
+}
+
+type formatter struct {
+	src      []byte
+	comments []Token
+	next     int // index of the first comment not written yet
+}
+
+func tabs(n int) string {
+	return strings.Repeat("\t", n)
+}
+
+// width returns the display width of the first line of s.
+func width(s string) int {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	return len(s) + strings.Count(s, "\t")*(tabWidth-1)
+}
+
+// fits reports whether s is a single line that fits from column col.
+func fits(col int, s string) bool {
+	return !strings.Contains(s, "\n") && col+width(s) <= maxWidth
+}
+
+// hasComments reports whether a comment starts between start and end.
+func (f *formatter) hasComments(start, end int) bool {
+	i := sort.Search(len(f.comments), func(i int) bool { return f.comments[i].Pos.Offset >= start })
+	return i < len(f.comments) && f.comments[i].Pos.Offset < end
+}
+
+// commentText renders a comment without trailing spaces.
+func commentText(c Token) string {
+	text := strings.TrimRight(c.Literal, " \t\r\n")
+	if c.Type == CommentBlock {
+		// the lexer includes the character before the comment
+		if i := strings.Index(text, "-*"); i >= 0 {
+			text = text[i:]
+		}
+	}
+	return text
+}
+
+// block renders stmts at indent, each on a new line, followed by the
+// comments that start before end.
+func (f *formatter) block(stmts []Stmt, indent, end int) string {
+	var b strings.Builder
+	lastLine := -1 // no blank line before the first item
+	item := func(line, endLine int, text string) {
+		if lastLine >= 0 && line > lastLine+1 {
+			b.WriteByte('\n')
+		}
+		b.WriteString("\n" + tabs(indent) + text)
+		lastLine = endLine
+	}
+	comments := func(before int) {
+		for f.next < len(f.comments) && f.comments[f.next].Pos.Offset < before {
+			c := f.comments[f.next]
+			f.next++
+			item(c.Pos.Line, c.End.Line, commentText(c))
+		}
+	}
+
+	for i, s := range stmts {
+		comments(s.Pos().Offset)
+		text := f.stmt(s, indent)
+		if i > 0 && startsWithParen(s) {
+			// otherwise it would call the statement above
+			text = ";" + text
+		}
+		// comments inside the statement that found no place go before it
+		start := f.next
+		for f.next < len(f.comments) && f.comments[f.next].Pos.Offset < s.End().Offset {
+			f.next++
+		}
+		for _, c := range f.comments[start:f.next] {
+			item(c.Pos.Line, c.Pos.Line, commentText(c))
+			lastLine = s.Pos().Line - 1
+		}
+		if f.next < len(f.comments) && f.comments[f.next].Pos.Line == s.End().Line && f.comments[f.next].Pos.Offset < end &&
+			(i+1 == len(stmts) || f.comments[f.next].Pos.Offset < stmts[i+1].Pos().Offset) {
+			c := f.comments[f.next]
+			f.next++
+			text += " " + commentText(c)
+			item(s.Pos().Line, c.End.Line, text)
+			continue
+		}
+		item(s.Pos().Line, s.End().Line, text)
+	}
+	comments(end)
+	return b.String()
+}
+
+// body renders a block ending with the keyword closing it, which is at
+// end in the source.
+func (f *formatter) body(b *Block, indent, end int) string {
+	return f.block(b.Stmts, indent+1, end) + "\n" + tabs(indent)
+}
+
+// oneLine renders header body end on one line when the source has it on
+// one line, the body has at most one statement, nothing in it is a
+// comment and the result fits.
+func (f *formatter) oneLine(n Node, header string, b *Block, indent, col int) (string, bool) {
+	if n.Pos().Line != n.End().Line || len(b.Stmts) > 1 || f.hasComments(n.Pos().Offset, n.End().Offset) {
+		return "", false
+	}
+	text := header + " end"
+	if len(b.Stmts) == 1 {
+		if _, ok := b.Stmts[0].(*RepeatStmt); ok {
+			return "", false
+		}
+		text = header + " " + f.stmt(b.Stmts[0], indent+1) + " end"
+	}
+	return text, fits(col, text)
+}
+
+func (f *formatter) stmt(s Stmt, indent int) string {
+	col := indent * tabWidth
+	switch s := s.(type) {
+	case *LocalStmt:
+		text := "local " + f.bindings(s.Names)
+		if len(s.Values) > 0 {
+			text += " = "
+			text += f.exprList(s.Values, indent, col+width(text))
+		}
+		return text
+	case *LocalFunctionStmt:
+		header := "local function " + s.Name.Name
+		return header + f.funcBody(s.Func, false, indent, col+len(header))
+	case *FunctionStmt:
+		header := "function " + f.expr(s.Name, indent, col)
+		if s.Method != nil {
+			header += ":" + s.Method.Name
+		}
+		return header + f.funcBody(s.Func, s.Method != nil, indent, col+len(header))
+	case *AssignStmt:
+		text := f.exprList(s.Targets, indent, col) + " = "
+		return text + f.exprList(s.Values, indent, col+width(text))
+	case *CompoundAssignStmt:
+		text := f.expr(s.Target, indent, col) + " " + binaryOps[s.Op] + "= "
+		return text + f.expr(s.Value, indent, col+width(text))
+	case *CallStmt:
+		return f.expr(s.Call, indent, col)
+	case *DoStmt:
+		if text, ok := f.oneLine(s, "do", s.Body, indent, col); ok {
+			return text
+		}
+		return "do" + f.body(s.Body, indent, s.End().Offset) + "end"
+	case *WhileStmt:
+		header := "while " + f.expr(s.Cond, indent, col+6) + " do"
+		if text, ok := f.oneLine(s, header, s.Body, indent, col); ok {
+			return text
+		}
+		return header + f.body(s.Body, indent, s.End().Offset) + "end"
+	case *RepeatStmt:
+		text := "repeat" + f.body(s.Body, indent, s.Cond.Pos().Offset) + "until "
+		return text + f.expr(s.Cond, indent, col+6)
+	case *IfStmt:
+		return f.ifStmt(s, indent)
+	case *NumericForStmt:
+		header := "for " + f.binding(s.Var) + " = " + f.expr(s.Start, indent, col) + ", " + f.expr(s.Limit, indent, col)
+		if s.Step != nil {
+			header += ", " + f.expr(s.Step, indent, col)
+		}
+		header += " do"
+		if text, ok := f.oneLine(s, header, s.Body, indent, col); ok {
+			return text
+		}
+		return header + f.body(s.Body, indent, s.End().Offset) + "end"
+	case *GenericForStmt:
+		header := "for " + f.bindings(s.Names) + " in "
+		header += f.exprList(s.Exprs, indent, col+width(header)) + " do"
+		if text, ok := f.oneLine(s, header, s.Body, indent, col); ok {
+			return text
+		}
+		return header + f.body(s.Body, indent, s.End().Offset) + "end"
+	case *ReturnStmt:
+		if len(s.Values) == 0 {
+			return "return"
+		}
+		return "return " + f.exprList(s.Values, indent, col+7)
+	case *BreakStmt:
+		return "break"
+	case *ContinueStmt:
+		return "continue"
+	case *TypeStmt:
+		return "type " + s.Name.Name + " = " + f.typeExpr(s.Type)
+	case *ImportStmt:
+		if s.Namespace != nil {
+			return "import * as " + s.Namespace.Name + " from " + f.expr(s.Path, indent, col)
+		}
+		return "import " + importList(s.Names) + " from " + f.expr(s.Path, indent, col)
+	case *ExportStmt:
+		switch decl := s.Decl.(type) {
+		case nil:
+			return "export " + importList(s.Names)
+		case *LocalFunctionStmt:
+			header := "export function " + decl.Name.Name
+			return header + f.funcBody(decl.Func, false, indent, col+len(header))
+		}
+		return "export " + f.stmt(s.Decl, indent)
+	}
+	return ""
+}
+
+func (f *formatter) ifStmt(s *IfStmt, indent int) string {
+	col := indent * tabWidth
+	if len(s.Clauses) == 1 && s.Else == nil {
+		header := "if " + f.expr(s.Clauses[0].Cond, indent, col+3) + " then"
+		if text, ok := f.oneLine(s, header, s.Clauses[0].Body, indent, col); ok {
+			return text
+		}
+	}
+	var b strings.Builder
+	for i, c := range s.Clauses {
+		if i == 0 {
+			b.WriteString("if ")
+		} else {
+			b.WriteString("elseif ")
+		}
+		b.WriteString(f.expr(c.Cond, indent, col+7) + " then")
+		end := s.End().Offset
+		if i+1 < len(s.Clauses) {
+			end = s.Clauses[i+1].Pos().Offset
+		} else if s.Else != nil {
+			end = f.keywordBefore(s.Else.Pos().Offset, "else")
+		}
+		b.WriteString(f.body(c.Body, indent, end))
+	}
+	if s.Else != nil {
+		b.WriteString("else" + f.body(s.Else, indent, s.End().Offset))
+	}
+	b.WriteString("end")
+	return b.String()
+}
+
+// keywordBefore returns the offset of the last kw before offset outside
+// comments, so that comments before an else keyword stay in the block
+// above it and those after it go in the block it opens.
+func (f *formatter) keywordBefore(offset int, kw string) int {
+	end := offset
+	for {
+		i := bytes.LastIndex(f.src[:end], []byte(kw))
+		if i < 0 {
+			return offset
+		}
+		c := sort.Search(len(f.comments), func(c int) bool { return f.comments[c].End.Offset > i })
+		if c == len(f.comments) || f.comments[c].Pos.Offset > i {
+			return i
+		}
+		end = f.comments[c].Pos.Offset
+	}
+}
+
+func importList(specs []*ImportSpec) string {
+	names := make([]string, len(specs))
+	for i, s := range specs {
+		names[i] = s.Name.Name
+		if s.Alias != nil {
+			names[i] += " as " + s.Alias.Name
+		}
+	}
+	return "{ " + strings.Join(names, ", ") + " }"
+}
+
+func (f *formatter) bindings(bs []*Binding) string {
+	names := make([]string, len(bs))
+	for i, b := range bs {
+		names[i] = f.binding(b)
+	}
+	return strings.Join(names, ", ")
+}
+
+func (f *formatter) binding(b *Binding) string {
+	if b.Type != nil {
+		return b.Name.Name + ": " + f.typeExpr(b.Type)
+	}
+	return b.Name.Name
+}
+
+// funcBody renders the parameters and body of fn starting at column
+// col; method functions skip their implicit self.
+func (f *formatter) funcBody(fn *FunctionExpr, method bool, indent, col int) string {
+	params := fn.Params
+	if method {
+		params = params[1:]
+	}
+	list := f.bindings(params)
+	if fn.IsVararg {
+		if list != "" {
+			list += ", "
+		}
+		list += "..."
+		if fn.VarargType != nil {
+			list += ": " + f.typeExpr(fn.VarargType)
+		}
+	}
+	header := "(" + list + ")"
+	if fn.ReturnType != nil {
+		header += ": " + f.typeExpr(fn.ReturnType)
+	}
+	if text, ok := f.oneLine(fn, header, fn.Body, indent, col); ok {
+		return text
+	}
+	return header + f.body(fn.Body, indent, fn.End().Offset) + "end"
+}
+
+// Expressions
+
+// exprList renders exprs separated by commas, starting at column col.
+func (f *formatter) exprList(exprs []Expr, indent, col int) string {
+	var b strings.Builder
+	for i, e := range exprs {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(f.expr(e, indent, col+width(b.String())))
+	}
+	return b.String()
+}
+
+// expr renders e starting at column col; lines it breaks are indented
+// from indent.
+func (f *formatter) expr(e Expr, indent, col int) string {
+	switch e := e.(type) {
+	case *NilExpr:
+		return "nil"
+	case *BoolExpr:
+		if e.Value {
+			return "true"
+		}
+		return "false"
+	case *NumberExpr:
+		return e.Raw
+	case *StringExpr:
+		return `"` + e.Raw + `"`
+	case *VarargExpr:
+		return "..."
+	case *Ident:
+		return e.Name
+	case *FunctionExpr:
+		return "function" + f.funcBody(e, false, indent, col+8)
+	case *TableExpr:
+		return f.table(e, indent, col)
+	case *BinaryExpr:
+		left := f.expr(e.Left, indent, col) + " " + binaryOps[e.Op] + " "
+		return left + f.expr(e.Right, indent, col+width(left))
+	case *UnaryExpr:
+		op := unaryOps[e.Op]
+		x := f.expr(e.X, indent, col+len(op))
+		if op == "-" && strings.HasPrefix(x, "-") {
+			op += " " // not a comment
+		}
+		return op + x
+	case *ParenExpr:
+		return "(" + f.expr(e.X, indent, col+1) + ")"
+	case *FieldExpr:
+		return f.expr(e.X, indent, col) + "." + e.Name.Name
+	case *IndexExpr:
+		x := f.expr(e.X, indent, col) + "["
+		return x + f.expr(e.Key, indent, col+width(x)) + "]"
+	case *CallExpr:
+		fn := f.expr(e.Fn, indent, col)
+		return fn + f.args(e.Args, e.Fn.End().Offset, e.End().Offset, indent, col+width(fn))
+	case *MethodCallExpr:
+		recv := f.expr(e.Recv, indent, col) + ":" + e.Name.Name
+		return recv + f.args(e.Args, e.Name.End().Offset, e.End().Offset, indent, col+width(recv))
+	}
+	return ""
+}
+
+// args renders the arguments of a call, found between start and end in
+// the source. A single string or table argument written without
+// parentheses stays so.
+func (f *formatter) args(args []Expr, start, end, indent, col int) string {
+	if len(args) == 1 && !bytes.Contains(f.src[start:args[0].Pos().Offset], []byte("(")) {
+		switch arg := args[0].(type) {
+		case *StringExpr:
+			return " " + f.expr(arg, indent, col+1)
+		case *TableExpr:
+			return f.expr(arg, indent, col)
+		}
+	}
+	if len(args) == 0 {
+		return "()"
+	}
+	if !f.loose(args, start, end) {
+		saved := f.next
+		parts := make([]string, len(args))
+		text := "("
+		for i, a := range args {
+			if i > 0 {
+				text += ", "
+			}
+			parts[i] = f.expr(a, indent, col+width(text))
+			text += parts[i]
+		}
+		text += ")"
+		// a function or table last argument may span lines
+		if fits(col, text) || strings.Contains(text, "\n") && col+width(text) <= maxWidth &&
+			!strings.Contains(strings.Join(parts[:len(parts)-1], ""), "\n") {
+			return text
+		}
+		f.next = saved
+	}
+	return "(" + f.items(args, nil, indent, end, false) + "\n" + tabs(indent) + ")"
+}
+
+// loose reports whether there are comments between the items of a list
+// or inside items that do not place their own comments, which forces
+// the list onto several lines.
+func (f *formatter) loose(items []Expr, start, end int) bool {
+	for i := f.next; i < len(f.comments); i++ {
+		off := f.comments[i].Pos.Offset
+		if off >= end {
+			return false
+		}
+		if off < start {
+			continue
+		}
+		placed := false
+		for _, e := range items {
+			switch e.(type) {
+			case *FunctionExpr, *TableExpr:
+				placed = placed || e.Pos().Offset <= off && off < e.End().Offset
+			}
+		}
+		if !placed {
+			return true
+		}
+	}
+	return false
+}
+
+// items renders one item per line at indent+1, with the comments
+// before each item on their own lines. keys, if not nil, renders the
+// key part of an item. Items end with a comma, except the last one
+// unless trailing is set.
+func (f *formatter) items(items []Expr, keys func(i int) string, indent, end int, trailing bool) string {
+	var b strings.Builder
+	inner := indent + 1
+	for i, e := range items {
+		for f.next < len(f.comments) && f.comments[f.next].Pos.Offset < e.Pos().Offset {
+			b.WriteString("\n" + tabs(inner) + commentText(f.comments[f.next]))
+			f.next++
+		}
+		text := ""
+		if keys != nil {
+			text = keys(i)
+		}
+		text += f.expr(e, inner, inner*tabWidth+width(text))
+		if i < len(items)-1 || trailing {
+			text += ","
+		}
+		b.WriteString("\n" + tabs(inner) + text)
+		if f.next < len(f.comments) && f.comments[f.next].Pos.Line == e.End().Line && f.comments[f.next].Pos.Offset < end {
+			b.WriteString(" " + commentText(f.comments[f.next]))
+			f.next++
+		}
+	}
+	for f.next < len(f.comments) && f.comments[f.next].Pos.Offset < end {
+		b.WriteString("\n" + tabs(inner) + commentText(f.comments[f.next]))
+		f.next++
+	}
+	return b.String()
+}
+
+// table renders a table constructor, one field per line when the source
+// starts the fields on a new line or the fields do not fit.
+func (f *formatter) table(e *TableExpr, indent, col int) string {
+	if len(e.Fields) == 0 {
+		return "{}"
+	}
+	values := make([]Expr, len(e.Fields))
+	for i, field := range e.Fields {
+		values[i] = field.Value
+	}
+	keys := func(i int) string {
+		field := e.Fields[i]
+		switch {
+		case field.Named:
+			return field.Key.(*Ident).Name + " = "
+		case field.Key != nil:
+			k := f.expr(field.Key, indent+1, (indent+1)*tabWidth+1)
+			return "[" + k + "] = "
+		}
+		return ""
+	}
+	first := e.Fields[0].Pos().Offset
+	broken := bytes.ContainsRune(f.src[e.Pos().Offset:first], '\n')
+	if !broken && !f.loose(values, e.Pos().Offset, e.End().Offset) {
+		saved := f.next
+		text := "{"
+		for i, v := range values {
+			if i > 0 {
+				text += ", "
+			}
+			text += keys(i)
+			text += f.expr(v, indent, col+width(text))
+		}
+		text += "}"
+		if fits(col, text) || strings.Contains(text, "\n") && col+width(text) <= maxWidth &&
+			!strings.Contains(text[:strings.LastIndex(text, keys(len(values)-1))], "\n") {
+			return text
+		}
+		f.next = saved
+	}
+	return "{" + f.items(values, keys, indent, e.End().Offset, true) + "\n" + tabs(indent) + "}"
+}
+
+// Types
+
+func (f *formatter) typeExpr(t TypeExpr) string {
+	switch t := t.(type) {
+	case *NamedType:
+		if len(t.Args) == 0 {
+			return t.Name
+		}
+		return t.Name + "<" + f.typeList(t.Args, false) + ">"
+	case *OptionalType:
+		if _, ok := t.Inner.(*FunctionType); ok {
+			return "(" + f.typeExpr(t.Inner) + ")?"
+		}
+		return f.typeExpr(t.Inner) + "?"
+	case *FunctionType:
+		return "(" + f.typeList(t.Params, t.Variadic) + ") -> " + f.typeExpr(t.Return)
+	case *TupleType:
+		return "(" + f.typeList(t.Types, t.Variadic) + ")"
+	case *TableType:
+		fields := make([]string, len(t.Fields))
+		for i, field := range t.Fields {
+			switch {
+			case field.Name != nil:
+				fields[i] = field.Name.Name + ": " + f.typeExpr(field.Value)
+			case field.Key != nil:
+				fields[i] = "[" + f.typeExpr(field.Key) + "]: " + f.typeExpr(field.Value)
+			default:
+				fields[i] = f.typeExpr(field.Value)
+			}
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	}
+	return ""
+}
+
+func (f *formatter) typeList(types []TypeExpr, variadic bool) string {
+	s := make([]string, len(types))
+	for i, t := range types {
+		s[i] = f.typeExpr(t)
+	}
+	if variadic && len(s) > 0 {
+		s[len(s)-1] = "..." + s[len(s)-1]
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,212 @@
+package luanova
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Herograme/LuaNova/luanova/diag"
+)
+
+func format(t *testing.T, src string) string {
+	t.Helper()
+	out, err := Format("test", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"spacing", `local   x,y=1,2;local z=x+y*-x.."s"
+t.a[ 1 ]=not x==y`, `local x, y = 1, 2
+local z = x + y * -x .. "s"
+t.a[1] = not x == y
+`},
+		{"blocks", `
+
+if x then print(1) end
+if x then print(1) else print(2) end
+while x do x -= 1 end
+
+
+for i=1,10,2 do
+print(i) end
+function obj:add(k) self.n += k; return self end
+repeat local y = 1 until y
+
+`, `if x then print(1) end
+if x then
+	print(1)
+else
+	print(2)
+end
+while x do x -= 1 end
+
+for i = 1, 10, 2 do
+	print(i)
+end
+function obj:add(k)
+	self.n += k
+	return self
+end
+repeat
+	local y = 1
+until y
+`},
+		{"comments", `-- header
+local x = 1 -- one
+-* block *-
+if x then
+  -- inside
+  print(x) -- trailing
+
+  -- end of block
+end
+local t = {1, -- first
+  2}
+`, `-- header
+local x = 1 -- one
+-* block *-
+if x then
+	-- inside
+	print(x) -- trailing
+
+	-- end of block
+end
+local t = {
+	1, -- first
+	2,
+}
+`},
+		{"comments after else", `if x>1 then print("a") elseif x<0 then print("b") else -- trailing else
+  -- inside else
+end`, `if x > 1 then
+	print("a")
+elseif x < 0 then
+	print("b")
+else
+	-- trailing else
+	-- inside else
+end
+`},
+		{"calls and tables", `require "mod"
+setup{name="x"}
+print(- -x, #t, (a + b) * c)
+local t = {
+  a = 1, [k] = 2, 3
+}
+local u = {a = 1, [k] = 2, 3}
+foo(1, function()
+  return 1
+end)
+print("a very long string argument here", "another very long string argument", "and yet another one")`, `require "mod"
+setup{name = "x"}
+print(- -x, #t, (a + b) * c)
+local t = {
+	a = 1,
+	[k] = 2,
+	3,
+}
+local u = {a = 1, [k] = 2, 3}
+foo(1, function()
+	return 1
+end)
+print(
+	"a very long string argument here",
+	"another very long string argument",
+	"and yet another one"
+)
+`},
+		{"types and modules", `type Pair = { first:int, second:string? }
+local f:(int,...string)->int = function(a:int,...:string):int return a end
+import {a,b as c} from "./m"
+import * as m from "./m"
+export local function g() end
+export {x as y}`, `type Pair = {first: int, second: string?}
+local f: (int, ...string) -> int = function(a: int, ...: string): int return a end
+import { a, b as c } from "./m"
+import * as m from "./m"
+export function g() end
+export { x as y }
+`},
+	}
+	for _, tt := range tests {
+		got := format(t, tt.input)
+		if got != tt.expected {
+			t.Errorf("%s: got:\n%s\nexpected:\n%s", tt.name, got, tt.expected)
+			continue
+		}
+		if again := format(t, got); again != got {
+			t.Errorf("%s: not idempotent:\n%s", tt.name, again)
+		}
+	}
+}
+
+func TestFormatKeepsBehavior(t *testing.T) {
+	inputs := []string{
+		`local x, s, t = 10, "a", {n = 1, list = {5}}
+x += 2; s ..= "b" .. "c"; t.list[1] *= 2 + 1
+print(x, s, t.n, t.list[1], - -x, 2 ^ 3 ^ 2, (2 ^ 3) ^ 2)`,
+		`local out = {}
+for i = 1, 10 do if i % 2 == 0 then continue end out[#out + 1] = i end
+local obj = {n = 0}
+function obj:add(k) self.n += k return self end
+obj:add(2):add(3)
+;(print)(table.concat(out, " "), obj.n, select("#", 1, nil, 3))`,
+	}
+	for _, input := range inputs {
+		out := format(t, input)
+		if got, expected := runScript(t, out), runScript(t, input); got != expected {
+			t.Errorf("got %q, expected %q\n%s", got, expected, out)
+		}
+	}
+}
+
+func TestFormatLongLines(t *testing.T) {
+	var args []string
+	for i := range 30 {
+		args = append(args, "argument"+strings.Repeat("x", i%5))
+	}
+	out := format(t, "call("+strings.Join(args, ", ")+")\nlocal t = {"+strings.Join(args, ", ")+"}")
+	for _, line := range strings.Split(out, "\n") {
+		if width(line) > maxWidth {
+			t.Errorf("line too long: %q", line)
+		}
+	}
+	if again := format(t, out); again != out {
+		t.Errorf("not idempotent:\n%s\n%s", out, again)
+	}
+}
+
+func TestFormatRange(t *testing.T) {
+	src := "local a=1\nif a then\n  local b=2\n    print( b )\nend\nlocal c=3\n"
+	tests := []struct {
+		from, to int
+		expected string
+	}{
+		{1, 1, "local a = 1\nif a then\n  local b=2\n    print( b )\nend\nlocal c=3\n"},
+		{3, 4, "local a=1\nif a then\n\tlocal b = 2\n\tprint(b)\nend\nlocal c=3\n"},
+		{4, 6, "local a=1\nif a then\n\tlocal b = 2\n\tprint(b)\nend\nlocal c = 3\n"},
+	}
+	for _, tt := range tests {
+		edit, err := FormatRange("test", []byte(src), tt.from, tt.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(diag.ApplyEdits([]byte(src), []diag.TextEdit{edit})); got != tt.expected {
+			t.Errorf("%d:%d: got %q, expected %q", tt.from, tt.to, got, tt.expected)
+		}
+	}
+}
+
+func TestFormatSyntaxError(t *testing.T) {
+	_, err := Format("test", []byte("local = 1"))
+	if _, ok := err.(ErrorList); !ok {
+		t.Errorf("got %v, expected a syntax error list", err)
+	}
+}
@@ -0,0 +1,37 @@
+//go:build ignore
+
+// Gengrammar writes the TextMate grammar and the language configuration
+// of the editor extension under lsp/highlight from the token tables.
+//
+//	go generate ./luanova
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Herograme/LuaNova/luanova"
+)
+
+const extension = "../lsp/highlight"
+
+func main() {
+	files := []struct {
+		path     string
+		generate func() ([]byte, error)
+	}{
+		{"syntaxes/luanova.tmLanguage.json", luanova.TextMateGrammar},
+		{"language-configuration.json", luanova.LanguageConfiguration},
+	}
+	for _, f := range files {
+		data, err := f.generate()
+		if err == nil {
+			err = os.WriteFile(filepath.Join(extension, f.path), data, 0o644)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "gengrammar:", err)
+			os.Exit(1)
+		}
+	}
+}
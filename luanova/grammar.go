@@ -0,0 +1,258 @@
+package luanova
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The editor extension under lsp/highlight highlights with a TextMate
+// grammar until the language server answers with semantic tokens. Both
+// its grammar and its language configuration are generated from the
+// token tables, so they cannot drift from the lexer; gengrammar.go
+// writes them.
+
+// tmRule is a rule of a TextMate grammar.
+type tmRule struct {
+	Comment  string   `json:"comment,omitempty"`
+	Name     string   `json:"name,omitempty"`
+	Match    string   `json:"match,omitempty"`
+	Begin    string   `json:"begin,omitempty"`
+	End      string   `json:"end,omitempty"`
+	Include  string   `json:"include,omitempty"`
+	Patterns []tmRule `json:"patterns,omitempty"`
+}
+
+type tmGrammar struct {
+	Schema     string            `json:"$schema"`
+	Name       string            `json:"name"`
+	ScopeName  string            `json:"scopeName"`
+	Comment    string            `json:"comment"`
+	Patterns   []tmRule          `json:"patterns"`
+	Repository map[string]tmRule `json:"repository"`
+}
+
+const generatedComment = "Code generated by go generate from luanova/tokens.go; DO NOT EDIT."
+
+// Scopes of the grammar for what the lexer reads besides keywords and
+// operators.
+const (
+	scopeLineComment  = "comment.line.double-dash.luanova"
+	scopeBlockComment = "comment.block.luanova"
+	scopeString       = "string.quoted.double.luanova"
+	scopeEscape       = "constant.character.escape.luanova"
+	scopeNumber       = "constant.numeric.luanova"
+	scopeIdentifier   = "variable.other.luanova"
+	scopeContextual   = "keyword.control.import.luanova"
+)
+
+// tokenScope returns the scope highlighting tok, or "" for the tokens
+// the grammar does not match on their own.
+func tokenScope(tok int) string {
+	switch tok {
+	case Comment:
+		return scopeLineComment
+	case CommentBlock:
+		return scopeBlockComment
+	case StringDelim:
+		return scopeString
+	case True, False:
+		return "constant.language.boolean.luanova"
+	case Nil:
+		return "constant.language.nil.luanova"
+	case And, Or, Not:
+		return "keyword.operator.logical.luanova"
+	case Function, Local:
+		return "storage.type.luanova"
+	case Import, Export, From, As:
+		return scopeContextual
+	case Assign, PlusAssign, SubAssign, MultiAssign, DivAssign, ModAssign:
+		return "keyword.operator.assignment.luanova"
+	case Equal, NotEqual, Less, LessEqual, Greater, GreaterEqual:
+		return "keyword.operator.comparison.luanova"
+	case Plus, Sub, Multi, Div, Mod, Po:
+		return "keyword.operator.arithmetic.luanova"
+	case Concat:
+		return "keyword.operator.concatenation.luanova"
+	case Len:
+		return "keyword.operator.length.luanova"
+	case Dots:
+		return "variable.language.vararg.luanova"
+	case Arrow:
+		return "keyword.operator.arrow.luanova"
+	case Question:
+		return "keyword.operator.optional.luanova"
+	case LParen, RParen, LBrace, RBrace, LBrack, RBrack:
+		return "punctuation.section.brackets.luanova"
+	case Comma:
+		return "punctuation.separator.comma.luanova"
+	case Semi:
+		return "punctuation.terminator.statement.luanova"
+	case Colom:
+		return "punctuation.separator.colon.luanova"
+	case Dot:
+		return "punctuation.accessor.luanova"
+	}
+	if Function <= tok && tok <= Do {
+		return "keyword.control.luanova"
+	}
+	return ""
+}
+
+// numberPattern matches the numerals of readNumber, joining "1", "."
+// and "5" the way the parser does.
+const numberPattern = `\b(?:0[xX](?:[pP][+-]|\w)*|\d(?:[eEpP][+-]|\w)*(?:\.\d(?:[eEpP][+-]|\w)*)?)`
+
+// wordRules returns a rule per scope matching the words of table.
+func wordRules(table map[string]int) []tmRule {
+	byScope := map[string][]string{}
+	for word, tok := range table {
+		byScope[tokenScope(tok)] = append(byScope[tokenScope(tok)], word)
+	}
+	var rules []tmRule
+	for scope, words := range byScope {
+		sort.Strings(words)
+		rules = append(rules, tmRule{Name: scope, Match: `\b(?:` + strings.Join(words, "|") + `)\b`})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules
+}
+
+// operatorRules returns a rule per scope and length matching the
+// operators, the longer ones first: a TextMate grammar takes the first
+// rule matching at a position, not the longest match.
+func operatorRules() []tmRule {
+	type group struct {
+		scope string
+		n     int
+	}
+	groups := map[group][]string{}
+	for op, tok := range operators {
+		g := group{tokenScope(tok), len(op)}
+		groups[g] = append(groups[g], regexp.QuoteMeta(op))
+	}
+	var keys []group
+	for g := range groups {
+		keys = append(keys, g)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].n != keys[j].n {
+			return keys[i].n > keys[j].n
+		}
+		return keys[i].scope < keys[j].scope
+	})
+	var rules []tmRule
+	for _, g := range keys {
+		ops := groups[g]
+		sort.Strings(ops)
+		match := strings.Join(ops, "|")
+		if len(ops) > 1 {
+			match = "(?:" + match + ")"
+		}
+		rules = append(rules, tmRule{Name: g.scope, Match: match})
+	}
+	return rules
+}
+
+func textMateGrammar() *tmGrammar {
+	return &tmGrammar{
+		Schema:    "https://raw.githubusercontent.com/martinring/tmlanguage/master/tmlanguage.json",
+		Name:      "LuaNova",
+		ScopeName: "source.luanova",
+		Comment:   generatedComment,
+		Patterns: []tmRule{
+			{Include: "#comments"},
+			{Include: "#strings"},
+			{Include: "#numbers"},
+			{Include: "#keywords"},
+			{Include: "#identifiers"},
+			{Include: "#operators"},
+		},
+		Repository: map[string]tmRule{
+			"comments": {Patterns: []tmRule{
+				{Name: scopeBlockComment, Begin: `-\*`, End: `\*-`},
+				{Name: scopeLineComment, Match: `--.*$`},
+			}},
+			"strings": {Patterns: []tmRule{
+				{Name: scopeString, Begin: `"`, End: `"`, Patterns: []tmRule{
+					{Name: scopeEscape, Match: `\\.`},
+				}},
+			}},
+			"numbers": {Patterns: []tmRule{
+				{Name: scopeNumber, Match: numberPattern},
+			}},
+			"keywords": {Patterns: append(wordRules(keywords), tmRule{
+				Comment: "import, export, from and as are keywords only where a module declaration can start",
+				Name:    scopeContextual,
+				Match:   wordRules(contextualKeywords)[0].Match,
+			})},
+			"identifiers": {Patterns: []tmRule{
+				{Name: scopeIdentifier, Match: `[\p{L}_][\p{L}\p{N}_]*`},
+			}},
+			"operators": {Patterns: operatorRules()},
+		},
+	}
+}
+
+// TextMateGrammar returns the TextMate grammar of the editor extension.
+func TextMateGrammar() ([]byte, error) {
+	return marshalJSON(textMateGrammar())
+}
+
+// LanguageConfiguration returns the VS Code language configuration of
+// the editor extension: comments, brackets and indentation.
+func LanguageConfiguration() ([]byte, error) {
+	spell := func(toks ...int) string {
+		var words []string
+		for word, tok := range keywords {
+			for _, t := range toks {
+				if tok == t {
+					words = append(words, word)
+				}
+			}
+		}
+		sort.Strings(words)
+		return strings.Join(words, "|")
+	}
+	pairs := [][]string{{"{", "}"}, {"[", "]"}, {"(", ")"}, {`"`, `"`}}
+	autoClosing := []map[string]any{
+		{"open": "{", "close": "}"},
+		{"open": "[", "close": "]"},
+		{"open": "(", "close": ")"},
+		{"open": `"`, "close": `"`, "notIn": []string{"string", "comment"}},
+	}
+	config := map[string]any{
+		"comments": map[string]any{
+			"lineComment":  "--",
+			"blockComment": []string{"-*", "*-"},
+		},
+		"brackets":         pairs[:3],
+		"autoClosingPairs": autoClosing,
+		"surroundingPairs": pairs,
+		"wordPattern":      `[\p{L}_][\p{L}\p{N}_]*|\d[\w.]*`,
+		"indentationRules": map[string]any{
+			"increaseIndentPattern": `^((?!--).)*((\b(` + spell(Function, Then, Do, Repeat, Else) + `)\b((?!\b(` + spell(End, Until) + `)\b).)*)|(\{\s*)|(\(\s*))$`,
+			"decreaseIndentPattern": `^\s*((\b(` + spell(ElseIf, Else, End, Until) + `)\b)|(\})|(\)))`,
+		},
+	}
+	data, err := marshalJSON(config)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("// "+generatedComment+"\n"), data...), nil
+}
+
+// marshalJSON indents v with tabs, leaving < and > unescaped in the
+// patterns.
+func marshalJSON(v any) ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
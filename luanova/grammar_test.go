@@ -0,0 +1,270 @@
+package luanova
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGeneratedGrammar(t *testing.T) {
+	files := []struct {
+		path     string
+		generate func() ([]byte, error)
+	}{
+		{"syntaxes/luanova.tmLanguage.json", TextMateGrammar},
+		{"language-configuration.json", LanguageConfiguration},
+	}
+	for _, f := range files {
+		want, err := f.generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join("..", "lsp", "highlight", f.path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s is stale: run go generate ./luanova", f.path)
+		}
+	}
+}
+
+func TestTokenTables(t *testing.T) {
+	for _, table := range []map[string]int{keywords, operators} {
+		for text, want := range table {
+			l := NewLexer(text)
+			if tok := l.NextToken(); tok.Type != want || tok.Literal != text {
+				t.Errorf("%q lexed as %s %q, want %s", text, TokenName(tok.Type), tok.Literal, TokenName(want))
+			}
+			if tok := l.NextToken(); tok.Type != EOF {
+				t.Errorf("%q lexed as more than one token", text)
+			}
+		}
+	}
+}
+
+// TestGrammarHighlighting highlights the inputs of the lexer tests with
+// the generated grammar and checks that every token gets the scope of
+// its lexer token type.
+func TestGrammarHighlighting(t *testing.T) {
+	data, err := TextMateGrammar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var g tmGrammar
+	if err := json.Unmarshal(data, &g); err != nil {
+		t.Fatal(err)
+	}
+	m := compileGrammar(t, g.Patterns, g.Repository)
+	inputs := lexerTestInputs(t)
+	if len(inputs) < 10 {
+		t.Fatalf("found %d lexer test inputs", len(inputs))
+	}
+	for _, input := range inputs {
+		name := strings.SplitN(input, "\n", 2)[0]
+		scopes := m.highlight(input)
+		l := NewLexer(input)
+		for tok := l.NextToken(); tok.Type != EOF; tok = l.NextToken() {
+			if tok.Type == Illegal {
+				continue
+			}
+			want := tokenScope(tok.Type)
+			if tok.Type == Literal {
+				switch {
+				case isDigit(tok.Literal[0]):
+					want = scopeNumber
+				case LookupContextual(tok.Literal) != Literal:
+					want = scopeContextual
+				default:
+					want = scopeIdentifier
+				}
+			}
+			got := scopes[tok.Pos.Offset]
+			if tok.Type == Dot && got == scopeNumber {
+				continue // the dot of 1.5
+			}
+			if got != want {
+				t.Errorf("%q: %s %q at %v highlighted as %q, want %q", name, TokenName(tok.Type), tok.Literal, tok.Pos, got, want)
+			}
+			for i := tok.Pos.Offset; i < tok.End.Offset; i++ {
+				if scopes[i] == "" && !strings.ContainsRune(" \t\r\n", rune(input[i])) {
+					t.Errorf("%q: %s %q at %v is not highlighted at offset %d", name, TokenName(tok.Type), tok.Literal, tok.Pos, i)
+					break
+				}
+			}
+		}
+	}
+}
+
+// lexerTestInputs returns the strings lexer_test.go lexes: the values
+// of input variables and input fields.
+func lexerTestInputs(t *testing.T) []string {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), "lexer_test.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inputs []string
+	add := func(e ast.Expr) {
+		if lit, ok := e.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			s, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			inputs = append(inputs, s)
+		}
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			if id, ok := n.Lhs[0].(*ast.Ident); ok && id.Name == "input" && len(n.Rhs) == 1 {
+				add(n.Rhs[0])
+			}
+		case *ast.CompositeLit:
+			// tables of struct{input string; ...}
+			arr, ok := n.Type.(*ast.ArrayType)
+			if !ok {
+				return true
+			}
+			st, ok := arr.Elt.(*ast.StructType)
+			if !ok || len(st.Fields.List) == 0 || st.Fields.List[0].Names[0].Name != "input" {
+				return true
+			}
+			for _, elt := range n.Elts {
+				if c, ok := elt.(*ast.CompositeLit); ok && len(c.Elts) > 0 {
+					add(c.Elts[0])
+				}
+			}
+		}
+		return true
+	})
+	return inputs
+}
+
+// tmMatcher applies the subset of TextMate the generated grammar uses:
+// match rules and begin/end rules with nested match rules, matched a
+// line at a time.
+type tmMatcher struct {
+	rules []*tmCompiled
+}
+
+type tmCompiled struct {
+	name              string
+	match, begin, end *regexp.Regexp
+	inner             []*tmCompiled
+}
+
+func compileGrammar(t *testing.T, patterns []tmRule, repo map[string]tmRule) *tmMatcher {
+	t.Helper()
+	var compile func(rules []tmRule) []*tmCompiled
+	re := func(s string) *regexp.Regexp {
+		if s == "" {
+			return nil
+		}
+		r, err := regexp.Compile(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	compile = func(rules []tmRule) []*tmCompiled {
+		var out []*tmCompiled
+		for _, r := range rules {
+			if r.Include != "" {
+				inc, ok := repo[strings.TrimPrefix(r.Include, "#")]
+				if !ok {
+					t.Fatalf("unknown include %s", r.Include)
+				}
+				if inc.Match == "" && inc.Begin == "" {
+					out = append(out, compile(inc.Patterns)...)
+				} else {
+					out = append(out, compile([]tmRule{inc})...)
+				}
+				continue
+			}
+			c := &tmCompiled{name: r.Name, match: re(r.Match), begin: re(r.Begin), end: re(r.End)}
+			if c.begin != nil {
+				c.inner = compile(r.Patterns)
+			}
+			out = append(out, c)
+		}
+		return out
+	}
+	return &tmMatcher{rules: compile(patterns)}
+}
+
+// firstRule returns the rule of rules matching first in s and the match;
+// the earlier rule wins a tie.
+func firstRule(rules []*tmCompiled, s string) (*tmCompiled, []int) {
+	var best *tmCompiled
+	var loc []int
+	for _, r := range rules {
+		re := r.match
+		if re == nil {
+			re = r.begin
+		}
+		if m := re.FindStringIndex(s); m != nil && (loc == nil || m[0] < loc[0]) {
+			best, loc = r, m
+		}
+	}
+	return best, loc
+}
+
+// highlight returns the innermost scope of each byte of src.
+func (m *tmMatcher) highlight(src string) []string {
+	scopes := make([]string, len(src))
+	fill := func(from, to int, scope string) {
+		for i := from; i < to; i++ {
+			scopes[i] = scope
+		}
+	}
+	var open *tmCompiled // the begin/end rule spanning lines
+	for start := 0; start < len(src); {
+		end := strings.IndexByte(src[start:], '\n')
+		if end < 0 {
+			end = len(src)
+		} else {
+			end += start
+		}
+		line := src[start:end]
+		for p := 0; p < len(line); {
+			if open != nil {
+				endLoc := open.end.FindStringIndex(line[p:])
+				r, loc := firstRule(open.inner, line[p:])
+				if r != nil && (endLoc == nil || loc[0] < endLoc[0]) {
+					fill(start+p, start+p+loc[0], open.name)
+					fill(start+p+loc[0], start+p+loc[1], r.name)
+					p += max(loc[1], 1)
+					continue
+				}
+				if endLoc == nil {
+					fill(start+p, end, open.name)
+					break
+				}
+				fill(start+p, start+p+endLoc[1], open.name)
+				p += endLoc[1]
+				open = nil
+				continue
+			}
+			r, loc := firstRule(m.rules, line[p:])
+			if r == nil {
+				break
+			}
+			fill(start+p+loc[0], start+p+loc[1], r.name)
+			if r.begin != nil {
+				open = r
+			}
+			p += max(loc[1], 1)
+		}
+		start = end + 1
+	}
+	return scopes
+}
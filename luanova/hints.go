@@ -0,0 +1,352 @@
+package luanova
+
+import "strings"
+
+// Inlay hint kinds of the protocol.
+const (
+	inlayType      = 1
+	inlayParameter = 2
+)
+
+type lspMarkup struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type lspInlayHint struct {
+	Position     lspPosition `json:"position"`
+	Label        string      `json:"label"`
+	Kind         int         `json:"kind"`
+	PaddingLeft  bool        `json:"paddingLeft,omitempty"`
+	PaddingRight bool        `json:"paddingRight,omitempty"`
+}
+
+type lspSignature struct {
+	Label         string         `json:"label"`
+	Documentation *lspMarkup     `json:"documentation,omitempty"`
+	Parameters    []lspParameter `json:"parameters"`
+}
+
+type lspParameter struct {
+	Label [2]int `json:"label"`
+}
+
+// markdown renders code as a luanova code block followed by doc.
+func markdown(code, doc string) *lspMarkup {
+	s := "```luanova\n" + code + "\n```"
+	if doc != "" {
+		s += "\n\n" + doc
+	}
+	return &lspMarkup{"markdown", s}
+}
+
+// nodesAt returns the nodes of the chunk containing offset, outermost
+// first.
+func (doc *document) nodesAt(offset int) []Node {
+	var nodes []Node
+	Inspect(doc.chunk.Block, func(n Node) bool {
+		if n.Pos().Offset > offset || n.End().Offset < offset {
+			return false
+		}
+		nodes = append(nodes, n)
+		return true
+	})
+	return nodes
+}
+
+func (doc *document) hover(p lspPosition) any {
+	doc.analyze()
+	if doc.chunk == nil {
+		return nil
+	}
+	inf := doc.types
+	id := doc.identAt(p)
+	if id == nil {
+		// a type name
+		nodes := doc.nodesAt(doc.offset(p))
+		for i := len(nodes) - 1; i >= 0; i-- {
+			if t, ok := nodes[i].(*NamedType); ok && inf.aliases[t.Name] != nil {
+				s := inf.aliases[t.Name]
+				return map[string]any{
+					"contents": markdown("type "+s.Name.Name+" = "+typeString(s.Type), inf.docComment(s.Pos().Line)),
+					"range":    lspRange{doc.position(t.Pos().Offset), doc.position(t.End().Offset)},
+				}
+			}
+		}
+		return nil
+	}
+	var code, text string
+	if v := doc.res.VariableOf(id); v != nil {
+		code, text = inf.describe(v), inf.docs[v]
+	} else {
+		code, text = inf.describeName(doc.nodesAt(id.Pos().Offset), id)
+	}
+	if code == "" {
+		return nil
+	}
+	return map[string]any{"contents": markdown(code, text), "range": doc.identRange(id)}
+}
+
+// describe renders the declaration of v.
+func (inf *inference) describe(v *Variable) string {
+	if d := inf.funcs[v]; d != nil {
+		sig, _ := d.signature(inf, d.method)
+		if v.Kind == VarFunction || v.Kind == VarLocal {
+			return "local function " + sig
+		}
+		return "function " + sig
+	}
+	var s string
+	switch v.Kind {
+	case VarParam:
+		s = "(parameter) "
+	case VarSelf:
+		s = "(self) "
+	case VarLoop:
+		s = "(loop variable) "
+	case VarImport:
+		s = "(import) "
+	default:
+		s = "local "
+	}
+	s += v.Name.Name
+	if t := inf.varType(v); t != nil {
+		s += ": " + typeString(t)
+	}
+	return s
+}
+
+// describeName renders what id names when it is not a local: a global,
+// a function declared with a function statement or a field; nodes are
+// the nodes containing id.
+func (inf *inference) describeName(nodes []Node, id *Ident) (string, string) {
+	var parent Node
+	if len(nodes) > 1 {
+		parent = nodes[len(nodes)-2]
+	}
+	switch n := parent.(type) {
+	case *TypeStmt:
+		if n.Name == id {
+			return "type " + id.Name + " = " + typeString(n.Type), inf.docComment(n.Pos().Line)
+		}
+	case *FunctionStmt:
+		if n.Method == id {
+			path := exprPath(n.Name) + "." + id.Name
+			if d := inf.paths[path]; d != nil {
+				sig, _ := d.signature(inf, true)
+				return "function " + sig, d.doc
+			}
+		}
+	case *FieldExpr:
+		if n.Name == id {
+			if d := inf.paths[exprPath(n)]; d != nil {
+				sig, _ := d.signature(inf, d.method)
+				return "function " + sig, d.doc
+			}
+			s := "(field) " + id.Name
+			if t := inf.exprType(n); t != nil {
+				s += ": " + typeString(t)
+			}
+			return s, ""
+		}
+		return "", ""
+	case *MethodCallExpr:
+		if n.Name == id {
+			if d := inf.paths[exprPath(n.Recv)+"."+id.Name]; d != nil {
+				sig, _ := d.signature(inf, true)
+				return "method " + sig, d.doc
+			}
+			return "(method) " + id.Name, ""
+		}
+	case *TableField:
+		if n.Key == id && n.Named {
+			s := "(field) " + id.Name
+			if t := inf.exprType(n.Value); t != nil {
+				s += ": " + typeString(t)
+			}
+			return s, ""
+		}
+	}
+	if d := inf.paths[id.Name]; d != nil {
+		sig, _ := d.signature(inf, d.method)
+		return "function " + sig, d.doc
+	}
+	for _, g := range inf.res.Globals {
+		if g.Name == id {
+			if _, ok := standardGlobals()[id.Name]; ok {
+				return "(global) " + id.Name, "Standard library."
+			}
+			return "(global) " + id.Name, ""
+		}
+	}
+	return "", ""
+}
+
+// callAt finds the call whose argument list holds offset with the
+// lexer, so that it works while the arguments are being typed: it
+// returns the called name as a path, whether it is called with a
+// colon, the offset of its last name and the index of the argument.
+func callAt(src string, offset int) (path string, colon bool, nameAt, arg int, ok bool) {
+	type frame struct {
+		open   int // index of the token opening it
+		commas int
+	}
+	var toks []Token
+	var frames []frame
+	l := NewLexer(src)
+	for tok := l.NextToken(); tok.Type != EOF && tok.Pos.Offset < offset; tok = l.NextToken() {
+		if tok.Type == Comment || tok.Type == CommentBlock {
+			continue
+		}
+		toks = append(toks, tok)
+		switch tok.Type {
+		case LParen, LBrace, LBrack:
+			frames = append(frames, frame{open: len(toks) - 1})
+		case RParen, RBrace, RBrack:
+			if len(frames) > 0 {
+				frames = frames[:len(frames)-1]
+			}
+		case Comma:
+			if len(frames) > 0 {
+				frames[len(frames)-1].commas++
+			}
+		}
+	}
+	if len(frames) == 0 {
+		return
+	}
+	f := frames[len(frames)-1]
+	i := f.open
+	if toks[i].Type != LParen || i == 0 || !isIdentToken(toks[i-1]) {
+		return
+	}
+	nameAt = toks[i-1].Pos.Offset
+	parts := []string{toks[i-1].Literal}
+	j := i - 1
+	for j >= 2 && (toks[j-1].Type == Dot || toks[j-1].Type == Colom) && isIdentToken(toks[j-2]) {
+		if toks[j-1].Type == Colom {
+			if j != i-1 {
+				break // a:b.c is not a call path
+			}
+			colon = true
+		}
+		parts = append([]string{toks[j-2].Literal}, parts...)
+		j -= 2
+	}
+	if j > 0 && toks[j-1].Type == Function {
+		return // a declaration
+	}
+	return strings.Join(parts, "."), colon, nameAt, f.commas, true
+}
+
+func isIdentToken(t Token) bool {
+	return t.Type == Literal && t.Literal != "" && isLetter(t.Literal[0])
+}
+
+func (doc *document) signatureHelp(p lspPosition) any {
+	doc.analyze()
+	offset := doc.offset(p)
+	path, colon, nameAt, arg, ok := callAt(doc.text, offset)
+	inf := doc.types
+	if !ok || inf == nil {
+		return nil
+	}
+	var d *funcDecl
+	if doc.chunk != nil && !strings.Contains(path, ".") {
+		if id := doc.identAt(doc.position(nameAt)); id != nil {
+			if v := doc.res.VariableOf(id); v != nil {
+				d = inf.funcs[v]
+			}
+		}
+	}
+	if doc.chunk == nil && !strings.Contains(path, ".") {
+		// the text does not parse: the last function of that name
+		for _, v := range inf.res.Vars {
+			if v.Name.Name == path && inf.funcs[v] != nil {
+				d = inf.funcs[v]
+			}
+		}
+	}
+	if d == nil {
+		d = inf.paths[path]
+	}
+	if d == nil {
+		return nil
+	}
+	label, params := d.signature(inf, colon)
+	sig := lspSignature{Label: label, Parameters: []lspParameter{}}
+	for _, r := range params {
+		sig.Parameters = append(sig.Parameters, lspParameter{r})
+	}
+	if d.doc != "" {
+		sig.Documentation = &lspMarkup{"markdown", d.doc}
+	}
+	if d.fn.IsVararg && arg >= len(params) {
+		arg = len(params) - 1
+	}
+	return map[string]any{"signatures": []lspSignature{sig}, "activeSignature": 0, "activeParameter": arg}
+}
+
+// inlayHints returns the inferred types of locals declared without
+// annotation and the parameter names of the arguments of calls in r.
+func (doc *document) inlayHints(r lspRange) []lspInlayHint {
+	hints := []lspInlayHint{}
+	doc.analyze()
+	if doc.chunk == nil {
+		return hints
+	}
+	inf := doc.types
+	from, to := doc.offset(r.Start), doc.offset(r.End)
+	add := func(offset int, h lspInlayHint) {
+		if from <= offset && offset <= to {
+			h.Position = doc.position(offset)
+			hints = append(hints, h)
+		}
+	}
+	params := func(d *funcDecl, colon bool, args []Expr) {
+		names := d.fn.Params
+		if colon && len(names) > 0 {
+			names = names[1:]
+		}
+		for i, a := range args {
+			if i >= len(names) {
+				break
+			}
+			name := names[i].Name.Name
+			if id, ok := a.(*Ident); ok && id.Name == name {
+				continue
+			}
+			add(a.Pos().Offset, lspInlayHint{Label: name + ":", Kind: inlayParameter, PaddingRight: true})
+		}
+	}
+	Inspect(doc.chunk.Block, func(n Node) bool {
+		switch n := n.(type) {
+		case *LocalStmt:
+			for i, b := range n.Names {
+				if b.Type != nil || i < len(n.Values) && isFunction(n.Values[i]) {
+					continue
+				}
+				if v := doc.res.VariableOf(b.Name); v != nil {
+					if t := inf.varType(v); t != nil {
+						add(b.Name.End().Offset, lspInlayHint{Label: ": " + typeString(t), Kind: inlayType})
+					}
+				}
+			}
+		case *CallExpr:
+			if d := inf.callee(n.Fn); d != nil {
+				params(d, false, n.Args)
+			}
+		case *MethodCallExpr:
+			if d := inf.callee(&FieldExpr{X: n.Recv, Name: n.Name}); d != nil {
+				params(d, true, n.Args)
+			}
+		}
+		return true
+	})
+	return hints
+}
+
+func isFunction(e Expr) bool {
+	_, ok := e.(*FunctionExpr)
+	return ok
+}
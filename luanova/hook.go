@@ -0,0 +1,119 @@
+package luanova
+
+// HookMask selects the events a hook receives.
+type HookMask int
+
+const (
+	HookCall   HookMask = 1 << iota // a function is called
+	HookReturn                      // a function returns
+	HookLine                        // a new line starts, or a loop jumps back
+	HookCount                       // a number of statements ran
+)
+
+// DefaultHookCount is the number of statements between count events
+// until SetHookCount changes it.
+const DefaultHookCount = 1000
+
+// name returns the name of an event as debug.sethook hooks see it.
+func (m HookMask) name() string {
+	switch m {
+	case HookCall:
+		return "call"
+	case HookReturn:
+		return "return"
+	case HookLine:
+		return "line"
+	}
+	return "count"
+}
+
+// HookEvent describes an event passed to a hook. Pos is the position of
+// the statement for line and count events, of the function for call
+// events and of the last statement run for return events; it is zero
+// in Go functions.
+type HookEvent struct {
+	Kind   HookMask // a single event
+	Source string   // chunk name, empty in Go functions
+	Pos    Position
+	Name   string // function called or returning, empty if unknown
+}
+
+// Hook is called on the events it was installed for. No events are
+// reported while a hook runs. Errors a hook raises with RaiseError or
+// Raise propagate to the running script.
+type Hook func(s *State, ev HookEvent)
+
+// hooks is the hook installed in a State. The interpreter only checks
+// that it is nil when no hook is installed.
+type hooks struct {
+	fn      Hook
+	mask    HookMask
+	left    int // statements until the next count event
+	running bool
+}
+
+// SetHook makes fn receive the events in mask, replacing the hook set
+// before. A nil fn or an empty mask removes the hook.
+func (s *State) SetHook(mask HookMask, fn Hook) {
+	running := s.hooks != nil && s.hooks.running
+	if fn == nil || mask == 0 {
+		s.hooks = nil
+		return
+	}
+	s.hooks = &hooks{fn: fn, mask: mask, left: s.hookCount(), running: running}
+}
+
+// SetHookCount sets the number of statements between count events.
+func (s *State) SetHookCount(n int) {
+	s.countEvery = max(n, 1)
+	if s.hooks != nil {
+		s.hooks.left = s.countEvery
+	}
+}
+
+func (s *State) hookCount() int {
+	if s.countEvery == 0 {
+		return DefaultHookCount
+	}
+	return s.countEvery
+}
+
+func (h *hooks) fire(s *State, ev HookEvent) {
+	h.running = true
+	defer func() { h.running = false }()
+	h.fn(s, ev)
+}
+
+// statement reports the line and count events of the statement fr
+// starts; prev is the statement fr ran before.
+func (h *hooks) statement(fr *frame, prev *stmtInfo) {
+	if h.running {
+		return
+	}
+	at := fr.at
+	if h.mask&HookCount != 0 {
+		if h.left--; h.left <= 0 {
+			h.left = fr.s.hookCount()
+			h.fire(fr.s, HookEvent{Kind: HookCount, Source: fr.fn.proto.source, Pos: at.pos})
+		}
+	}
+	if h.mask&HookLine != 0 && at.newLine(prev) && !h.running {
+		h.fire(fr.s, HookEvent{Kind: HookLine, Source: fr.fn.proto.source, Pos: at.pos})
+	}
+}
+
+// call reports a call or return event of fn; fr is nil for Go
+// functions.
+func (h *hooks) call(s *State, kind HookMask, fn *Closure, fr *frame) {
+	if h.running || h.mask&kind == 0 {
+		return
+	}
+	ev := HookEvent{Kind: kind, Name: fn.Name()}
+	if p := fn.proto; p != nil {
+		ev.Source, ev.Pos = p.source, p.pos
+		if kind == HookReturn && fr.at != nil {
+			ev.Pos = fr.at.pos
+		}
+	}
+	h.fire(s, ev)
+}
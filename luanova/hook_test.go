@@ -0,0 +1,149 @@
+package luanova
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSetHook(t *testing.T) {
+	src := `local function add(a, b)
+	return a + b
+end
+local x = add(1, 2)
+for i = 1, 2 do x = x + i end
+`
+	s := NewState()
+	fn, err := s.Load(src, "hook.lunv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	s.SetHook(HookCall|HookReturn|HookLine, func(s *State, ev HookEvent) {
+		e := fmt.Sprintf("%s %d:%d", ev.Kind.name(), ev.Pos.Line, ev.Pos.Column)
+		if ev.Name != "" {
+			e += " " + ev.Name
+		}
+		events = append(events, e)
+	})
+	if _, err := s.PCall(fn); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"call 1:1",
+		"line 1:1",
+		"line 4:1",
+		"call 1:1 add",
+		"line 2:2",
+		"return 2:2 add",
+		"line 5:1",
+		"line 5:17", // the second iteration jumps back
+		"return 5:17",
+	}
+	if got := strings.Join(events, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("events:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+
+	t.Run("count", func(t *testing.T) {
+		s := NewState()
+		count := 0
+		s.SetHookCount(10)
+		s.SetHook(HookCount, func(*State, HookEvent) { count++ })
+		if err := s.DoString("local n = 0 for i = 1, 100 do n = n + i end"); err != nil {
+			t.Fatal(err)
+		}
+		// 102 statements: the local, the loop and 100 iterations
+		if count != 10 {
+			t.Errorf("%d count events, want 10", count)
+		}
+	})
+
+	t.Run("no events in hooks", func(t *testing.T) {
+		s := NewState()
+		calls := 0
+		s.SetHook(HookCall, func(s *State, ev HookEvent) {
+			calls++
+			if ev.Name == "f" {
+				s.Call("g")
+			}
+		})
+		if err := s.DoString("function g() end function f() end f()"); err != nil {
+			t.Fatal(err)
+		}
+		if calls != 2 { // the main chunk and f
+			t.Errorf("%d call events, want 2", calls)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		s := NewState()
+		s.SetHook(HookLine, func(s *State, ev HookEvent) {
+			if ev.Pos.Line == 2 {
+				s.RaiseError("stopped at line %d", ev.Pos.Line)
+			}
+		})
+		err := s.DoString("local x = 1\nx = 2")
+		if err == nil || !strings.Contains(err.Error(), "stopped at line 2") {
+			t.Errorf("err = %v", err)
+		}
+		s.SetHook(0, nil)
+		if err := s.DoString("local x = 1\nx = 2"); err != nil {
+			t.Errorf("after removing the hook: %v", err)
+		}
+	})
+}
+
+func TestDebugLibrary(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"getlocal", `local function f(a, ...)
+	local x = a * 2
+	do local y = 1 end
+	print(debug.getlocal(1, 1))
+	print(debug.getlocal(1, 2))
+	print(debug.getlocal(1, 3))
+	print(debug.getlocal(1, -2))
+	print(debug.getlocal(f, 1), debug.getlocal(f, 2))
+end
+f(21, "p", "q")`, "a\t21\nx\t42\nnil\n(vararg)\tq\na\tnil"},
+		{"getlocal of the caller", `local function where() return debug.getlocal(2, 2) end
+local outer = "here"
+print(where())`, "outer\there"},
+		{"setlocal", `local a, b = 1, 2
+print(debug.setlocal(1, 2, "two"), b, debug.setlocal(1, 9, 0))`, "b\ttwo\tnil"},
+		{"getupvalue", `local n = 3
+local function f() return n end
+print(debug.getupvalue(f, 1))
+print(debug.getupvalue(f, 2), select("#", debug.getupvalue(print, 1)))`, "n\t3\nnil\t0"},
+		{"getinfo", `local function f()
+	local info = debug.getinfo(1)
+	return info.name, info.what, info.currentline, info.linedefined, info.lastlinedefined, info.func == f
+end
+print(f())
+local main = debug.getinfo(1, "Sl")
+print(main.what, main.source, main.currentline, main.name)
+print(debug.getinfo(print).what, debug.getinfo(f, "u").nparams, debug.getinfo(100))`,
+			"f\tLua\t2\t1\t4\ttrue\nmain\ttest\t6\tnil\nGo\t0\tnil"},
+		{"sethook", `local events = {}
+local function f() end
+debug.sethook(function(ev, line) events[#events + 1] = ev .. (line or "") end, "crl")
+f()
+debug.sethook()
+print(table.concat(events, " "))`, "return line4 call return line5 call"},
+		{"count hook", `local n = 0
+debug.sethook(function() n = n + 1 end, "", 2)
+for i = 1, 10 do local _ = i end
+debug.sethook()
+print(n)`, "6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := strings.TrimSpace(runScript(t, tt.input)); got != tt.expected {
+				t.Errorf("got %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
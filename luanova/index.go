@@ -0,0 +1,670 @@
+package luanova
+
+import (
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Symbol kinds of the protocol.
+const (
+	symbolFile     = 1
+	symbolClass    = 5
+	symbolMethod   = 6
+	symbolFunction = 12
+	symbolVariable = 13
+)
+
+// maxWorkspaceSymbols bounds the answer to a workspace symbol query.
+const maxWorkspaceSymbols = 100
+
+type lspSymbolInformation struct {
+	Name          string      `json:"name"`
+	Kind          int         `json:"kind"`
+	Location      lspLocation `json:"location"`
+	ContainerName string      `json:"containerName,omitempty"`
+}
+
+type lspCallHierarchyItem struct {
+	Name           string   `json:"name"`
+	Kind           int      `json:"kind"`
+	Detail         string   `json:"detail,omitempty"`
+	URI            string   `json:"uri"`
+	Range          lspRange `json:"range"`
+	SelectionRange lspRange `json:"selectionRange"`
+}
+
+type lspIncomingCall struct {
+	From       lspCallHierarchyItem `json:"from"`
+	FromRanges []lspRange           `json:"fromRanges"`
+}
+
+type lspOutgoingCall struct {
+	To         lspCallHierarchyItem `json:"to"`
+	FromRanges []lspRange           `json:"fromRanges"`
+}
+
+// indexSymbol is a top-level declaration of a file.
+type indexSymbol struct {
+	file      *fileIndex
+	path      string // the name, prefixed by the table for fields: M.f
+	name      string
+	container string
+	kind      int
+	public    bool // seen by other files: exported, global or a field
+	exported  bool
+	detail    string // the signature of a function, the type of a variable
+	rng       lspRange
+	selection lspRange
+	calls     []indexCall // made by the function it declares
+}
+
+// indexCall is a call of the function named path.
+type indexCall struct {
+	path string
+	rng  lspRange // of the called name
+}
+
+// fileIndex is what the index keeps of a file.
+type fileIndex struct {
+	uri     string
+	symbols []*indexSymbol
+	calls   []indexCall // made by the main chunk
+	returns string      // the name of the local table the chunk returns
+	open    bool        // indexed from an open document
+}
+
+// workspaceIndex holds the top-level declarations and the calls of the
+// .lunv files of the workspace. Files are read and parsed by a
+// background goroutine; requests read the index under its lock and see
+// the previous state of a file until it has been indexed again. Open
+// documents are indexed from their text rather than from the disk.
+type workspaceIndex struct {
+	mu      sync.RWMutex
+	files   map[string]*fileIndex // by URI
+	pending []string              // URIs to read from the disk
+	queued  map[string]bool
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+func newWorkspaceIndex() *workspaceIndex {
+	return &workspaceIndex{
+		files:  map[string]*fileIndex{},
+		queued: map[string]bool{},
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+// run indexes the queued files until stop is called.
+func (x *workspaceIndex) run() {
+	for {
+		select {
+		case <-x.done:
+			return
+		case <-x.wake:
+		}
+		for {
+			uri, ok := x.next()
+			if !ok {
+				break
+			}
+			x.load(uri)
+		}
+	}
+}
+
+func (x *workspaceIndex) stop() {
+	close(x.done)
+}
+
+func (x *workspaceIndex) stopped() bool {
+	select {
+	case <-x.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// queue schedules the files at uris to be read from the disk again.
+func (x *workspaceIndex) queue(uris ...string) {
+	x.mu.Lock()
+	for _, uri := range uris {
+		uri = canonicalURI(uri)
+		if !x.queued[uri] {
+			x.queued[uri] = true
+			x.pending = append(x.pending, uri)
+		}
+	}
+	x.mu.Unlock()
+	select {
+	case x.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (x *workspaceIndex) next() (string, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if len(x.pending) == 0 || x.stopped() {
+		return "", false
+	}
+	uri := x.pending[0]
+	x.pending = x.pending[1:]
+	delete(x.queued, uri)
+	return uri, true
+}
+
+// scan queues the .lunv files under the directories of roots, skipping
+// hidden directories.
+func (x *workspaceIndex) scan(roots []string) {
+	for _, root := range roots {
+		dir, ok := uriToPath(root)
+		if !ok {
+			continue
+		}
+		var uris []string
+		filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			switch {
+			case err != nil || x.stopped():
+				return filepath.SkipDir
+			case d.IsDir() && p != dir && strings.HasPrefix(d.Name(), "."):
+				return filepath.SkipDir
+			case !d.IsDir() && strings.HasSuffix(p, ".lunv"):
+				uris = append(uris, pathToURI(p))
+			}
+			return nil
+		})
+		x.queue(uris...)
+	}
+}
+
+// load indexes the file at uri from the disk, or drops it when it is
+// gone. Files that do not parse keep their previous entry.
+func (x *workspaceIndex) load(uri string) {
+	p, ok := uriToPath(uri)
+	if !ok {
+		return
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		x.mu.Lock()
+		if f := x.files[uri]; f != nil && !f.open {
+			delete(x.files, uri)
+		}
+		x.mu.Unlock()
+		return
+	}
+	f := indexDocument(newDocument(uri, 0, string(data)))
+	if f == nil {
+		return
+	}
+	x.mu.Lock()
+	if old := x.files[uri]; old == nil || !old.open {
+		x.files[uri] = f
+	}
+	x.mu.Unlock()
+}
+
+// update indexes an open document.
+func (x *workspaceIndex) update(doc *document) {
+	f := indexDocument(doc)
+	if f == nil {
+		return
+	}
+	f.uri, f.open = canonicalURI(doc.uri), true
+	x.mu.Lock()
+	x.files[f.uri] = f
+	x.mu.Unlock()
+}
+
+// closed reads the file of a document that is no longer open from the
+// disk again.
+func (x *workspaceIndex) closed(uri string) {
+	uri = canonicalURI(uri)
+	x.mu.Lock()
+	if f := x.files[uri]; f != nil {
+		f.open = false
+	}
+	x.mu.Unlock()
+	x.queue(uri)
+}
+
+// indexDocument returns the top-level declarations and the calls of doc,
+// or nil if it does not parse.
+func indexDocument(doc *document) *fileIndex {
+	doc.analyze()
+	if doc.chunk == nil {
+		return nil
+	}
+	f := &fileIndex{uri: canonicalURI(doc.uri)}
+	// names bound by import, by the name they are exported under
+	imported := map[string]string{}
+	for _, s := range doc.chunk.Block.Stmts {
+		if s, ok := s.(*ImportStmt); ok {
+			if s.Namespace != nil {
+				imported[s.Namespace.Name] = ""
+			}
+			for _, spec := range s.Names {
+				imported[spec.LocalName().Name] = spec.Name.Name
+			}
+		}
+	}
+	calls := func(n Node, self string) []indexCall {
+		var cs []indexCall
+		add := func(fn Expr, name *Ident) {
+			p := exprPath(fn)
+			if p == "" {
+				return
+			}
+			first, rest, dotted := strings.Cut(p, ".")
+			if exported, ok := imported[first]; ok {
+				switch {
+				case exported != "":
+					p = exported
+					if dotted {
+						p += "." + rest
+					}
+				case dotted:
+					p = rest
+				}
+			} else if first == "self" && self != "" && dotted {
+				p = self + "." + rest
+			}
+			cs = append(cs, indexCall{p, doc.identRange(name)})
+		}
+		Inspect(n, func(n Node) bool {
+			switch n := n.(type) {
+			case *CallExpr:
+				switch fn := n.Fn.(type) {
+				case *Ident:
+					add(fn, fn)
+				case *FieldExpr:
+					add(fn, fn.Name)
+				}
+			case *MethodCallExpr:
+				add(&FieldExpr{X: n.Recv, Name: n.Name}, n.Name)
+			}
+			return true
+		})
+		return cs
+	}
+	declare := func(stmt Stmt, name *Ident, path string, kind int, public bool, body Node) {
+		sym := &indexSymbol{
+			file:      f,
+			path:      path,
+			name:      name.Name,
+			kind:      kind,
+			public:    public,
+			rng:       doc.span(stmt.Pos().Offset, stmt.End().Offset),
+			selection: doc.identRange(name),
+		}
+		if i := strings.LastIndexByte(path, '.'); i >= 0 {
+			sym.container = path[:i]
+		}
+		if body != nil {
+			sym.calls = calls(body, sym.container)
+		}
+		if fn, ok := body.(*FunctionExpr); ok {
+			sym.detail, _ = (&funcDecl{name: path, fn: fn, method: kind == symbolMethod}).signature(doc.types, kind == symbolMethod)
+		} else if v := doc.res.VariableOf(name); v != nil {
+			sym.detail = typeString(doc.types.varType(v))
+		}
+		f.symbols = append(f.symbols, sym)
+	}
+	for _, stmt := range doc.chunk.Block.Stmts {
+		s, exported := stmt, false
+		if e, ok := stmt.(*ExportStmt); ok && e.Decl != nil {
+			s, exported = e.Decl, true
+		}
+		first := len(f.symbols)
+		switch s := s.(type) {
+		case *LocalFunctionStmt:
+			declare(stmt, s.Name, s.Name.Name, symbolFunction, exported, s.Func)
+		case *FunctionStmt:
+			name, kind := exprPath(s.Name), symbolFunction
+			if name == "" {
+				break
+			}
+			id, _ := s.Name.(*Ident)
+			if fe, ok := s.Name.(*FieldExpr); ok {
+				id = fe.Name
+			}
+			if s.Method != nil {
+				name, id, kind = name+"."+s.Method.Name, s.Method, symbolMethod
+			}
+			global := doc.res.VariableOf(id) == nil
+			declare(stmt, id, name, kind, exported || global || strings.Contains(name, "."), s.Func)
+		case *LocalStmt:
+			for i, b := range s.Names {
+				var value Expr
+				if i < len(s.Values) {
+					value = s.Values[i]
+				}
+				if fn, ok := value.(*FunctionExpr); ok {
+					declare(stmt, b.Name, b.Name.Name, symbolFunction, exported, fn)
+				} else {
+					declare(stmt, b.Name, b.Name.Name, symbolVariable, exported, nil)
+					if value != nil {
+						f.calls = append(f.calls, calls(value, "")...)
+					}
+				}
+			}
+		case *TypeStmt:
+			declare(stmt, s.Name, s.Name.Name, symbolClass, exported, nil)
+			f.symbols[first].detail = typeString(s.Type)
+		case *ReturnStmt:
+			if len(s.Values) == 1 {
+				if id, ok := s.Values[0].(*Ident); ok && doc.res.VariableOf(id) != nil {
+					f.returns = id.Name
+				}
+			}
+			f.calls = append(f.calls, calls(stmt, "")...)
+		default:
+			f.calls = append(f.calls, calls(stmt, "")...)
+		}
+		for _, sym := range f.symbols[first:] {
+			sym.exported = exported
+		}
+	}
+	return f
+}
+
+// symbols answers a workspace symbol query: the declarations whose name
+// holds the letters of query in order, ignoring case, best matches
+// first.
+func (x *workspaceIndex) symbols(query string) []lspSymbolInformation {
+	type match struct {
+		sym   *indexSymbol
+		score int
+	}
+	var matches []match
+	x.mu.RLock()
+	for _, f := range x.files {
+		for _, sym := range f.symbols {
+			if score, ok := matchName(query, sym.name); ok {
+				matches = append(matches, match{sym, score})
+			}
+		}
+	}
+	x.mu.RUnlock()
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		switch {
+		case a.score != b.score:
+			return a.score < b.score
+		case a.sym.name != b.sym.name:
+			return a.sym.name < b.sym.name
+		case a.sym.file.uri != b.sym.file.uri:
+			return a.sym.file.uri < b.sym.file.uri
+		}
+		return a.sym.selection.Start.Line < b.sym.selection.Start.Line
+	})
+	infos := []lspSymbolInformation{}
+	for _, m := range matches[:min(len(matches), maxWorkspaceSymbols)] {
+		infos = append(infos, lspSymbolInformation{
+			Name:          m.sym.name,
+			Kind:          m.sym.kind,
+			Location:      lspLocation{m.sym.file.uri, m.sym.rng},
+			ContainerName: m.sym.container,
+		})
+	}
+	return infos
+}
+
+// matchName reports whether the letters of query appear in name in
+// order, ignoring case, and scores the match: an exact match scores 0,
+// a prefix 1, a substring 2 and other matches 3.
+func matchName(query, name string) (int, bool) {
+	q, n := strings.ToLower(query), strings.ToLower(name)
+	switch {
+	case q == n:
+		return 0, true
+	case strings.HasPrefix(n, q):
+		return 1, true
+	case strings.Contains(n, q):
+		return 2, true
+	}
+	i := 0
+	for j := 0; i < len(q) && j < len(n); j++ {
+		if q[i] == n[j] {
+			i++
+		}
+	}
+	return 3, i == len(q)
+}
+
+// callees returns the declarations a call of path from the file uri may
+// reach: those of the file itself if any, otherwise the public ones of
+// the workspace with that path, or with the same field name when the
+// tables are named differently in each file. The caller holds the lock.
+func (x *workspaceIndex) callees(uri, path string) []*indexSymbol {
+	var local, public []*indexSymbol
+	for _, f := range x.files {
+		for _, sym := range f.symbols {
+			switch {
+			case sym.kind != symbolFunction && sym.kind != symbolMethod:
+			case f.uri == uri && sym.path == path:
+				local = append(local, sym)
+			case f.uri != uri && sym.public && (sym.path == path ||
+				sym.container != "" && strings.Contains(path, ".") && sym.name == path[strings.LastIndexByte(path, '.')+1:]):
+				public = append(public, sym)
+			}
+		}
+	}
+	if len(local) > 0 {
+		return local
+	}
+	return public
+}
+
+func (sym *indexSymbol) item() lspCallHierarchyItem {
+	return lspCallHierarchyItem{
+		Name:           sym.name,
+		Kind:           sym.kind,
+		Detail:         sym.container,
+		URI:            sym.file.uri,
+		Range:          sym.rng,
+		SelectionRange: sym.selection,
+	}
+}
+
+// item stands for the main chunk of f as a caller.
+func (f *fileIndex) item() lspCallHierarchyItem {
+	return lspCallHierarchyItem{Name: path.Base(f.uri), Kind: symbolFile, URI: f.uri}
+}
+
+// symbolOf returns the declaration an item was made from. The caller
+// holds the lock.
+func (x *workspaceIndex) symbolOf(item lspCallHierarchyItem) *indexSymbol {
+	if f := x.files[canonicalURI(item.URI)]; f != nil {
+		for _, sym := range f.symbols {
+			if sym.selection.Start == item.SelectionRange.Start && sym.name == item.Name {
+				return sym
+			}
+		}
+	}
+	return nil
+}
+
+// prepareCallHierarchy returns the functions named at p, declared there
+// or called.
+func (x *workspaceIndex) prepareCallHierarchy(uri string, p lspPosition) []lspCallHierarchyItem {
+	uri = canonicalURI(uri)
+	items := []lspCallHierarchyItem{}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	f := x.files[uri]
+	if f == nil {
+		return items
+	}
+	calls := append([]indexCall(nil), f.calls...)
+	for _, sym := range f.symbols {
+		if contains(sym.selection, p) {
+			if sym.kind == symbolFunction || sym.kind == symbolMethod {
+				items = append(items, sym.item())
+			}
+			return items
+		}
+		calls = append(calls, sym.calls...)
+	}
+	for _, c := range calls {
+		if contains(c.rng, p) {
+			for _, sym := range x.callees(uri, c.path) {
+				items = append(items, sym.item())
+			}
+			break
+		}
+	}
+	return items
+}
+
+func contains(r lspRange, p lspPosition) bool {
+	return !before(p, r.Start) && !before(r.End, p)
+}
+
+func before(a, b lspPosition) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Character < b.Character
+}
+
+// incomingCalls returns the callers of item with the ranges of their
+// calls, the main chunks of files included.
+func (x *workspaceIndex) incomingCalls(item lspCallHierarchyItem) []lspIncomingCall {
+	in := []lspIncomingCall{}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	target := x.symbolOf(item)
+	if target == nil {
+		return in
+	}
+	from := func(caller lspCallHierarchyItem, uri string, calls []indexCall) {
+		var ranges []lspRange
+		for _, c := range calls {
+			for _, sym := range x.callees(uri, c.path) {
+				if sym == target {
+					ranges = append(ranges, c.rng)
+				}
+			}
+		}
+		if len(ranges) > 0 {
+			in = append(in, lspIncomingCall{caller, ranges})
+		}
+	}
+	for _, f := range x.files {
+		from(f.item(), f.uri, f.calls)
+		for _, sym := range f.symbols {
+			from(sym.item(), f.uri, sym.calls)
+		}
+	}
+	sort.Slice(in, func(i, j int) bool {
+		a, b := in[i].From, in[j].From
+		if a.URI != b.URI {
+			return a.URI < b.URI
+		}
+		return before(a.SelectionRange.Start, b.SelectionRange.Start)
+	})
+	return in
+}
+
+// outgoingCalls returns the functions item calls, in the order of their
+// first call, with the ranges of the calls.
+func (x *workspaceIndex) outgoingCalls(item lspCallHierarchyItem) []lspOutgoingCall {
+	out := []lspOutgoingCall{}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	caller := x.symbolOf(item)
+	if caller == nil {
+		return out
+	}
+	index := map[*indexSymbol]int{}
+	for _, c := range caller.calls {
+		for _, sym := range x.callees(caller.file.uri, c.path) {
+			i, ok := index[sym]
+			if !ok {
+				i = len(out)
+				index[sym] = i
+				out = append(out, lspOutgoingCall{To: sym.item()})
+			}
+			out[i].FromRanges = append(out[i].FromRanges, c.rng)
+		}
+	}
+	return out
+}
+
+// members returns the declarations a module gives to the file uri that
+// loads it by name: the exported ones for an import, the fields of the
+// table it returns for require. A relative name is a path from the
+// directory of uri, other names are found under any directory of the
+// workspace, the shortest path first.
+func (x *workspaceIndex) members(uri, name string, imported bool) []*indexSymbol {
+	var want []string
+	if strings.HasPrefix(name, "./") || strings.HasPrefix(name, "../") {
+		p, ok := uriToPath(uri)
+		if !ok {
+			return nil
+		}
+		want = append(want, pathToURI(filepath.Join(filepath.Dir(p), filepath.FromSlash(name))+".lunv"))
+	} else {
+		base := strings.ReplaceAll(name, ".", "/")
+		want = append(want, "/"+base+".lunv", "/"+base+"/init.lunv")
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	var mod *fileIndex
+	for _, f := range x.files {
+		for _, w := range want {
+			if f.uri != canonicalURI(uri) && strings.HasSuffix(f.uri, w) &&
+				(mod == nil || len(f.uri) < len(mod.uri) || len(f.uri) == len(mod.uri) && f.uri < mod.uri) {
+				mod = f
+			}
+		}
+	}
+	if mod == nil {
+		return nil
+	}
+	var syms []*indexSymbol
+	for _, sym := range mod.symbols {
+		if imported && sym.exported || !imported && mod.returns != "" && sym.container == mod.returns {
+			syms = append(syms, sym)
+		}
+	}
+	return syms
+}
+
+// uriToPath returns the path of a file URI.
+func uriToPath(uri string) (string, bool) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	p := u.Path
+	if len(p) >= 3 && p[0] == '/' && p[2] == ':' {
+		p = p[1:] // a Windows drive
+	}
+	return filepath.FromSlash(p), true
+}
+
+func pathToURI(p string) string {
+	p = filepath.ToSlash(p)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return (&url.URL{Scheme: "file", Path: p}).String()
+}
+
+// canonicalURI spells file URIs the same way whatever the client's
+// escaping, so that they can be compared.
+func canonicalURI(uri string) string {
+	if p, ok := uriToPath(uri); ok {
+		return pathToURI(p)
+	}
+	return uri
+}
@@ -0,0 +1,208 @@
+package luanova
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var indexFiles = map[string]string{
+	"geometry.lunv": `export type Point = {x: number, y: number}
+
+local function square(n: number): number
+	return n * n
+end
+
+export function distance(p: Point, q: Point): number
+	return math.sqrt(square(p.x - q.x) + square(p.y - q.y))
+end
+`,
+	"main.lunv": `import { distance } from "geometry"
+local shapes = require("shapes")
+
+local function report(a, b)
+	print(distance(a, b))
+end
+
+report({x = 0, y = 0}, {x = 3, y = 4})
+shapes.area(1)
+`,
+	"lib/shapes.lunv": `local M = {}
+
+function M.area(r)
+	return 3 * r * r
+end
+
+function M:describe()
+	return "area " .. self.area(2)
+end
+
+return M
+`,
+	".git/hidden.lunv": `local function hidden() end`,
+}
+
+// symbolNames renders symbols as "name kind container file:line".
+func symbolNames(syms []lspSymbolInformation) string {
+	var s []string
+	for _, sym := range syms {
+		s = append(s, fmt.Sprintf("%s %d %s %s:%d", sym.Name, sym.Kind, sym.ContainerName, path.Base(sym.Location.URI), sym.Location.Range.Start.Line+1))
+	}
+	return strings.Join(s, "|")
+}
+
+// waitSymbols queries the workspace symbols until there are n of them,
+// as the index is built in the background.
+func (c *lspClient) waitSymbols(query string, n int) []lspSymbolInformation {
+	c.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var syms []lspSymbolInformation
+		c.request("workspace/symbol", map[string]any{"query": query}, &syms)
+		if len(syms) == n {
+			return syms
+		}
+		if time.Now().After(deadline) {
+			c.t.Fatalf("workspace symbols %q = %s, want %d of them", query, symbolNames(syms), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWorkspaceIndex(t *testing.T) {
+	dir := t.TempDir()
+	for name, text := range indexFiles {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	uri := func(name string) string { return pathToURI(filepath.Join(dir, filepath.FromSlash(name))) }
+	c := startLSPWith(t, map[string]any{"capabilities": map[string]any{}, "rootUri": pathToURI(dir)})
+
+	c.waitSymbols("", 8)
+	queries := []struct {
+		query    string
+		expected string
+	}{
+		{"dist", "distance 12  geometry.lunv:7"},
+		{"ar", "area 12 M shapes.lunv:3|square 12  geometry.lunv:3"},
+		{"POINT", "Point 5  geometry.lunv:1"},
+		{"describe", "describe 6 M shapes.lunv:7"},
+		{"hidden", ""},
+	}
+	for _, tt := range queries {
+		var syms []lspSymbolInformation
+		c.request("workspace/symbol", map[string]any{"query": tt.query}, &syms)
+		if got := symbolNames(syms); got != tt.expected {
+			t.Errorf("workspace symbols %q = %s, want %s", tt.query, got, tt.expected)
+		}
+	}
+
+	prepare := func(name string, line, char int) []lspCallHierarchyItem {
+		var items []lspCallHierarchyItem
+		c.request("textDocument/prepareCallHierarchy", map[string]any{
+			"textDocument": map[string]any{"uri": uri(name)},
+			"position":     lspPosition{line - 1, char - 1},
+		}, &items)
+		return items
+	}
+	ranges := func(rs []lspRange) string {
+		var s []string
+		for _, r := range rs {
+			s = append(s, fmt.Sprintf("%d:%d", r.Start.Line+1, r.Start.Character+1))
+		}
+		return strings.Join(s, ",")
+	}
+	items := prepare("main.lunv", 5, 9) // the call of distance
+	if len(items) != 1 || items[0].Name != "distance" || items[0].URI != uri("geometry.lunv") {
+		t.Fatalf("call hierarchy items = %+v, want distance of geometry.lunv", items)
+	}
+	var in []lspIncomingCall
+	c.request("callHierarchy/incomingCalls", map[string]any{"item": items[0]}, &in)
+	var out []lspOutgoingCall
+	c.request("callHierarchy/outgoingCalls", map[string]any{"item": items[0]}, &out)
+	var got []string
+	for _, call := range in {
+		got = append(got, "from "+call.From.Name+" "+ranges(call.FromRanges))
+	}
+	for _, call := range out {
+		got = append(got, "to "+call.To.Name+" "+ranges(call.FromRanges))
+	}
+	if want := "from report 5:8|to square 8:19,8:39"; strings.Join(got, "|") != want {
+		t.Errorf("calls of distance = %s, want %s", strings.Join(got, "|"), want)
+	}
+
+	items = prepare("lib/shapes.lunv", 3, 12) // the declaration of M.area
+	if len(items) != 1 {
+		t.Fatalf("call hierarchy items = %+v, want area", items)
+	}
+	in = nil
+	c.request("callHierarchy/incomingCalls", map[string]any{"item": items[0]}, &in)
+	got = nil
+	for _, call := range in {
+		got = append(got, fmt.Sprintf("%s %d %s", call.From.Name, call.From.Kind, ranges(call.FromRanges)))
+	}
+	if want := "describe 6 8:25|main.lunv 1 9:8"; strings.Join(got, "|") != want {
+		t.Errorf("callers of area = %s, want %s", strings.Join(got, "|"), want)
+	}
+
+	// the index follows the files on the disk
+	os.WriteFile(filepath.Join(dir, "extra.lunv"), []byte("function helper() end"), 0o644)
+	os.Remove(filepath.Join(dir, "geometry.lunv"))
+	c.notify("workspace/didChangeWatchedFiles", map[string]any{"changes": []map[string]any{
+		{"uri": uri("extra.lunv"), "type": 1},
+		{"uri": uri("geometry.lunv"), "type": 3},
+	}})
+	c.waitSymbols("helper", 1)
+	c.waitSymbols("distance", 0)
+
+	// and the text of open documents, saved or not
+	c.open(uri("main.lunv"), indexFiles["main.lunv"]+"local function fresh() end\n")
+	var syms []lspSymbolInformation
+	c.request("workspace/symbol", map[string]any{"query": "fresh"}, &syms)
+	if got := symbolNames(syms); got != "fresh 12  main.lunv:10" {
+		t.Errorf("workspace symbols fresh = %s, want the open document's", got)
+	}
+}
+
+func TestWatchRegistration(t *testing.T) {
+	c := startLSPWith(t, map[string]any{"capabilities": map[string]any{
+		"workspace": map[string]any{"didChangeWatchedFiles": map[string]any{"dynamicRegistration": true}},
+	}})
+	var reg map[string]json.RawMessage
+	for reg == nil {
+		select {
+		case m := <-c.messages:
+			if string(m["method"]) == `"client/registerCapability"` {
+				reg = m
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no client/registerCapability request")
+		}
+	}
+	if want := `"workspace/didChangeWatchedFiles"`; !strings.Contains(string(reg["params"]), want) {
+		t.Errorf("registration %s, want %s", reg["params"], want)
+	}
+	// the answer of the client is not a request to answer
+	c.write(map[string]any{"id": reg["id"], "result": nil})
+	c.id++
+	c.write(map[string]any{"id": c.id, "method": "shutdown"})
+	for {
+		m := <-c.messages
+		if string(m["id"]) == string(reg["id"]) {
+			t.Fatalf("server answered the client's response: %s", m["error"])
+		}
+		if string(m["id"]) == fmt.Sprint(c.id) {
+			break
+		}
+	}
+}
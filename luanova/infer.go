@@ -0,0 +1,446 @@
+package luanova
+
+import "strings"
+
+// inference holds what editors show about the names of a chunk: the
+// annotated types, the types inferred from initializers, the functions
+// bound to names and the doc comments of declarations. Inference is
+// local and best effort; an unknown type is nil.
+type inference struct {
+	src   string
+	chunk *Chunk
+	res   *Resolution
+
+	vars    map[*Variable]TypeExpr // annotated or inferred
+	inits   map[*Variable]Expr     // initializers of the variables to infer
+	funcs   map[*Variable]*funcDecl
+	paths   map[string]*funcDecl // functions declared as function a.b:c, by path
+	docs    map[*Variable]string
+	aliases map[string]*TypeStmt
+
+	returns   map[*FunctionExpr]TypeExpr
+	inferring map[*FunctionExpr]bool
+	comments  map[int]Token // by the line they end on
+}
+
+// funcDecl is a function with the name and doc comment of its
+// declaration.
+type funcDecl struct {
+	name   string
+	fn     *FunctionExpr
+	method bool // declared with a colon: the first parameter is self
+	doc    string
+}
+
+func inferTypes(src string, chunk *Chunk, res *Resolution) *inference {
+	inf := &inference{
+		src: src, chunk: chunk, res: res,
+		vars:      map[*Variable]TypeExpr{},
+		inits:     map[*Variable]Expr{},
+		funcs:     map[*Variable]*funcDecl{},
+		paths:     map[string]*funcDecl{},
+		docs:      map[*Variable]string{},
+		aliases:   map[string]*TypeStmt{},
+		returns:   map[*FunctionExpr]TypeExpr{},
+		inferring: map[*FunctionExpr]bool{},
+		comments:  map[int]Token{},
+	}
+	for _, c := range chunk.Comments {
+		inf.comments[c.End.Line] = c
+	}
+	Inspect(chunk.Block, func(n Node) bool {
+		if s, ok := n.(*TypeStmt); ok {
+			inf.aliases[s.Name.Name] = s
+		}
+		return true
+	})
+	Inspect(chunk.Block, func(n Node) bool {
+		switch n := n.(type) {
+		case *LocalStmt:
+			doc := inf.docComment(n.Pos().Line)
+			for i, b := range n.Names {
+				v := res.VariableOf(b.Name)
+				if v == nil {
+					continue
+				}
+				inf.docs[v] = doc
+				switch {
+				case b.Type != nil:
+					inf.vars[v] = b.Type
+				case i < len(n.Values):
+					inf.inits[v] = n.Values[i]
+				}
+				if i < len(n.Values) {
+					if fn, ok := n.Values[i].(*FunctionExpr); ok {
+						inf.funcs[v] = &funcDecl{name: b.Name.Name, fn: fn, doc: doc}
+					}
+				}
+			}
+		case *LocalFunctionStmt:
+			if v := res.VariableOf(n.Name); v != nil {
+				d := &funcDecl{name: n.Name.Name, fn: n.Func, doc: inf.docComment(n.Pos().Line)}
+				inf.funcs[v], inf.docs[v] = d, d.doc
+				inf.inits[v] = n.Func
+			}
+		case *FunctionStmt:
+			path := exprPath(n.Name)
+			if path == "" {
+				break
+			}
+			d := &funcDecl{name: path, fn: n.Func, method: n.Method != nil, doc: inf.docComment(n.Pos().Line)}
+			if n.Method != nil {
+				d.name += ":" + n.Method.Name
+				path += "." + n.Method.Name
+			}
+			inf.paths[path] = d
+			if id, ok := n.Name.(*Ident); ok && n.Method == nil {
+				if v := res.VariableOf(id); v != nil {
+					inf.funcs[v] = d
+				}
+			}
+		case *FunctionExpr:
+			for _, p := range n.Params {
+				if v := res.VariableOf(p.Name); v != nil && p.Type != nil {
+					inf.vars[v] = p.Type
+				}
+			}
+		case *NumericForStmt:
+			if v := res.VariableOf(n.Var.Name); v != nil {
+				inf.vars[v] = n.Var.Type
+				if n.Var.Type == nil {
+					inf.vars[v] = namedType("number")
+				}
+			}
+		}
+		return true
+	})
+	return inf
+}
+
+// multiValued reports whether e can produce several values.
+func multiValued(e Expr) bool {
+	switch e.(type) {
+	case *CallExpr, *MethodCallExpr, *VarargExpr:
+		return true
+	}
+	return false
+}
+
+// exprPath returns "a.b.c" for a chain of names and fields, or "".
+func exprPath(e Expr) string {
+	switch e := e.(type) {
+	case *Ident:
+		return e.Name
+	case *FieldExpr:
+		if x := exprPath(e.X); x != "" {
+			return x + "." + e.Name.Name
+		}
+	}
+	return ""
+}
+
+func namedType(name string) *NamedType { return &NamedType{Name: name} }
+
+// typeString renders t in annotation syntax.
+func typeString(t TypeExpr) string {
+	if t == nil {
+		return ""
+	}
+	return (&formatter{}).typeExpr(t)
+}
+
+// underlying resolves type aliases declared in the chunk.
+func (inf *inference) underlying(t TypeExpr) TypeExpr {
+	for i := 0; i < 10; i++ {
+		n, ok := t.(*NamedType)
+		if !ok || inf.aliases[n.Name] == nil || len(n.Args) > 0 {
+			break
+		}
+		t = inf.aliases[n.Name].Type
+	}
+	return t
+}
+
+// varType returns the type of v, inferring it from its initializer the
+// first time.
+func (inf *inference) varType(v *Variable) TypeExpr {
+	if t, ok := inf.vars[v]; ok {
+		return t
+	}
+	init, ok := inf.inits[v]
+	if !ok {
+		return nil
+	}
+	delete(inf.inits, v) // a variable used in its own initializer is unknown
+	t := inf.exprType(init)
+	inf.vars[v] = t
+	return t
+}
+
+func (inf *inference) exprType(e Expr) TypeExpr {
+	switch e := e.(type) {
+	case *NilExpr:
+		return namedType("nil")
+	case *BoolExpr:
+		return namedType("boolean")
+	case *NumberExpr:
+		return namedType("number")
+	case *StringExpr:
+		return namedType("string")
+	case *TableExpr:
+		return inf.tableType(e)
+	case *FunctionExpr:
+		return inf.funcType(e, false)
+	case *Ident:
+		if v := inf.res.VariableOf(e); v != nil {
+			return inf.varType(v)
+		}
+	case *ParenExpr:
+		return inf.exprType(e.X)
+	case *UnaryExpr:
+		switch e.Op {
+		case Not:
+			return namedType("boolean")
+		case Len, Sub:
+			return namedType("number")
+		}
+	case *BinaryExpr:
+		switch e.Op {
+		case Plus, Sub, Multi, Div, Mod, Po:
+			return namedType("number")
+		case Concat:
+			return namedType("string")
+		case Equal, NotEqual, Less, LessEqual, Greater, GreaterEqual:
+			return namedType("boolean")
+		case And, Or:
+			l, r := inf.exprType(e.Left), inf.exprType(e.Right)
+			if opt, ok := l.(*OptionalType); ok && e.Op == Or && typeString(opt.Inner) == typeString(r) {
+				return r // x or default
+			}
+			if l != nil && typeString(l) == typeString(r) {
+				return l
+			}
+		}
+	case *CallExpr:
+		if d := inf.callee(e.Fn); d != nil {
+			return firstType(inf.returnType(d.fn))
+		}
+	case *MethodCallExpr:
+		if d := inf.callee(&FieldExpr{X: e.Recv, Name: e.Name}); d != nil {
+			return firstType(inf.returnType(d.fn))
+		}
+	case *FieldExpr:
+		if t, ok := inf.underlying(inf.exprType(e.X)).(*TableType); ok {
+			for _, f := range t.Fields {
+				if f.Name != nil && f.Name.Name == e.Name.Name {
+					return f.Value
+				}
+			}
+		}
+	case *IndexExpr:
+		if t, ok := inf.underlying(inf.exprType(e.X)).(*TableType); ok && len(t.Fields) == 1 && t.Fields[0].Name == nil {
+			return t.Fields[0].Value
+		}
+	}
+	return nil
+}
+
+func firstType(t TypeExpr) TypeExpr {
+	if tuple, ok := t.(*TupleType); ok {
+		if len(tuple.Types) == 0 || tuple.Variadic && len(tuple.Types) == 1 {
+			return nil
+		}
+		return tuple.Types[0]
+	}
+	return t
+}
+
+// tableType is {x: T, ...} for records, {T} for arrays of one type
+// and table otherwise.
+func (inf *inference) tableType(e *TableExpr) TypeExpr {
+	if len(e.Fields) == 0 {
+		return namedType("table") // filled later
+	}
+	t := &TableType{}
+	var elem TypeExpr
+	for i, f := range e.Fields {
+		value := inf.exprType(f.Value)
+		switch {
+		case f.Named:
+			if value == nil {
+				value = namedType("any")
+			}
+			t.Fields = append(t.Fields, &TableTypeField{Name: f.Key.(*Ident), Value: value})
+		case f.Key == nil:
+			if value == nil || i > 0 && typeString(value) != typeString(elem) {
+				return namedType("table")
+			}
+			elem = value
+		default:
+			return namedType("table")
+		}
+	}
+	if elem != nil {
+		if len(t.Fields) > 0 {
+			return namedType("table")
+		}
+		t.Fields = []*TableTypeField{{Value: elem}}
+	}
+	return t
+}
+
+// funcType returns the type of fn; a method's self is left out.
+func (inf *inference) funcType(fn *FunctionExpr, method bool) *FunctionType {
+	t := &FunctionType{Variadic: fn.IsVararg, Return: inf.returnType(fn)}
+	for i, p := range fn.Params {
+		if method && i == 0 {
+			continue
+		}
+		t.Params = append(t.Params, orAny(p.Type))
+	}
+	if fn.IsVararg {
+		t.Params = append(t.Params, orAny(fn.VarargType))
+	}
+	if t.Return == nil {
+		t.Return = namedType("any")
+	}
+	return t
+}
+
+func orAny(t TypeExpr) TypeExpr {
+	if t == nil {
+		return namedType("any")
+	}
+	return t
+}
+
+// returnType returns the annotated return type of fn or the one of its
+// return statements when they agree: () when there are none, nil when
+// unknown.
+func (inf *inference) returnType(fn *FunctionExpr) TypeExpr {
+	if fn.ReturnType != nil {
+		return fn.ReturnType
+	}
+	if t, ok := inf.returns[fn]; ok || inf.inferring[fn] {
+		return t
+	}
+	inf.inferring[fn] = true
+	var ret TypeExpr
+	known := true
+	Inspect(fn.Body, func(n Node) bool {
+		switch n := n.(type) {
+		case *FunctionExpr:
+			return false
+		case *ReturnStmt:
+			var t TypeExpr
+			switch len(n.Values) {
+			case 0:
+				t = &TupleType{}
+			case 1:
+				if !multiValued(n.Values[0]) {
+					t = inf.exprType(n.Values[0])
+				}
+			}
+			switch {
+			case t == nil || ret != nil && typeString(ret) != typeString(t):
+				known = false
+			case ret == nil:
+				ret = t
+			}
+		}
+		return known
+	})
+	switch {
+	case !known:
+		ret = nil
+	case ret == nil:
+		ret = &TupleType{}
+	}
+	delete(inf.inferring, fn)
+	inf.returns[fn] = ret
+	return ret
+}
+
+// callee returns the function a call expression calls, when it is
+// bound to a local or declared with a function statement.
+func (inf *inference) callee(fn Expr) *funcDecl {
+	if id, ok := fn.(*Ident); ok {
+		if v := inf.res.VariableOf(id); v != nil {
+			return inf.funcs[v]
+		}
+	}
+	if path := exprPath(fn); path != "" {
+		return inf.paths[path]
+	}
+	return nil
+}
+
+// signature renders d as `name(a: T, b: U): R` with the offsets of the
+// parameters in the label; colon says the call passes self with a
+// colon.
+func (d *funcDecl) signature(inf *inference, colon bool) (string, [][2]int) {
+	var b strings.Builder
+	b.WriteString(d.name + "(")
+	var params [][2]int
+	add := func(s string) {
+		if len(params) > 0 {
+			b.WriteString(", ")
+		}
+		start := utf16Count(b.String())
+		b.WriteString(s)
+		params = append(params, [2]int{start, utf16Count(b.String())})
+	}
+	for i, p := range d.fn.Params {
+		if colon && i == 0 {
+			continue // self
+		}
+		s := p.Name.Name
+		if p.Type != nil {
+			s += ": " + typeString(p.Type)
+		}
+		add(s)
+	}
+	if d.fn.IsVararg {
+		s := "..."
+		if d.fn.VarargType != nil {
+			s += ": " + typeString(d.fn.VarargType)
+		}
+		add(s)
+	}
+	b.WriteString(")")
+	if ret := inf.returnType(d.fn); ret != nil {
+		if tuple, ok := ret.(*TupleType); !ok || len(tuple.Types) > 0 {
+			b.WriteString(": " + typeString(ret))
+		}
+	}
+	return b.String(), params
+}
+
+// docComment returns the text of the comments on the lines right above
+// line, each alone on its line.
+func (inf *inference) docComment(line int) string {
+	var lines []string
+	for {
+		c, ok := inf.comments[line-1]
+		if !ok || strings.TrimSpace(inf.src[lineStart(inf.src, c.Pos.Offset):c.Pos.Offset]) != "" {
+			break
+		}
+		text := inf.src[c.Pos.Offset:c.End.Offset]
+		if c.Type == CommentBlock {
+			text = strings.TrimSuffix(strings.TrimPrefix(text, "-*"), "*-")
+		} else {
+			text = strings.TrimPrefix(text, "--")
+		}
+		var block []string
+		for _, l := range strings.Split(text, "\n") {
+			block = append(block, strings.TrimSpace(l))
+		}
+		lines = append(block, lines...)
+		line = c.Pos.Line
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+func lineStart(src string, offset int) int {
+	return strings.LastIndexByte(src[:offset], '\n') + 1
+}
@@ -0,0 +1,80 @@
+package luanova
+
+import (
+	"testing"
+)
+
+func TestInferTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string // type of the last local
+	}{
+		{"literals", `local x = "s"`, "string"},
+		{"annotation", `local x: number? = nil`, "number?"},
+		{"arithmetic", `local a = 1 local x = a * 2 + #"s"`, "number"},
+		{"concat", `local x = 1 .. 2`, "string"},
+		{"comparison", `local x = not (1 < 2)`, "boolean"},
+		{"or default", `local a: string? = nil local x = a or "none"`, "string"},
+		{"record", `local x = {name = "n", age = 3, tags = {"a", "b"}}`, "{name: string, age: number, tags: {string}}"},
+		{"mixed table", `local x = {1, "a"}`, "table"},
+		{"field", `local p = {x = 1, y = "b"} local x = p.y`, "string"},
+		{"alias field", `type Point = {x: number} local p: Point = f() local x = p.x`, "number"},
+		{"annotated call", `local function f(a: number): boolean return a > 0 end local x = f(1)`, "boolean"},
+		{"inferred return", `local function f(a) if a then return "x" end return "y" end local x = f()`, "string"},
+		{"conflicting returns", `local function f(a) if a then return 1 end return "y" end local x = f()`, ""},
+		{"method call", `local obj = {} function obj:get() return 1 end local x = obj:get()`, "number"},
+		{"function", `local x = function(a: number, ...: string) return a end`, "(number, ...string) -> number"},
+		{"no returns", `local x = function() end`, "() -> ()"},
+		{"recursion", `local function f(n) return f(n - 1) end local x = f(1)`, ""},
+		{"own initializer", `local x = x`, ""},
+		{"later local", `local function f() local y = 2 return y end local x = f()`, "number"},
+		{"multiple values", `local a, x = 1, g()`, ""},
+		{"loop", `for i = 1, 3 do local x = i end`, "number"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunk, err := Parse("test", tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := Resolve(chunk)
+			inf := inferTypes(tt.input, chunk, res)
+			var x *Variable
+			for _, v := range res.Vars {
+				if v.Name.Name == "x" {
+					x = v
+				}
+			}
+			if got := typeString(inf.varType(x)); got != tt.expected {
+				t.Errorf("type = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDocComments(t *testing.T) {
+	src := `-- Adds two numbers.
+-- Returns their sum.
+local function add(a, b) return a + b end
+
+local x = 1 -- not a doc comment
+local function sub(a, b) return a - b end
+
+-*
+	Multiplies.
+*-
+local function mul(a, b) return a * b end`
+	chunk, err := Parse("test", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := Resolve(chunk)
+	inf := inferTypes(src, chunk, res)
+	want := map[string]string{"add": "Adds two numbers.\nReturns their sum.", "sub": "", "mul": "Multiplies."}
+	for _, v := range res.Vars {
+		if w, ok := want[v.Name.Name]; ok && v.Kind == VarFunction && inf.docs[v] != w {
+			t.Errorf("doc of %s = %q, want %q", v.Name.Name, inf.docs[v], w)
+		}
+	}
+}
@@ -1,10 +1,21 @@
 package luanova
 
-import "unicode"
+import (
+	"strings"
+	"unicode"
+
+	"github.com/Herograme/LuaNova/luanova/diag"
+)
+
+// Position is a location in the source. Line and Column are 1-based;
+// Column counts bytes.
+type Position = diag.Position
 
 type Token struct {
 	Type    int
 	Literal string
+	Pos     Position // first character of the token
+	End     Position // first character after the token
 }
 
 type Lexer struct {
@@ -12,15 +23,24 @@ type Lexer struct {
 	pos     int
 	readPos int
 	ch      byte
+	line    int
+	col     int
 }
 
 func NewLexer(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.readChar()
 	return l
 }
 
 func (l *Lexer) readChar() {
+	if l.readPos > len(l.input) {
+		return // at EOF
+	}
+	if l.ch == '\n' {
+		l.line++
+		l.col = 0
+	}
 	if l.readPos >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -28,6 +48,11 @@ func (l *Lexer) readChar() {
 	}
 	l.pos = l.readPos
 	l.readPos++
+	l.col++
+}
+
+func (l *Lexer) position() Position {
+	return Position{Offset: min(l.pos, len(l.input)), Line: l.line, Column: l.col}
 }
 
 func (l *Lexer) peekChar() byte {
@@ -38,9 +63,16 @@ func (l *Lexer) peekChar() byte {
 }
 
 func (l *Lexer) NextToken() Token {
-	var tok Token
-
 	l.skipWhitespace()
+	pos := l.position()
+	tok := l.scanToken()
+	tok.Pos = pos
+	tok.End = l.position()
+	return tok
+}
+
+func (l *Lexer) scanToken() Token {
+	var tok Token
 
 	switch l.ch {
 	case '+':
@@ -98,6 +130,8 @@ func (l *Lexer) NextToken() Token {
 		}
 	case '^':
 		tok = Token{Type: Po, Literal: string(l.ch)}
+	case '#':
+		tok = Token{Type: Len, Literal: string(l.ch)}
 	case '.':
 		if l.peekChar() == '.' {
 			l.readChar()
@@ -218,61 +252,35 @@ func isDigit(ch byte) bool {
 }
 
 func LookupIdent(ident string) int {
-	var token int
-	switch ident {
-	case "function":
-		token = Function
-	case "local":
-		token = Local
-	case "if":
-		token = If
-	case "else":
-		token = Else
-	case "elseif":
-		token = ElseIf
-	case "and":
-		token = And
-	case "or":
-		token = Or
-	case "not":
-		token = Not
-	case "while":
-		token = While
-	case "for":
-		token = For
-	case "return":
-		token = Return
-	case "break":
-		token = Break
-	case "continue":
-		token = Continue
-	case "true":
-		token = True
-	case "false":
-		token = False
-	case "do":
-		token = Do
-	case "in":
-		token = In
-	case "then":
-		token = Then
-	case "end":
-		token = End
-
-	default:
-		token = Literal
+	if tok, ok := keywords[ident]; ok {
+		return tok
 	}
+	return Literal
+}
 
-	return token
+// LookupContextual returns the contextual keyword ident spells, or
+// Literal. The lexer never produces these tokens: import, export, from
+// and as stay valid names, and the parser treats them as keywords only
+// where a module declaration can start.
+func LookupContextual(ident string) int {
+	if tok, ok := contextualKeywords[ident]; ok {
+		return tok
+	}
+	return Literal
 }
 
+// readString returns the raw text between the quotes; escape sequences
+// are skipped over here and decoded by the parser.
 func (l *Lexer) readString() string {
 	l.readChar()
 	start := l.pos
 	for l.ch != '"' && l.ch != 0 {
+		if l.ch == '\\' {
+			l.readChar()
+		}
 		l.readChar()
 	}
-	return l.input[start:l.pos]
+	return l.input[min(start, len(l.input)):min(l.pos, len(l.input))]
 }
 
 func (l *Lexer) readBlockComment() string {
@@ -300,9 +308,27 @@ func (l *Lexer) readBlockComment() string {
 	return l.input[start:l.pos]
 }
 
+// readNumber reads a numeral the way Lua does: greedily, including hex
+// digits and signed exponents, leaving validation to the parser. A '.'
+// ends the token; the parser joins "1", ".", "5" back into 1.5, which is
+// why 'p' exponents are also accepted after decimal digits.
 func (l *Lexer) readNumber() string {
 	start := l.pos
-	for isDigit(l.ch) {
+	exponents := "EePp"
+	if l.ch == '0' && (l.peekChar() == 'x' || l.peekChar() == 'X') {
+		exponents = "Pp"
+		l.readChar()
+		l.readChar()
+	}
+	for {
+		if strings.IndexByte(exponents, l.ch) >= 0 && (l.peekChar() == '+' || l.peekChar() == '-') {
+			l.readChar()
+			l.readChar()
+			continue
+		}
+		if !isDigit(l.ch) && !isLetter(l.ch) {
+			break
+		}
 		l.readChar()
 	}
 	return l.input[start:l.pos]
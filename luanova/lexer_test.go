@@ -386,6 +386,7 @@ func TestTokenName(t *testing.T) {
 		CommentBlock: "CommentBlock",
 		True:         "True",
 		False:        "False",
+		Nil:          "Nil",
 		Literal:      "Literal",
 		Function:     "Function",
 		Local:        "Local",
@@ -397,6 +398,7 @@ func TestTokenName(t *testing.T) {
 		End:          "End",
 		Then:         "Then",
 		Repeat:       "Repeat",
+		Until:        "Until",
 		Continue:     "Continue",
 		Break:        "Break",
 		In:           "In",
@@ -425,6 +427,7 @@ func TestTokenName(t *testing.T) {
 		Mod:          "Mod",
 		Po:           "Po",
 		Concat:       "Concat",
+		Len:          "Len",
 		StringDelim:  "StringDelim",
 		LParen:       "LParen",
 		RParen:       "RParen",
@@ -439,6 +442,10 @@ func TestTokenName(t *testing.T) {
 		Dots:         "Dots",
 		Arrow:        "Arrow",
 		Question:     "Question",
+		Import:       "Import",
+		Export:       "Export",
+		From:         "From",
+		As:           "As",
 		String:       "String",
 		Int:          "Int",
 		Bool:         "Bool",
@@ -567,3 +574,90 @@ func TestComplexTokens(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "local x = #t\n  x ..= \"a\\\"b\""
+	l := NewLexer(input)
+
+	tests := []struct {
+		expectedType    int
+		expectedLiteral string
+		pos, end        Position
+	}{
+		{Local, "local", Position{Offset: 0, Line: 1, Column: 1}, Position{Offset: 5, Line: 1, Column: 6}},
+		{Literal, "x", Position{Offset: 6, Line: 1, Column: 7}, Position{Offset: 7, Line: 1, Column: 8}},
+		{Assign, "=", Position{Offset: 8, Line: 1, Column: 9}, Position{Offset: 9, Line: 1, Column: 10}},
+		{Len, "#", Position{Offset: 10, Line: 1, Column: 11}, Position{Offset: 11, Line: 1, Column: 12}},
+		{Literal, "t", Position{Offset: 11, Line: 1, Column: 12}, Position{Offset: 12, Line: 1, Column: 13}},
+		{Literal, "x", Position{Offset: 15, Line: 2, Column: 3}, Position{Offset: 16, Line: 2, Column: 4}},
+		{Concat, "..", Position{Offset: 17, Line: 2, Column: 5}, Position{Offset: 19, Line: 2, Column: 7}},
+		{Assign, "=", Position{Offset: 19, Line: 2, Column: 7}, Position{Offset: 20, Line: 2, Column: 8}},
+		{StringDelim, `a\"b`, Position{Offset: 21, Line: 2, Column: 9}, Position{Offset: 27, Line: 2, Column: 15}},
+		{EOF, "", Position{Offset: 27, Line: 2, Column: 15}, Position{Offset: 27, Line: 2, Column: 15}},
+	}
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - got %s %q, expected %s %q",
+				i, TokenName(tok.Type), tok.Literal, TokenName(tt.expectedType), tt.expectedLiteral)
+		}
+		if tok.Pos != tt.pos || tok.End != tt.end {
+			t.Fatalf("tests[%d] - position wrong. expected=%v-%v, got=%v-%v",
+				i, tt.pos, tt.end, tok.Pos, tok.End)
+		}
+	}
+}
+
+func TestNumberLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"0xFF", []string{"0xFF"}},
+		{"1e10", []string{"1e10"}},
+		{"2E-3", []string{"2E-3"}},
+		{"0x1p+4", []string{"0x1p+4"}},
+		{"0xep-1", []string{"0xep-1"}},
+		{"3-1", []string{"3", "-", "1"}},
+		{"0xe-1", []string{"0xe", "-", "1"}},
+	}
+
+	for _, tt := range tests {
+		l := NewLexer(tt.input)
+		for i, lit := range tt.expected {
+			tok := l.NextToken()
+			if tok.Literal != lit {
+				t.Errorf("%q: token %d = %q, expected %q", tt.input, i, tok.Literal, lit)
+			}
+		}
+		if tok := l.NextToken(); tok.Type != EOF {
+			t.Errorf("%q: expected EOF, got %s %q", tt.input, TokenName(tok.Type), tok.Literal)
+		}
+	}
+}
+
+func TestContextualKeywords(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int
+	}{
+		{"import", Import},
+		{"export", Export},
+		{"from", From},
+		{"as", As},
+		{"imports", Literal},
+		{"local", Literal},
+	}
+
+	for _, tt := range tests {
+		if got := LookupContextual(tt.input); got != tt.expected {
+			t.Errorf("LookupContextual(%q) = %s, expected %s", tt.input, TokenName(got), TokenName(tt.expected))
+		}
+		// the lexer keeps them as names
+		if tok := NewLexer(tt.input).NextToken(); tt.expected != Literal && tok.Type != Literal {
+			t.Errorf("%q lexed as %s", tt.input, TokenName(tok.Type))
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package luanova
+
+import (
+	"context"
+	"errors"
+	"math"
+)
+
+// Errors raised when a script exceeds a limit. They are raised like other
+// runtime errors, so pcall catches them, but the limit stays exhausted and
+// the next step fails again. On the Go side the returned *Error unwraps to
+// one of these, or to the context's error on cancellation.
+var (
+	ErrStepLimit      = errors.New("step limit exceeded")
+	ErrMemoryLimit    = errors.New("not enough memory")
+	ErrStackOverflow  = errors.New("stack overflow")
+	ErrStringTooLarge = errors.New("string too large")
+)
+
+// Limits bounds the resources of one execution, that is one call of
+// DoString, DoFile, Call or PCall from Go. Zero fields are unlimited.
+type Limits struct {
+	// MaxSteps bounds the number of function calls and loop iterations,
+	// counting the work of library functions such as the steps of the
+	// pattern matcher and the elements table.concat or table.sort visit.
+	MaxSteps int64
+	// MaxMemory bounds the approximate number of bytes allocated for
+	// strings, tables and functions. Memory is counted when allocated,
+	// not when collected.
+	MaxMemory int64
+	// MaxCallDepth bounds nested calls; zero means DefaultMaxCallDepth.
+	MaxCallDepth int
+	// MaxStringSize bounds the length of strings built by scripts.
+	MaxStringSize int
+}
+
+// checkInterval is the number of steps between two checks of the
+// context.
+const checkInterval = 1024
+
+// Approximate sizes charged against MaxMemory.
+const (
+	tableSize   = 64
+	slotSize    = 24
+	closureSize = 64
+)
+
+// SetLimits sets the limits of the following executions.
+func (s *State) SetLimits(l Limits) {
+	s.limits = l
+	s.maxDepth = l.MaxCallDepth
+	if s.maxDepth <= 0 {
+		s.maxDepth = DefaultMaxCallDepth
+	}
+}
+
+// Limits returns the limits set with SetLimits.
+func (s *State) Limits() Limits {
+	return s.limits
+}
+
+// SetContext makes executions stop with ctx.Err() once ctx is done. A
+// nil ctx removes the context.
+func (s *State) SetContext(ctx context.Context) {
+	s.ctx = ctx
+}
+
+// startExecution resets the budgets at the start of an execution.
+func (s *State) startExecution() error {
+	if s.ctx != nil {
+		if err := s.ctx.Err(); err != nil {
+			return err
+		}
+	}
+	s.steps, s.mem = 0, 0
+	s.refill()
+	return nil
+}
+
+// refill sets the number of steps until the next call of checkLimits.
+func (s *State) refill() {
+	s.chunk = math.MaxInt64
+	if s.ctx != nil {
+		s.chunk = checkInterval
+	}
+	if s.limits.MaxSteps > 0 {
+		s.chunk = max(min(s.chunk, s.limits.MaxSteps-s.steps+1), 1)
+	}
+	s.ticks = s.chunk
+}
+
+// step counts a function call or loop iteration. It is the only cost of
+// the limits while none is reached.
+func (s *State) step() {
+	s.ticks--
+	if s.ticks <= 0 {
+		s.checkLimits()
+	}
+}
+
+// charge counts n steps of work done by a library function.
+func (s *State) charge(n int64) {
+	s.ticks -= n
+	if s.ticks <= 0 {
+		s.checkLimits()
+	}
+}
+
+func (s *State) checkLimits() {
+	s.steps += s.chunk - s.ticks
+	s.refill()
+	if s.ctx != nil {
+		if err := s.ctx.Err(); err != nil {
+			s.limitError(err)
+		}
+	}
+	if s.limits.MaxSteps > 0 && s.steps > s.limits.MaxSteps {
+		s.limitError(ErrStepLimit)
+	}
+}
+
+// alloc charges n bytes against the memory limit.
+func (s *State) alloc(n int) {
+	if s.profiler != nil && len(s.calls) > 0 {
+		s.profiler.alloc(n)
+	}
+	if s.limits.MaxMemory <= 0 {
+		return
+	}
+	s.mem += int64(n)
+	if s.mem > s.limits.MaxMemory && len(s.calls) > 0 {
+		s.limitError(ErrMemoryLimit)
+	}
+}
+
+// allocString checks the length of a string about to be built and
+// charges it against the memory limit.
+func (s *State) allocString(n int) {
+	if s.limits.MaxStringSize > 0 && n > s.limits.MaxStringSize {
+		s.limitError(ErrStringTooLarge)
+	}
+	s.alloc(n)
+}
+
+// stringBudget returns the length of the longest string the limits
+// still allow, or -1 when any length is allowed. Functions that build
+// a string piece by piece check it as the string grows.
+func (s *State) stringBudget() int {
+	budget := -1
+	if s.limits.MaxStringSize > 0 {
+		budget = s.limits.MaxStringSize
+	}
+	if s.limits.MaxMemory > 0 {
+		left := int(max(s.limits.MaxMemory-s.mem, 0))
+		if budget < 0 || left < budget {
+			budget = left
+		}
+	}
+	return budget
+}
+
+// tableSet is rawSet for scripts: it charges new entries against the
+// memory limit.
+func (s *State) tableSet(t *Table, k, v any) {
+	if s.limits.MaxMemory <= 0 && s.profiler == nil {
+		t.Set(k, v)
+		return
+	}
+	n := len(t.arr) + len(t.entries)
+	t.Set(k, v)
+	if grown := len(t.arr) + len(t.entries) - n; grown > 0 {
+		s.alloc(grown * slotSize)
+	}
+}
+
+// limitError raises err at the running Lua function, or at its caller
+// when a Go function is running.
+func (s *State) limitError(err error) {
+	level := 0
+	if n := len(s.calls); n > 0 && s.calls[n-1].fr == nil {
+		level = 1
+	}
+	panic(&Error{Value: s.where(level) + err.Error(), Stack: s.stackTrace(0), Cause: err})
+}
@@ -0,0 +1,143 @@
+package luanova
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   Limits
+		input    string
+		expected error
+		message  string
+	}{
+		{"steps", Limits{MaxSteps: 1000}, `while true do end`,
+			ErrStepLimit, "test:1: step limit exceeded"},
+		{"steps in calls", Limits{MaxSteps: 1000}, `local function f() return f() end f()`,
+			ErrStepLimit, "test:1: step limit exceeded"},
+		{"steps in patterns", Limits{MaxSteps: 1000}, `local s = ("a"):rep(1e5):gsub("a", "b")`,
+			ErrStepLimit, "test:1: step limit exceeded"},
+		{"steps in table functions", Limits{MaxSteps: 1000}, `local t = table.pack(("x"):rep(5e4):byte(1, -1)) table.sort(t)`,
+			ErrStepLimit, "test:1: step limit exceeded"},
+		{"memory", Limits{MaxMemory: 1 << 16}, `local t = {} for i = 1, 1e6 do t[i] = i end`,
+			ErrMemoryLimit, "test:1: not enough memory"},
+		{"memory from strings", Limits{MaxMemory: 1 << 16}, `local s = "" while true do s = s .. "xxxxxxxx" end`,
+			ErrMemoryLimit, "test:1: not enough memory"},
+		{"call depth", Limits{MaxCallDepth: 50}, `local function f() f() end f()`,
+			ErrStackOverflow, "test:1: stack overflow"},
+		{"string size", Limits{MaxStringSize: 100}, `local s = ("x"):rep(101)`,
+			ErrStringTooLarge, "test:1: string too large"},
+		{"string size in concat", Limits{MaxStringSize: 100}, `local s = "x" while true do s = s .. s end`,
+			ErrStringTooLarge, "test:1: string too large"},
+		{"string size in gsub", Limits{MaxStringSize: 1 << 20, MaxMemory: 1 << 24},
+			`local s = string.gsub(string.rep("x", 3e6), "", string.rep("y", 1e5))`,
+			ErrStringTooLarge, "test:1: string too large"},
+		{"memory in gsub", Limits{MaxMemory: 1 << 22},
+			`local s = string.gsub(string.rep("x", 3e6), "", string.rep("y", 1e5))`,
+			ErrMemoryLimit, "test:1: not enough memory"},
+		{"string size in table.concat", Limits{MaxStringSize: 1 << 20, MaxMemory: 1 << 24},
+			`local t = {} for i = 1, 1e4 do t[i] = "x" end local s = table.concat(t, string.rep("y", 1e5))`,
+			ErrStringTooLarge, "test:1: string too large"},
+		{"memory in table.concat", Limits{MaxMemory: 1 << 24},
+			`local t = {} for i = 1, 1e3 do t[i] = "x" end local s = table.concat(t, string.rep("y", 1e5))`,
+			ErrMemoryLimit, "test:1: not enough memory"},
+	}
+
+	for _, tt := range tests {
+		s := NewState()
+		s.SetLimits(tt.limits)
+		fn, err := s.Load(tt.input, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.PCall(fn)
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("%s: got %v, expected %v", tt.name, err, tt.expected)
+			continue
+		}
+		if err.Error() != tt.message {
+			t.Errorf("%s: got %q, expected %q", tt.name, err.Error(), tt.message)
+		}
+	}
+}
+
+func TestLimitsPerExecution(t *testing.T) {
+	s := NewState()
+	s.SetLimits(Limits{MaxSteps: 100})
+	for i := 0; i < 3; i++ {
+		if err := s.DoString(`for i = 1, 90 do end`); err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+	}
+	if err := s.DoString(`for i = 1, 101 do end`); !errors.Is(err, ErrStepLimit) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestLimitCaughtByPcall(t *testing.T) {
+	s := NewState()
+	s.SetLimits(Limits{MaxSteps: 500})
+	err := s.DoString(`
+caught = not pcall(function() while true do end end)
+while true do end`)
+	if !errors.Is(err, ErrStepLimit) {
+		t.Errorf("the budget should stay exhausted after pcall, got %v", err)
+	}
+	if s.GetGlobal("caught") != true {
+		t.Errorf("pcall should catch the limit")
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	s := NewState()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	s.SetContext(ctx)
+
+	start := time.Now()
+	err := s.DoString(`while true do end`)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("cancellation took %v", d)
+	}
+
+	// so is the work of library functions
+	for _, script := range []string{
+		`local s = string.rep("a", 1e6) for i = 1, 1e9 do s:gsub("a", "b") end`,
+		`local s = string.rep("a", 1e6) for i = 1, 1e9 do s:find(".-b") end`,
+		`local t = {} for i = 1, 1e6 do t[i] = "x" end for i = 1, 1e9 do table.concat(t) end`,
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		s := NewState()
+		s.SetContext(ctx)
+		start := time.Now()
+		err := s.DoString(script)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: got %v", script, err)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("%s: cancellation took %v", script, d)
+		}
+	}
+
+	// a done context fails before the script starts
+	if err := s.DoString(`x = 1`); !errors.Is(err, context.DeadlineExceeded) || s.GetGlobal("x") != nil {
+		t.Errorf("got %v", err)
+	}
+
+	// blocking channel operations are interrupted too
+	ctx, cancel = context.WithCancel(context.Background())
+	s.SetContext(ctx)
+	s.SetGlobal("ch", make(chan int))
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if err := s.DoString(`ch:recv()`); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v", err)
+	}
+}
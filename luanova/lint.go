@@ -0,0 +1,147 @@
+package luanova
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+
+	"github.com/Herograme/LuaNova/luanova/diag"
+)
+
+// LintRule is a check run by Lint. Rules are registered with
+// RegisterLintRule, usually from an init function.
+type LintRule struct {
+	Name     string // used in the config and in ignore comments
+	Doc      string
+	Severity diag.Severity // default severity
+	Run      func(p *LintPass)
+}
+
+var lintRules = map[string]*LintRule{}
+
+// RegisterLintRule adds a rule to those run by Lint. It panics if a rule
+// of the same name is already registered.
+func RegisterLintRule(r *LintRule) {
+	if _, dup := lintRules[r.Name]; dup {
+		panic("luanova: lint rule " + r.Name + " registered twice")
+	}
+	lintRules[r.Name] = r
+}
+
+// LintRules returns the registered rules sorted by name.
+func LintRules() []*LintRule {
+	rules := make([]*LintRule, 0, len(lintRules))
+	for _, r := range lintRules {
+		rules = append(rules, r)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules
+}
+
+// LintConfig overrides the default severity of rules by name.
+type LintConfig struct {
+	Severity map[string]diag.Severity
+}
+
+// LintPass is what a rule sees of the chunk being checked.
+type LintPass struct {
+	Chunk *Chunk
+	*Resolution
+
+	rule     *LintRule
+	severity diag.Severity
+	diags    []diag.Diagnostic
+}
+
+// Report adds a diagnostic for the span of n.
+func (p *LintPass) Report(n Node, format string, args ...any) {
+	p.ReportFix(n, nil, format, args...)
+}
+
+// ReportFix adds a diagnostic for the span of n that fix resolves.
+func (p *LintPass) ReportFix(n Node, fix *diag.Fix, format string, args ...any) {
+	p.ReportDiagnostic(diag.Diagnostic{
+		Pos:     n.Pos(),
+		End:     n.End(),
+		Message: fmt.Sprintf(format, args...),
+	}, fix)
+}
+
+// ReportDiagnostic adds d, which may have notes, with the source, rule
+// and severity of the pass filled in.
+func (p *LintPass) ReportDiagnostic(d diag.Diagnostic, fix *diag.Fix) {
+	d.Source, d.Code, d.Severity = p.Chunk.Name, p.rule.Name, p.severity
+	if fix != nil {
+		d.Fixes = append(d.Fixes, *fix)
+	}
+	p.diags = append(p.diags, d)
+}
+
+// Lint runs the registered rules on chunk and returns their diagnostics
+// sorted by position. config may be nil. A comment
+// `--luanova:ignore rule1, rule2` silences those rules, or all of them
+// when none is named, on its own line and the line after it.
+func Lint(chunk *Chunk, config *LintConfig) []diag.Diagnostic {
+	pass := &LintPass{Chunk: chunk, Resolution: Resolve(chunk)}
+	for _, rule := range LintRules() {
+		severity, ok := diag.Severity(0), false
+		if config != nil {
+			severity, ok = config.Severity[rule.Name]
+		}
+		if !ok {
+			severity = rule.Severity
+		}
+		if severity == diag.SeverityOff {
+			continue
+		}
+		pass.rule, pass.severity = rule, severity
+		rule.Run(pass)
+	}
+
+	ignored := ignoreComments(chunk.Comments)
+	diags := pass.diags[:0]
+	for _, d := range pass.diags {
+		if rules, ok := ignored[d.Pos.Line]; ok && (rules == nil || rules[d.Code]) {
+			continue
+		}
+		diags = append(diags, d)
+	}
+	sort.SliceStable(diags, func(i, j int) bool { return diags[i].Pos.Offset < diags[j].Pos.Offset })
+	return diags
+}
+
+// ignoreComments returns the rules silenced by line; a nil set stands
+// for all rules.
+func ignoreComments(comments []Token) map[int]map[string]bool {
+	ignored := map[int]map[string]bool{}
+	for _, c := range comments {
+		if c.Type != Comment {
+			continue
+		}
+		text := strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(c.Literal), "-"))
+		rest, ok := strings.CutPrefix(text, "luanova:ignore")
+		if !ok || rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+			continue
+		}
+		var rules map[string]bool
+		for _, name := range strings.FieldsFunc(rest, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+			if rules == nil {
+				rules = map[string]bool{}
+			}
+			rules[name] = true
+		}
+		for _, line := range []int{c.Pos.Line, c.Pos.Line + 1} {
+			if prev, ok := ignored[line]; ok && (prev == nil || rules == nil) {
+				ignored[line] = nil
+			} else if ok {
+				for name := range rules {
+					prev[name] = true
+				}
+			} else {
+				ignored[line] = maps.Clone(rules)
+			}
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,201 @@
+package luanova
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Herograme/LuaNova/luanova/diag"
+)
+
+func lint(t *testing.T, src string, config *LintConfig) []diag.Diagnostic {
+	t.Helper()
+	chunk, err := Parse("test", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Lint(chunk, config)
+}
+
+func diagStrings(diags []diag.Diagnostic) []string {
+	var s []string
+	for _, d := range diags {
+		s = append(s, d.String())
+	}
+	return s
+}
+
+func TestLintRules(t *testing.T) {
+	tests := []struct {
+		rule     string
+		input    string
+		expected []string
+	}{
+		{"unused-local", `local a, _b = 1, 2
+local c = 3; c = 4
+local function f() end
+for i, v in pairs({}) do print(v) end
+import { x } from "./m"
+export local y = 1
+print(a)`, []string{
+			"test:2:7: local 'c' is never used (unused-local)",
+			"test:3:16: local 'f' is never used (unused-local)",
+			"test:4:5: loop variable 'i' is never used (unused-local)",
+			"test:5:10: import 'x' is never used (unused-local)",
+		}},
+		{"unused-param", `local t = {}
+function t:m(a, _b, c) return c end
+print(function(x, ...) return ... end)`, []string{
+			"test:2:14: parameter 'a' is never used (unused-param)",
+			"test:3:16: parameter 'x' is never used (unused-param)",
+		}},
+		{"shadow", `local x = 1
+local x = x + 1
+do local x = 2 end
+local function f(x) for x = 1, 2 do end end
+local _ = 1; local _ = 2`, []string{
+			"test:2:7: 'x' shadows the local declared at line 1 (shadow)",
+			"test:3:10: 'x' shadows the local declared at line 2 (shadow)",
+			"test:4:18: 'x' shadows the local declared at line 2 (shadow)",
+			"test:4:25: 'x' shadows the local declared at line 4 (shadow)",
+		}},
+		{"global-assign", `config = {}
+local function f() count = 1; config.x = 1; config = 2 end
+function g() end
+local function h() function helper() end end`, []string{
+			"test:2:20: assignment to global 'count'; missing local? (global-assign)",
+			"test:4:29: assignment to global 'helper'; missing local? (global-assign)",
+		}},
+		{"global-assign", `x = 1
+export local y = 2`, []string{
+			"test:1:1: assignment to global 'x'; missing local? (global-assign)",
+		}},
+		{"unreachable", `local function f(x)
+  while x do break; print(x) end
+  for i = 1, 2 do continue; print(i); print(i) end
+  return x
+end`, []string{
+			"test:2:21: unreachable code (unreachable)",
+			"test:3:29: unreachable code (unreachable)",
+		}},
+		{"continue-outside-loop", `continue
+for i = 1, 2 do
+  if i then continue end
+  local f = function() continue end
+end`, []string{
+			"test:1:1: continue outside a loop (continue-outside-loop)",
+			"test:4:24: continue outside a loop (continue-outside-loop)",
+		}},
+		{"self-compare", `local a, t = 1, {}
+print(a == a, t.x[1] < t.x[1], a ~= a, a == (a), f() == f(), 1 >= 1.0)`, []string{
+			"test:2:7: comparison of a with itself (self-compare)",
+			"test:2:15: comparison of t.x[1] with itself (self-compare)",
+			"test:2:62: comparison of 1 with itself (self-compare)",
+		}},
+		{"duplicate-key", `local k = "a"
+local t = {a = 1, ["a"] = 2, "x", [1] = 3, [2.0] = 4, "y", [k] = 5, [k] = 6, b = {a = 1}}`, []string{
+			`test:2:19: duplicate key "a" in table (first set at line 2) (duplicate-key)`,
+			"test:2:35: duplicate key 1 in table (first set at line 2) (duplicate-key)",
+			"test:2:55: duplicate key 2 in table (first set at line 2) (duplicate-key)",
+		}},
+		{"empty-if", `local x = 1
+if x == 1 then
+end
+if x then
+  -- nothing to do yet
+elseif x > 2 then
+else
+end
+if x then print(x) else -* todo *- end`, []string{
+			"test:2:1: empty if body (empty-if)",
+			"test:4:1: empty else body (empty-if)",
+			"test:6:1: empty elseif body (empty-if)",
+		}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, d := range lint(t, tt.input, nil) {
+			if d.Code == tt.rule {
+				got = append(got, d.String())
+			}
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s: got %q, expected %q", tt.rule, got, tt.expected)
+		}
+	}
+}
+
+func TestLintConfig(t *testing.T) {
+	src := `local x = 1 --luanova:ignore unused-local
+--luanova:ignore
+local y = 2
+-- luanova:ignore shadow
+local x = 3
+local function f(a) return 1 end
+print(f)`
+	config := &LintConfig{Severity: map[string]diag.Severity{"unused-param": diag.SeverityError, "shadow": diag.SeverityOff}}
+	diags := lint(t, src, config)
+	expected := []string{
+		"test:5:7: local 'x' is never used (unused-local)",
+		"test:6:18: parameter 'a' is never used (unused-param)",
+	}
+	if got := diagStrings(diags); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got %q, expected %q", got, expected)
+	}
+	if diags[0].Severity != diag.SeverityWarning || diags[1].Severity != diag.SeverityError {
+		t.Errorf("got severities %s and %s", diags[0].Severity, diags[1].Severity)
+	}
+}
+
+func TestLintFixes(t *testing.T) {
+	src := `local unused = 1
+local function f(a, b)
+  total = a
+  return a
+end
+local t = {}
+while true do break; print(1) end
+if t == nil then end
+print(f, t)
+`
+	expected := `local _unused = 1
+local function f(a, _b)
+  local total = a
+  return a
+end
+local t = {}
+while true do break end
+
+print(f, t)
+`
+	chunk, err := Parse("test", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(diag.ApplyEdits([]byte(src), diag.FixAll(Lint(chunk, nil))))
+	if got != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestRegisterLintRule(t *testing.T) {
+	rule := &LintRule{Name: "no-print", Severity: diag.SeverityInfo, Run: func(p *LintPass) {
+		for _, g := range p.Globals {
+			if g.Name.Name == "print" {
+				p.Report(g.Name, "print call")
+			}
+		}
+	}}
+	RegisterLintRule(rule)
+	defer delete(lintRules, rule.Name)
+
+	got := diagStrings(lint(t, "print(1)", nil))
+	if expected := []string{"test:1:1: print call (no-print)"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a rule twice did not panic")
+		}
+	}()
+	RegisterLintRule(rule)
+}
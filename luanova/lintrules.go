@@ -0,0 +1,323 @@
+package luanova
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/Herograme/LuaNova/luanova/diag"
+)
+
+func init() {
+	for _, r := range []*LintRule{
+		{"unused-local", "Local variables, local functions, loop variables and imports that are never read.", diag.SeverityWarning, unusedLocals},
+		{"unused-param", "Function parameters that are never read.", diag.SeverityWarning, unusedParams},
+		{"shadow", "Locals that hide a local of the same name from an enclosing scope.", diag.SeverityWarning, shadowing},
+		{"global-assign", "Assignments to globals from functions or modules, usually a missing local.", diag.SeverityWarning, globalAssign},
+		{"unreachable", "Statements after return, break or continue.", diag.SeverityWarning, unreachable},
+		{"continue-outside-loop", "continue statements that are not in a loop.", diag.SeverityError, continueOutsideLoop},
+		{"self-compare", "Comparisons of an expression with itself. x ~= x, the usual NaN test, is allowed.", diag.SeverityWarning, selfCompare},
+		{"duplicate-key", "Table constructors that set the same constant key twice.", diag.SeverityWarning, duplicateKeys},
+		{"empty-if", "if, elseif and else branches without statements or comments.", diag.SeverityWarning, emptyIf},
+	} {
+		RegisterLintRule(r)
+	}
+}
+
+// hasComment reports whether a comment starts between start and end.
+func (p *LintPass) hasComment(start, end Position) bool {
+	for _, c := range p.Chunk.Comments {
+		if c.Pos.Offset >= start.Offset && c.Pos.Offset < end.Offset {
+			return true
+		}
+	}
+	return false
+}
+
+// renameFix prefixes the declaration and assignments of v with an
+// underscore, which marks it as unused on purpose.
+func renameFix(v *Variable) *diag.Fix {
+	fix := &diag.Fix{Message: fmt.Sprintf("rename to _%s", v.Name.Name)}
+	for _, id := range append([]*Ident{v.Name}, v.Writes...) {
+		fix.Edits = append(fix.Edits, diag.TextEdit{Start: id.Pos(), End: id.Pos(), NewText: "_"})
+	}
+	return fix
+}
+
+func unused(v *Variable) bool {
+	return len(v.Reads) == 0 && !v.Exported && !strings.HasPrefix(v.Name.Name, "_")
+}
+
+func unusedLocals(p *LintPass) {
+	for _, v := range p.Vars {
+		if !unused(v) {
+			continue
+		}
+		switch v.Kind {
+		case VarLocal, VarFunction:
+			p.ReportFix(v.Name, renameFix(v), "local '%s' is never used", v.Name.Name)
+		case VarLoop:
+			p.ReportFix(v.Name, renameFix(v), "loop variable '%s' is never used", v.Name.Name)
+		case VarImport:
+			// renaming would change the imported name
+			p.Report(v.Name, "import '%s' is never used", v.Name.Name)
+		}
+	}
+}
+
+func unusedParams(p *LintPass) {
+	for _, v := range p.Vars {
+		if v.Kind == VarParam && unused(v) {
+			p.ReportFix(v.Name, renameFix(v), "parameter '%s' is never used", v.Name.Name)
+		}
+	}
+}
+
+func shadowing(p *LintPass) {
+	for _, v := range p.Vars {
+		if v.Shadows == nil || v.Kind == VarSelf || strings.HasPrefix(v.Name.Name, "_") {
+			continue
+		}
+		outer := v.Shadows.Name
+		p.ReportDiagnostic(diag.Diagnostic{
+			Pos:     v.Name.Pos(),
+			End:     v.Name.End(),
+			Message: fmt.Sprintf("'%s' shadows the local declared at line %d", v.Name.Name, outer.Pos().Line),
+			Notes:   []diag.Note{{Pos: outer.Pos(), End: outer.End(), Message: "shadowed declaration"}},
+		}, nil)
+	}
+}
+
+func globalAssign(p *LintPass) {
+	module := isModule(p.Chunk)
+	defined := map[string]bool{} // globals assigned by the main chunk
+	refs := map[string]int{}
+	for _, g := range p.Globals {
+		refs[g.Name.Name]++
+		if g.Write && g.Func == nil {
+			defined[g.Name.Name] = true
+		}
+	}
+	for _, g := range p.Globals {
+		if !g.Write || !module && (g.Func == nil || defined[g.Name.Name]) {
+			continue
+		}
+		var fix *diag.Fix
+		if refs[g.Name.Name] == 1 && declares(g.Stmt, g.Name) {
+			fix = &diag.Fix{
+				Message: "declare it local",
+				Edits:   []diag.TextEdit{{Start: g.Stmt.Pos(), End: g.Stmt.Pos(), NewText: "local "}},
+			}
+		}
+		p.ReportFix(g.Name, fix, "assignment to global '%s'; missing local?", g.Name.Name)
+	}
+}
+
+// declares reports whether adding local before s makes a declaration of
+// id alone.
+func declares(s Stmt, id *Ident) bool {
+	switch s := s.(type) {
+	case *AssignStmt:
+		return len(s.Targets) == 1 && s.Targets[0] == id
+	case *FunctionStmt:
+		return s.Name == id && s.Method == nil
+	}
+	return false
+}
+
+func unreachable(p *LintPass) {
+	Inspect(p.Chunk.Block, func(n Node) bool {
+		b, ok := n.(*Block)
+		if !ok {
+			return true
+		}
+		for i, s := range b.Stmts[:max(len(b.Stmts)-1, 0)] {
+			switch s.(type) {
+			case *ReturnStmt, *BreakStmt, *ContinueStmt:
+			default:
+				continue
+			}
+			last := b.Stmts[len(b.Stmts)-1]
+			fix := &diag.Fix{
+				Message: "remove the unreachable code",
+				Edits:   []diag.TextEdit{{Start: s.End(), End: last.End()}},
+			}
+			p.ReportFix(Span{b.Stmts[i+1].Pos(), last.End()}, fix, "unreachable code")
+			break
+		}
+		return true
+	})
+}
+
+func continueOutsideLoop(p *LintPass) {
+	loops := map[*Block]bool{}
+	Inspect(p.Chunk.Block, func(n Node) bool {
+		switch n := n.(type) {
+		case *WhileStmt:
+			loops[n.Body] = true
+		case *RepeatStmt:
+			loops[n.Body] = true
+		case *NumericForStmt:
+			loops[n.Body] = true
+		case *GenericForStmt:
+			loops[n.Body] = true
+		}
+		return true
+	})
+	var walk func(root Node, inLoop bool)
+	walk = func(root Node, inLoop bool) {
+		Inspect(root, func(n Node) bool {
+			switch n := n.(type) {
+			case *ContinueStmt:
+				if !inLoop {
+					p.Report(n, "continue outside a loop")
+				}
+			case *Block:
+				if n != root && loops[n] {
+					walk(n, true)
+					return false
+				}
+			case *FunctionExpr:
+				walk(n.Body, false)
+				return false
+			}
+			return true
+		})
+	}
+	walk(p.Chunk.Block, false)
+}
+
+var comparisons = map[int]bool{Equal: true, Less: true, LessEqual: true, Greater: true, GreaterEqual: true}
+
+func selfCompare(p *LintPass) {
+	Inspect(p.Chunk.Block, func(n Node) bool {
+		if e, ok := n.(*BinaryExpr); ok && comparisons[e.Op] && sameExpr(e.Left, e.Right) {
+			p.Report(e, "comparison of %s with itself", (&formatter{}).expr(e.Left, 0, 0))
+		}
+		return true
+	})
+}
+
+// sameExpr reports whether a and b are the same expression without side
+// effects.
+func sameExpr(a, b Expr) bool {
+	switch a := a.(type) {
+	case *Ident:
+		b, ok := b.(*Ident)
+		return ok && a.Name == b.Name
+	case *ParenExpr:
+		b, ok := b.(*ParenExpr)
+		return ok && sameExpr(a.X, b.X)
+	case *FieldExpr:
+		b, ok := b.(*FieldExpr)
+		return ok && a.Name.Name == b.Name.Name && sameExpr(a.X, b.X)
+	case *IndexExpr:
+		b, ok := b.(*IndexExpr)
+		return ok && sameExpr(a.X, b.X) && sameExpr(a.Key, b.Key)
+	case *NilExpr:
+		_, ok := b.(*NilExpr)
+		return ok
+	case *BoolExpr, *NumberExpr, *StringExpr:
+		ka, _ := constantKey(a)
+		kb, ok := constantKey(b)
+		return ok && ka == kb
+	}
+	return false
+}
+
+// constantKey returns the table key a constant expression stands for;
+// integral floats are normalized to integers like the table does.
+func constantKey(e Expr) (any, bool) {
+	switch e := e.(type) {
+	case *StringExpr:
+		return e.Value, true
+	case *BoolExpr:
+		return e.Value, true
+	case *NumberExpr:
+		if f, ok := e.Value.(float64); ok && f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+			return int64(f), true
+		}
+		return e.Value, true
+	}
+	return nil, false
+}
+
+func duplicateKeys(p *LintPass) {
+	Inspect(p.Chunk.Block, func(n Node) bool {
+		t, ok := n.(*TableExpr)
+		if !ok {
+			return true
+		}
+		seen := map[any]*TableField{}
+		var index int64
+		for _, f := range t.Fields {
+			var key any
+			switch {
+			case f.Named:
+				key = f.Key.(*Ident).Name
+			case f.Key == nil:
+				index++
+				key = index
+			default:
+				if key, ok = constantKey(f.Key); !ok {
+					continue
+				}
+			}
+			if first, dup := seen[key]; dup {
+				if s, ok := key.(string); ok {
+					key = fmt.Sprintf("%q", s)
+				}
+				p.ReportDiagnostic(diag.Diagnostic{
+					Pos:     f.Pos(),
+					End:     f.End(),
+					Message: fmt.Sprintf("duplicate key %v in table (first set at line %d)", key, first.Pos().Line),
+					Notes:   []diag.Note{{Pos: first.Pos(), End: first.End(), Message: "first set here"}},
+				}, nil)
+				continue
+			}
+			seen[key] = f
+		}
+		return true
+	})
+}
+
+func emptyIf(p *LintPass) {
+	Inspect(p.Chunk.Block, func(n Node) bool {
+		s, ok := n.(*IfStmt)
+		if !ok {
+			return true
+		}
+		for i, c := range s.Clauses {
+			if len(c.Body.Stmts) > 0 || p.hasComment(c.Cond.End(), c.Body.Pos()) {
+				continue
+			}
+			if i > 0 {
+				p.Report(c, "empty elseif body")
+				continue
+			}
+			var fix *diag.Fix
+			if len(s.Clauses) == 1 && s.Else == nil && pure(c.Cond) {
+				fix = &diag.Fix{Message: "remove the if statement", Edits: []diag.TextEdit{{Start: s.Pos(), End: s.End()}}}
+			}
+			p.ReportFix(c, fix, "empty if body")
+		}
+		if s.Else != nil && len(s.Else.Stmts) == 0 && !p.hasComment(s.Clauses[len(s.Clauses)-1].Body.End(), s.End()) {
+			p.Report(s, "empty else body")
+		}
+		return true
+	})
+}
+
+// pure reports whether evaluating e has no side effects, ignoring
+// metamethods.
+func pure(e Expr) bool {
+	pure := true
+	Inspect(e, func(n Node) bool {
+		switch n.(type) {
+		case *CallExpr, *MethodCallExpr:
+			pure = false
+		}
+		return pure
+	})
+	return pure
+}
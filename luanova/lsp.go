@@ -0,0 +1,507 @@
+package luanova
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/Herograme/LuaNova/luanova/diag"
+)
+
+// LanguageServer serves the Language Server Protocol for .lunv files.
+// Documents are synchronized incrementally and analyzed with the lexer,
+// the parser and the resolver when a request needs them; their syntax
+// errors and lint findings are published after every change. The
+// declarations of the other files of the workspace are indexed in the
+// background. Positions are in UTF-16 code units, the protocol's
+// default.
+type LanguageServer struct {
+	wmu sync.Mutex
+	w   io.Writer
+
+	docs        map[string]*document
+	index       *workspaceIndex
+	watch       bool // register a watcher of .lunv files once initialized
+	initialized bool
+	shutdown    bool
+	results     int // semantic token results sent
+}
+
+// JSON-RPC error codes.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcNotInitialized = -32002
+	rpcRequestFailed  = -32803
+)
+
+type lspMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type lspResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result"`
+}
+
+type lspErrorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   *lspError       `json:"error"`
+}
+
+type lspRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      string `json:"id"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type lspNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *lspError) Error() string { return e.Message }
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspTextDocument struct {
+	URI     string `json:"uri"`
+	Version int    `json:"version"`
+	Text    string `json:"text"`
+}
+
+type lspContentChange struct {
+	Range *lspRange `json:"range"`
+	Text  string    `json:"text"`
+}
+
+// NewLanguageServer returns a language server without documents.
+func NewLanguageServer() *LanguageServer {
+	return &LanguageServer{docs: map[string]*document{}, index: newWorkspaceIndex()}
+}
+
+// Serve answers the messages read from rw until the client sends exit
+// or closes the stream.
+func (ls *LanguageServer) Serve(rw io.ReadWriter) error {
+	ls.w = rw
+	go ls.index.run()
+	defer ls.index.stop()
+	r := bufio.NewReader(rw)
+	for {
+		data, err := readFrame(r, "lsp")
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		var msg lspMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			ls.reply(json.RawMessage("null"), nil, &lspError{rpcParseError, err.Error()})
+			continue
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		if msg.Method == "" {
+			continue // the client's answer to a request of the server
+		}
+		result, err := ls.handle(&msg)
+		if msg.ID == nil {
+			continue // a notification
+		}
+		ls.reply(msg.ID, result, err)
+	}
+}
+
+func (ls *LanguageServer) reply(id json.RawMessage, result any, err error) {
+	if err == nil {
+		ls.send(&lspResponse{JSONRPC: "2.0", ID: id, Result: result})
+		return
+	}
+	var rerr *lspError
+	if !errors.As(err, &rerr) {
+		rerr = &lspError{rpcInvalidRequest, err.Error()}
+	}
+	ls.send(&lspErrorResponse{JSONRPC: "2.0", ID: id, Error: rerr})
+}
+
+func (ls *LanguageServer) notify(method string, params any) {
+	ls.send(&lspNotification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (ls *LanguageServer) send(msg any) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	ls.wmu.Lock()
+	defer ls.wmu.Unlock()
+	fmt.Fprintf(ls.w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+func (ls *LanguageServer) handle(msg *lspMessage) (any, error) {
+	var params struct {
+		TextDocument     lspTextDocument      `json:"textDocument"`
+		ContentChanges   []lspContentChange   `json:"contentChanges"`
+		PreviousResultID string               `json:"previousResultId"`
+		Position         lspPosition          `json:"position"`
+		Range            lspRange             `json:"range"`
+		NewName          string               `json:"newName"`
+		Query            string               `json:"query"`
+		Item             lspCallHierarchyItem `json:"item"`
+		Positions        []lspPosition        `json:"positions"`
+		Changes          []struct {
+			URI string `json:"uri"`
+		} `json:"changes"`
+		RootURI          string `json:"rootUri"`
+		WorkspaceFolders []struct {
+			URI string `json:"uri"`
+		} `json:"workspaceFolders"`
+		Capabilities struct {
+			Workspace struct {
+				DidChangeWatchedFiles struct {
+					DynamicRegistration bool `json:"dynamicRegistration"`
+				} `json:"didChangeWatchedFiles"`
+			} `json:"workspace"`
+		} `json:"capabilities"`
+		Context struct {
+			IncludeDeclaration bool     `json:"includeDeclaration"`
+			Only               []string `json:"only"`
+		} `json:"context"`
+	}
+	if len(msg.Params) > 0 {
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{rpcInvalidParams, err.Error()}
+		}
+	}
+	if !ls.initialized && msg.Method != "initialize" {
+		return nil, &lspError{rpcNotInitialized, "the server is not initialized"}
+	}
+	uri := params.TextDocument.URI
+
+	switch msg.Method {
+	case "initialize":
+		ls.initialized = true
+		ls.watch = params.Capabilities.Workspace.DidChangeWatchedFiles.DynamicRegistration
+		roots := []string{}
+		for _, f := range params.WorkspaceFolders {
+			roots = append(roots, f.URI)
+		}
+		if len(roots) == 0 && params.RootURI != "" {
+			roots = append(roots, params.RootURI)
+		}
+		go ls.index.scan(roots)
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync": map[string]any{
+					"openClose": true,
+					"change":    2, // incremental
+				},
+				"semanticTokensProvider": map[string]any{
+					"legend": map[string]any{
+						"tokenTypes":     semanticTypes,
+						"tokenModifiers": semanticModifiers,
+					},
+					"full": map[string]any{"delta": true},
+				},
+				"referencesProvider":        true,
+				"documentHighlightProvider": true,
+				"renameProvider":            map[string]any{"prepareProvider": true},
+				"hoverProvider":             true,
+				"signatureHelpProvider": map[string]any{
+					"triggerCharacters":   []string{"(", ","},
+					"retriggerCharacters": []string{","},
+				},
+				"inlayHintProvider": true,
+				"completionProvider": map[string]any{
+					"triggerCharacters": []string{".", ":"},
+				},
+				"codeActionProvider": map[string]any{
+					"codeActionKinds": []string{actionQuickFix, actionRewrite, actionOrganizeImports},
+				},
+				"workspaceSymbolProvider": true,
+				"callHierarchyProvider":   true,
+				"foldingRangeProvider":    true,
+				"selectionRangeProvider":  true,
+			},
+			"serverInfo": map[string]any{"name": "luanova"},
+		}, nil
+	case "initialized":
+		if ls.watch {
+			ls.send(&lspRequest{JSONRPC: "2.0", ID: "watch", Method: "client/registerCapability", Params: map[string]any{
+				"registrations": []map[string]any{{
+					"id":              "watch",
+					"method":          "workspace/didChangeWatchedFiles",
+					"registerOptions": map[string]any{"watchers": []map[string]any{{"globPattern": "**/*.lunv"}}},
+				}},
+			}})
+		}
+		return nil, nil
+	case "$/cancelRequest", "$/setTrace", "workspace/didChangeConfiguration":
+		return nil, nil
+	case "workspace/didChangeWatchedFiles":
+		for _, c := range params.Changes {
+			if strings.HasSuffix(c.URI, ".lunv") {
+				ls.index.queue(c.URI)
+			}
+		}
+		return nil, nil
+	case "workspace/symbol":
+		return ls.index.symbols(params.Query), nil
+	case "textDocument/prepareCallHierarchy":
+		return ls.index.prepareCallHierarchy(uri, params.Position), nil
+	case "callHierarchy/incomingCalls":
+		return ls.index.incomingCalls(params.Item), nil
+	case "callHierarchy/outgoingCalls":
+		return ls.index.outgoingCalls(params.Item), nil
+	case "shutdown":
+		ls.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		doc := newDocument(uri, params.TextDocument.Version, params.TextDocument.Text)
+		ls.docs[uri] = doc
+		ls.publishDiagnostics(doc)
+		ls.index.update(doc)
+		return nil, nil
+	case "textDocument/didChange":
+		doc, err := ls.document(uri)
+		if err != nil {
+			return nil, err
+		}
+		for _, change := range params.ContentChanges {
+			doc.apply(change)
+		}
+		doc.version = params.TextDocument.Version
+		ls.publishDiagnostics(doc)
+		ls.index.update(doc)
+		return nil, nil
+	case "textDocument/didClose":
+		delete(ls.docs, uri)
+		ls.index.closed(uri)
+		ls.notify("textDocument/publishDiagnostics", map[string]any{"uri": uri, "diagnostics": []lspDiagnostic{}})
+		return nil, nil
+	case "textDocument/completion":
+		doc, err := ls.document(uri)
+		if err != nil {
+			return nil, err
+		}
+		return doc.completion(params.Position, ls.index), nil
+	case "textDocument/semanticTokens/full":
+		doc, err := ls.document(uri)
+		if err != nil {
+			return nil, err
+		}
+		data := doc.semanticTokens()
+		return map[string]any{"resultId": ls.tokenResult(doc, data), "data": data}, nil
+	case "textDocument/semanticTokens/full/delta":
+		doc, err := ls.document(uri)
+		if err != nil {
+			return nil, err
+		}
+		data := doc.semanticTokens()
+		prev, prevID := doc.tokens, doc.resultID
+		id := ls.tokenResult(doc, data)
+		if prevID == "" || prevID != params.PreviousResultID {
+			return map[string]any{"resultId": id, "data": data}, nil
+		}
+		return map[string]any{"resultId": id, "edits": semanticTokensEdits(prev, data)}, nil
+	case "textDocument/references", "textDocument/documentHighlight",
+		"textDocument/prepareRename", "textDocument/rename",
+		"textDocument/hover", "textDocument/signatureHelp", "textDocument/inlayHint",
+		"textDocument/codeAction", "textDocument/foldingRange", "textDocument/selectionRange":
+		doc, err := ls.document(uri)
+		if err != nil {
+			return nil, err
+		}
+		var result any
+		switch msg.Method {
+		case "textDocument/references":
+			result = doc.references(params.Position, params.Context.IncludeDeclaration)
+		case "textDocument/documentHighlight":
+			result = doc.highlights(params.Position)
+		case "textDocument/prepareRename":
+			result, err = doc.prepareRename(params.Position)
+		case "textDocument/rename":
+			result, err = doc.rename(params.Position, params.NewName)
+		case "textDocument/hover":
+			result = doc.hover(params.Position)
+		case "textDocument/signatureHelp":
+			result = doc.signatureHelp(params.Position)
+		case "textDocument/inlayHint":
+			result = doc.inlayHints(params.Range)
+		case "textDocument/codeAction":
+			result = doc.codeActions(params.Range, params.Context.Only)
+		case "textDocument/foldingRange":
+			result = doc.foldingRanges()
+		case "textDocument/selectionRange":
+			result = doc.selectionRanges(params.Positions)
+		}
+		if err != nil {
+			return nil, &lspError{rpcRequestFailed, err.Error()}
+		}
+		return result, nil
+	}
+	if strings.HasPrefix(msg.Method, "$/") {
+		return nil, nil
+	}
+	return nil, &lspError{rpcMethodNotFound, fmt.Sprintf("method %q is not supported", msg.Method)}
+}
+
+// publishDiagnostics sends the diagnostics of the current version of doc.
+func (ls *LanguageServer) publishDiagnostics(doc *document) {
+	ls.notify("textDocument/publishDiagnostics", map[string]any{
+		"uri":         doc.uri,
+		"version":     doc.version,
+		"diagnostics": doc.diagnostics(),
+	})
+}
+
+func (ls *LanguageServer) document(uri string) (*document, error) {
+	doc := ls.docs[uri]
+	if doc == nil {
+		return nil, &lspError{rpcInvalidParams, fmt.Sprintf("unknown document %s", uri)}
+	}
+	return doc, nil
+}
+
+// tokenResult records data as the last semantic tokens sent for doc and
+// returns their result id.
+func (ls *LanguageServer) tokenResult(doc *document, data []uint32) string {
+	ls.results++
+	doc.tokens, doc.resultID = data, fmt.Sprint(ls.results)
+	return doc.resultID
+}
+
+// document is an open text document.
+type document struct {
+	uri     string
+	version int
+	text    string
+	lines   []int // offsets of the line starts
+
+	analyzed bool
+	chunk    *Chunk // nil when the text does not parse
+	res      *Resolution
+	err      error      // of the parse
+	types    *inference // of the last version that parsed
+	linted   bool
+	diags    []diag.Diagnostic
+
+	tokens   []uint32 // semantic tokens last sent
+	resultID string
+}
+
+func newDocument(uri string, version int, text string) *document {
+	doc := &document{uri: uri, version: version}
+	doc.setText(text)
+	return doc
+}
+
+func (doc *document) setText(text string) {
+	doc.text = text
+	doc.lines = append(doc.lines[:0], 0)
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			doc.lines = append(doc.lines, i+1)
+		}
+	}
+	doc.analyzed, doc.chunk, doc.res, doc.err = false, nil, nil, nil
+	doc.linted, doc.diags = false, nil
+}
+
+// apply applies a change; a change without a range replaces the text.
+func (doc *document) apply(c lspContentChange) {
+	if c.Range == nil {
+		doc.setText(c.Text)
+		return
+	}
+	start, end := doc.offset(c.Range.Start), doc.offset(c.Range.End)
+	if end < start {
+		start, end = end, start
+	}
+	doc.setText(doc.text[:start] + c.Text + doc.text[end:])
+}
+
+// analyze parses and resolves the text once per version.
+func (doc *document) analyze() {
+	if doc.analyzed {
+		return
+	}
+	doc.analyzed = true
+	chunk, err := Parse(doc.uri, doc.text)
+	doc.err = err
+	if err == nil {
+		doc.chunk, doc.res = chunk, Resolve(chunk)
+		doc.types = inferTypes(doc.text, chunk, doc.res)
+	}
+}
+
+// offset converts a protocol position to a byte offset, clamping it to
+// the text.
+func (doc *document) offset(p lspPosition) int {
+	if p.Line < 0 {
+		return 0
+	}
+	if p.Line >= len(doc.lines) {
+		return len(doc.text)
+	}
+	i := doc.lines[p.Line]
+	for units := 0; units < p.Character && i < len(doc.text) && doc.text[i] != '\n'; {
+		r, size := utf8.DecodeRuneInString(doc.text[i:])
+		units += utf16Len(r)
+		i += size
+	}
+	return i
+}
+
+// position converts a byte offset to a protocol position.
+func (doc *document) position(offset int) lspPosition {
+	offset = max(0, min(offset, len(doc.text)))
+	line := sort.Search(len(doc.lines), func(i int) bool { return doc.lines[i] > offset }) - 1
+	return lspPosition{Line: line, Character: utf16Count(doc.text[doc.lines[line]:offset])}
+}
+
+func utf16Len(r rune) int {
+	if n := utf16.RuneLen(r); n > 0 {
+		return n
+	}
+	return 1 // invalid UTF-8 counts as U+FFFD
+}
+
+func utf16Count(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16Len(r)
+	}
+	return n
+}
@@ -0,0 +1,536 @@
+package luanova
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lspClient drives a LanguageServer like an editor would.
+type lspClient struct {
+	t        *testing.T
+	w        io.WriteCloser
+	messages chan map[string]json.RawMessage
+	id       int
+	done     chan error
+}
+
+func startLSP(t *testing.T) *lspClient {
+	t.Helper()
+	return startLSPWith(t, map[string]any{"capabilities": map[string]any{}})
+}
+
+// startLSPWith starts a server initialized with params.
+func startLSPWith(t *testing.T, params map[string]any) *lspClient {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &lspClient{t: t, w: inW, messages: make(chan map[string]json.RawMessage, 100), done: make(chan error, 1)}
+	go func() {
+		c.done <- NewLanguageServer().Serve(struct {
+			io.Reader
+			io.Writer
+		}{inR, outW})
+		outW.Close()
+	}()
+	go func() {
+		defer close(c.messages)
+		r := bufio.NewReader(outR)
+		for {
+			data, err := readFrame(r, "test")
+			if err != nil {
+				return
+			}
+			var m map[string]json.RawMessage
+			if err := json.Unmarshal(data, &m); err != nil {
+				t.Errorf("bad message %s: %v", data, err)
+				return
+			}
+			c.messages <- m
+		}
+	}()
+	t.Cleanup(func() { inW.Close() })
+	c.request("initialize", params, nil)
+	c.notify("initialized", map[string]any{})
+	return c
+}
+
+func (c *lspClient) write(msg map[string]any) {
+	c.t.Helper()
+	msg["jsonrpc"] = "2.0"
+	data, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(data), data); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *lspClient) notify(method string, params any) {
+	c.t.Helper()
+	c.write(map[string]any{"method": method, "params": params})
+}
+
+// request sends a request and decodes the result of its response into
+// result; it returns the error message of an error response.
+func (c *lspClient) request(method string, params, result any) string {
+	c.t.Helper()
+	c.id++
+	c.write(map[string]any{"id": c.id, "method": method, "params": params})
+	for {
+		var m map[string]json.RawMessage
+		select {
+		case msg, ok := <-c.messages:
+			if !ok {
+				c.t.Fatal("server closed the stream")
+			}
+			m = msg
+		case <-time.After(5 * time.Second):
+			c.t.Fatalf("timeout waiting for the response to %s", method)
+		}
+		if string(m["id"]) != fmt.Sprint(c.id) {
+			continue // a notification
+		}
+		if e, ok := m["error"]; ok {
+			var rerr lspError
+			json.Unmarshal(e, &rerr)
+			return rerr.Message
+		}
+		if result != nil {
+			if err := json.Unmarshal(m["result"], result); err != nil {
+				c.t.Fatalf("%s result %s: %v", method, m["result"], err)
+			}
+		}
+		return ""
+	}
+}
+
+func (c *lspClient) open(uri, text string) {
+	c.t.Helper()
+	c.notify("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "languageId": "luanova", "version": 1, "text": text},
+	})
+}
+
+// decodeTokens renders encoded semantic tokens as "line:col text type
+// modifiers" with 1-based positions.
+func decodeTokens(text string, data []uint32) []string {
+	lines := strings.Split(text, "\n")
+	var toks []string
+	line, col := 0, 0
+	for i := 0; i+4 < len(data); i += 5 {
+		if data[i] > 0 {
+			line, col = line+int(data[i]), 0
+		}
+		col += int(data[i+1])
+		// the tests use ASCII, so UTF-16 units are bytes
+		tok := fmt.Sprintf("%d:%d %s %s", line+1, col+1, lines[line][col:col+int(data[i+2])], semanticTypes[data[i+3]])
+		for j, m := range semanticModifiers {
+			if data[i+4]&(1<<j) != 0 {
+				tok += " " + m
+			}
+		}
+		toks = append(toks, tok)
+	}
+	return toks
+}
+
+func TestSemanticTokens(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{"locals, parameters and globals", `local function add(a, b)
+	return a + b -- sum
+end
+total = add(1, 2)
+print(total)`, []string{
+			"1:1 local keyword",
+			"1:7 function keyword",
+			"1:16 add function declaration",
+			"1:20 a parameter declaration",
+			"1:23 b parameter declaration",
+			"2:2 return keyword",
+			"2:9 a parameter",
+			"2:13 b parameter",
+			"2:15 -- sum comment",
+			"3:1 end keyword",
+			"4:1 total variable modification global",
+			"4:9 add function",
+			"4:13 1 number",
+			"4:16 2 number",
+			"5:1 print function defaultLibrary global",
+			"5:7 total variable global",
+		}},
+		{"fields, methods and libraries", `local t = {name = "x"}
+function t:greet() return self.name end
+t.name = string.upper(t.name)
+t:greet()`, []string{
+			"1:1 local keyword",
+			"1:7 t variable declaration",
+			"1:12 name property declaration",
+			`1:19 "x" string`,
+			"2:1 function keyword",
+			"2:10 t variable modification",
+			"2:12 greet method declaration",
+			"2:20 return keyword",
+			"2:27 self parameter",
+			"2:32 name property",
+			"2:37 end keyword",
+			"3:1 t variable",
+			"3:3 name property",
+			"3:10 string namespace defaultLibrary global",
+			"3:17 upper function defaultLibrary",
+			"3:23 t variable",
+			"3:25 name property",
+			"4:1 t variable",
+			"4:3 greet method",
+		}},
+		{"types and modules", `import { parse as p } from "./parser"
+type Point = {x: number, tag: Tag?}
+export local origin: Point = p("0,0")
+-* block
+comment *-`, []string{
+			"1:1 import keyword",
+			"1:10 parse property",
+			"1:16 as keyword",
+			"1:19 p variable declaration",
+			"1:23 from keyword",
+			`1:28 "./parser" string`,
+			"2:1 type keyword",
+			"2:6 Point type declaration",
+			"2:15 x property declaration",
+			"2:18 number type defaultLibrary",
+			"2:26 tag property declaration",
+			"2:31 Tag type",
+			"3:1 export keyword",
+			"3:8 local keyword",
+			"3:14 origin variable declaration",
+			"3:22 Point type",
+			"3:30 p variable",
+			`3:32 "0,0" string`,
+			"4:1 -* block comment",
+			"5:1 comment *- comment",
+		}},
+		{"syntax errors", `local x = = 1 -- still lexed`, []string{
+			"1:1 local keyword",
+			"1:13 1 number",
+			"1:15 -- still lexed comment",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := newDocument("file:///test.lunv", 1, tt.input)
+			got := decodeTokens(tt.input, doc.semanticTokens())
+			if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+				t.Errorf("tokens:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.expected, "\n"))
+			}
+		})
+	}
+}
+
+func TestLanguageServer(t *testing.T) {
+	c := startLSP(t)
+	const uri = "file:///main.lunv"
+	text := "local x = 1\nprint(x)\n"
+	c.open(uri, text)
+
+	var full struct {
+		ResultID string   `json:"resultId"`
+		Data     []uint32 `json:"data"`
+	}
+	c.request("textDocument/semanticTokens/full", map[string]any{"textDocument": map[string]any{"uri": uri}}, &full)
+	want := "1:1 local keyword|1:7 x variable declaration|1:11 1 number|2:1 print function defaultLibrary global|2:7 x variable"
+	if got := strings.Join(decodeTokens(text, full.Data), "|"); got != want {
+		t.Errorf("full tokens = %s, want %s", got, want)
+	}
+
+	// rename x to y on the first line and make the second line use a global
+	c.notify("textDocument/didChange", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "version": 2},
+		"contentChanges": []map[string]any{
+			{"range": map[string]any{"start": map[string]any{"line": 0, "character": 6}, "end": map[string]any{"line": 0, "character": 7}}, "text": "y"},
+		},
+	})
+	text = "local y = 1\nprint(x)\n"
+	var delta struct {
+		ResultID string `json:"resultId"`
+		Edits    []struct {
+			Start       int      `json:"start"`
+			DeleteCount int      `json:"deleteCount"`
+			Data        []uint32 `json:"data"`
+		} `json:"edits"`
+	}
+	c.request("textDocument/semanticTokens/full/delta",
+		map[string]any{"textDocument": map[string]any{"uri": uri}, "previousResultId": full.ResultID}, &delta)
+	if delta.ResultID == full.ResultID || len(delta.Edits) != 1 {
+		t.Fatalf("delta = %+v", delta)
+	}
+	e := delta.Edits[0]
+	data := append(append(append([]uint32{}, full.Data[:e.Start]...), e.Data...), full.Data[e.Start+e.DeleteCount:]...)
+	want = "1:1 local keyword|1:7 y variable declaration|1:11 1 number|2:1 print function defaultLibrary global|2:7 x variable global"
+	if got := strings.Join(decodeTokens(text, data), "|"); got != want {
+		t.Errorf("tokens after the delta = %s, want %s", got, want)
+	}
+	if e.Start != 24 || e.DeleteCount != 1 {
+		t.Errorf("edit replaces %d values at %d, want only the modifiers of x", e.DeleteCount, e.Start)
+	}
+
+	var stale struct {
+		Data []uint32 `json:"data"`
+	}
+	c.request("textDocument/semanticTokens/full/delta",
+		map[string]any{"textDocument": map[string]any{"uri": uri}, "previousResultId": "stale"}, &stale)
+	if len(stale.Data) != len(data) {
+		t.Errorf("a stale result id gave %d values, want the full %d", len(stale.Data), len(data))
+	}
+
+	if msg := c.request("textDocument/unknown", map[string]any{}, nil); !strings.Contains(msg, "not supported") {
+		t.Errorf("unknown method: %q", msg)
+	}
+	if msg := c.request("textDocument/semanticTokens/full", map[string]any{"textDocument": map[string]any{"uri": "file:///none.lunv"}}, nil); !strings.Contains(msg, "unknown document") {
+		t.Errorf("unknown document: %q", msg)
+	}
+	c.request("shutdown", nil, nil)
+	c.notify("exit", nil)
+	select {
+	case err := <-c.done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server did not exit")
+	}
+}
+
+func TestDocumentPositions(t *testing.T) {
+	doc := newDocument("file:///u.lunv", 1, "a = \"é𝄞\"\nb")
+	tests := []struct {
+		offset int
+		pos    lspPosition
+	}{
+		{0, lspPosition{0, 0}},
+		{5, lspPosition{0, 5}},  // after é
+		{7, lspPosition{0, 6}},  // é is one unit
+		{11, lspPosition{0, 8}}, // 𝄞 is two
+		{13, lspPosition{1, 0}},
+	}
+	for _, tt := range tests {
+		if got := doc.position(tt.offset); got != tt.pos {
+			t.Errorf("position(%d) = %v, want %v", tt.offset, got, tt.pos)
+		}
+		if got := doc.offset(tt.pos); got != tt.offset {
+			t.Errorf("offset(%v) = %d, want %d", tt.pos, got, tt.offset)
+		}
+	}
+}
+
+func TestReferencesAndRename(t *testing.T) {
+	c := startLSP(t)
+	const uri = "file:///refs.lunv"
+	c.open(uri, `local count = 0
+local function add(n)
+	count += n
+	local count = "shadow"
+	return count
+end
+function obj:get() return self, total end
+export local limit = 10
+print(count, total)`)
+	at := func(line, char int) map[string]any {
+		return map[string]any{"textDocument": map[string]any{"uri": uri}, "position": map[string]any{"line": line, "character": char}}
+	}
+	ranges := func(rs []lspRange) string {
+		var out []string
+		for _, r := range rs {
+			out = append(out, fmt.Sprintf("%d:%d-%d", r.Start.Line+1, r.Start.Character+1, r.End.Character+1))
+		}
+		return strings.Join(out, " ")
+	}
+
+	t.Run("references", func(t *testing.T) {
+		tests := []struct {
+			line, char int
+			withDecl   bool
+			expected   string
+		}{
+			{0, 6, true, "1:7-12 3:2-7 9:7-12"},
+			{2, 3, false, "3:2-7 9:7-12"},
+			{4, 10, true, "4:8-13 5:9-14"},   // the shadowing local
+			{8, 16, true, "7:33-38 9:14-19"}, // a global
+			{6, 14, true, ""},                // a method name
+		}
+		for _, tt := range tests {
+			params := at(tt.line, tt.char)
+			params["context"] = map[string]any{"includeDeclaration": tt.withDecl}
+			var locs []lspLocation
+			c.request("textDocument/references", params, &locs)
+			var rs []lspRange
+			for _, l := range locs {
+				rs = append(rs, l.Range)
+			}
+			if got := ranges(rs); got != tt.expected {
+				t.Errorf("references at %d:%d = %s, want %s", tt.line+1, tt.char+1, got, tt.expected)
+			}
+		}
+	})
+
+	t.Run("highlight", func(t *testing.T) {
+		var hs []lspDocumentHighlight
+		c.request("textDocument/documentHighlight", at(8, 8), &hs)
+		var got []string
+		for _, h := range hs {
+			got = append(got, fmt.Sprintf("%s/%d", ranges([]lspRange{h.Range}), h.Kind))
+		}
+		if want := "1:7-12/3 3:2-7/3 9:7-12/2"; strings.Join(got, " ") != want {
+			t.Errorf("highlights = %s, want %s", strings.Join(got, " "), want)
+		}
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		var prep struct {
+			Range       lspRange `json:"range"`
+			Placeholder string   `json:"placeholder"`
+		}
+		if msg := c.request("textDocument/prepareRename", at(2, 1), &prep); msg != "" || prep.Placeholder != "count" || ranges([]lspRange{prep.Range}) != "3:2-7" {
+			t.Errorf("prepareRename = %+v, %q", prep, msg)
+		}
+		params := at(0, 8)
+		params["newName"] = "sum"
+		var edit struct {
+			Changes map[string][]lspTextEdit `json:"changes"`
+		}
+		c.request("textDocument/rename", params, &edit)
+		var rs []lspRange
+		for _, e := range edit.Changes[uri] {
+			if e.NewText != "sum" {
+				t.Errorf("edit text %q", e.NewText)
+			}
+			rs = append(rs, e.Range)
+		}
+		if got := ranges(rs); got != "1:7-12 3:2-7 9:7-12" {
+			t.Errorf("rename edits = %s", got)
+		}
+
+		refused := []struct {
+			line, char int
+			newName    string
+			expected   string
+		}{
+			{0, 6, "add", "count at 3:2 would refer to the add declared at 2:16"},
+			{0, 6, "n", "count at 3:2 would refer to the n declared at 2:20"},
+			{0, 6, "total", "total at 7:33 would refer to the renamed count"},
+			{0, 6, "function", `"function" is not a valid name`},
+			{6, 26, "me", "cannot rename self"},
+			{8, 14, "sum", "cannot rename the global total"},
+			{7, 14, "max", "limit is imported or exported under this name"},
+			{6, 14, "x", "no variable to rename here"},
+		}
+		for _, tt := range refused {
+			params := at(tt.line, tt.char)
+			params["newName"] = tt.newName
+			if msg := c.request("textDocument/rename", params, nil); !strings.Contains(msg, tt.expected) {
+				t.Errorf("rename at %d:%d to %s: %q, want %q", tt.line+1, tt.char+1, tt.newName, msg, tt.expected)
+			}
+		}
+	})
+}
+
+func TestHoverSignaturesAndHints(t *testing.T) {
+	c := startLSP(t)
+	const uri = "file:///hints.lunv"
+	text := `-- Scales a point.
+local function scale(p: Point, by: number): Point
+	return {x = p.x * by, y = p.y * by}
+end
+type Point = {x: number, y: number}
+local obj = {}
+-- Gets the name.
+function obj:name(prefix: string) return prefix .. "obj" end
+local origin = scale({x = 0, y = 0}, 2)
+local label = obj:name("the ")
+print(origin.x, by)`
+	c.open(uri, text)
+	pos := func(line, char int) map[string]any {
+		return map[string]any{"textDocument": map[string]any{"uri": uri}, "position": map[string]any{"line": line, "character": char}}
+	}
+
+	hovers := []struct {
+		line, char int
+		expected   string
+	}{
+		{1, 16, "```luanova\nlocal function scale(p: Point, by: number): Point\n```\n\nScales a point."},
+		{1, 31, "```luanova\n(parameter) by: number\n```"},
+		{8, 8, "```luanova\nlocal origin: Point\n```"},
+		{9, 7, "```luanova\nlocal label: string\n```"},
+		{9, 19, "```luanova\nmethod obj:name(prefix: string): string\n```\n\nGets the name."},
+		{10, 14, "```luanova\n(field) x: number\n```"},
+		{10, 1, "```luanova\n(global) print\n```\n\nStandard library."},
+		{1, 26, "```luanova\ntype Point = {x: number, y: number}\n```"},
+		{0, 5, ""},
+	}
+	for _, tt := range hovers {
+		var h struct {
+			Contents lspMarkup `json:"contents"`
+		}
+		c.request("textDocument/hover", pos(tt.line, tt.char), &h)
+		if h.Contents.Value != tt.expected {
+			t.Errorf("hover at %d:%d = %q, want %q", tt.line+1, tt.char+1, h.Contents.Value, tt.expected)
+		}
+	}
+
+	var hints []lspInlayHint
+	c.request("textDocument/inlayHint", map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"range":        map[string]any{"start": map[string]any{"line": 5, "character": 0}, "end": map[string]any{"line": 10, "character": 0}},
+	}, &hints)
+	var got []string
+	for _, h := range hints {
+		got = append(got, fmt.Sprintf("%d:%d %s", h.Position.Line+1, h.Position.Character+1, h.Label))
+	}
+	if want := "6:10 : table|9:13 : Point|9:22 p:|9:38 by:|10:12 : string|10:24 prefix:"; strings.Join(got, "|") != want {
+		t.Errorf("inlay hints = %s, want %s", strings.Join(got, "|"), want)
+	}
+
+	signatures := []struct {
+		text     string
+		expected string
+	}{
+		{"scale({x = 1, y = 2}, ", "scale(p: Point, by: number): Point 1 [6 14] Scales a point."},
+		{"scale(", "scale(p: Point, by: number): Point 0 [6 14] Scales a point."},
+		{"obj:name(", "obj:name(prefix: string): string 0 [9 23] Gets the name."},
+		{"obj.name(obj, ", "obj:name(self, prefix: string): string 1 [9 13] Gets the name."},
+		{"print(", ""},
+		{"function scale(", ""},
+	}
+	for i, tt := range signatures {
+		// typed at the end of the document, which then does not parse
+		c.notify("textDocument/didChange", map[string]any{
+			"textDocument":   map[string]any{"uri": uri, "version": 2 + i},
+			"contentChanges": []map[string]any{{"text": text + "\n" + tt.text}},
+		})
+		var help struct {
+			Signatures []struct {
+				Label         string         `json:"label"`
+				Documentation lspMarkup      `json:"documentation"`
+				Parameters    []lspParameter `json:"parameters"`
+			} `json:"signatures"`
+			ActiveParameter int `json:"activeParameter"`
+		}
+		c.request("textDocument/signatureHelp", pos(11, len(tt.text)), &help)
+		got := ""
+		if len(help.Signatures) > 0 {
+			s := help.Signatures[0]
+			got = fmt.Sprintf("%s %d %v %s", s.Label, help.ActiveParameter, s.Parameters[0].Label, s.Documentation.Value)
+		}
+		if got != tt.expected {
+			t.Errorf("signature help after %q = %q, want %q", tt.text, got, tt.expected)
+		}
+	}
+}
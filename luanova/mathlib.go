@@ -0,0 +1,233 @@
+package luanova
+
+import (
+	"math"
+	"math/rand"
+)
+
+func openMath(s *State) {
+	lib := NewTable()
+	setFuncs(lib, "math.", map[string]GoFunction{
+		"abs":        mathAbs,
+		"acos":       mathFloatFunc(math.Acos),
+		"asin":       mathFloatFunc(math.Asin),
+		"atan":       mathAtan,
+		"ceil":       mathCeil,
+		"cos":        mathFloatFunc(math.Cos),
+		"exp":        mathFloatFunc(math.Exp),
+		"floor":      mathFloor,
+		"fmod":       mathFmod,
+		"log":        mathLog,
+		"max":        mathMax,
+		"min":        mathMin,
+		"modf":       mathModf,
+		"random":     mathRandom,
+		"randomseed": mathRandomseed,
+		"sin":        mathFloatFunc(math.Sin),
+		"sqrt":       mathFloatFunc(math.Sqrt),
+		"tan":        mathFloatFunc(math.Tan),
+		"tointeger":  mathTointeger,
+		"type":       mathType,
+		"ult":        mathUlt,
+	})
+	lib.Set("pi", math.Pi)
+	lib.Set("huge", math.Inf(1))
+	lib.Set("maxinteger", int64(math.MaxInt64))
+	lib.Set("mininteger", int64(math.MinInt64))
+	s.globals.Set("math", lib)
+	s.rand = rand.New(rand.NewSource(rand.Int63()))
+}
+
+func mathFloatFunc(f func(float64) float64) GoFunction {
+	return func(s *State) int {
+		s.Push(f(s.CheckNumber(1)))
+		return 1
+	}
+}
+
+func mathAbs(s *State) int {
+	if n, ok := s.Get(1).(int64); ok {
+		if n < 0 {
+			n = -n
+		}
+		s.Push(n)
+		return 1
+	}
+	s.Push(math.Abs(s.CheckNumber(1)))
+	return 1
+}
+
+func mathAtan(s *State) int {
+	s.Push(math.Atan2(s.CheckNumber(1), s.OptNumber(2, 1)))
+	return 1
+}
+
+// pushFloatInt pushes f as an integer when it fits, as floor and ceil do.
+func pushFloatInt(s *State, f float64) {
+	if i, ok := floatToInteger(f); ok {
+		s.Push(i)
+	} else {
+		s.Push(f)
+	}
+}
+
+func mathFloor(s *State) int {
+	if n, ok := s.Get(1).(int64); ok {
+		s.Push(n)
+		return 1
+	}
+	pushFloatInt(s, math.Floor(s.CheckNumber(1)))
+	return 1
+}
+
+func mathCeil(s *State) int {
+	if n, ok := s.Get(1).(int64); ok {
+		s.Push(n)
+		return 1
+	}
+	pushFloatInt(s, math.Ceil(s.CheckNumber(1)))
+	return 1
+}
+
+func mathFmod(s *State) int {
+	a, aInt := s.Get(1).(int64)
+	b, bInt := s.Get(2).(int64)
+	if aInt && bInt {
+		switch b {
+		case 0:
+			s.ArgError(2, "zero")
+		case -1:
+			s.Push(int64(0))
+		default:
+			s.Push(a % b)
+		}
+		return 1
+	}
+	s.Push(math.Mod(s.CheckNumber(1), s.CheckNumber(2)))
+	return 1
+}
+
+func mathLog(s *State) int {
+	x := s.CheckNumber(1)
+	if s.Get(2) == nil {
+		s.Push(math.Log(x))
+		return 1
+	}
+	switch base := s.CheckNumber(2); base {
+	case 2:
+		s.Push(math.Log2(x))
+	case 10:
+		s.Push(math.Log10(x))
+	default:
+		s.Push(math.Log(x) / math.Log(base))
+	}
+	return 1
+}
+
+func mathMax(s *State) int {
+	best := s.CheckAny(1)
+	s.CheckNumber(1)
+	for i := 2; i <= s.Top(); i++ {
+		s.CheckNumber(i)
+		if s.lessThan(best, s.Get(i)) {
+			best = s.Get(i)
+		}
+	}
+	s.Push(best)
+	return 1
+}
+
+func mathMin(s *State) int {
+	best := s.CheckAny(1)
+	s.CheckNumber(1)
+	for i := 2; i <= s.Top(); i++ {
+		s.CheckNumber(i)
+		if s.lessThan(s.Get(i), best) {
+			best = s.Get(i)
+		}
+	}
+	s.Push(best)
+	return 1
+}
+
+func mathModf(s *State) int {
+	x := s.CheckNumber(1)
+	if math.IsInf(x, 0) {
+		s.Push(x, 0.0)
+		return 2
+	}
+	ip, frac := math.Modf(x)
+	pushFloatInt(s, ip)
+	s.Push(frac)
+	return 2
+}
+
+func mathRandom(s *State) int {
+	var lo, hi int64
+	switch s.Top() {
+	case 0:
+		s.Push(s.rand.Float64())
+		return 1
+	case 1:
+		lo, hi = 1, s.CheckInteger(1)
+	case 2:
+		lo, hi = s.CheckInteger(1), s.CheckInteger(2)
+	default:
+		s.RaiseError("wrong number of arguments")
+	}
+	if lo > hi {
+		s.ArgError(s.Top(), "interval is empty")
+	}
+	span := uint64(hi) - uint64(lo)
+	var r uint64
+	if span == math.MaxUint64 {
+		r = s.rand.Uint64()
+	} else {
+		r = uint64(s.rand.Int63n(int64(min(span, math.MaxInt64-1)) + 1))
+	}
+	s.Push(lo + int64(r))
+	return 1
+}
+
+func mathRandomseed(s *State) int {
+	if s.Top() == 0 {
+		s.rand.Seed(rand.Int63())
+		return 0
+	}
+	s.rand.Seed(s.CheckInteger(1))
+	return 0
+}
+
+func mathTointeger(s *State) int {
+	switch v := s.Get(1).(type) {
+	case int64:
+		s.Push(v)
+	case float64:
+		if i, ok := floatToInteger(v); ok {
+			s.Push(i)
+		} else {
+			s.Push(nil)
+		}
+	default:
+		s.CheckAny(1)
+		s.Push(nil)
+	}
+	return 1
+}
+
+func mathType(s *State) int {
+	switch s.CheckAny(1).(type) {
+	case int64:
+		s.Push("integer")
+	case float64:
+		s.Push("float")
+	default:
+		s.Push(nil)
+	}
+	return 1
+}
+
+func mathUlt(s *State) int {
+	s.Push(uint64(s.CheckInteger(1)) < uint64(s.CheckInteger(2)))
+	return 1
+}
@@ -0,0 +1,278 @@
+package luanova
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultPath is the initial package.path: a module a.b is looked up as
+// a/b.lunv, then a/b/init.lunv.
+const DefaultPath = "?.lunv;?/init.lunv"
+
+// CycleError reports modules that require each other. Chain lists the
+// files from the first require of the module to the one that closed the
+// cycle.
+type CycleError struct {
+	Module string
+	Chain  []string
+}
+
+func (e *CycleError) Error() string {
+	return fmt.Sprintf("cyclic require of module '%s': %s", e.Module, strings.Join(e.Chain, " -> "))
+}
+
+// loadingModule is a module whose chunk is running.
+type loadingModule struct {
+	name, file string
+}
+
+func openPackage(s *State) {
+	s.pkg = NewTable()
+	s.loaded = NewTable()
+	s.pkg.Set("loaded", s.loaded)
+	s.pkg.Set("preload", NewTable())
+	s.pkg.Set("path", DefaultPath)
+	for _, name := range []string{"string", "table", "math", "debug"} {
+		s.loaded.Set(name, s.globals.GetString(name))
+	}
+	s.loaded.Set("_G", s.globals)
+	s.globals.Set("package", s.pkg)
+	s.require = NewFunction("require", pkgRequire)
+	s.globals.Set("require", s.require)
+}
+
+// SetFS sets the file system require loads modules from. The default is
+// the current directory.
+func (s *State) SetFS(fsys fs.FS) {
+	s.fsys, s.dir = fsys, ""
+}
+
+// SetDir makes require load modules from the directory dir. Their chunks
+// are named by their path on disk, like a script run from dir, and the
+// relative imports of both resolve under dir.
+func (s *State) SetDir(dir string) {
+	s.fsys, s.dir = os.DirFS(dir), dir
+}
+
+// SetPath sets package.path, the ';'-separated list of templates require
+// tries in order. Each '?' is replaced by the module name with dots
+// turned into slashes.
+func (s *State) SetPath(path string) {
+	s.pkg.Set("path", path)
+}
+
+// RegisterModule makes a Go module available to require. A GoFunction
+// is called on the first require with the module name as argument and
+// its first result becomes the module, like a Lua loader; any other
+// value is converted with ToValue and is the module itself.
+func (s *State) RegisterModule(name string, mod any) {
+	preload, _ := s.pkg.GetString("preload").(*Table)
+	if preload == nil {
+		preload = NewTable()
+		s.pkg.Set("preload", preload)
+	}
+	if f := goFunction(mod); f != nil {
+		preload.Set(name, NewFunction(name, f))
+		return
+	}
+	v := s.ToValue(mod)
+	preload.Set(name, NewFunction(name, func(s *State) int {
+		s.Push(v)
+		return 1
+	}))
+}
+
+// pkgRequire implements require(name).
+func pkgRequire(s *State) int {
+	name := s.CheckString(1)
+	if v := s.loaded.Get(name); v != nil {
+		s.Push(v)
+		return 1
+	}
+	for i, m := range s.loading {
+		if m.name == name {
+			chain := make([]string, 0, len(s.loading)-i+1)
+			for _, l := range s.loading[i:] {
+				chain = append(chain, l.file)
+			}
+			s.Raise(&CycleError{Module: name, Chain: append(chain, m.file)})
+		}
+	}
+
+	loader, file := s.findModule(name)
+	s.loading = append(s.loading, loadingModule{name, file})
+	defer func() { s.loading = s.loading[:len(s.loading)-1] }()
+
+	v := first(s.call(loader, []any{name, file}))
+	if v != nil {
+		s.loaded.Set(name, v)
+	}
+	if s.loaded.Get(name) == nil {
+		s.loaded.Set(name, true)
+	}
+	s.Push(s.loaded.Get(name), file)
+	return 2
+}
+
+// findModule returns the loader of a module and the file it comes from,
+// "preload" for registered modules.
+func (s *State) findModule(name string) (*Closure, string) {
+	var tried []string
+	if preload, ok := s.pkg.GetString("preload").(*Table); ok {
+		if f, ok := preload.GetString(name).(*Closure); ok {
+			return f, ":preload:"
+		}
+	}
+	tried = append(tried, fmt.Sprintf("no field package.preload['%s']", name))
+
+	path, ok := s.pkg.GetString("path").(string)
+	if !ok {
+		s.RaiseError("'package.path' must be a string")
+	}
+	fsys := s.fsys
+	if fsys == nil {
+		fsys = os.DirFS(".")
+	}
+	base := strings.ReplaceAll(name, ".", "/")
+	for _, tmpl := range strings.Split(path, ";") {
+		if tmpl == "" {
+			continue
+		}
+		file := strings.ReplaceAll(tmpl, "?", base)
+		src, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			tried = append(tried, fmt.Sprintf("no file '%s'", file))
+			continue
+		}
+		if s.dir != "" {
+			file = filepath.Join(s.dir, filepath.FromSlash(file))
+		}
+		fn, err := s.Load(string(src), file)
+		if err != nil {
+			s.RaiseError("error loading module '%s' from file '%s':\n\t%v", name, file, err)
+		}
+		return fn, file
+	}
+	s.RaiseError("module '%s' not found:\n\t%s", name, strings.Join(tried, "\n\t"))
+	return nil, ""
+}
+
+// ResolveImport returns the module name an import path refers to from
+// the chunk named from. Paths starting with ./ or ../ are relative to the
+// directory of from, so "./util" in "pkg/main.lunv" is module pkg.util;
+// other paths are module names as given to require. Module names are
+// relative to root, the directory modules are loaded from as it appears
+// in chunk names; an empty root means chunks are named relative to it.
+func ResolveImport(root, from, path string) (string, error) {
+	if !strings.HasPrefix(path, "./") && !strings.HasPrefix(path, "../") {
+		if path == "" {
+			return "", errors.New("empty import path")
+		}
+		return path, nil
+	}
+	outside := fmt.Errorf("import path '%s' is outside the module root", path)
+	dir := "."
+	if !strings.HasPrefix(from, "[") {
+		if root != "" {
+			rel, err := filepath.Rel(root, from)
+			if err != nil {
+				return "", outside
+			}
+			from = rel
+		}
+		dir = pathpkg.Dir(filepath.ToSlash(from))
+	}
+	p := strings.TrimSuffix(pathpkg.Join(dir, path), ".lunv")
+	if !fs.ValidPath(p) || p == "." {
+		return "", outside
+	}
+	return strings.ReplaceAll(p, "/", "."), nil
+}
+
+// ModuleExport is a name exported by a chunk.
+type ModuleExport struct {
+	Name string
+	Type bool // exported with export type
+	Node Node // the declaring node
+}
+
+// ChunkExports lists the exports of chunk in order, without running it.
+func ChunkExports(chunk *Chunk) []ModuleExport {
+	var exports []ModuleExport
+	for _, stmt := range chunk.Block.Stmts {
+		export, ok := stmt.(*ExportStmt)
+		if !ok {
+			continue
+		}
+		switch decl := export.Decl.(type) {
+		case nil:
+			for _, spec := range export.Names {
+				exports = append(exports, ModuleExport{Name: spec.LocalName().Name, Node: spec})
+			}
+		case *LocalStmt:
+			for _, b := range decl.Names {
+				exports = append(exports, ModuleExport{Name: b.Name.Name, Node: b})
+			}
+		case *LocalFunctionStmt:
+			exports = append(exports, ModuleExport{Name: decl.Name.Name, Node: decl})
+		case *TypeStmt:
+			exports = append(exports, ModuleExport{Name: decl.Name.Name, Type: true, Node: decl})
+		}
+	}
+	return exports
+}
+
+// importModule loads the module an import statement in chunk from
+// names, through require so both share the cache and cycle detection.
+func (s *State) importModule(from, path string) any {
+	name, err := ResolveImport(s.dir, from, path)
+	if err != nil {
+		s.runtimeError("%s", err)
+	}
+	return first(s.call(s.require, []any{name}))
+}
+
+// declareExports records the names a module chunk declared with
+// export, mapped to true for types, so import checks them rather than
+// the values, which may be nil.
+func (s *State) declareExports(mod *Table, declared map[string]bool) {
+	if s.exports == nil {
+		s.exports = make(map[*Table]map[string]bool)
+	}
+	s.exports[mod] = declared
+}
+
+// importName returns the export name of a module loaded by import. Types
+// exist only for the checker and import as nil.
+func (s *State) importName(mod any, path, name string) any {
+	if t, ok := mod.(*Table); ok {
+		if declared, ok := s.exports[t]; ok {
+			isType, ok := declared[name]
+			if !ok {
+				s.runtimeError("module '%s' has no export '%s'", path, name)
+			}
+			if isType {
+				return nil
+			}
+			return t.Get(name)
+		}
+	}
+	var v any
+	if s.metaField(mod, "__index") != nil || isTable(mod) {
+		v = s.index(mod, name, "")
+	}
+	if v == nil {
+		s.runtimeError("module '%s' has no export '%s'", path, name)
+	}
+	return v
+}
+
+func isTable(v any) bool {
+	_, ok := v.(*Table)
+	return ok
+}
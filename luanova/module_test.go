@@ -0,0 +1,217 @@
+package luanova
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRequire(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.lunv":          {Data: []byte(`return require("pkg.sub").value`)},
+		"pkg/sub.lunv":       {Data: []byte(`loads = (loads or 0) + 1; return {value = require("pkg").name .. "/sub"}`)},
+		"pkg/init.lunv":      {Data: []byte(`local name, file = ...; return {name = name, file = file}`)},
+		"lib/util.lunv":      {Data: []byte(`return {twice = function(x) return 2 * x end}`)},
+		"noresult.lunv":      {Data: []byte(`x = 1`)},
+		"broken.lunv":        {Data: []byte(`local = 1`)},
+		"cycle/a.lunv":       {Data: []byte(`require("cycle.b")`)},
+		"cycle/b.lunv":       {Data: []byte(`require("cycle.c")`)},
+		"cycle/c.lunv":       {Data: []byte(`require("cycle.a")`)},
+		"failing.lunv":       {Data: []byte(`error("boom")`)},
+		"lib/nested/mod.lua": {Data: []byte(`return "lua file"`)},
+	}
+	s := NewState()
+	s.SetFS(fsys)
+
+	out := runModules(t, s, `
+print(require("main"))
+print(require("pkg.sub") == require("pkg.sub"), loads)
+print(require("pkg").file, select("#", require("pkg")))
+print(require("noresult"), package.loaded.noresult)
+package.path = package.path .. ";lib/?.lunv;lib/?.lua"
+print(require("util").twice(21), require("nested.mod"))
+print(require("string") == string)
+`)
+	expected := `pkg/sub	main.lunv
+true	1
+pkg/init.lunv	1
+true	true
+42	lua file	lib/nested/mod.lua
+true
+`
+	if out != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", out, expected)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`require("missing")`, "test:1: module 'missing' not found:\n\tno field package.preload['missing']\n\tno file 'missing.lunv'\n\tno file 'missing/init.lunv'\n\tno file 'lib/missing.lunv'\n\tno file 'lib/missing.lua'"},
+		{`require("broken")`, "test:1: error loading module 'broken' from file 'broken.lunv':\n\tbroken.lunv:1:7: <name> expected near '='"},
+		{`require("failing")`, "failing.lunv:1: boom"},
+		{`require("cycle.a")`, "cycle/c.lunv:1: cyclic require of module 'cycle.a': cycle/a.lunv -> cycle/b.lunv -> cycle/c.lunv -> cycle/a.lunv"},
+	}
+	for _, tt := range tests {
+		fn, err := s.Load(tt.input, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.PCall(fn)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: got %v, expected %q", tt.input, err, tt.expected)
+		}
+	}
+
+	// the failed modules were not cached and can be retried
+	_, err := s.Call("require", "cycle.b")
+	var cycle *CycleError
+	if !errors.As(err, &cycle) || strings.Join(cycle.Chain, " ") != "cycle/b.lunv cycle/c.lunv cycle/a.lunv cycle/b.lunv" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestRegisterModule(t *testing.T) {
+	s := NewState()
+	s.SetFS(fstest.MapFS{})
+	calls := 0
+	s.RegisterModule("native", func(s *State) int {
+		calls++
+		mod := NewTable()
+		mod.Set("name", s.CheckString(1))
+		s.Push(mod)
+		return 1
+	})
+	s.RegisterModule("config", map[string]any{"debug": true, "port": 8080})
+
+	out := runModules(t, s, `
+local native = require("native")
+print(native.name, require("native") == native)
+local config = require("config")
+print(config.debug, config.port)
+`)
+	if out != "native\ttrue\ntrue\t8080\n" {
+		t.Errorf("got %q", out)
+	}
+	if calls != 1 {
+		t.Errorf("loader called %d times", calls)
+	}
+}
+
+func runModules(t *testing.T, s *State, src string) string {
+	t.Helper()
+	var out strings.Builder
+	s.Stdout = &out
+	fn, err := s.Load(src, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PCall(fn); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestImportExport(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app/main.lunv": {Data: []byte(`
+import { double, version as v, Num, nothing } from "./lib/math"
+import * as util from "../shared/util"
+import { greet } from "native"
+local n: Num = double(21)
+print(n, v, util.name, greet("x"), Num, nothing)
+`)},
+		"app/lib/math.lunv": {Data: []byte(`
+export function double(x) return 2 * x end
+export local version = "1.0"
+export type Num = number
+export local nothing
+local hidden = 1
+local answer = 42
+export { answer as theAnswer }
+version = "1.1"
+`)},
+		"shared/util.lunv":   {Data: []byte(`export local name = "util"`)},
+		"bad/missing.lunv":   {Data: []byte(`import { nope } from "../shared/util"`)},
+		"bad/escape.lunv":    {Data: []byte(`import { x } from "../../outside"`)},
+		"bad/cycle_a.lunv":   {Data: []byte(`import { b } from "./cycle_b" export local a = 1`)},
+		"bad/cycle_b.lunv":   {Data: []byte(`import { a } from "./cycle_a" export local b = 1`)},
+		"bad/nested.lunv":    {Data: []byte(`do import { x } from "./y" end`)},
+		"bad/return.lunv":    {Data: []byte(`export local x = 1 return x`)},
+		"bad/undef.lunv":     {Data: []byte(`export { y }`)},
+		"bad/duplicate.lunv": {Data: []byte(`export local x = 1 export { x }`)},
+	}
+	s := NewState()
+	s.SetFS(fsys)
+	s.RegisterModule("native", map[string]any{"greet": func(n string) string { return "hi " + n }})
+
+	var out strings.Builder
+	s.Stdout = &out
+	if _, err := s.Call("require", "app.main"); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "42\t1.1\tutil\thi x\tnil\tnil\n" {
+		t.Errorf("got %q", out.String())
+	}
+
+	rs, err := s.Call("require", "app.lib.math")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod := rs[0].(*Table)
+	if mod.GetString("theAnswer") != int64(42) || mod.GetString("hidden") != nil || mod.GetString("Num") != nil {
+		t.Errorf("module table: theAnswer=%v hidden=%v", mod.GetString("theAnswer"), mod.GetString("hidden"))
+	}
+
+	tests := []struct {
+		module   string
+		expected string
+	}{
+		{"bad.missing", "bad/missing.lunv:1: module '../shared/util' has no export 'nope'"},
+		{"bad.escape", "bad/escape.lunv:1: import path '../../outside' is outside the module root"},
+		{"bad.cycle_a", "bad/cycle_b.lunv:1: cyclic require of module 'bad.cycle_a': bad/cycle_a.lunv -> bad/cycle_b.lunv -> bad/cycle_a.lunv"},
+		{"bad.nested", "bad/nested.lunv:1:4: import must be at the top level of a chunk"},
+		{"bad.return", "bad/return.lunv:1:20: a module with exports cannot return values"},
+		{"bad.undef", "bad/undef.lunv:1:10: cannot export 'y': not a local"},
+		{"bad.duplicate", "bad/duplicate.lunv:1:29: duplicate export 'x'"},
+	}
+	for _, tt := range tests {
+		_, err := s.Call("require", tt.module)
+		if err == nil || !strings.HasSuffix(err.Error(), tt.expected) {
+			t.Errorf("%s: got %v, expected %q", tt.module, err, tt.expected)
+		}
+	}
+}
+
+func TestResolveImport(t *testing.T) {
+	tests := []struct {
+		root, from, path string
+		expected         string
+	}{
+		{"", "main.lunv", "./util", "util"},
+		{"", "pkg/main.lunv", "./util", "pkg.util"},
+		{"", "pkg/sub/main.lunv", "../util.lunv", "pkg.util"},
+		{"", "pkg/main.lunv", "json", "json"},
+		{"", `[string "x"]`, "./util", "util"},
+		{"", "main.lunv", "../util", ""},
+		{"proj", "proj/main.lunv", "./util", "util"},
+		{"proj", "proj/pkg/main.lunv", "../util", "util"},
+		{"/home/proj", "/home/proj/main.lunv", "./pkg/util", "pkg.util"},
+		{"/home/proj", "/home/proj/main.lunv", "../util", ""},
+		{"proj", "/home/proj/main.lunv", "./util", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := ResolveImport(tt.root, tt.from, tt.path)
+		if tt.expected == "" {
+			if err == nil {
+				t.Errorf("%s from %s: expected an error, got %q", tt.path, tt.from, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.expected {
+			t.Errorf("%s from %s: got %q, %v, expected %q", tt.path, tt.from, got, err, tt.expected)
+		}
+	}
+}
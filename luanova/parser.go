@@ -0,0 +1,1198 @@
+package luanova
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Herograme/LuaNova/luanova/diag"
+)
+
+// SyntaxError is an error found while parsing or compiling. End is the
+// end of the offending source when known.
+type SyntaxError struct {
+	Source string
+	Pos    Position
+	End    Position
+	Msg    string
+	Notes  []diag.Note
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.Source, e.Pos.Line, e.Pos.Column, e.Msg)
+}
+
+// Diagnostic returns e as a diagnostic for rendering.
+func (e *SyntaxError) Diagnostic() diag.Diagnostic {
+	end := e.End
+	if end.Line == 0 {
+		end = e.Pos
+	}
+	return diag.Diagnostic{Source: e.Source, Pos: e.Pos, End: end, Severity: diag.SeverityError, Message: e.Msg, Notes: e.Notes}
+}
+
+// ErrorList is the list of syntax errors of a chunk.
+type ErrorList []*SyntaxError
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Diagnostics returns the errors as diagnostics for rendering.
+func (l ErrorList) Diagnostics() []diag.Diagnostic {
+	diags := make([]diag.Diagnostic, len(l))
+	for i, e := range l {
+		diags[i] = e.Diagnostic()
+	}
+	return diags
+}
+
+// Err returns nil for an empty list and the list itself otherwise.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// Operator priorities, as in Lua's lparser.c: {left, right}.
+var binaryPriority = map[int][2]int{
+	Or:           {1, 1},
+	And:          {2, 2},
+	Less:         {3, 3},
+	Greater:      {3, 3},
+	LessEqual:    {3, 3},
+	GreaterEqual: {3, 3},
+	NotEqual:     {3, 3},
+	Equal:        {3, 3},
+	Concat:       {9, 8},
+	Plus:         {10, 10},
+	Sub:          {10, 10},
+	Multi:        {11, 11},
+	Div:          {11, 11},
+	Mod:          {11, 11},
+	Po:           {14, 13},
+}
+
+const unaryPriority = 12
+
+// maxSyntaxLevels bounds the nesting of statements, expressions and
+// types, like LUAI_MAXCCALLS in Lua. maxSyntaxDepth bounds the depth of
+// the syntax tree, which operators and suffixes chained in one
+// expression also add to. Together they keep the compiler and the
+// evaluator from overflowing the Go stack.
+const (
+	maxSyntaxLevels = 1000
+	maxSyntaxDepth  = 1 << 16
+)
+
+// bailout unwinds the parser to the enclosing statement after an error.
+type bailout struct{}
+
+// abort unwinds the whole parse after an error the parser cannot
+// recover from.
+type abort struct{}
+
+type Parser struct {
+	l        *Lexer
+	name     string
+	buf      []Token
+	tok      Token
+	peek     Token
+	prevEnd  Position
+	comments []Token
+	errors   ErrorList
+	level    int // of nesting
+	depth    int // of the syntax tree
+}
+
+func NewParser(name string, l *Lexer) *Parser {
+	p := &Parser{l: l, name: name}
+	p.tok = p.scan()
+	p.peek = p.scan()
+	return p
+}
+
+// Parse parses a whole chunk. The returned chunk is usable even when err
+// is not nil; err is then an ErrorList.
+func Parse(name, src string) (*Chunk, error) {
+	p := NewParser(name, NewLexer(src))
+	chunk := p.ParseChunk()
+	return chunk, p.Errors().Err()
+}
+
+func (p *Parser) Errors() ErrorList {
+	return p.errors
+}
+
+// lex returns the next token that is not a comment.
+func (p *Parser) lex() Token {
+	if n := len(p.buf); n > 0 {
+		tok := p.buf[n-1]
+		p.buf = p.buf[:n-1]
+		return tok
+	}
+	for {
+		tok := p.l.NextToken()
+		if tok.Type == Comment || tok.Type == CommentBlock {
+			p.comments = append(p.comments, tok)
+			continue
+		}
+		return tok
+	}
+}
+
+func (p *Parser) unlex(tok Token) {
+	p.buf = append(p.buf, tok)
+}
+
+// scan returns the next token, joining the pieces the lexer produces for
+// numerals with a fraction ("1", ".", "5") back into a single Literal.
+func (p *Parser) scan() Token {
+	tok := p.lex()
+	isNum := func(t Token) bool { return t.Type == Literal && t.Literal != "" && isDigit(t.Literal[0]) }
+	switch {
+	case isNum(tok):
+		dot := p.lex()
+		if dot.Type != Dot || dot.Pos.Offset != tok.End.Offset {
+			p.unlex(dot)
+			return tok
+		}
+		tok.Literal += "."
+		tok.End = dot.End
+		frac := p.lex()
+		if isNum(frac) && frac.Pos.Offset == dot.End.Offset {
+			tok.Literal += frac.Literal
+			tok.End = frac.End
+		} else {
+			p.unlex(frac)
+		}
+	case tok.Type == Dot:
+		frac := p.lex()
+		if isNum(frac) && frac.Pos.Offset == tok.End.Offset {
+			return Token{Type: Literal, Literal: "." + frac.Literal, Pos: tok.Pos, End: frac.End}
+		}
+		p.unlex(frac)
+	}
+	return tok
+}
+
+// enterLevel counts one more level of nesting. Callers defer
+// restoreLevel with the level and depth they started at.
+func (p *Parser) enterLevel() {
+	p.level++
+	p.deepen()
+	if p.level > maxSyntaxLevels {
+		p.tooDeep()
+	}
+}
+
+// deepen counts an operator or suffix that adds to the depth of the
+// syntax tree without nesting.
+func (p *Parser) deepen() {
+	p.depth++
+	if p.depth > maxSyntaxDepth {
+		p.tooDeep()
+	}
+}
+
+func (p *Parser) tooDeep() {
+	p.record(p.tok.Pos, p.tok.Pos, nil, "chunk has too many syntax levels")
+	panic(abort{})
+}
+
+func (p *Parser) restoreLevel(level, depth int) {
+	p.level, p.depth = level, depth
+}
+
+func (p *Parser) next() {
+	p.prevEnd = p.tok.End
+	p.tok = p.peek
+	p.peek = p.scan()
+}
+
+func (p *Parser) errorAt(pos Position, format string, args ...any) {
+	p.errorSpan(pos, pos, nil, format, args...)
+}
+
+// errorSpan records an error about the source from pos to end and
+// bails out.
+func (p *Parser) errorSpan(pos, end Position, notes []diag.Note, format string, args ...any) {
+	p.record(pos, end, notes, format, args...)
+	panic(bailout{})
+}
+
+// record records an error unless one was already reported at pos.
+func (p *Parser) record(pos, end Position, notes []diag.Note, format string, args ...any) {
+	if n := len(p.errors); n > 0 && p.errors[n-1].Pos == pos {
+		return
+	}
+	p.errors = append(p.errors, &SyntaxError{Source: p.name, Pos: pos, End: end, Msg: fmt.Sprintf(format, args...), Notes: notes})
+}
+
+func (p *Parser) errorNear(format string, args ...any) {
+	p.errorSpan(p.tok.Pos, p.tok.End, nil, "%s near %s", fmt.Sprintf(format, args...), tokenText(p.tok))
+}
+
+func tokenText(tok Token) string {
+	switch tok.Type {
+	case EOF:
+		return "<eof>"
+	case StringDelim:
+		return "'\"" + tok.Literal + "\"'"
+	}
+	return "'" + tok.Literal + "'"
+}
+
+func (p *Parser) expect(typ int, what string) Token {
+	if p.tok.Type != typ {
+		p.errorNear("'%s' expected", what)
+	}
+	tok := p.tok
+	p.next()
+	return tok
+}
+
+// expectMatch expects the token closing a construct opened at open. At
+// the end of the source the construct is closed there after the error
+// is recorded, so that the chunk keeps what is being typed.
+func (p *Parser) expectMatch(typ int, what, opener string, open Position) {
+	if p.tok.Type == typ {
+		p.next()
+		return
+	}
+	msg := fmt.Sprintf("'%s' expected", what)
+	if open.Line != p.tok.Pos.Line {
+		msg += fmt.Sprintf(" (to close '%s' at line %d)", opener, open.Line)
+	}
+	end := open
+	end.Offset += len(opener)
+	end.Column += len(opener)
+	note := diag.Note{Pos: open, End: end, Message: fmt.Sprintf("'%s' opened here", opener)}
+	if p.tok.Type == EOF {
+		p.record(p.tok.Pos, p.tok.End, []diag.Note{note}, "%s near %s", msg, tokenText(p.tok))
+		p.prevEnd = p.tok.End
+		return
+	}
+	p.errorSpan(p.tok.Pos, p.tok.End, []diag.Note{note}, "%s near %s", msg, tokenText(p.tok))
+}
+
+func isName(tok Token) bool {
+	return tok.Type == Literal && tok.Literal != "" && !isDigit(tok.Literal[0]) && tok.Literal[0] != '.'
+}
+
+func (p *Parser) parseIdent() *Ident {
+	if !isName(p.tok) {
+		p.errorNear("<name> expected")
+	}
+	id := &Ident{Span: Span{p.tok.Pos, p.tok.End}, Name: p.tok.Literal}
+	p.next()
+	return id
+}
+
+func (p *Parser) ParseChunk() *Chunk {
+	chunk := &Chunk{Name: p.name}
+	start := p.tok.Pos
+	var stmts []Stmt
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(abort); !ok {
+				panic(r)
+			}
+			chunk.Block = &Block{Span: Span{start, p.tok.End}, Stmts: stmts}
+			chunk.Comments = p.comments
+		}
+	}()
+	for {
+		stmts = append(stmts, p.parseStatements()...)
+		if p.tok.Type == EOF {
+			break
+		}
+		p.recover(func() { p.errorNear("'<eof>' expected") })
+		p.next()
+	}
+	chunk.Block = &Block{Span: Span{start, p.tok.End}, Stmts: stmts}
+	chunk.Comments = p.comments
+	return chunk
+}
+
+// recover runs f and swallows the bailout raised by a syntax error.
+func (p *Parser) recover(f func()) (failed bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(bailout); !ok {
+				panic(r)
+			}
+			failed = true
+		}
+	}()
+	f()
+	return false
+}
+
+func blockFollow(typ int) bool {
+	switch typ {
+	case EOF, End, Else, ElseIf, Until:
+		return true
+	}
+	return false
+}
+
+func (p *Parser) parseBlock() *Block {
+	start := p.tok.Pos
+	stmts := p.parseStatements()
+	end := p.prevEnd
+	if len(stmts) == 0 {
+		end = start
+	}
+	return &Block{Span: Span{start, end}, Stmts: stmts}
+}
+
+func (p *Parser) parseStatements() []Stmt {
+	var stmts []Stmt
+	for !blockFollow(p.tok.Type) {
+		if p.tok.Type == Semi {
+			p.next()
+			continue
+		}
+		var stmt Stmt
+		if p.recover(func() { stmt = p.parseStatement() }) {
+			p.sync()
+			continue
+		}
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
+
+// sync skips to the start of the next statement after a syntax error: a
+// statement keyword, or a name that starts a new line.
+func (p *Parser) sync() {
+	start := p.tok.Pos.Offset
+	for {
+		moved := p.tok.Pos.Offset != start
+		switch p.tok.Type {
+		case EOF, End, Else, ElseIf, Until:
+			return
+		case Local, Function, If, While, For, Repeat, Return, Break, Continue, Do:
+			if moved {
+				return
+			}
+		case Literal:
+			if moved && isName(p.tok) && p.tok.Pos.Line > p.prevEnd.Line {
+				return
+			}
+		}
+		p.next()
+	}
+}
+
+func (p *Parser) parseStatement() Stmt {
+	defer p.restoreLevel(p.level, p.depth)
+	p.enterLevel()
+	start := p.tok.Pos
+	switch p.tok.Type {
+	case If:
+		return p.parseIf()
+	case While:
+		open := p.tok.Pos
+		p.next()
+		cond := p.parseExpr()
+		p.expect(Do, "do")
+		body := p.parseBlock()
+		p.expectMatch(End, "end", "while", open)
+		return &WhileStmt{Span: Span{start, p.prevEnd}, Cond: cond, Body: body}
+	case Do:
+		open := p.tok.Pos
+		p.next()
+		body := p.parseBlock()
+		p.expectMatch(End, "end", "do", open)
+		return &DoStmt{Span: Span{start, p.prevEnd}, Body: body}
+	case For:
+		return p.parseFor()
+	case Repeat:
+		open := p.tok.Pos
+		p.next()
+		body := p.parseBlock()
+		p.expectMatch(Until, "until", "repeat", open)
+		cond := p.parseExpr()
+		return &RepeatStmt{Span: Span{start, p.prevEnd}, Body: body, Cond: cond}
+	case Function:
+		return p.parseFunctionStmt()
+	case Local:
+		p.next()
+		if p.tok.Type == Function {
+			p.next()
+			name := p.parseIdent()
+			fn := p.parseFunctionBody(start, name.Name)
+			return &LocalFunctionStmt{Span: Span{start, p.prevEnd}, Name: name, Func: fn}
+		}
+		return p.parseLocal(start)
+	case Return:
+		p.next()
+		var values []Expr
+		if !blockFollow(p.tok.Type) && p.tok.Type != Semi && startsExpr(p.tok.Type) {
+			values = p.parseExprList()
+		}
+		if p.tok.Type == Semi {
+			p.next()
+		}
+		return &ReturnStmt{Span: Span{start, p.prevEnd}, Values: values}
+	case Break:
+		p.next()
+		return &BreakStmt{Span: Span{start, p.prevEnd}}
+	case Continue:
+		p.next()
+		return &ContinueStmt{Span: Span{start, p.prevEnd}}
+	}
+	// import and export start a declaration only when followed by what
+	// the declaration needs, so `import(x)` and `export.x = 1` stay
+	// ordinary statements; a call like export{...} needs parentheses
+	if isName(p.tok) {
+		switch LookupContextual(p.tok.Literal) {
+		case Import:
+			if p.peek.Type == LBrace || p.peek.Type == Multi {
+				return p.parseImport()
+			}
+		case Export:
+			switch {
+			case p.peek.Type == Function, p.peek.Type == Local, p.peek.Type == LBrace,
+				isName(p.peek) && p.peek.Literal == "type":
+				return p.parseExport()
+			}
+		}
+	}
+	if p.tok.Type == Literal && p.tok.Literal == "type" && isName(p.peek) {
+		p.next()
+		name := p.parseIdent()
+		p.expect(Assign, "=")
+		typ := p.parseType()
+		return &TypeStmt{Span: Span{start, p.prevEnd}, Name: name, Type: typ}
+	}
+	return p.parseExprStatement()
+}
+
+func (p *Parser) parseImport() Stmt {
+	start := p.tok.Pos
+	p.next()
+	stmt := &ImportStmt{}
+	if p.tok.Type == Multi {
+		p.next()
+		p.expectContextual(As, "as")
+		stmt.Namespace = p.parseIdent()
+	} else {
+		stmt.Names = p.parseImportList()
+	}
+	p.expectContextual(From, "from")
+	if p.tok.Type != StringDelim {
+		p.errorNear("module path expected")
+	}
+	stmt.Path = p.parseString()
+	stmt.Span = Span{start, p.prevEnd}
+	return stmt
+}
+
+func (p *Parser) parseExport() Stmt {
+	start := p.tok.Pos
+	p.next()
+	stmt := &ExportStmt{}
+	switch p.tok.Type {
+	case LBrace:
+		stmt.Names = p.parseImportList()
+	case Function:
+		fstart := p.tok.Pos
+		p.next()
+		name := p.parseIdent()
+		fn := p.parseFunctionBody(fstart, name.Name)
+		stmt.Decl = &LocalFunctionStmt{Span: Span{fstart, p.prevEnd}, Name: name, Func: fn}
+	default:
+		stmt.Decl = p.parseStatement()
+	}
+	stmt.Span = Span{start, p.prevEnd}
+	return stmt
+}
+
+// parseImportList parses `{ a, b as c }`.
+func (p *Parser) parseImportList() []*ImportSpec {
+	open := p.tok.Pos
+	p.expect(LBrace, "{")
+	var specs []*ImportSpec
+	for p.tok.Type != RBrace {
+		spec := &ImportSpec{Name: p.parseIdent()}
+		if p.isContextual(As) {
+			p.next()
+			spec.Alias = p.parseIdent()
+		}
+		spec.Span = Span{spec.Name.Pos(), p.prevEnd}
+		specs = append(specs, spec)
+		if p.tok.Type != Comma {
+			break
+		}
+		p.next()
+	}
+	p.expectMatch(RBrace, "}", "{", open)
+	return specs
+}
+
+func (p *Parser) isContextual(kw int) bool {
+	return isName(p.tok) && LookupContextual(p.tok.Literal) == kw
+}
+
+func (p *Parser) expectContextual(kw int, what string) {
+	if !p.isContextual(kw) {
+		p.errorNear("'%s' expected", what)
+	}
+	p.next()
+}
+
+func startsExpr(typ int) bool {
+	switch typ {
+	case Nil, True, False, Literal, StringDelim, Dots, Function, LBrace, LParen, Sub, Not, Len:
+		return true
+	}
+	return false
+}
+
+func (p *Parser) parseIf() Stmt {
+	start := p.tok.Pos
+	open := start
+	stmt := &IfStmt{}
+	for p.tok.Type == If || p.tok.Type == ElseIf {
+		clauseStart := p.tok.Pos
+		p.next()
+		cond := p.parseExpr()
+		p.expect(Then, "then")
+		body := p.parseBlock()
+		stmt.Clauses = append(stmt.Clauses, &IfClause{Span: Span{clauseStart, p.prevEnd}, Cond: cond, Body: body})
+	}
+	if p.tok.Type == Else {
+		p.next()
+		stmt.Else = p.parseBlock()
+	}
+	p.expectMatch(End, "end", "if", open)
+	stmt.Span = Span{start, p.prevEnd}
+	return stmt
+}
+
+func (p *Parser) parseBinding() *Binding {
+	name := p.parseIdent()
+	b := &Binding{Name: name}
+	if p.tok.Type == Colom {
+		p.next()
+		b.Type = p.parseType()
+	}
+	b.Span = Span{name.Pos(), p.prevEnd}
+	return b
+}
+
+func (p *Parser) parseFor() Stmt {
+	start := p.tok.Pos
+	open := start
+	p.next()
+	first := p.parseBinding()
+	if p.tok.Type == Assign {
+		p.next()
+		stmt := &NumericForStmt{Var: first}
+		stmt.Start = p.parseExpr()
+		p.expect(Comma, ",")
+		stmt.Limit = p.parseExpr()
+		if p.tok.Type == Comma {
+			p.next()
+			stmt.Step = p.parseExpr()
+		}
+		p.expect(Do, "do")
+		stmt.Body = p.parseBlock()
+		p.expectMatch(End, "end", "for", open)
+		stmt.Span = Span{start, p.prevEnd}
+		return stmt
+	}
+	stmt := &GenericForStmt{Names: []*Binding{first}}
+	for p.tok.Type == Comma {
+		p.next()
+		stmt.Names = append(stmt.Names, p.parseBinding())
+	}
+	if p.tok.Type != In {
+		p.errorNear("'=' or 'in' expected")
+	}
+	p.next()
+	stmt.Exprs = p.parseExprList()
+	p.expect(Do, "do")
+	stmt.Body = p.parseBlock()
+	p.expectMatch(End, "end", "for", open)
+	stmt.Span = Span{start, p.prevEnd}
+	return stmt
+}
+
+func (p *Parser) parseFunctionStmt() Stmt {
+	start := p.tok.Pos
+	p.next()
+	id := p.parseIdent()
+	var name Expr = id
+	fullName := id.Name
+	for p.tok.Type == Dot {
+		p.next()
+		field := p.parseIdent()
+		name = &FieldExpr{Span: Span{start, field.End()}, X: name, Name: field}
+		fullName += "." + field.Name
+	}
+	var method *Ident
+	if p.tok.Type == Colom {
+		p.next()
+		method = p.parseIdent()
+		fullName += ":" + method.Name
+	}
+	fn := p.parseFunctionBody(start, fullName)
+	if method != nil {
+		self := &Ident{Span: Span{method.Pos(), method.Pos()}, Name: "self"}
+		fn.Params = append([]*Binding{{Span: self.Span, Name: self}}, fn.Params...)
+	}
+	return &FunctionStmt{Span: Span{start, p.prevEnd}, Name: name, Method: method, Func: fn}
+}
+
+func (p *Parser) parseLocal(start Position) Stmt {
+	stmt := &LocalStmt{Names: []*Binding{p.parseBinding()}}
+	for p.tok.Type == Comma {
+		p.next()
+		stmt.Names = append(stmt.Names, p.parseBinding())
+	}
+	if p.tok.Type == Assign {
+		p.next()
+		stmt.Values = p.parseExprList()
+		nameFunctions(stmt.Values, stmt.Names)
+	}
+	stmt.Span = Span{start, p.prevEnd}
+	return stmt
+}
+
+// nameFunctions gives anonymous functions assigned to names a name for
+// stack traces, like `local f = function() end`.
+func nameFunctions(values []Expr, names []*Binding) {
+	for i, v := range values {
+		if fn, ok := v.(*FunctionExpr); ok && fn.Name == "" && i < len(names) {
+			fn.Name = names[i].Name.Name
+		}
+	}
+}
+
+var compoundOps = map[int]int{
+	PlusAssign:  Plus,
+	SubAssign:   Sub,
+	MultiAssign: Multi,
+	DivAssign:   Div,
+	ModAssign:   Mod,
+}
+
+func (p *Parser) parseExprStatement() Stmt {
+	start := p.tok.Pos
+	target := p.parseSuffixedExpr()
+	if op, ok := compoundOps[p.tok.Type]; ok {
+		p.checkAssignable(target)
+		p.next()
+		value := p.parseExpr()
+		return &CompoundAssignStmt{Span: Span{start, p.prevEnd}, Op: op, Target: target, Value: value}
+	}
+	// `..=` is lexed as Concat followed by Assign
+	if p.tok.Type == Concat && p.peek.Type == Assign && p.peek.Pos.Offset == p.tok.End.Offset {
+		p.checkAssignable(target)
+		p.next()
+		p.next()
+		value := p.parseExpr()
+		return &CompoundAssignStmt{Span: Span{start, p.prevEnd}, Op: Concat, Target: target, Value: value}
+	}
+	if p.tok.Type == Assign || p.tok.Type == Comma {
+		targets := []Expr{target}
+		p.checkAssignable(target)
+		for p.tok.Type == Comma {
+			p.next()
+			t := p.parseSuffixedExpr()
+			p.checkAssignable(t)
+			targets = append(targets, t)
+		}
+		p.expect(Assign, "=")
+		values := p.parseExprList()
+		for i, v := range values {
+			if fn, ok := v.(*FunctionExpr); ok && fn.Name == "" && i < len(targets) {
+				fn.Name = exprName(targets[i])
+			}
+		}
+		return &AssignStmt{Span: Span{start, p.prevEnd}, Targets: targets, Values: values}
+	}
+	switch target.(type) {
+	case *CallExpr, *MethodCallExpr:
+		return &CallStmt{Span: Span{start, p.prevEnd}, Call: target}
+	}
+	p.errorNear("syntax error")
+	return nil
+}
+
+// exprName renders simple variable expressions such as `a.b.c`.
+func exprName(e Expr) string {
+	switch e := e.(type) {
+	case *Ident:
+		return e.Name
+	case *FieldExpr:
+		if x := exprName(e.X); x != "" {
+			return x + "." + e.Name.Name
+		}
+	}
+	return ""
+}
+
+func (p *Parser) checkAssignable(e Expr) {
+	switch e.(type) {
+	case *Ident, *FieldExpr, *IndexExpr:
+		return
+	}
+	p.errorNear("syntax error")
+}
+
+func (p *Parser) parseExprList() []Expr {
+	list := []Expr{p.parseExpr()}
+	for p.tok.Type == Comma {
+		p.next()
+		list = append(list, p.parseExpr())
+	}
+	return list
+}
+
+func (p *Parser) parseExpr() Expr {
+	return p.parseSubExpr(0)
+}
+
+func (p *Parser) parseSubExpr(limit int) Expr {
+	defer p.restoreLevel(p.level, p.depth)
+	p.enterLevel()
+	start := p.tok.Pos
+	var left Expr
+	switch p.tok.Type {
+	case Not, Sub, Len:
+		op := p.tok.Type
+		p.next()
+		x := p.parseSubExpr(unaryPriority)
+		left = &UnaryExpr{Span: Span{start, p.prevEnd}, Op: op, X: x}
+	default:
+		left = p.parseSimpleExpr()
+	}
+	for {
+		op := p.tok.Type
+		prio, ok := binaryPriority[op]
+		if !ok || prio[0] <= limit {
+			return left
+		}
+		// `x ..= y` is a statement, not a concatenation
+		if op == Concat && p.peek.Type == Assign && p.peek.Pos.Offset == p.tok.End.Offset {
+			return left
+		}
+		p.deepen()
+		opPos := p.tok.Pos
+		p.next()
+		right := p.parseSubExpr(prio[1])
+		left = &BinaryExpr{Span: Span{start, p.prevEnd}, Op: op, OpPos: opPos, Left: left, Right: right}
+	}
+}
+
+func (p *Parser) parseSimpleExpr() Expr {
+	tok := p.tok
+	span := Span{tok.Pos, tok.End}
+	switch tok.Type {
+	case Nil:
+		p.next()
+		return &NilExpr{Span: span}
+	case True, False:
+		p.next()
+		return &BoolExpr{Span: span, Value: tok.Type == True}
+	case Dots:
+		p.next()
+		return &VarargExpr{Span: span}
+	case StringDelim:
+		return p.parseString()
+	case LBrace:
+		return p.parseTable()
+	case Function:
+		p.next()
+		return p.parseFunctionBody(tok.Pos, "")
+	case Literal:
+		if !isName(tok) {
+			p.next()
+			v, ok := parseNumber(tok.Literal)
+			if !ok || strings.ContainsAny(tok.Literal, " \t\n") {
+				p.errorSpan(tok.Pos, tok.End, nil, "malformed number near '%s'", tok.Literal)
+			}
+			return &NumberExpr{Span: span, Raw: tok.Literal, Value: v}
+		}
+	}
+	return p.parseSuffixedExpr()
+}
+
+func (p *Parser) parseString() *StringExpr {
+	tok := p.tok
+	if tok.End.Offset-tok.Pos.Offset < len(tok.Literal)+2 {
+		p.errorSpan(tok.Pos, tok.End, nil, "unfinished string near '\"%s'", tok.Literal)
+	}
+	p.next()
+	value, err := unquote(tok.Literal)
+	if err != nil {
+		p.errorSpan(tok.Pos, tok.End, nil, "%s in string \"%s\"", err, tok.Literal)
+	}
+	return &StringExpr{Span: Span{tok.Pos, tok.End}, Raw: tok.Literal, Value: value}
+}
+
+// unquote decodes Lua escape sequences.
+func unquote(raw string) (string, error) {
+	if strings.IndexByte(raw, '\\') < 0 {
+		return raw, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i >= len(raw) {
+			return "", fmt.Errorf("unfinished escape sequence")
+		}
+		switch c = raw[i]; c {
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n', '\n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '\\', '"', '\'':
+			b.WriteByte(c)
+		case '\r':
+			b.WriteByte('\n')
+			if i+1 < len(raw) && raw[i+1] == '\n' {
+				i++
+			}
+		case 'z':
+			for i+1 < len(raw) && isspace(raw[i+1]) {
+				i++
+			}
+		case 'x':
+			if i+2 >= len(raw) || !isxdigit(raw[i+1]) || !isxdigit(raw[i+2]) {
+				return "", fmt.Errorf("hexadecimal digit expected")
+			}
+			b.WriteByte(hexValue(raw[i+1])<<4 | hexValue(raw[i+2]))
+			i += 2
+		case 'u':
+			if i+1 >= len(raw) || raw[i+1] != '{' {
+				return "", fmt.Errorf("missing '{' in \\u{xxxx}")
+			}
+			j := i + 2
+			var r uint64
+			for j < len(raw) && isxdigit(raw[j]) {
+				r = r<<4 | uint64(hexValue(raw[j]))
+				if r > 0x7FFFFFFF {
+					return "", fmt.Errorf("UTF-8 value too large")
+				}
+				j++
+			}
+			if j == i+2 {
+				return "", fmt.Errorf("hexadecimal digit expected")
+			}
+			if j >= len(raw) || raw[j] != '}' {
+				return "", fmt.Errorf("missing '}' in \\u{xxxx}")
+			}
+			b.WriteString(encodeUTF8(r))
+			i = j
+		default:
+			if !isDigit(c) {
+				return "", fmt.Errorf("invalid escape sequence '\\%c'", c)
+			}
+			n := 0
+			j := i
+			for ; j < len(raw) && j < i+3 && isDigit(raw[j]); j++ {
+				n = n*10 + int(raw[j]-'0')
+			}
+			if n > 255 {
+				return "", fmt.Errorf("decimal escape too large")
+			}
+			b.WriteByte(byte(n))
+			i = j - 1
+		}
+	}
+	return b.String(), nil
+}
+
+// encodeUTF8 encodes code points up to 2^31 like Lua's \u escape, using
+// the original (pre-2003) UTF-8 forms past U+10FFFF.
+func encodeUTF8(r uint64) string {
+	if r <= utf8.MaxRune && (r < 0xD800 || r > 0xDFFF) {
+		return string(rune(r))
+	}
+	var buf [6]byte
+	n := 0
+	limit := uint64(0x3f)
+	for r > limit {
+		buf[5-n] = byte(0x80 | (r & 0x3f))
+		n++
+		r >>= 6
+		limit >>= 1
+	}
+	buf[5-n] = byte((^limit << 1) | r)
+	return string(buf[5-n:])
+}
+
+func (p *Parser) parseTable() Expr {
+	start := p.tok.Pos
+	open := start
+	p.next()
+	table := &TableExpr{}
+	for p.tok.Type != RBrace {
+		fieldStart := p.tok.Pos
+		field := &TableField{}
+		switch {
+		case p.tok.Type == LBrack:
+			p.next()
+			field.Key = p.parseExpr()
+			p.expect(RBrack, "]")
+			p.expect(Assign, "=")
+			field.Value = p.parseExpr()
+		case isName(p.tok) && p.peek.Type == Assign:
+			key := p.parseIdent()
+			field.Key, field.Named = key, true
+			p.next()
+			field.Value = p.parseExpr()
+			if fn, ok := field.Value.(*FunctionExpr); ok && fn.Name == "" {
+				fn.Name = key.Name
+			}
+		default:
+			field.Value = p.parseExpr()
+		}
+		field.Span = Span{fieldStart, p.prevEnd}
+		table.Fields = append(table.Fields, field)
+		if p.tok.Type != Comma && p.tok.Type != Semi {
+			break
+		}
+		p.next()
+	}
+	p.expectMatch(RBrace, "}", "{", open)
+	table.Span = Span{start, p.prevEnd}
+	return table
+}
+
+// parseFunctionBody parses parameters, return type and body; start is
+// the position of the `function` keyword.
+func (p *Parser) parseFunctionBody(start Position, name string) *FunctionExpr {
+	open := start
+	fn := &FunctionExpr{Name: name}
+	p.expect(LParen, "(")
+	for p.tok.Type != RParen {
+		if p.tok.Type == Dots {
+			p.next()
+			fn.IsVararg = true
+			if p.tok.Type == Colom {
+				p.next()
+				fn.VarargType = p.parseType()
+			}
+			break
+		}
+		fn.Params = append(fn.Params, p.parseBinding())
+		if p.tok.Type != Comma {
+			break
+		}
+		p.next()
+	}
+	p.expect(RParen, ")")
+	if p.tok.Type == Colom {
+		p.next()
+		fn.ReturnType = p.parseType()
+	}
+	fn.Body = p.parseBlock()
+	p.expectMatch(End, "end", "function", open)
+	fn.Span = Span{start, p.prevEnd}
+	return fn
+}
+
+func (p *Parser) parsePrimaryExpr() Expr {
+	switch {
+	case isName(p.tok):
+		return p.parseIdent()
+	case p.tok.Type == LParen:
+		start := p.tok.Pos
+		open := start
+		p.next()
+		x := p.parseExpr()
+		p.expectMatch(RParen, ")", "(", open)
+		return &ParenExpr{Span: Span{start, p.prevEnd}, X: x}
+	}
+	p.errorNear("unexpected symbol")
+	return nil
+}
+
+func (p *Parser) parseSuffixedExpr() Expr {
+	defer p.restoreLevel(p.level, p.depth)
+	start := p.tok.Pos
+	x := p.parsePrimaryExpr()
+	for {
+		p.deepen()
+		switch p.tok.Type {
+		case Dot:
+			p.next()
+			name := p.parseIdent()
+			x = &FieldExpr{Span: Span{start, p.prevEnd}, X: x, Name: name}
+		case LBrack:
+			p.next()
+			key := p.parseExpr()
+			p.expect(RBrack, "]")
+			x = &IndexExpr{Span: Span{start, p.prevEnd}, X: x, Key: key}
+		case Colom:
+			p.next()
+			name := p.parseIdent()
+			args := p.parseArgs()
+			x = &MethodCallExpr{Span: Span{start, p.prevEnd}, Recv: x, Name: name, Args: args}
+		case LParen, StringDelim, LBrace:
+			args := p.parseArgs()
+			x = &CallExpr{Span: Span{start, p.prevEnd}, Fn: x, Args: args}
+		default:
+			return x
+		}
+	}
+}
+
+func (p *Parser) parseArgs() []Expr {
+	switch p.tok.Type {
+	case StringDelim:
+		return []Expr{p.parseString()}
+	case LBrace:
+		return []Expr{p.parseTable()}
+	case LParen:
+		open := p.tok.Pos
+		p.next()
+		var args []Expr
+		if p.tok.Type != RParen {
+			args = p.parseExprList()
+		}
+		p.expectMatch(RParen, ")", "(", open)
+		return args
+	}
+	p.errorNear("function arguments expected")
+	return nil
+}
+
+// Types
+
+func (p *Parser) parseType() TypeExpr {
+	defer p.restoreLevel(p.level, p.depth)
+	p.enterLevel()
+	start := p.tok.Pos
+	t := p.parseSimpleType()
+	for p.tok.Type == Question {
+		p.deepen()
+		p.next()
+		t = &OptionalType{Span: Span{start, p.prevEnd}, Inner: t}
+	}
+	return t
+}
+
+func (p *Parser) parseSimpleType() TypeExpr {
+	start := p.tok.Pos
+	switch p.tok.Type {
+	case Nil:
+		p.next()
+		return &NamedType{Span: Span{start, p.prevEnd}, Name: "nil"}
+	case LBrace:
+		return p.parseTableType()
+	case LParen:
+		open := start
+		p.next()
+		var types []TypeExpr
+		variadic := false
+		for p.tok.Type != RParen {
+			if p.tok.Type == Dots {
+				p.next()
+				variadic = true
+				types = append(types, p.parseType())
+				break
+			}
+			types = append(types, p.parseType())
+			if p.tok.Type != Comma {
+				break
+			}
+			p.next()
+		}
+		p.expectMatch(RParen, ")", "(", open)
+		if p.tok.Type == Arrow {
+			p.next()
+			ret := p.parseType()
+			return &FunctionType{Span: Span{start, p.prevEnd}, Params: types, Variadic: variadic, Return: ret}
+		}
+		if len(types) == 1 && !variadic {
+			return types[0]
+		}
+		return &TupleType{Span: Span{start, p.prevEnd}, Types: types, Variadic: variadic}
+	case Function:
+		p.next()
+		return &NamedType{Span: Span{start, p.prevEnd}, Name: "function"}
+	}
+	name := p.parseIdent().Name
+	for p.tok.Type == Dot {
+		p.next()
+		name += "." + p.parseIdent().Name
+	}
+	t := &NamedType{Name: name}
+	if p.tok.Type == Less {
+		p.next()
+		for p.tok.Type != Greater {
+			t.Args = append(t.Args, p.parseType())
+			if p.tok.Type != Comma {
+				break
+			}
+			p.next()
+		}
+		p.expect(Greater, ">")
+	}
+	t.Span = Span{start, p.prevEnd}
+	return t
+}
+
+func (p *Parser) parseTableType() TypeExpr {
+	start := p.tok.Pos
+	open := start
+	p.next()
+	t := &TableType{}
+	for p.tok.Type != RBrace {
+		fieldStart := p.tok.Pos
+		field := &TableTypeField{}
+		switch {
+		case p.tok.Type == LBrack:
+			p.next()
+			field.Key = p.parseType()
+			p.expect(RBrack, "]")
+			p.expect(Colom, ":")
+			field.Value = p.parseType()
+		case isName(p.tok) && p.peek.Type == Colom:
+			field.Name = p.parseIdent()
+			p.next()
+			field.Value = p.parseType()
+		default:
+			field.Value = p.parseType()
+		}
+		field.Span = Span{fieldStart, p.prevEnd}
+		t.Fields = append(t.Fields, field)
+		if p.tok.Type != Comma && p.tok.Type != Semi {
+			break
+		}
+		p.next()
+	}
+	p.expectMatch(RBrace, "}", "{", open)
+	t.Span = Span{start, p.prevEnd}
+	return t
+}
@@ -0,0 +1,509 @@
+package luanova
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Herograme/LuaNova/luanova/diag"
+)
+
+// sexpr renders an expression with explicit grouping.
+func sexpr(e Expr) string {
+	switch e := e.(type) {
+	case *NilExpr:
+		return "nil"
+	case *BoolExpr:
+		return fmt.Sprint(e.Value)
+	case *NumberExpr:
+		return fmt.Sprintf("%v", e.Value)
+	case *StringExpr:
+		return fmt.Sprintf("%q", e.Value)
+	case *VarargExpr:
+		return "..."
+	case *Ident:
+		return e.Name
+	case *BinaryExpr:
+		return fmt.Sprintf("(%s %s %s)", sexpr(e.Left), TokenName(e.Op), sexpr(e.Right))
+	case *UnaryExpr:
+		return fmt.Sprintf("(%s %s)", TokenName(e.Op), sexpr(e.X))
+	case *ParenExpr:
+		return "(" + sexpr(e.X) + ")"
+	case *FieldExpr:
+		return sexpr(e.X) + "." + e.Name.Name
+	case *IndexExpr:
+		return sexpr(e.X) + "[" + sexpr(e.Key) + "]"
+	case *CallExpr:
+		return sexpr(e.Fn) + "(" + sexprs(e.Args) + ")"
+	case *MethodCallExpr:
+		return sexpr(e.Recv) + ":" + e.Name.Name + "(" + sexprs(e.Args) + ")"
+	case *FunctionExpr:
+		return "function"
+	case *TableExpr:
+		var parts []string
+		for _, f := range e.Fields {
+			switch {
+			case f.Key == nil:
+				parts = append(parts, sexpr(f.Value))
+			case f.Named:
+				parts = append(parts, sexpr(f.Key)+"="+sexpr(f.Value))
+			default:
+				parts = append(parts, "["+sexpr(f.Key)+"]="+sexpr(f.Value))
+			}
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	}
+	return fmt.Sprintf("%T", e)
+}
+
+func sexprs(es []Expr) string {
+	parts := make([]string, len(es))
+	for i, e := range es {
+		parts[i] = sexpr(e)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func parseExprString(t *testing.T, src string) Expr {
+	t.Helper()
+	chunk, err := Parse("test", "return "+src)
+	if err != nil {
+		t.Fatalf("%q: %v", src, err)
+	}
+	ret, ok := chunk.Block.Stmts[0].(*ReturnStmt)
+	if !ok || len(ret.Values) != 1 {
+		t.Fatalf("%q: expected a single expression", src)
+	}
+	return ret.Values[0]
+}
+
+func TestParseExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2 * 3", "(1 Plus (2 Multi 3))"},
+		{"(1 + 2) * 3", "(((1 Plus 2)) Multi 3)"},
+		{"1 - 2 - 3", "((1 Sub 2) Sub 3)"},
+		{"2 ^ 3 ^ 2", "(2 Po (3 Po 2))"},
+		{"-x ^ 2", "(Sub (x Po 2))"},
+		{`"a" .. "b" .. "c"`, `("a" Concat ("b" Concat "c"))`},
+		{"a or b and c", "(a Or (b And c))"},
+		{"not a == b", "((Not a) Equal b)"},
+		{"a < b == c <= d", "(((a Less b) Equal c) LessEqual d)"},
+		{"#t + 1", "((Len t) Plus 1)"},
+		{"1 .. 2 + 3", "(1 Concat (2 Plus 3))"},
+		{"a.b.c", "a.b.c"},
+		{"a[1].b", "a[1].b"},
+		{"f(1, 2)(3)", "f(1, 2)(3)"},
+		{`f"str"`, `f("str")`},
+		{"f{1, 2}", "f({1, 2})"},
+		{"obj:method(x)", "obj:method(x)"},
+		{"{1, x = 2, [3] = 4; 5}", "{1, x=2, [3]=4, 5}"},
+		{"...", "..."},
+		{"nil", "nil"},
+		{"true", "true"},
+		{"1.5", "1.5"},
+		{".5", "0.5"},
+		{"3.", "3"},
+		{"0x10", "16"},
+		{"1e2", "100"},
+		{"0x1.8p1", "3"},
+		{"a.b", "a.b"},
+		{"t[1.5]", "t[1.5]"},
+	}
+
+	for _, tt := range tests {
+		got := sexpr(parseExprString(t, tt.input))
+		if got != tt.expected {
+			t.Errorf("%q: got %s, expected %s", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestParseNumberValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"10", int64(10)},
+		{"1.0", 1.0},
+		{"3.", 3.0},
+		{".25", 0.25},
+		{"1.5e3", 1500.0},
+		{"2.5E-1", 0.25},
+		{"0xff", int64(255)},
+		{"0xA.8p0", 10.5},
+		{"9223372036854775808", 9223372036854775808.0},
+		{"0xffffffffffffffff", int64(-1)},
+	}
+
+	for _, tt := range tests {
+		n, ok := parseExprString(t, tt.input).(*NumberExpr)
+		if !ok {
+			t.Errorf("%q: not a number", tt.input)
+			continue
+		}
+		if n.Value != tt.expected {
+			t.Errorf("%q: got %#v, expected %#v", tt.input, n.Value, tt.expected)
+		}
+	}
+}
+
+func TestParseStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"plain"`, "plain"},
+		{`"tab\tnew\nline"`, "tab\tnew\nline"},
+		{`"q\"q\\"`, `q"q\`},
+		{`"\65\066\0677"`, "ABC7"},
+		{`"\x41\x7a"`, "Az"},
+		{`"\u{48}\u{e9}\u{20AC}"`, "Hé€"},
+		{"\"a\\z  \n  b\"", "ab"},
+		{`"\a\b\f\v\r"`, "\a\b\f\v\r"},
+		{`"\'"`, "'"},
+	}
+
+	for _, tt := range tests {
+		s, ok := parseExprString(t, tt.input).(*StringExpr)
+		if !ok {
+			t.Errorf("%s: not a string", tt.input)
+			continue
+		}
+		if s.Value != tt.expected {
+			t.Errorf("%s: got %q, expected %q", tt.input, s.Value, tt.expected)
+		}
+	}
+}
+
+func TestParseStatements(t *testing.T) {
+	input := `local a: number, b = 1, 2
+local function f(x: number, ...): string return x end
+function m.n.o:p(y) end
+a, b = b, a
+x.y += 1
+s ..= "!"
+f(1)
+obj:m()
+do end
+while a < 10 do a = a + 1 end
+repeat local z = 1 until z == 1
+if a then elseif b then else end
+for i = 1, 10, 2 do continue end
+for k, v in pairs(t) do break end
+type Point = {x: number, y: number}
+return`
+
+	chunk, err := Parse("test", input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"*luanova.LocalStmt",
+		"*luanova.LocalFunctionStmt",
+		"*luanova.FunctionStmt",
+		"*luanova.AssignStmt",
+		"*luanova.CompoundAssignStmt",
+		"*luanova.CompoundAssignStmt",
+		"*luanova.CallStmt",
+		"*luanova.CallStmt",
+		"*luanova.DoStmt",
+		"*luanova.WhileStmt",
+		"*luanova.RepeatStmt",
+		"*luanova.IfStmt",
+		"*luanova.NumericForStmt",
+		"*luanova.GenericForStmt",
+		"*luanova.TypeStmt",
+		"*luanova.ReturnStmt",
+	}
+	if len(chunk.Block.Stmts) != len(expected) {
+		t.Fatalf("got %d statements, expected %d", len(chunk.Block.Stmts), len(expected))
+	}
+	for i, stmt := range chunk.Block.Stmts {
+		if got := fmt.Sprintf("%T", stmt); got != expected[i] {
+			t.Errorf("statement %d: got %s, expected %s", i, got, expected[i])
+		}
+	}
+
+	local := chunk.Block.Stmts[0].(*LocalStmt)
+	if named, ok := local.Names[0].Type.(*NamedType); !ok || named.Name != "number" {
+		t.Errorf("local type annotation: got %#v", local.Names[0].Type)
+	}
+
+	fn := chunk.Block.Stmts[2].(*FunctionStmt)
+	if fn.Method == nil || fn.Method.Name != "p" || fn.Func.Name != "m.n.o:p" {
+		t.Errorf("method name: got %+v", fn)
+	}
+	if len(fn.Func.Params) != 2 || fn.Func.Params[0].Name.Name != "self" {
+		t.Errorf("method params: expected self, y")
+	}
+
+	concat := chunk.Block.Stmts[5].(*CompoundAssignStmt)
+	if concat.Op != Concat {
+		t.Errorf("..= operator: got %s", TokenName(concat.Op))
+	}
+
+	ifStmt := chunk.Block.Stmts[11].(*IfStmt)
+	if len(ifStmt.Clauses) != 2 || ifStmt.Else == nil {
+		t.Errorf("if clauses: got %d, else %v", len(ifStmt.Clauses), ifStmt.Else != nil)
+	}
+}
+
+func TestParseTypes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"number", "*luanova.NamedType"},
+		{"string?", "*luanova.OptionalType"},
+		{"{number}", "*luanova.TableType"},
+		{"{[string]: number}", "*luanova.TableType"},
+		{"(number, string) -> boolean", "*luanova.FunctionType"},
+		{"(number, string)", "*luanova.TupleType"},
+		{"Array<number>", "*luanova.NamedType"},
+		{"mod.Type", "*luanova.NamedType"},
+	}
+
+	for _, tt := range tests {
+		chunk, err := Parse("test", "type T = "+tt.input)
+		if err != nil {
+			t.Errorf("%q: %v", tt.input, err)
+			continue
+		}
+		typ := chunk.Block.Stmts[0].(*TypeStmt).Type
+		if got := fmt.Sprintf("%T", typ); got != tt.expected {
+			t.Errorf("%q: got %s, expected %s", tt.input, got, tt.expected)
+		}
+	}
+
+	// type is only a keyword at the start of a type statement
+	if _, err := Parse("test", "local type = 1\ntype = type + 1\nprint(type)"); err != nil {
+		t.Errorf("type as a name: %v", err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = ", "test:1:5: unexpected symbol near <eof>"},
+		{"if x then", "test:1:10: 'end' expected near <eof>"},
+		{"if x then\n\n", "test:3:1: 'end' expected (to close 'if' at line 1) near <eof>"},
+		{"f(", "test:1:3: unexpected symbol near <eof>"},
+		{"for i do end", "test:1:7: '=' or 'in' expected near 'do'"},
+		{"local 1 = 2", "test:1:7: <name> expected near '1'"},
+		{"x", "test:1:2: syntax error near <eof>"},
+		{"f() = 1", "test:1:5: syntax error near '='"},
+		{`x = "abc`, `test:1:5: unfinished string near '"abc'`},
+		{`x = "\q"`, `test:1:5: invalid escape sequence '\q' in string "\q"`},
+		{"x = 1e", "test:1:5: malformed number near '1e'"},
+		{"x = 0x", "test:1:5: malformed number near '0x'"},
+		{"end", "test:1:1: '<eof>' expected near 'end'"},
+		{"t = {1, 2", "test:1:10: '}' expected near <eof>"},
+		{"x = @", "test:1:5: unexpected symbol near '@'"},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse("test", tt.input)
+		if err == nil {
+			t.Errorf("%q: expected an error", tt.input)
+			continue
+		}
+		list := err.(ErrorList)
+		if got := list[0].Error(); got != tt.expected {
+			t.Errorf("%q: got %q, expected %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestParseRecovery(t *testing.T) {
+	input := `local a = 1
+local b = = 2
+print(a)
+local c = )
+print(c)`
+
+	chunk, err := Parse("test", input)
+	list, ok := err.(ErrorList)
+	if !ok || len(list) != 2 {
+		t.Fatalf("expected 2 errors, got %v", err)
+	}
+	if list[0].Pos.Line != 2 || list[1].Pos.Line != 4 {
+		t.Errorf("error lines: got %d and %d", list[0].Pos.Line, list[1].Pos.Line)
+	}
+	// the valid statements are kept
+	if len(chunk.Block.Stmts) != 3 {
+		t.Errorf("got %d statements, expected 3", len(chunk.Block.Stmts))
+	}
+}
+
+func TestParseRecoveryAtEOF(t *testing.T) {
+	input := "local function f(a)\n\tlocal b = a\n\tif b then\n\t\tprint(b.)"
+	chunk, err := Parse("test", input)
+	list, ok := err.(ErrorList)
+	if !ok || len(list) != 2 || list[1].Pos.Offset != len(input) {
+		t.Fatalf("expected 2 errors, the last at the end, got %v", err)
+	}
+	// the constructs left open are closed at the end of the source
+	if len(chunk.Block.Stmts) != 1 {
+		t.Fatalf("got %d statements, expected 1", len(chunk.Block.Stmts))
+	}
+	fn, ok := chunk.Block.Stmts[0].(*LocalFunctionStmt)
+	if !ok || len(fn.Func.Body.Stmts) != 2 || fn.End().Offset != len(input) {
+		t.Fatalf("got %#v, expected the function up to the end", chunk.Block.Stmts[0])
+	}
+	if _, ok := fn.Func.Body.Stmts[1].(*IfStmt); !ok {
+		t.Errorf("got %T, expected the if statement", fn.Func.Body.Stmts[1])
+	}
+}
+
+func TestParseSyntaxLevels(t *testing.T) {
+	deep := 1 << 20
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"parentheses", "local x = " + strings.Repeat("(", deep) + "1" + strings.Repeat(")", deep)},
+		{"unary operators", "local x = " + strings.Repeat("- ", deep) + "1"},
+		{"binary operators", "local x = 0" + strings.Repeat(" + 1", deep)},
+		{"suffixes", "local x = t" + strings.Repeat(".a", deep)},
+		{"tables", "local x = " + strings.Repeat("{", deep) + strings.Repeat("}", deep)},
+		{"blocks", strings.Repeat("do ", deep) + strings.Repeat("end ", deep)},
+		{"functions", "local f = " + strings.Repeat("function() return ", deep) + "1" + strings.Repeat(" end", deep)},
+		{"types", "local x: number" + strings.Repeat("?", deep)},
+	}
+	for _, tt := range tests {
+		_, err := Parse("test", tt.input)
+		list, ok := err.(ErrorList)
+		if !ok || len(list) != 1 || list[0].Msg != "chunk has too many syntax levels" {
+			t.Errorf("%s: got %.100v, expected too many syntax levels", tt.name, err)
+		}
+	}
+
+	// ordinary code stays well below the limit, and long flat chains are
+	// not nesting
+	s := NewState()
+	s.SetLimits(Limits{MaxCallDepth: 200})
+	for _, script := range []string{
+		"local x = " + strings.Repeat("(", 100) + "0" + strings.Repeat(" + 1", 500) + strings.Repeat(")", 100) + "\nassert(x == 500)",
+		"local x = 0" + strings.Repeat(" + 1", 1200) + "\nassert(x == 1200)",
+		"local function f() return f end\nassert(f" + strings.Repeat("(1)", 1200) + " == f)",
+		"local t = {}\nt.a = t\nassert(t" + strings.Repeat(".a", 1200) + " == t)",
+	} {
+		if err := s.DoString(script); err != nil {
+			t.Errorf("%.60s: %v", script, err)
+		}
+	}
+}
+
+func TestParseComments(t *testing.T) {
+	chunk, err := Parse("test", "-- first\nlocal x = 1 -* block *-\nreturn x -- last")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunk.Comments) != 3 {
+		t.Fatalf("got %d comments, expected 3", len(chunk.Comments))
+	}
+	if chunk.Comments[2].Literal != "-- last" || chunk.Comments[2].Pos.Line != 3 {
+		t.Errorf("last comment: got %q at %v", chunk.Comments[2].Literal, chunk.Comments[2].Pos)
+	}
+}
+
+func TestParsePositions(t *testing.T) {
+	chunk, err := Parse("test", "local x = 1\nif x then\n  print(x + 2)\nend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifStmt := chunk.Block.Stmts[1].(*IfStmt)
+	if ifStmt.Pos() != (Position{Offset: 12, Line: 2, Column: 1}) || ifStmt.End() != (Position{Offset: 40, Line: 4, Column: 4}) {
+		t.Errorf("if span: got %v-%v", ifStmt.Pos(), ifStmt.End())
+	}
+	call := ifStmt.Clauses[0].Body.Stmts[0].(*CallStmt).Call.(*CallExpr)
+	bin := call.Args[0].(*BinaryExpr)
+	if bin.OpPos != (Position{Offset: 32, Line: 3, Column: 11}) {
+		t.Errorf("operator position: got %v", bin.OpPos)
+	}
+}
+
+func TestParseModules(t *testing.T) {
+	input := `import { a, b as c } from "./util"
+import * as m from "pkg.mod"
+export function f(x) return x end
+export local g, h = 1, 2
+export type T = number
+export { a as d }
+import(1)
+export.x = 1
+local import, from, as = 1, 2, 3`
+
+	chunk, err := Parse("test", input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts := chunk.Block.Stmts
+	if len(stmts) != 9 {
+		t.Fatalf("got %d statements", len(stmts))
+	}
+
+	imp := stmts[0].(*ImportStmt)
+	if imp.Path.Value != "./util" || len(imp.Names) != 2 || imp.Names[1].LocalName().Name != "c" || imp.Names[1].Name.Name != "b" {
+		t.Errorf("import list: got %+v", imp)
+	}
+	if ns := stmts[1].(*ImportStmt); ns.Namespace == nil || ns.Namespace.Name != "m" || ns.Names != nil {
+		t.Errorf("namespace import: got %+v", ns)
+	}
+	expected := []string{"*luanova.LocalFunctionStmt", "*luanova.LocalStmt", "*luanova.TypeStmt", "<nil>"}
+	for i, want := range expected {
+		exp := stmts[2+i].(*ExportStmt)
+		if got := fmt.Sprintf("%T", exp.Decl); exp.Decl == nil && want != "<nil>" || exp.Decl != nil && got != want {
+			t.Errorf("export %d: got %s, expected %s", i, got, want)
+		}
+	}
+	for i, want := range []string{"*luanova.CallStmt", "*luanova.AssignStmt", "*luanova.LocalStmt"} {
+		if got := fmt.Sprintf("%T", stmts[6+i]); got != want {
+			t.Errorf("statement %d: got %s, expected %s", 6+i, got, want)
+		}
+	}
+
+	names := []string{}
+	for _, e := range ChunkExports(chunk) {
+		names = append(names, fmt.Sprintln(e.Name, e.Type))
+	}
+	if got := strings.Join(names, ""); got != "f false\ng false\nh false\nT true\nd false\n" {
+		t.Errorf("exports: got %s", got)
+	}
+}
+
+func TestParseModuleErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import { a } "x"`, `test:1:14: 'from' expected near '"x"'`},
+		{`import { a, } from x`, `test:1:20: module path expected near 'x'`},
+		{`import * from "x"`, `test:1:10: 'as' expected near 'from'`},
+		{`import { a as } from "x"`, `test:1:15: <name> expected near '}'`},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse("test", tt.input)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: got %v, expected %q", tt.input, err, tt.expected)
+		}
+	}
+}
+
+func TestParseErrorDiagnostics(t *testing.T) {
+	_, err := Parse("test", "local t = {\n  x = 1,\n  y = 2 3\n")
+	d := err.(ErrorList)[0].Diagnostic()
+	if d.Severity != diag.SeverityError || d.Pos.String() != "3:9" || d.End.String() != "3:10" {
+		t.Errorf("got %s %s-%s", d.Severity, d.Pos, d.End)
+	}
+	if len(d.Notes) != 1 || d.Notes[0].Pos.String() != "1:11" || d.Notes[0].Message != "'{' opened here" {
+		t.Errorf("got notes %+v", d.Notes)
+	}
+}
@@ -0,0 +1,676 @@
+package luanova
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Lua patterns are not regular expressions, so this is a port of the
+// matcher in Lua 5.4's lstrlib.c. Positions are 0-based byte offsets
+// internally; the string library converts them to 1-based indices.
+
+const (
+	patternMaxCaptures = 32
+	patternMaxDepth    = 200
+	patternSpecials    = "^$*+?.([%-"
+
+	capUnfinished = -1
+	capPosition   = -2
+)
+
+// DefaultPatternSteps bounds how much backtracking a single match
+// attempt may do before it fails with ErrPatternTooComplex. Each
+// position gsub, gmatch or find tries gets the whole budget.
+const DefaultPatternSteps = 1 << 22
+
+var ErrPatternTooComplex = errors.New("pattern too complex")
+
+// patternCapture is the result of one capture. Position captures `()`
+// report a 1-based index instead of a substring.
+type patternCapture struct {
+	Value    string
+	Position int
+	IsPos    bool
+}
+
+// patternLimits bounds the work of the pattern functions.
+type patternLimits struct {
+	maxSteps int    // per match attempt; 0 means DefaultPatternSteps
+	state    *State // charged a step for every step of the matcher, if not nil
+}
+
+type patternState struct {
+	src      string
+	pat      string
+	level    int
+	depth    int
+	steps    int
+	maxSteps int
+	state    *State
+	capture  [patternMaxCaptures]struct{ init, len int }
+}
+
+// matchError is used to unwind out of deep recursion on malformed patterns.
+type matchError struct{ err error }
+
+func newPatternState(src, pat string, lim patternLimits) *patternState {
+	maxSteps := lim.maxSteps
+	if maxSteps <= 0 {
+		maxSteps = DefaultPatternSteps
+	}
+	return &patternState{src: src, pat: pat, maxSteps: maxSteps, state: lim.state}
+}
+
+func (ms *patternState) fail(format string, args ...any) {
+	panic(matchError{fmt.Errorf(format, args...)})
+}
+
+func (ms *patternState) reset() {
+	ms.level = 0
+	ms.depth = 0
+	ms.steps = 0
+}
+
+// do runs a match at s, converting internal panics to errors.
+func (ms *patternState) do(s, p int) (end int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			me, ok := r.(matchError)
+			if !ok {
+				panic(r)
+			}
+			end, err = -1, me.err
+		}
+	}()
+	ms.reset()
+	return ms.match(s, p), nil
+}
+
+func (ms *patternState) classEnd(p int) int {
+	if p >= len(ms.pat) {
+		ms.fail("malformed pattern (ends with '%%')")
+	}
+	c := ms.pat[p]
+	p++
+	if c == '%' {
+		if p >= len(ms.pat) {
+			ms.fail("malformed pattern (ends with '%%')")
+		}
+		return p + 1
+	}
+	if c == '[' {
+		if p < len(ms.pat) && ms.pat[p] == '^' {
+			p++
+		}
+		for {
+			if p >= len(ms.pat) {
+				ms.fail("malformed pattern (missing ']')")
+			}
+			c := ms.pat[p]
+			p++
+			if c == '%' {
+				if p >= len(ms.pat) {
+					ms.fail("malformed pattern (missing ']')")
+				}
+				p++
+			}
+			if p < len(ms.pat) && ms.pat[p] == ']' {
+				return p + 1
+			}
+		}
+	}
+	return p
+}
+
+func isalpha(c byte) bool  { return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' }
+func isdigitc(c byte) bool { return '0' <= c && c <= '9' }
+func islower(c byte) bool  { return 'a' <= c && c <= 'z' }
+func isupper(c byte) bool  { return 'A' <= c && c <= 'Z' }
+func isspace(c byte) bool  { return c == ' ' || '\t' <= c && c <= '\r' }
+func iscntrl(c byte) bool  { return c < 32 || c == 127 }
+func ispunct(c byte) bool  { return c > 32 && c < 127 && !isalpha(c) && !isdigitc(c) }
+func isxdigit(c byte) bool {
+	return isdigitc(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func singleClass(c, cl byte) bool {
+	var res bool
+	switch cl | 0x20 {
+	case 'a':
+		res = isalpha(c)
+	case 'c':
+		res = iscntrl(c)
+	case 'd':
+		res = isdigitc(c)
+	case 'g':
+		res = c > 32 && c < 127
+	case 'l':
+		res = islower(c)
+	case 'p':
+		res = ispunct(c)
+	case 's':
+		res = isspace(c)
+	case 'u':
+		res = isupper(c)
+	case 'w':
+		res = isalpha(c) || isdigitc(c)
+	case 'x':
+		res = isxdigit(c)
+	case 'z':
+		res = c == 0
+	default:
+		return cl == c
+	}
+	if isupper(cl) {
+		return !res
+	}
+	return res
+}
+
+// matchBracketClass reports whether c is in the set [p, ec], where p
+// points at '[' and ec at the closing ']'.
+func (ms *patternState) matchBracketClass(c byte, p, ec int) bool {
+	sig := true
+	if ms.pat[p+1] == '^' {
+		sig = false
+		p++
+	}
+	for p++; p < ec; p++ {
+		if ms.pat[p] == '%' {
+			p++
+			if singleClass(c, ms.pat[p]) {
+				return sig
+			}
+		} else if ms.pat[p+1] == '-' && p+2 < ec {
+			p += 2
+			if ms.pat[p-2] <= c && c <= ms.pat[p] {
+				return sig
+			}
+		} else if ms.pat[p] == c {
+			return sig
+		}
+	}
+	return !sig
+}
+
+func (ms *patternState) singleMatch(s, p, ep int) bool {
+	if s >= len(ms.src) {
+		return false
+	}
+	c := ms.src[s]
+	switch ms.pat[p] {
+	case '.':
+		return true
+	case '%':
+		return singleClass(c, ms.pat[p+1])
+	case '[':
+		return ms.matchBracketClass(c, p, ep-1)
+	default:
+		return ms.pat[p] == c
+	}
+}
+
+// step counts a step of backtracking. The steps also count against the
+// limits of the State, so that a long match stops with the execution.
+func (ms *patternState) step() {
+	ms.steps++
+	if ms.steps > ms.maxSteps {
+		panic(matchError{ErrPatternTooComplex})
+	}
+	if ms.state != nil {
+		ms.state.step()
+	}
+}
+
+func (ms *patternState) match(s, p int) int {
+	ms.depth++
+	if ms.depth > patternMaxDepth {
+		ms.fail("pattern too complex")
+	}
+	defer func() { ms.depth-- }()
+	for {
+		ms.step()
+		if p >= len(ms.pat) {
+			return s
+		}
+		switch ms.pat[p] {
+		case '(':
+			if p+1 < len(ms.pat) && ms.pat[p+1] == ')' {
+				return ms.startCapture(s, p+2, capPosition)
+			}
+			return ms.startCapture(s, p+1, capUnfinished)
+		case ')':
+			return ms.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(ms.pat) {
+				if s == len(ms.src) {
+					return s
+				}
+				return -1
+			}
+		case '%':
+			if p+1 < len(ms.pat) {
+				switch ms.pat[p+1] {
+				case 'b':
+					s = ms.matchBalance(s, p+2)
+					if s != -1 {
+						p += 4
+						continue
+					}
+					return -1
+				case 'f':
+					p += 2
+					if p >= len(ms.pat) || ms.pat[p] != '[' {
+						ms.fail("missing '[' after '%%f' in pattern")
+					}
+					ep := ms.classEnd(p)
+					var prev, cur byte
+					if s > 0 {
+						prev = ms.src[s-1]
+					}
+					if s < len(ms.src) {
+						cur = ms.src[s]
+					}
+					if !ms.matchBracketClass(prev, p, ep-1) && ms.matchBracketClass(cur, p, ep-1) {
+						p = ep
+						continue
+					}
+					return -1
+				case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+					s = ms.matchCapture(s, ms.pat[p+1])
+					if s != -1 {
+						p += 2
+						continue
+					}
+					return -1
+				}
+			}
+		}
+
+		ep := ms.classEnd(p)
+		if !ms.singleMatch(s, p, ep) {
+			if ep < len(ms.pat) && (ms.pat[ep] == '*' || ms.pat[ep] == '?' || ms.pat[ep] == '-') {
+				p = ep + 1
+				continue
+			}
+			return -1
+		}
+		if ep < len(ms.pat) {
+			switch ms.pat[ep] {
+			case '?':
+				if res := ms.match(s+1, ep+1); res != -1 {
+					return res
+				}
+				p = ep + 1
+				continue
+			case '+':
+				return ms.maxExpand(s+1, p, ep)
+			case '*':
+				return ms.maxExpand(s, p, ep)
+			case '-':
+				return ms.minExpand(s, p, ep)
+			}
+		}
+		s++
+		p = ep
+	}
+}
+
+func (ms *patternState) matchBalance(s, p int) int {
+	if p+1 >= len(ms.pat) {
+		ms.fail("malformed pattern (missing arguments to '%%b')")
+	}
+	if s >= len(ms.src) || ms.src[s] != ms.pat[p] {
+		return -1
+	}
+	b, e := ms.pat[p], ms.pat[p+1]
+	cont := 1
+	for s++; s < len(ms.src); s++ {
+		switch ms.src[s] {
+		case e:
+			cont--
+			if cont == 0 {
+				return s + 1
+			}
+		case b:
+			cont++
+		}
+	}
+	return -1
+}
+
+func (ms *patternState) maxExpand(s, p, ep int) int {
+	i := 0
+	for ms.singleMatch(s+i, p, ep) {
+		i++
+	}
+	for ; i >= 0; i-- {
+		ms.step()
+		if res := ms.match(s+i, ep+1); res != -1 {
+			return res
+		}
+	}
+	return -1
+}
+
+func (ms *patternState) minExpand(s, p, ep int) int {
+	for {
+		ms.step()
+		if res := ms.match(s, ep+1); res != -1 {
+			return res
+		}
+		if !ms.singleMatch(s, p, ep) {
+			return -1
+		}
+		s++
+	}
+}
+
+func (ms *patternState) startCapture(s, p, what int) int {
+	if ms.level >= patternMaxCaptures {
+		ms.fail("too many captures")
+	}
+	ms.capture[ms.level].init = s
+	ms.capture[ms.level].len = what
+	ms.level++
+	res := ms.match(s, p)
+	if res == -1 {
+		ms.level--
+	}
+	return res
+}
+
+func (ms *patternState) endCapture(s, p int) int {
+	l := ms.captureToClose()
+	ms.capture[l].len = s - ms.capture[l].init
+	res := ms.match(s, p)
+	if res == -1 {
+		ms.capture[l].len = capUnfinished
+	}
+	return res
+}
+
+func (ms *patternState) captureToClose() int {
+	for level := ms.level - 1; level >= 0; level-- {
+		if ms.capture[level].len == capUnfinished {
+			return level
+		}
+	}
+	ms.fail("invalid pattern capture")
+	return 0
+}
+
+func (ms *patternState) checkCapture(l byte) int {
+	idx := int(l) - '1'
+	if idx < 0 || idx >= ms.level || ms.capture[idx].len == capUnfinished {
+		ms.fail("invalid capture index %%%d", idx+1)
+	}
+	return idx
+}
+
+func (ms *patternState) matchCapture(s int, l byte) int {
+	idx := ms.checkCapture(l)
+	n := ms.capture[idx].len
+	init := ms.capture[idx].init
+	if len(ms.src)-s >= n && ms.src[init:init+n] == ms.src[s:s+n] {
+		return s + n
+	}
+	return -1
+}
+
+// getCapture returns capture i, or the whole match [s, e) when the
+// pattern has no explicit captures and i is 0.
+func (ms *patternState) getCapture(i, s, e int) (patternCapture, error) {
+	if i >= ms.level {
+		if i != 0 {
+			return patternCapture{}, fmt.Errorf("invalid capture index %%%d", i+1)
+		}
+		return patternCapture{Value: ms.src[s:e]}, nil
+	}
+	c := ms.capture[i]
+	switch c.len {
+	case capUnfinished:
+		return patternCapture{}, errors.New("unfinished capture")
+	case capPosition:
+		return patternCapture{Position: c.init + 1, IsPos: true}, nil
+	}
+	return patternCapture{Value: ms.src[c.init : c.init+c.len]}, nil
+}
+
+// captures returns all captures of the last match. When wholeIfNone is
+// set and the pattern has no captures, the whole match is returned.
+func (ms *patternState) captures(s, e int, wholeIfNone bool) ([]patternCapture, error) {
+	n := ms.level
+	if n == 0 && wholeIfNone {
+		n = 1
+	}
+	caps := make([]patternCapture, 0, n)
+	for i := 0; i < n; i++ {
+		c, err := ms.getCapture(i, s, e)
+		if err != nil {
+			return nil, err
+		}
+		caps = append(caps, c)
+	}
+	return caps, nil
+}
+
+// translateInit converts a 1-based, possibly negative, init argument to a
+// 0-based offset. ok is false when init is past the end of s.
+func translateInit(init, n int) (int, bool) {
+	switch {
+	case init > 0:
+		init--
+	case init == 0:
+		init = 0
+	case -init > n:
+		init = 0
+	default:
+		init = n + init
+	}
+	return init, init <= n
+}
+
+func noSpecials(p string) bool {
+	return !strings.ContainsAny(p, patternSpecials)
+}
+
+// patternFind implements string.find. start and end are 1-based and
+// inclusive; start is 0 when there is no match.
+func patternFind(s, p string, init int, plain bool, lim patternLimits) (start, end int, caps []patternCapture, err error) {
+	i, ok := translateInit(init, len(s))
+	if !ok {
+		return 0, 0, nil, nil
+	}
+	if plain || noSpecials(p) {
+		idx := strings.Index(s[i:], p)
+		if idx < 0 {
+			return 0, 0, nil, nil
+		}
+		return i + idx + 1, i + idx + len(p), nil, nil
+	}
+	ms := newPatternState(s, p, lim)
+	pi, anchor := 0, len(p) > 0 && p[0] == '^'
+	if anchor {
+		pi = 1
+	}
+	for si := i; ; si++ {
+		e, err := ms.do(si, pi)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if e != -1 {
+			caps, err := ms.captures(si, e, false)
+			return si + 1, e, caps, err
+		}
+		if anchor || si >= len(s) {
+			return 0, 0, nil, nil
+		}
+	}
+}
+
+// patternMatch implements string.match. It returns nil captures when
+// there is no match.
+func patternMatch(s, p string, init int, lim patternLimits) ([]patternCapture, error) {
+	i, ok := translateInit(init, len(s))
+	if !ok {
+		return nil, nil
+	}
+	ms := newPatternState(s, p, lim)
+	pi, anchor := 0, len(p) > 0 && p[0] == '^'
+	if anchor {
+		pi = 1
+	}
+	for si := i; ; si++ {
+		e, err := ms.do(si, pi)
+		if err != nil {
+			return nil, err
+		}
+		if e != -1 {
+			return ms.captures(si, e, true)
+		}
+		if anchor || si >= len(s) {
+			return nil, nil
+		}
+	}
+}
+
+// patternIter is the state behind string.gmatch.
+type patternIter struct {
+	ms      *patternState
+	src     int
+	lastEnd int
+}
+
+func newPatternIter(s, p string, init int, lim patternLimits) *patternIter {
+	i, ok := translateInit(init, len(s))
+	if !ok {
+		i = len(s) + 1
+	}
+	return &patternIter{ms: newPatternState(s, p, lim), src: i, lastEnd: -1}
+}
+
+// next returns the captures of the next match, or nil when exhausted.
+func (it *patternIter) next() ([]patternCapture, error) {
+	ms := it.ms
+	for ; it.src <= len(ms.src); it.src++ {
+		e, err := ms.do(it.src, 0)
+		if err != nil {
+			return nil, err
+		}
+		if e != -1 && e != it.lastEnd {
+			start := it.src
+			it.src, it.lastEnd = e, e
+			return ms.captures(start, e, true)
+		}
+	}
+	return nil, nil
+}
+
+// patternReplacer produces the replacement for one gsub match. whole is
+// the matched text and caps the captures (the whole match when the
+// pattern has none). Returning keep leaves the original text in place,
+// which is what a nil or false table/function result means in Lua.
+type patternReplacer func(whole string, caps []patternCapture) (repl string, keep bool, err error)
+
+// stringReplacer returns a patternReplacer for a replacement string,
+// expanding %0-%9 and %%.
+func stringReplacer(repl string) patternReplacer {
+	return func(whole string, caps []patternCapture) (string, bool, error) {
+		if !strings.Contains(repl, "%") {
+			return repl, false, nil
+		}
+		var b strings.Builder
+		for i := 0; i < len(repl); i++ {
+			c := repl[i]
+			if c != '%' {
+				b.WriteByte(c)
+				continue
+			}
+			i++
+			if i >= len(repl) {
+				return "", false, errors.New("invalid use of '%' in replacement string")
+			}
+			c = repl[i]
+			switch {
+			case c == '%':
+				b.WriteByte('%')
+			case c == '0':
+				b.WriteString(whole)
+			case '1' <= c && c <= '9':
+				idx := int(c - '1')
+				if idx >= len(caps) {
+					return "", false, fmt.Errorf("invalid capture index %%%d in replacement string", idx+1)
+				}
+				b.WriteString(caps[idx].String())
+			default:
+				return "", false, errors.New("invalid use of '%' in replacement string")
+			}
+		}
+		return b.String(), false, nil
+	}
+}
+
+// String returns the capture as text; position captures become numbers.
+func (c patternCapture) String() string {
+	if c.IsPos {
+		return fmt.Sprint(c.Position)
+	}
+	return c.Value
+}
+
+// errResultTooLong is returned by patternGsub when the result grows
+// past maxLen.
+var errResultTooLong = errors.New("result too long")
+
+// patternGsub implements string.gsub. maxN < 0 means no limit on the
+// replacements and maxLen < 0 no limit on the length of the result.
+func patternGsub(s, p string, maxN int, repl patternReplacer, lim patternLimits, maxLen int) (string, int, error) {
+	pi, anchor := 0, len(p) > 0 && p[0] == '^'
+	if anchor {
+		pi = 1
+	}
+	ms := newPatternState(s, p, lim)
+	var b strings.Builder
+	si, lastEnd, n := 0, -1, 0
+	for maxN < 0 || n < maxN {
+		e, err := ms.do(si, pi)
+		if err != nil {
+			return "", 0, err
+		}
+		if e != -1 && e != lastEnd {
+			n++
+			caps, err := ms.captures(si, e, true)
+			if err != nil {
+				return "", 0, err
+			}
+			r, keep, err := repl(s[si:e], caps)
+			if err != nil {
+				return "", 0, err
+			}
+			if keep {
+				b.WriteString(s[si:e])
+			} else {
+				b.WriteString(r)
+			}
+			si, lastEnd = e, e
+		} else if si < len(s) {
+			b.WriteByte(s[si])
+			si++
+		} else {
+			break
+		}
+		if maxLen >= 0 && b.Len() > maxLen {
+			return "", 0, errResultTooLong
+		}
+		if anchor {
+			break
+		}
+	}
+	if maxLen >= 0 && b.Len()+len(s)-si > maxLen {
+		return "", 0, errResultTooLong
+	}
+	b.WriteString(s[si:])
+	return b.String(), n, nil
+}
@@ -0,0 +1,484 @@
+package luanova
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// The cases below are taken from pm.lua in the official Lua 5.4 test
+// suite. Latin-1 literals from the original were replaced by ASCII where
+// the result depended on single-byte characters.
+
+// findSub returns the substring matched by string.find, like f() in pm.lua.
+func findSub(t *testing.T, s, p string) (string, bool) {
+	t.Helper()
+	start, end, _, err := patternFind(s, p, 1, false, patternLimits{})
+	if err != nil {
+		t.Fatalf("find(%q, %q) - unexpected error: %v", s, p, err)
+	}
+	if start == 0 {
+		return "", false
+	}
+	return s[start-1 : end], true
+}
+
+func gsubString(t *testing.T, s, p, repl string, maxN int) (string, int) {
+	t.Helper()
+	res, n, err := patternGsub(s, p, maxN, stringReplacer(repl), patternLimits{}, -1)
+	if err != nil {
+		t.Fatalf("gsub(%q, %q, %q) - unexpected error: %v", s, p, repl, err)
+	}
+	return res, n
+}
+
+func TestPatternFindPositions(t *testing.T) {
+	tests := []struct {
+		s, p          string
+		init          int
+		plain         bool
+		expectedStart int
+		expectedEnd   int
+	}{
+		{"", "", 1, false, 1, 0},
+		{"alo", "", 1, false, 1, 0},
+		{"a\x00o a\x00o a\x00o", "a", 1, false, 1, 1},
+		{"a\x00o a\x00o a\x00o", "a\x00o", 2, false, 5, 7},
+		{"a\x00o a\x00o a\x00o", "a\x00o", 9, false, 9, 11},
+		{"a\x00a\x00a\x00a\x00\x00ab", "\x00ab", 2, false, 9, 11},
+		{"a\x00a\x00a\x00a\x00\x00ab", "b", 1, false, 11, 11},
+		{"a\x00a\x00a\x00a\x00\x00ab", "b\x00", 1, false, 0, 0},
+		{"", "\x00", 1, false, 0, 0},
+		{"alo123alo", "12", 1, false, 4, 5},
+		{"alo123alo", "^12", 1, false, 0, 0},
+		{"a.b", ".", 1, true, 2, 2},
+		{"abc", "c", -1, false, 3, 3},
+		{"abc", "a", -10, false, 1, 1},
+		{"abc", "", 4, false, 4, 3},
+		{"abc", "", 5, false, 0, 0},
+		{"(alo)", "%(a", 1, false, 1, 2},
+		{"b$a", "$\x00?", 1, false, 2, 2},
+		{"abc\x00efg", "%\x00", 1, false, 4, 4},
+		{"abc\x00\x00", "\x00.", 1, false, 4, 5},
+		{"abcx\x00\x00abc\x00abc", "x\x00\x00abc\x00a.", 1, false, 4, 12},
+		{"a", "%f[a]", 1, false, 1, 0},
+		{"a", "%f[^%z]", 1, false, 1, 0},
+		{"a", "%f[^%l]", 1, false, 2, 1},
+		{"aba", "%f[a%z]", 1, false, 3, 2},
+		{"aba", "%f[%z]", 1, false, 4, 3},
+		{"aba", "%f[%l%z]", 1, false, 0, 0},
+		{"aba", "%f[^%l%z]", 1, false, 0, 0},
+		{" alo aalo allo", "%f[%S].-%f[%s].-%f[%S]", 1, false, 2, 5},
+	}
+
+	for i, tt := range tests {
+		start, end, _, err := patternFind(tt.s, tt.p, tt.init, tt.plain, patternLimits{})
+		if err != nil {
+			t.Fatalf("tests[%d] - unexpected error: %v", i, err)
+		}
+		if start != tt.expectedStart || (start != 0 && end != tt.expectedEnd) {
+			t.Errorf("tests[%d] - find(%q, %q) wrong. expected=%d,%d, got=%d,%d",
+				i, tt.s, tt.p, tt.expectedStart, tt.expectedEnd, start, end)
+		}
+	}
+}
+
+func TestPatternFindSubstring(t *testing.T) {
+	tests := []struct {
+		s, p     string
+		expected string
+		found    bool
+	}{
+		{"aloALO", "%l*", "alo", true},
+		{"aLo_ALO", "%a*", "aLo", true},
+		{"  \n\r*&\n\r   xuxu  \n\n", "%g%g%g+", "xuxu", true},
+		{"aaab", "a*", "aaa", true},
+		{"aaa", "^.*$", "aaa", true},
+		{"aaa", "b*", "", true},
+		{"aaa", "ab*a", "aa", true},
+		{"aba", "ab*a", "aba", true},
+		{"aaab", "a+", "aaa", true},
+		{"aaa", "^.+$", "aaa", true},
+		{"aaa", "b+", "", false},
+		{"aaa", "ab+a", "", false},
+		{"aba", "ab+a", "aba", true},
+		{"a$a", ".$", "a", true},
+		{"a$a", ".%$", "a$", true},
+		{"a$a", ".$.", "a$a", true},
+		{"a$a", "$$", "", false},
+		{"a$b", "a$", "", false},
+		{"a$a", "$", "", true},
+		{"", "b*", "", true},
+		{"aaa", "bb*", "", false},
+		{"aaab", "a-", "", true},
+		{"aaa", "^.-$", "aaa", true},
+		{"aabaaabaaabaaaba", "b.*b", "baaabaaabaaab", true},
+		{"aabaaabaaabaaaba", "b.-b", "baaab", true},
+		{"alo xo", ".o$", "xo", true},
+		{" \n isto e assim", "%S%S*", "isto", true},
+		{" \n isto e assim", "%S*$", "assim", true},
+		{" \n isto e assim", "[a-z]*$", "assim", true},
+		{"um caracter ? extra", "[^%sa-z]", "?", true},
+		{"", "a?", "", true},
+		{"e", "e?", "e", true},
+		{"ebl", "e?b?l?", "ebl", true},
+		{"  ebl", "e?b?l?", "", true},
+		{"aa", "^aa?a?a", "aa", true},
+		{"]]]eb", "[^]]", "e", true},
+		{"0alo alo", "%x*", "0a", true},
+		{"alo alo", "%C+", "alo alo", true},
+	}
+
+	for i, tt := range tests {
+		got, found := findSub(t, tt.s, tt.p)
+		if found != tt.found || got != tt.expected {
+			t.Errorf("tests[%d] - find(%q, %q) wrong. expected=%q (%v), got=%q (%v)",
+				i, tt.s, tt.p, tt.expected, tt.found, got, found)
+		}
+	}
+}
+
+func TestPatternMatch(t *testing.T) {
+	tests := []struct {
+		s, p     string
+		expected []string
+	}{
+		{"aaab", ".*b", []string{"aaab"}},
+		{"aaa", ".*a", []string{"aaa"}},
+		{"b", ".*b", []string{"b"}},
+		{"aaab", ".+b", []string{"aaab"}},
+		{"aaa", ".+a", []string{"aaa"}},
+		{"b", ".+b", nil},
+		{"aaab", ".?b", []string{"ab"}},
+		{"aaa", ".?a", []string{"aa"}},
+		{"b", ".?b", []string{"b"}},
+		{"alo xyzK", "(%w+)K", []string{"xyz"}},
+		{"254 K", "(%d*)K", []string{""}},
+		{"alo ", "(%w*)$", []string{""}},
+		{"alo ", "(%w+)$", nil},
+		{"alo alo", "^(((.).).* (%w*))$", []string{"alo alo", "al", "a", "alo"}},
+		{"0123456789", "(.+(.?)())", []string{"0123456789", "", "11"}},
+		{"==========", "^([=]*)=%1$", nil},
+		{"=======", "^(=*)=%1$", []string{"==="}},
+		{"ab\x00\x01\x02c", "[\x00-\x02]+", []string{"\x00\x01\x02"}},
+		{"ab\x00\x01\x02c", "[\x00-\x00]+", []string{"\x00"}},
+		{"abc\x00efg\x00\x01e\x01g", "%b\x00\x01", []string{"\x00efg\x00\x01e\x01"}},
+		{"abc\x00\x00\x00", "%\x00+", []string{"\x00\x00\x00"}},
+		{"abc\x00\x00\x00", "%\x00%\x00?", []string{"\x00\x00"}},
+		{" alo aalo allo", "%f[%S](.-%f[%s].-%f[%S])", []string{"alo "}},
+		{"key = value", "(%w+)%s*=%s*(%w+)", []string{"key", "value"}},
+	}
+
+	for i, tt := range tests {
+		caps, err := patternMatch(tt.s, tt.p, 1, patternLimits{})
+		if err != nil {
+			t.Fatalf("tests[%d] - unexpected error: %v", i, err)
+		}
+		if tt.expected == nil {
+			if caps != nil {
+				t.Errorf("tests[%d] - match(%q, %q) expected no match, got=%v", i, tt.s, tt.p, caps)
+			}
+			continue
+		}
+		if len(caps) != len(tt.expected) {
+			t.Fatalf("tests[%d] - match(%q, %q) capture count wrong. expected=%d, got=%d",
+				i, tt.s, tt.p, len(tt.expected), len(caps))
+		}
+		for j, c := range caps {
+			if c.String() != tt.expected[j] {
+				t.Errorf("tests[%d] - capture %d wrong. expected=%q, got=%q", i, j+1, tt.expected[j], c.String())
+			}
+		}
+	}
+}
+
+func TestPatternPositionCapture(t *testing.T) {
+	caps, err := patternMatch("0123456789", "(.+(.?)())", 1, patternLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !caps[2].IsPos || caps[2].Position != 11 {
+		t.Errorf("expected position capture 11, got=%+v", caps[2])
+	}
+	if caps[0].IsPos {
+		t.Errorf("expected string capture, got position %d", caps[0].Position)
+	}
+}
+
+func TestPatternGsubString(t *testing.T) {
+	tests := []struct {
+		s, p, repl string
+		maxN       int
+		expected   string
+		expectedN  int
+	}{
+		{"hello world", "o", "0", -1, "hell0 w0rld", 2},
+		{"alo ulo  ", " +$", "", -1, "alo ulo", 1},
+		{"  alo alo  ", "^%s*(.-)%s*$", "%1", -1, "alo alo", 1},
+		{"alo  alo  \n 123\n ", "%s+", " ", -1, "alo alo 123 ", 3},
+		{"abc d", "(.)", "%1@", -1, "a@b@c@ @d@", 5},
+		{"abc d", "", "@", -1, "@a@b@c@ @d@", 6},
+		{"abcd", "(.)", "%0@", 2, "a@b@cd", 2},
+		{"alo alo", "()[al]", "%1", -1, "12o 56o", 4},
+		{"abc=xyz", "(%w*)(%p)(%w+)", "%3%2%1-%0", -1, "xyz=abc-abc=xyz", 1},
+		{"abc", "%w", "%1%0", -1, "aabbcc", 3},
+		{"abc", "%w+", "%0%1", -1, "abcabc", 1},
+		{"abc", "$", "\x00de", -1, "abc\x00de", 1},
+		{"", "^", "r", -1, "r", 1},
+		{"", "$", "r", -1, "r", 1},
+		{"a b cd", " *", "-", -1, "-a-b-c-d-", 5},
+		{"alo 'oi' alo", "%b''", "\"", -1, "alo \" alo", 1},
+		{"aaa aa a aaa a", "%f[%w]%a", "x", -1, "xaa xa x xaa x", 5},
+		{"[[]] [][] [[[[", "%f[[].", "x", -1, "x[]] x]x] x[[[", 4},
+		{"01abc45de3", "%f[%d]", ".", -1, ".01abc.45de.3", 3},
+		{"01abc45 de3x", "%f[%D]%w", ".", -1, "01.bc45 de3.", 2},
+		{"function", "%f[\x01-\xff]%w", ".", -1, ".unction", 1},
+		{"function", "%f[^\x01-\xff]", ".", -1, "function.", 1},
+		{"100%", "%%", "%%%%", -1, "100%%", 1},
+	}
+
+	for i, tt := range tests {
+		got, n := gsubString(t, tt.s, tt.p, tt.repl, tt.maxN)
+		if got != tt.expected || n != tt.expectedN {
+			t.Errorf("tests[%d] - gsub(%q, %q, %q) wrong. expected=%q (%d), got=%q (%d)",
+				i, tt.s, tt.p, tt.repl, tt.expected, tt.expectedN, got, n)
+		}
+	}
+}
+
+func TestPatternGsubReplacers(t *testing.T) {
+	// table replacement: look up the first capture
+	table := func(m map[string]any) patternReplacer {
+		return func(whole string, caps []patternCapture) (string, bool, error) {
+			v, ok := m[caps[0].String()]
+			if !ok || v == false {
+				return "", true, nil
+			}
+			return v.(string), false, nil
+		}
+	}
+	tests := []struct {
+		s, p     string
+		repl     patternReplacer
+		expected string
+	}{
+		{"alo alo", ".", table(map[string]any{}), "alo alo"},
+		{"alo alo", "(.)", table(map[string]any{"a": "AA", "l": ""}), "AAo AAo"},
+		{"alo alo", "(.).", table(map[string]any{"a": "AA", "l": "K"}), "AAo AAo"},
+		{"alo alo", "((.)(.?))", table(map[string]any{"al": "AA", "o": false}), "AAo AAo"},
+		{"um (dois) tres (quatro)", "(%(%w+%))", func(whole string, caps []patternCapture) (string, bool, error) {
+			return strings.ToUpper(caps[0].Value), false, nil
+		}, "um (DOIS) tres (QUATRO)"},
+	}
+
+	for i, tt := range tests {
+		got, _, err := patternGsub(tt.s, tt.p, -1, tt.repl, patternLimits{}, -1)
+		if err != nil {
+			t.Fatalf("tests[%d] - unexpected error: %v", i, err)
+		}
+		if got != tt.expected {
+			t.Errorf("tests[%d] - gsub(%q, %q) wrong. expected=%q, got=%q", i, tt.s, tt.p, tt.expected, got)
+		}
+	}
+
+	// function replacement with position captures
+	lens := map[int]int{}
+	s := "a alo jose  joao"
+	r, _, err := patternGsub(s, "()(%w+)()", -1, func(whole string, caps []patternCapture) (string, bool, error) {
+		lens[caps[0].Position] = caps[2].Position - caps[0].Position
+		return "", true, nil
+	}, patternLimits{}, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != s || lens[1] != 1 || lens[3] != 3 || lens[7] != 4 || lens[13] != 4 {
+		t.Errorf("gsub with position captures wrong. got=%q %v", r, lens)
+	}
+}
+
+func TestPatternGmatch(t *testing.T) {
+	collect := func(s, p string, init int) []string {
+		var out []string
+		it := newPatternIter(s, p, init, patternLimits{})
+		for {
+			caps, err := it.next()
+			if err != nil {
+				t.Fatalf("gmatch(%q, %q) - unexpected error: %v", s, p, err)
+			}
+			if caps == nil {
+				return out
+			}
+			parts := make([]string, len(caps))
+			for i, c := range caps {
+				parts[i] = c.String()
+			}
+			out = append(out, strings.Join(parts, ","))
+		}
+	}
+
+	tests := []struct {
+		s, p     string
+		init     int
+		expected []string
+	}{
+		{"abcde", "()", 1, []string{"1", "2", "3", "4", "5", "6"}},
+		{"first second word", "%w+", 1, []string{"first", "second", "word"}},
+		{"xuxx uu ppar r", "()(.)%2", 1, []string{"3,x", "6,u", "9,p"}},
+		{"13 14 10 = 11, 15= 16, 22=23", "(%d+)%s*=%s*(%d+)", 1, []string{"10,11", "15,16", "22,23"}},
+		{"a  \nbc\t\td", "()%s*()", 1, []string{"1,1", "2,5", "6,6", "7,9", "10,10"}},
+		{"10 20 30", "%d+", 3, []string{"20", "30"}},
+		{"11 21 31", "%d+", -4, []string{"1", "31"}},
+		{"11 21 31", "%w*", 9, []string{""}},
+		{"11 21 31", "%w*", 10, nil},
+		{"alo alo th02 is 1hat", "()%f[%w%d]", 1, []string{"1", "5", "9", "14", "17"}},
+	}
+
+	for i, tt := range tests {
+		got := collect(tt.s, tt.p, tt.init)
+		if strings.Join(got, "|") != strings.Join(tt.expected, "|") || len(got) != len(tt.expected) {
+			t.Errorf("tests[%d] - gmatch(%q, %q) wrong. expected=%q, got=%q", i, tt.s, tt.p, tt.expected, got)
+		}
+	}
+}
+
+func TestPatternBalance(t *testing.T) {
+	isBalanced := func(s string) bool {
+		r, _ := gsubString(t, s, "%b()", "", -1)
+		_, found := findSub(t, r, "[()]")
+		return !found
+	}
+	if !isBalanced("(9 ((8))(\x00) 7) \x00\x00 a b ()(c)() a") {
+		t.Errorf("expected balanced string")
+	}
+	if isBalanced("(9 ((8) 7) a b (\x00 c) a") {
+		t.Errorf("expected unbalanced string")
+	}
+}
+
+func TestPatternCharacterSets(t *testing.T) {
+	var all strings.Builder
+	for c := 0; c < 256; c++ {
+		all.WriteByte(byte(c))
+	}
+	abc := all.String()
+	set := func(p string) string {
+		var b strings.Builder
+		patternGsub(abc, p, -1, func(whole string, caps []patternCapture) (string, bool, error) {
+			b.WriteString(whole)
+			return "", true, nil
+		}, patternLimits{}, -1)
+		return b.String()
+	}
+
+	tests := []struct {
+		p        string
+		expected string
+	}{
+		{"[a-z]", "abcdefghijklmnopqrstuvwxyz"},
+		{"[a-z%d]", set("[%da-uu-z]")},
+		{"[a-]", "-a"},
+		{"[^%W]", set("[%w]")},
+		{"[]%%]", "%]"},
+		{"[a%-z]", "-az"},
+		{"[%^%[%-a%]%-b]", "-[]^ab"},
+		{"%Z", set("[\x01-\xff]")},
+		{".", set("[\x01-\xff%z]")},
+	}
+	for i, tt := range tests {
+		if got := set(tt.p); got != tt.expected {
+			t.Errorf("tests[%d] - set %q wrong. expected=%q, got=%q", i, tt.p, tt.expected, got)
+		}
+	}
+	if n := len(set("[\xc8-\xd2]")); n != 11 {
+		t.Errorf("expected 11 characters in range, got=%d", n)
+	}
+}
+
+func TestPatternErrors(t *testing.T) {
+	tests := []struct {
+		p        string
+		expected string
+	}{
+		{"(.", "unfinished capture"},
+		{".)", "invalid pattern capture"},
+		{"[a", "malformed"},
+		{"[]", "malformed"},
+		{"[^]", "malformed"},
+		{"[a%]", "malformed"},
+		{"[a%", "malformed"},
+		{"%b", "malformed"},
+		{"%ba", "malformed"},
+		{"%", "malformed"},
+		{"%f", "missing"},
+		{"(%0)", "invalid capture index %0"},
+		{"(%1)", "invalid capture index %1"},
+	}
+
+	for i, tt := range tests {
+		_, _, _, err := patternFind("alo", tt.p, 1, false, patternLimits{})
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("tests[%d] - find(%q) error wrong. expected=%q, got=%v", i, tt.p, tt.expected, err)
+		}
+	}
+
+	replTests := []struct {
+		repl     string
+		expected string
+	}{
+		{"%2", "invalid capture index %2"},
+		{"%x", "invalid use of '%' in replacement string"},
+		{"%", "invalid use of '%' in replacement string"},
+	}
+	for i, tt := range replTests {
+		_, _, err := patternGsub("alo", ".", -1, stringReplacer(tt.repl), patternLimits{}, -1)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("tests[%d] - gsub repl %q error wrong. expected=%q, got=%v", i, tt.repl, tt.expected, err)
+		}
+	}
+}
+
+func TestPatternStepLimit(t *testing.T) {
+	big := strings.Repeat("a", 300000)
+	if _, found := findSub(t, big, "^a*.?$"); !found {
+		t.Errorf("expected match on big string")
+	}
+	if _, found := findSub(t, big, "^a*.?b$"); found {
+		t.Errorf("expected no match on big string")
+	}
+	if _, found := findSub(t, big, "^a-.?$"); !found {
+		t.Errorf("expected match on big string")
+	}
+
+	// backtracking through many lazy captures never finds the 'x'
+	_, _, _, err := patternFind(strings.Repeat("a", 200), "(.-)(.-)(.-)(.-)(.-)x", 1, false, patternLimits{})
+	if !errors.Is(err, ErrPatternTooComplex) {
+		t.Errorf("expected ErrPatternTooComplex, got=%v", err)
+	}
+
+	_, _, _, err = patternFind("aaaa", "a-a-a-b", 1, false, patternLimits{maxSteps: 10})
+	if !errors.Is(err, ErrPatternTooComplex) {
+		t.Errorf("expected ErrPatternTooComplex with small budget, got=%v", err)
+	}
+
+	// the budget is per match attempt, not for a whole gsub or gmatch
+	huge := strings.Repeat("a", 3000000)
+	r, n, err := patternGsub(huge, "a", -1, stringReplacer("b"), patternLimits{}, -1)
+	if err != nil || n != len(huge) || r != strings.Repeat("b", len(huge)) {
+		t.Errorf("gsub on a large subject: n=%d err=%v", n, err)
+	}
+	it := newPatternIter(huge, "a", 1, patternLimits{})
+	matches := 0
+	for {
+		caps, err := it.next()
+		if err != nil {
+			t.Fatalf("gmatch on a large subject: %v", err)
+		}
+		if caps == nil {
+			break
+		}
+		matches++
+	}
+	if matches != len(huge) {
+		t.Errorf("gmatch on a large subject: %d matches, expected %d", matches, len(huge))
+	}
+}
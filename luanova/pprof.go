@@ -0,0 +1,181 @@
+package luanova
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// This file writes profiles in the gzipped protocol buffer format of
+// pprof, described by profile.proto in github.com/google/pprof.
+
+// Field numbers of profile.proto.
+const (
+	profileSampleType    = 1
+	profileSample        = 2
+	profileLocation      = 4
+	profileFunction      = 5
+	profileStringTable   = 6
+	profileTimeNanos     = 9
+	profileDurationNanos = 10
+	profilePeriodType    = 11
+	profilePeriod        = 12
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocation = 1
+	sampleValue    = 2
+
+	locationID   = 1
+	locationLine = 4
+
+	lineFunction = 1
+	lineLine     = 2
+
+	functionID        = 1
+	functionName      = 2
+	functionFilename  = 4
+	functionStartLine = 5
+)
+
+// protobuf is a buffer of encoded protocol buffer fields.
+type protobuf struct {
+	data []byte
+}
+
+func (b *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protobuf) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.varint(uint64(field)<<3 | 0)
+	b.varint(x)
+}
+
+func (b *protobuf) int64(field int, x int64) {
+	b.uint64(field, uint64(x))
+}
+
+func (b *protobuf) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *protobuf) message(field int, m *protobuf) {
+	b.bytes(field, m.data)
+}
+
+func (b *protobuf) packed(field int, xs []uint64) {
+	if len(xs) == 0 {
+		return
+	}
+	var p protobuf
+	for _, x := range xs {
+		p.varint(x)
+	}
+	b.bytes(field, p.data)
+}
+
+// pprofWriter builds a profile; strings, functions and locations are
+// numbered as they are first used.
+type pprofWriter struct {
+	b         protobuf
+	strings   map[string]int
+	functions map[profFunc]uint64
+	locations map[profLoc]uint64
+	tail      protobuf // functions and locations, written after the samples
+	table     []string
+}
+
+func newPprofWriter() *pprofWriter {
+	return &pprofWriter{
+		strings:   map[string]int{"": 0},
+		functions: map[profFunc]uint64{},
+		locations: map[profLoc]uint64{},
+		table:     []string{""},
+	}
+}
+
+func (w *pprofWriter) str(s string) int64 {
+	i, ok := w.strings[s]
+	if !ok {
+		i = len(w.table)
+		w.strings[s] = i
+		w.table = append(w.table, s)
+	}
+	return int64(i)
+}
+
+func (w *pprofWriter) valueType(field int, typ, unit string) {
+	var m protobuf
+	m.int64(valueTypeType, w.str(typ))
+	m.int64(valueTypeUnit, w.str(unit))
+	w.b.message(field, &m)
+}
+
+func (w *pprofWriter) function(f profFunc) uint64 {
+	id, ok := w.functions[f]
+	if !ok {
+		id = uint64(len(w.functions) + 1)
+		w.functions[f] = id
+		var m protobuf
+		m.uint64(functionID, id)
+		m.int64(functionName, w.str(f.name))
+		m.int64(functionFilename, w.str(f.source))
+		m.int64(functionStartLine, int64(f.line))
+		w.tail.message(profileFunction, &m)
+	}
+	return id
+}
+
+func (w *pprofWriter) location(l profLoc) uint64 {
+	id, ok := w.locations[l]
+	if !ok {
+		id = uint64(len(w.locations) + 1)
+		w.locations[l] = id
+		var line protobuf
+		line.uint64(lineFunction, w.function(l.fn))
+		line.int64(lineLine, int64(l.line))
+		var m protobuf
+		m.uint64(locationID, id)
+		m.message(locationLine, &line)
+		w.tail.message(profileLocation, &m)
+	}
+	return id
+}
+
+func (w *pprofWriter) sample(stack []profLoc, values ...int64) {
+	ids := make([]uint64, len(stack))
+	for i, l := range stack {
+		ids[i] = w.location(l)
+	}
+	vs := make([]uint64, len(values))
+	for i, v := range values {
+		vs[i] = uint64(v)
+	}
+	var m protobuf
+	m.packed(sampleLocation, ids)
+	m.packed(sampleValue, vs)
+	w.b.message(profileSample, &m)
+}
+
+// flush writes the profile gzipped to out.
+func (w *pprofWriter) flush(out io.Writer) error {
+	w.b.data = append(w.b.data, w.tail.data...)
+	for _, s := range w.table {
+		w.b.bytes(profileStringTable, []byte(s))
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := zw.Write(w.b.data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
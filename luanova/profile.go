@@ -0,0 +1,259 @@
+package luanova
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultProfilePeriod is the sampling interval of a Profiler.
+const DefaultProfilePeriod = 10 * time.Millisecond
+
+// ProfileOptions configures a Profiler.
+type ProfileOptions struct {
+	// Instrument charges the time between consecutive calls, returns
+	// and statements to the running line instead of sampling the stack
+	// periodically. It is exact but slows scripts down more.
+	Instrument bool
+	// Period is the sampling interval; zero means DefaultProfilePeriod.
+	Period time.Duration
+}
+
+// Profiler attributes the time and the allocations of a State to the
+// functions and lines of its scripts. Allocations are those counted by
+// Limits.MaxMemory, and all of them are recorded.
+type Profiler struct {
+	s         *State
+	opts      ProfileOptions
+	hooksWas  *hooks // the hook of the State before the profiler
+	countWas  int
+	start     time.Time
+	duration  time.Duration
+	cpu       map[string]*stackSample
+	mem       map[string]*stackSample
+	tick      atomic.Bool
+	done      chan struct{}
+	last      time.Time // end of the time charged so far
+	lastFr    *frame
+	lastLine  int
+	stopped   bool
+	keyBuffer strings.Builder
+}
+
+// profFunc is a function as a profile shows it.
+type profFunc struct {
+	name   string
+	source string
+	line   int
+}
+
+// profLoc is a line of a function.
+type profLoc struct {
+	fn   profFunc
+	line int
+}
+
+type stackSample struct {
+	stack  []profLoc // innermost first
+	values [2]int64
+}
+
+// StartProfiler starts profiling s. The profiler replaces the hook of s
+// until Stop, which restores it.
+func (s *State) StartProfiler(opts ProfileOptions) *Profiler {
+	if opts.Period <= 0 {
+		opts.Period = DefaultProfilePeriod
+	}
+	p := &Profiler{
+		s:        s,
+		opts:     opts,
+		hooksWas: s.hooks,
+		countWas: s.countEvery,
+		start:    time.Now(),
+		cpu:      map[string]*stackSample{},
+		mem:      map[string]*stackSample{},
+		done:     make(chan struct{}),
+	}
+	p.last = p.start
+	s.SetHookCount(1)
+	if opts.Instrument {
+		s.SetHook(HookCall|HookReturn|HookCount, p.instrument)
+	} else {
+		s.SetHook(HookCall|HookReturn|HookCount, p.sample)
+		go p.ticker()
+	}
+	s.profiler = p
+	return p
+}
+
+// Stop stops profiling and restores the hook the State had before. It
+// must not be called while the State runs.
+func (p *Profiler) Stop() {
+	if p.stopped {
+		return
+	}
+	p.stopped = true
+	p.duration = time.Since(p.start)
+	close(p.done)
+	p.s.hooks, p.s.countEvery = p.hooksWas, p.countWas
+	if p.s.hooks != nil {
+		p.s.hooks.left = p.s.hookCount()
+	}
+	if p.s.profiler == p {
+		p.s.profiler = nil
+	}
+}
+
+func (p *Profiler) ticker() {
+	t := time.NewTicker(p.opts.Period)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			p.tick.Store(true)
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// sample charges the time since the last sample to the stack when the
+// ticker asks for one.
+func (p *Profiler) sample(s *State, ev HookEvent) {
+	if p.outside(s, ev) || !p.tick.Swap(false) {
+		return
+	}
+	now := time.Now()
+	skip := 0
+	if ev.Kind == HookCall {
+		// the callee has only just been entered: the caller ran
+		skip = 1
+	}
+	p.add(p.cpu, p.stack(skip, -1), int64(now.Sub(p.last)))
+	p.last = now
+}
+
+// instrument charges the time since the last event to the line that
+// ran meanwhile.
+func (p *Profiler) instrument(s *State, ev HookEvent) {
+	if p.outside(s, ev) {
+		return
+	}
+	elapsed := int64(time.Since(p.last))
+	top := s.calls[len(s.calls)-1]
+	switch ev.Kind {
+	case HookCall:
+		// the caller ran until the call
+		p.add(p.cpu, p.stack(1, -1), elapsed)
+	case HookCount:
+		line := -1
+		if top.fr == p.lastFr {
+			line = p.lastLine
+		}
+		p.add(p.cpu, p.stack(0, line), elapsed)
+	default:
+		p.add(p.cpu, p.stack(0, -1), elapsed)
+	}
+	p.lastFr, p.lastLine = top.fr, 0
+	if top.fr != nil {
+		p.lastLine = top.fr.line
+	}
+	p.last = time.Now()
+}
+
+// outside handles the calls made from Go, between which no script runs:
+// the time before such a call is not charged.
+func (p *Profiler) outside(s *State, ev HookEvent) bool {
+	if len(s.calls) == 1 && ev.Kind == HookCall {
+		p.last = time.Now()
+		p.tick.Store(false)
+		p.lastFr = nil
+		return true
+	}
+	return false
+}
+
+// alloc records an allocation of n bytes.
+func (p *Profiler) alloc(n int) {
+	p.add(p.mem, p.stack(0, -1), int64(n))
+}
+
+// stack returns the running stack without its skip innermost calls; a
+// line other than -1 replaces the current line of the innermost call.
+func (p *Profiler) stack(skip, line int) []profLoc {
+	calls := p.s.calls
+	var stack []profLoc
+	for i := len(calls) - 1 - skip; i >= 0; i-- {
+		f := calls[i].frame()
+		loc := profLoc{profFunc{f.funcName(), f.Source, f.DefLine}, f.Line}
+		if f.What == "Go" {
+			loc.fn.source = "[Go]"
+		}
+		if line >= 0 && len(stack) == 0 {
+			loc.line = line
+		}
+		stack = append(stack, loc)
+	}
+	return stack
+}
+
+// add adds a sample of value to the stack.
+func (p *Profiler) add(samples map[string]*stackSample, stack []profLoc, value int64) {
+	if len(stack) == 0 {
+		return
+	}
+	b := &p.keyBuffer
+	b.Reset()
+	for _, l := range stack {
+		b.WriteString(l.fn.source)
+		b.WriteByte(0)
+		b.WriteString(l.fn.name)
+		b.WriteByte(0)
+		b.WriteString(strconv.Itoa(l.fn.line))
+		b.WriteByte(0)
+		b.WriteString(strconv.Itoa(l.line))
+		b.WriteByte(0)
+	}
+	sample := samples[b.String()]
+	if sample == nil {
+		sample = &stackSample{stack: stack}
+		samples[b.String()] = sample
+	}
+	sample.values[0]++
+	sample.values[1] += value
+}
+
+// WriteCPUProfile writes the time profile in pprof format. The profiler
+// must be stopped.
+func (p *Profiler) WriteCPUProfile(w io.Writer) error {
+	return p.write(w, p.cpu, [2][2]string{{"samples", "count"}, {"cpu", "nanoseconds"}}, int64(p.opts.Period))
+}
+
+// WriteMemProfile writes the allocation profile in pprof format. The
+// profiler must be stopped.
+func (p *Profiler) WriteMemProfile(w io.Writer) error {
+	return p.write(w, p.mem, [2][2]string{{"alloc_objects", "count"}, {"alloc_space", "bytes"}}, 1)
+}
+
+func (p *Profiler) write(out io.Writer, samples map[string]*stackSample, types [2][2]string, period int64) error {
+	w := newPprofWriter()
+	for _, t := range types {
+		w.valueType(profileSampleType, t[0], t[1])
+	}
+	keys := make([]string, 0, len(samples))
+	for k := range samples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		w.sample(samples[k].stack, samples[k].values[:]...)
+	}
+	w.b.int64(profileTimeNanos, p.start.UnixNano())
+	w.b.int64(profileDurationNanos, int64(p.duration))
+	w.valueType(profilePeriodType, types[1][0], types[1][1])
+	w.b.int64(profilePeriod, period)
+	return w.flush(out)
+}
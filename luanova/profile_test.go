@@ -0,0 +1,289 @@
+package luanova
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+type pbField struct {
+	num int
+	v   uint64
+	b   []byte
+}
+
+// parsePB splits a protocol buffer message into its fields; packed
+// varints are left as bytes.
+func parsePB(t *testing.T, data []byte) []pbField {
+	t.Helper()
+	var fields []pbField
+	varint := func() uint64 {
+		var x uint64
+		for shift := 0; ; shift += 7 {
+			if len(data) == 0 {
+				t.Fatal("truncated varint")
+			}
+			c := data[0]
+			data = data[1:]
+			x |= uint64(c&0x7f) << shift
+			if c < 0x80 {
+				return x
+			}
+		}
+	}
+	for len(data) > 0 {
+		key := varint()
+		f := pbField{num: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			f.v = varint()
+		case 2:
+			n := varint()
+			f.b, data = data[:n], data[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func packedPB(f pbField) []uint64 {
+	var xs []uint64
+	var x uint64
+	shift := 0
+	for _, c := range f.b {
+		x |= uint64(c&0x7f) << shift
+		shift += 7
+		if c < 0x80 {
+			xs = append(xs, x)
+			x, shift = 0, 0
+		}
+	}
+	return xs
+}
+
+// readProfile decodes a profile into its sample types and samples,
+// rendered as "fn:line;caller:line value value".
+func readProfile(t *testing.T, data []byte) (types []string, samples []string) {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := parsePB(t, raw)
+	var strs []string
+	for _, f := range fields {
+		if f.num == profileStringTable {
+			strs = append(strs, string(f.b))
+		}
+	}
+	funcs := map[uint64]string{}
+	locs := map[uint64]string{}
+	for _, f := range fields {
+		switch f.num {
+		case profileSampleType:
+			m := parsePB(t, f.b)
+			types = append(types, strs[m[0].v]+"/"+strs[m[1].v])
+		case profileFunction:
+			var id uint64
+			var name string
+			for _, g := range parsePB(t, f.b) {
+				switch g.num {
+				case functionID:
+					id = g.v
+				case functionName:
+					name = strs[g.v]
+				}
+			}
+			funcs[id] = name
+		}
+	}
+	for _, f := range fields {
+		if f.num != profileLocation {
+			continue
+		}
+		var id, fn, line uint64
+		for _, g := range parsePB(t, f.b) {
+			switch g.num {
+			case locationID:
+				id = g.v
+			case locationLine:
+				for _, h := range parsePB(t, g.b) {
+					if h.num == lineFunction {
+						fn = h.v
+					} else {
+						line = h.v
+					}
+				}
+			}
+		}
+		locs[id] = fmt.Sprintf("%s:%d", funcs[fn], line)
+	}
+	for _, f := range fields {
+		if f.num != profileSample {
+			continue
+		}
+		var stack []string
+		var values []uint64
+		for _, g := range parsePB(t, f.b) {
+			switch g.num {
+			case sampleLocation:
+				for _, id := range packedPB(g) {
+					stack = append(stack, locs[id])
+				}
+			case sampleValue:
+				values = packedPB(g)
+			}
+		}
+		samples = append(samples, fmt.Sprintf("%s %v", strings.Join(stack, ";"), values))
+	}
+	return types, samples
+}
+
+const profiledScript = `local function build(n)
+	local t = {}
+	for i = 1, n do
+		t[i] = "item" .. i
+	end
+	return t
+end
+local function spin(n)
+	local x = 0
+	for i = 1, n do x = x + i end
+	return x
+end
+local total = 0
+for i = 1, 20 do
+	total = total + spin(5000) + #build(10)
+end
+return total
+`
+
+func TestProfiler(t *testing.T) {
+	for _, instrument := range []bool{false, true} {
+		t.Run(fmt.Sprint("instrument=", instrument), func(t *testing.T) {
+			s := NewState()
+			fn, err := s.Load(profiledScript, "prof.lunv")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := s.StartProfiler(ProfileOptions{Instrument: instrument, Period: time.Millisecond})
+			// run until the sampler has seen the loop, which a loaded
+			// machine may delay
+			sampled := func() (n int64) {
+				for _, s := range p.cpu {
+					n += s.values[0]
+				}
+				return n
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for runs := 0; runs < 3 || sampled() < 10 && time.Now().Before(deadline); runs++ {
+				if _, err := s.PCall(fn); err != nil {
+					t.Fatal(err)
+				}
+			}
+			p.Stop()
+			if s.hooks != nil || s.profiler != nil {
+				t.Error("Stop left the profiler installed")
+			}
+
+			var cpu bytes.Buffer
+			if err := p.WriteCPUProfile(&cpu); err != nil {
+				t.Fatal(err)
+			}
+			types, samples := readProfile(t, cpu.Bytes())
+			if strings.Join(types, " ") != "samples/count cpu/nanoseconds" {
+				t.Errorf("cpu sample types = %v", types)
+			}
+			all := strings.Join(samples, "\n")
+			if !strings.Contains(all, "spin:10;main chunk:15 ") {
+				t.Errorf("no time in the loop of spin:\n%s", all)
+			}
+
+			var mem bytes.Buffer
+			if err := p.WriteMemProfile(&mem); err != nil {
+				t.Fatal(err)
+			}
+			types, samples = readProfile(t, mem.Bytes())
+			if strings.Join(types, " ") != "alloc_objects/count alloc_space/bytes" {
+				t.Errorf("mem sample types = %v", types)
+			}
+			all = strings.Join(samples, "\n")
+			for _, want := range []string{"build:2;main chunk:15 ", "build:4;main chunk:15 "} {
+				if !strings.Contains(all, want) {
+					t.Errorf("no allocations at %s:\n%s", want, all)
+				}
+			}
+			if strings.Contains(all, "spin:") {
+				t.Errorf("allocations charged to spin:\n%s", all)
+			}
+		})
+	}
+}
+
+func TestProfilerSampleAtCall(t *testing.T) {
+	s := NewState()
+	p := s.StartProfiler(ProfileOptions{Period: time.Hour})
+	s.Register("probe", func(s *State) int {
+		// what the hook sees when the ticker fires just before probe
+		p.last = time.Now().Add(-time.Second)
+		p.tick.Store(true)
+		p.sample(s, HookEvent{Kind: HookCall})
+		return 0
+	})
+	fn, err := s.Load("local function caller()\n\tprobe()\nend\ncaller()", "call.lunv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PCall(fn); err != nil {
+		t.Fatal(err)
+	}
+	p.Stop()
+
+	var stacks []string
+	for _, sample := range p.cpu {
+		var names []string
+		for _, l := range sample.stack {
+			names = append(names, fmt.Sprintf("%s:%d", l.fn.name, l.line))
+		}
+		stacks = append(stacks, strings.Join(names, ";"))
+	}
+	if len(stacks) != 1 || stacks[0] != "caller:2;main chunk:4" {
+		t.Errorf("samples = %q, expected the time charged to caller", stacks)
+	}
+}
+
+func TestProfilerRestoresHook(t *testing.T) {
+	s := NewState()
+	var lines []int
+	s.SetHook(HookLine, func(s *State, ev HookEvent) {
+		lines = append(lines, ev.Pos.Line)
+	})
+	s.SetHookCount(5)
+	p := s.StartProfiler(ProfileOptions{})
+	if err := s.DoString("local x = 1\nx = x + 1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 0 {
+		t.Errorf("the hook ran while profiling: %v", lines)
+	}
+	p.Stop()
+	if s.hooks == nil || s.hooks.mask != HookLine || s.countEvery != 5 {
+		t.Fatalf("Stop did not restore the hook: %+v, count %d", s.hooks, s.countEvery)
+	}
+	if err := s.DoString("local x = 1\nx = x + 1"); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(lines) != "[1 2]" {
+		t.Errorf("lines after Stop = %v", lines)
+	}
+}
@@ -0,0 +1,201 @@
+package luanova
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Document highlight kinds of the protocol.
+const (
+	highlightRead  = 2
+	highlightWrite = 3
+)
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspDocumentHighlight struct {
+	Range lspRange `json:"range"`
+	Kind  int      `json:"kind"`
+}
+
+// identAt returns the identifier at the protocol position p, if the
+// document parses; a position just after a name selects it.
+func (doc *document) identAt(p lspPosition) *Ident {
+	doc.analyze()
+	if doc.chunk == nil {
+		return nil
+	}
+	offset := doc.offset(p)
+	var found *Ident
+	Inspect(doc.chunk.Block, func(n Node) bool {
+		if n.Pos().Offset > offset || n.End().Offset < offset {
+			return false
+		}
+		if id, ok := n.(*Ident); ok && id.End().Offset-id.Pos().Offset == len(id.Name) {
+			// prefer the name starting at the position over the one ending there
+			if found == nil || id.Pos().Offset == offset {
+				found = id
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// symbol is a variable or a global with the identifiers naming it.
+type symbol struct {
+	v      *Variable // nil for a global
+	decl   *Ident    // nil for a global
+	reads  []*Ident
+	writes []*Ident
+}
+
+// symbolAt returns the symbol named at p.
+func (doc *document) symbolAt(p lspPosition) *symbol {
+	id := doc.identAt(p)
+	if id == nil {
+		return nil
+	}
+	if v := doc.res.VariableOf(id); v != nil {
+		return &symbol{v: v, decl: v.Name, reads: v.Reads, writes: v.Writes}
+	}
+	sym := &symbol{}
+	for _, g := range doc.res.Globals {
+		if g.Name.Name != id.Name {
+			continue
+		}
+		if g.Write {
+			sym.writes = append(sym.writes, g.Name)
+		} else {
+			sym.reads = append(sym.reads, g.Name)
+		}
+	}
+	if len(sym.reads)+len(sym.writes) == 0 {
+		return nil // a field or a method name
+	}
+	return sym
+}
+
+// idents returns the identifiers of sym in source order.
+func (sym *symbol) idents(withDecl bool) []*Ident {
+	var ids []*Ident
+	if withDecl && sym.decl != nil {
+		ids = append(ids, sym.decl)
+	}
+	ids = append(append(ids, sym.reads...), sym.writes...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Pos().Offset < ids[j].Pos().Offset })
+	return ids
+}
+
+func (doc *document) identRange(id *Ident) lspRange {
+	return lspRange{doc.position(id.Pos().Offset), doc.position(id.End().Offset)}
+}
+
+func (doc *document) references(p lspPosition, withDecl bool) []lspLocation {
+	locs := []lspLocation{}
+	if sym := doc.symbolAt(p); sym != nil {
+		for _, id := range sym.idents(withDecl) {
+			locs = append(locs, lspLocation{doc.uri, doc.identRange(id)})
+		}
+	}
+	return locs
+}
+
+func (doc *document) highlights(p lspPosition) []lspDocumentHighlight {
+	hs := []lspDocumentHighlight{}
+	sym := doc.symbolAt(p)
+	if sym == nil {
+		return hs
+	}
+	writes := map[*Ident]bool{sym.decl: true}
+	for _, id := range sym.writes {
+		writes[id] = true
+	}
+	for _, id := range sym.idents(true) {
+		kind := highlightRead
+		if writes[id] {
+			kind = highlightWrite
+		}
+		hs = append(hs, lspDocumentHighlight{doc.identRange(id), kind})
+	}
+	return hs
+}
+
+// renameTarget returns the variable to rename at p, or why it cannot be
+// renamed.
+func (doc *document) renameTarget(p lspPosition) (*Variable, error) {
+	sym := doc.symbolAt(p)
+	switch {
+	case sym == nil:
+		return nil, fmt.Errorf("no variable to rename here")
+	case sym.v == nil:
+		return nil, fmt.Errorf("cannot rename the global %s", sym.idents(false)[0].Name)
+	case sym.v.Kind == VarSelf:
+		return nil, fmt.Errorf("cannot rename self")
+	}
+	// names that other modules see too
+	public := map[*Ident]bool{}
+	for _, stmt := range doc.chunk.Block.Stmts {
+		switch stmt := stmt.(type) {
+		case *ImportStmt:
+			for _, spec := range stmt.Names {
+				if spec.Alias == nil {
+					public[spec.Name] = true
+				}
+			}
+		case *ExportStmt:
+			for _, spec := range stmt.Names {
+				if spec.Alias == nil {
+					public[spec.Name] = true
+				}
+			}
+			switch d := stmt.Decl.(type) {
+			case *LocalStmt:
+				for _, b := range d.Names {
+					public[b.Name] = true
+				}
+			case *LocalFunctionStmt:
+				public[d.Name] = true
+			}
+		}
+	}
+	for _, id := range sym.idents(true) {
+		if public[id] {
+			return nil, fmt.Errorf("%s is imported or exported under this name; add an alias with as to rename it", id.Name)
+		}
+	}
+	return sym.v, nil
+}
+
+func (doc *document) prepareRename(p lspPosition) (any, error) {
+	v, err := doc.renameTarget(p)
+	if err != nil {
+		return nil, err
+	}
+	id := doc.identAt(p)
+	return map[string]any{"range": doc.identRange(id), "placeholder": v.Name.Name}, nil
+}
+
+func (doc *document) rename(p lspPosition, name string) (any, error) {
+	v, err := doc.renameTarget(p)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := doc.res.Rename(v, name)
+	if err != nil {
+		return nil, err
+	}
+	edits := []lspTextEdit{}
+	for _, id := range ids {
+		edits = append(edits, lspTextEdit{doc.identRange(id), name})
+	}
+	return doc.workspaceEdit(edits...), nil
+}
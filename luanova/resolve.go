@@ -0,0 +1,356 @@
+package luanova
+
+import (
+	"fmt"
+	"sort"
+)
+
+// VarKind tells how a local variable was declared.
+type VarKind int
+
+const (
+	VarLocal    VarKind = iota // local x
+	VarFunction                // local function f
+	VarParam                   // function parameter
+	VarSelf                    // implicit self of a method
+	VarLoop                    // for loop variable
+	VarImport                  // name bound by import
+)
+
+// Variable is a local variable of a chunk. Reads and Writes list the
+// identifiers that use it, in source order; `x += 1` is a write.
+type Variable struct {
+	Name     *Ident
+	Kind     VarKind
+	Func     *FunctionExpr // enclosing function, nil in the main chunk
+	Scope    *Scope
+	Reads    []*Ident
+	Writes   []*Ident
+	Exported bool
+	Shadows  *Variable // visible variable of the same name, if any
+
+	seq int // order of the declaration among the names of the chunk
+}
+
+// Scope is a lexical scope: a block, the variables of a for loop or the
+// parameters of a function. A variable is visible in its scope and the
+// nested ones from its declaration on.
+type Scope struct {
+	Parent *Scope
+	Vars   []*Variable // in declaration order
+}
+
+// GlobalRef is a use of a global variable.
+type GlobalRef struct {
+	Name  *Ident
+	Write bool
+	Func  *FunctionExpr // enclosing function, nil in the main chunk
+	Stmt  Stmt          // statement holding the use
+}
+
+// Resolution binds the identifiers of a chunk to their variables.
+type Resolution struct {
+	Scope   *Scope      // of the main chunk
+	Vars    []*Variable // in declaration order
+	Globals []*GlobalRef
+	uses    map[*Ident]*Variable
+	refs    map[*Ident]site
+}
+
+// site is where a name is declared or used: its scope and its order
+// among the names of the chunk.
+type site struct {
+	scope *Scope
+	seq   int
+}
+
+// VariableOf returns the variable an identifier declares or uses, or
+// nil if it is a global or not a variable name.
+func (r *Resolution) VariableOf(id *Ident) *Variable {
+	return r.uses[id]
+}
+
+// Resolve binds the identifiers of chunk following the scoping rules of
+// the compiler.
+func Resolve(chunk *Chunk) *Resolution {
+	r := &resolver{res: &Resolution{uses: map[*Ident]*Variable{}, refs: map[*Ident]site{}}}
+	r.open()
+	r.res.Scope = r.scope
+	r.stmts(chunk.Block.Stmts)
+	r.close()
+	return r.res
+}
+
+// lookup returns the variable named name visible at b, or nil.
+func lookup(b site, name string) *Variable {
+	for s := b.scope; s != nil; s = s.Parent {
+		for i := len(s.Vars) - 1; i >= 0; i-- {
+			if v := s.Vars[i]; v.seq < b.seq && v.Name.Name == name {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+type resolver struct {
+	res   *Resolution
+	scope *Scope
+	seq   int
+	fn    *FunctionExpr
+	stmt  Stmt
+}
+
+func (r *resolver) open()  { r.scope = &Scope{Parent: r.scope} }
+func (r *resolver) close() { r.scope = r.scope.Parent }
+
+// bind records where id appears.
+func (r *resolver) bind(id *Ident) site {
+	r.seq++
+	b := site{r.scope, r.seq}
+	r.res.refs[id] = b
+	return b
+}
+
+func (r *resolver) declare(id *Ident, kind VarKind) *Variable {
+	b := r.bind(id)
+	v := &Variable{Name: id, Kind: kind, Func: r.fn, Scope: r.scope, Shadows: lookup(b, id.Name), seq: b.seq}
+	r.scope.Vars = append(r.scope.Vars, v)
+	r.res.Vars = append(r.res.Vars, v)
+	r.res.uses[id] = v
+	return v
+}
+
+func (r *resolver) use(id *Ident, write bool) {
+	v := lookup(r.bind(id), id.Name)
+	if v == nil {
+		r.res.Globals = append(r.res.Globals, &GlobalRef{Name: id, Write: write, Func: r.fn, Stmt: r.stmt})
+		return
+	}
+	r.res.uses[id] = v
+	if write {
+		v.Writes = append(v.Writes, id)
+	} else {
+		v.Reads = append(v.Reads, id)
+	}
+}
+
+func (r *resolver) block(stmts []Stmt) {
+	r.open()
+	r.stmts(stmts)
+	r.close()
+}
+
+func (r *resolver) stmts(stmts []Stmt) {
+	for _, s := range stmts {
+		r.stmt = s
+		r.statement(s)
+	}
+}
+
+func (r *resolver) statement(s Stmt) {
+	switch s := s.(type) {
+	case *LocalStmt:
+		r.exprs(s.Values)
+		for _, b := range s.Names {
+			r.declare(b.Name, VarLocal)
+		}
+	case *LocalFunctionStmt:
+		r.declare(s.Name, VarFunction)
+		r.function(s.Func, false)
+	case *FunctionStmt:
+		r.target(s.Name)
+		r.function(s.Func, s.Method != nil)
+	case *AssignStmt:
+		for _, t := range s.Targets {
+			r.target(t)
+		}
+		r.exprs(s.Values)
+	case *CompoundAssignStmt:
+		r.target(s.Target)
+		r.expr(s.Value)
+	case *CallStmt:
+		r.expr(s.Call)
+	case *DoStmt:
+		r.block(s.Body.Stmts)
+	case *WhileStmt:
+		r.expr(s.Cond)
+		r.block(s.Body.Stmts)
+	case *RepeatStmt:
+		// the condition sees the locals of the body
+		r.open()
+		r.stmts(s.Body.Stmts)
+		r.stmt = s
+		r.expr(s.Cond)
+		r.close()
+	case *IfStmt:
+		for _, c := range s.Clauses {
+			r.expr(c.Cond)
+			r.block(c.Body.Stmts)
+			r.stmt = s
+		}
+		if s.Else != nil {
+			r.block(s.Else.Stmts)
+		}
+	case *NumericForStmt:
+		r.exprs([]Expr{s.Start, s.Limit, s.Step})
+		r.open()
+		r.declare(s.Var.Name, VarLoop)
+		r.block(s.Body.Stmts)
+		r.close()
+	case *GenericForStmt:
+		r.exprs(s.Exprs)
+		r.open()
+		for _, b := range s.Names {
+			r.declare(b.Name, VarLoop)
+		}
+		r.block(s.Body.Stmts)
+		r.close()
+	case *ReturnStmt:
+		r.exprs(s.Values)
+	case *ImportStmt:
+		if s.Namespace != nil {
+			r.declare(s.Namespace, VarImport)
+		}
+		for _, spec := range s.Names {
+			r.declare(spec.LocalName(), VarImport)
+		}
+	case *ExportStmt:
+		if s.Decl != nil {
+			r.statement(s.Decl)
+			var names []*Ident
+			switch d := s.Decl.(type) {
+			case *LocalStmt:
+				for _, b := range d.Names {
+					names = append(names, b.Name)
+				}
+			case *LocalFunctionStmt:
+				names = append(names, d.Name)
+			}
+			for _, id := range names {
+				r.res.uses[id].Exported = true
+			}
+		}
+		for _, spec := range s.Names {
+			r.use(spec.Name, false)
+			if v := r.res.uses[spec.Name]; v != nil {
+				v.Exported = true
+			}
+		}
+	}
+}
+
+// target resolves the target of an assignment.
+func (r *resolver) target(e Expr) {
+	if id, ok := e.(*Ident); ok {
+		r.use(id, true)
+		return
+	}
+	r.expr(e)
+}
+
+func (r *resolver) exprs(exprs []Expr) {
+	for _, e := range exprs {
+		r.expr(e)
+	}
+}
+
+func (r *resolver) expr(e Expr) {
+	switch e := e.(type) {
+	case *Ident:
+		r.use(e, false)
+	case *FunctionExpr:
+		r.function(e, false)
+	case *TableExpr:
+		for _, f := range e.Fields {
+			if !f.Named {
+				r.expr(f.Key)
+			}
+			r.expr(f.Value)
+		}
+	case *BinaryExpr:
+		r.expr(e.Left)
+		r.expr(e.Right)
+	case *UnaryExpr:
+		r.expr(e.X)
+	case *ParenExpr:
+		r.expr(e.X)
+	case *FieldExpr:
+		r.expr(e.X)
+	case *IndexExpr:
+		r.expr(e.X)
+		r.expr(e.Key)
+	case *CallExpr:
+		r.expr(e.Fn)
+		r.exprs(e.Args)
+	case *MethodCallExpr:
+		r.expr(e.Recv)
+		r.exprs(e.Args)
+	}
+}
+
+func (r *resolver) function(fn *FunctionExpr, method bool) {
+	outer, stmt := r.fn, r.stmt
+	r.fn = fn
+	r.open()
+	for i, p := range fn.Params {
+		kind := VarParam
+		if method && i == 0 {
+			kind = VarSelf
+		}
+		r.declare(p.Name, kind)
+	}
+	r.stmts(fn.Body.Stmts)
+	r.close()
+	r.fn, r.stmt = outer, stmt
+}
+
+// Rename returns the identifiers to rewrite to rename v to name: its
+// declaration and uses, in source order. It fails when a use of v would then resolve to
+// another variable, or a use of another variable or global named name
+// would resolve to v.
+func (r *Resolution) Rename(v *Variable, name string) ([]*Ident, error) {
+	if !isIdentifier(name) {
+		return nil, fmt.Errorf("%q is not a valid name", name)
+	}
+	ids := append([]*Ident{v.Name}, v.Reads...)
+	ids = append(ids, v.Writes...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Pos().Offset < ids[j].Pos().Offset })
+	if name == v.Name.Name {
+		return ids, nil
+	}
+	// the variable a name resolves to once v is named name
+	renamed := func(id *Ident) *Variable {
+		b := r.refs[id]
+		for s := b.scope; s != nil; s = s.Parent {
+			for i := len(s.Vars) - 1; i >= 0; i-- {
+				if w := s.Vars[i]; w.seq < b.seq && (w == v || w.Name.Name == name) {
+					return w
+				}
+			}
+		}
+		return nil
+	}
+	for _, id := range ids {
+		if w := renamed(id); w != v && id != v.Name {
+			return nil, fmt.Errorf("%s at %s would refer to the %s declared at %s", v.Name.Name, id.Pos(), name, w.Name.Pos())
+		}
+	}
+	var others []*Ident
+	for _, w := range r.Vars {
+		if w != v && w.Name.Name == name {
+			others = append(append(others, w.Reads...), w.Writes...)
+		}
+	}
+	for _, g := range r.Globals {
+		if g.Name.Name == name {
+			others = append(others, g.Name)
+		}
+	}
+	for _, id := range others {
+		if renamed(id) == v {
+			return nil, fmt.Errorf("%s at %s would refer to the renamed %s", name, id.Pos(), v.Name.Name)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,67 @@
+package luanova
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRename(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		decl     string // position of the declaration to rename
+		newName  string
+		expected string // renamed positions or the error
+	}{
+		{"uses and writes", "local x = 1\nprint(x)\nx = 2", "1:7", "y", "1:7 2:7 3:1"},
+		{"shadowed", "local x = 1\ndo local x = 2 print(x) end\nprint(x)", "1:7", "y", "1:7 3:7"},
+		{"inner", "local x = 1\ndo local x = 2 print(x) end\nprint(x)", "2:10", "y", "2:10 2:22"},
+		{"nested functions", "local n = 0\nlocal function inc() n += 1 return function() return n end end", "1:7", "count", "1:7 2:22 2:54"},
+		{"captured by a parameter", "local x = 1\nlocal function f(y) return x + y end", "1:7", "y",
+			"error: x at 2:28 would refer to the y declared at 2:18"},
+		{"capturing a local", "local a = 1\nlocal b = 2\nprint(a, b)", "2:7", "a",
+			"error: a at 3:7 would refer to the renamed b"},
+		{"capturing a global", "local n = 1\nprint(n)", "1:7", "print",
+			"error: print at 2:1 would refer to the renamed n"},
+		{"global used before", "print(count)\nlocal n = 1\nreturn n", "2:7", "count", "2:7 3:8"},
+		{"own initializer", "local y = 1\nlocal x = y\nreturn x", "2:7", "y", "2:7 3:8"},
+		{"loop variables", "local i = 10\nfor j = i, 3 do print(j) end", "2:5", "i", "2:5 2:23"},
+		{"generic for", "local k = 1\nfor k, v in pairs(t) do print(k, v) end\nprint(k)", "2:8", "k",
+			"error: k at 2:31 would refer to the renamed v"},
+		{"while and repeat", "while true do local done = f() if done then break end end\nrepeat local ok = g() until ok", "2:14", "done", "2:14 2:29"},
+		{"keyword", "local x = 1", "1:7", "end", `error: "end" is not a valid name`},
+		{"invalid", "local x = 1", "1:7", "2x", `error: "2x" is not a valid name`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunk, err := Parse("test", tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := Resolve(chunk)
+			var v *Variable
+			for _, w := range res.Vars {
+				if w.Name.Pos().String() == tt.decl {
+					v = w
+				}
+			}
+			if v == nil {
+				t.Fatalf("no variable declared at %s", tt.decl)
+			}
+			ids, err := res.Rename(v, tt.newName)
+			var got string
+			if err != nil {
+				got = "error: " + err.Error()
+			} else {
+				var pos []string
+				for _, id := range ids {
+					pos = append(pos, id.Pos().String())
+				}
+				got = strings.Join(pos, " ")
+			}
+			if got != tt.expected {
+				t.Errorf("got %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,349 @@
+package luanova
+
+import (
+	"strings"
+	"sync"
+)
+
+// Semantic token types and modifiers, in the order of the legend the
+// language server announces.
+var (
+	semanticTypes = []string{
+		"keyword", "comment", "string", "number", "variable", "parameter",
+		"function", "method", "property", "type", "namespace",
+	}
+	semanticModifiers = []string{"declaration", "modification", "defaultLibrary", "global"}
+)
+
+const (
+	semKeyword = iota
+	semComment
+	semString
+	semNumber
+	semVariable
+	semParameter
+	semFunction
+	semMethod
+	semProperty
+	semType
+	semNamespace
+)
+
+const (
+	modDeclaration = 1 << iota
+	modModification
+	modDefaultLibrary
+	modGlobal
+)
+
+// semToken is a classified token of a source, with byte offsets.
+type semToken struct {
+	start, end int
+	typ, mods  int
+}
+
+// builtinTypes are the type names known without declaration.
+var builtinTypes = map[string]bool{
+	"any": true, "nil": true, "boolean": true, "number": true, "integer": true,
+	"string": true, "table": true, "thread": true, "userdata": true,
+	"unknown": true, "never": true,
+}
+
+var (
+	stdOnce    sync.Once
+	stdGlobals map[string]int
+	stdMembers map[string]map[string]int // of the libraries
+)
+
+// standardGlobals returns the token types of the globals of a new
+// State: namespace for the libraries, function or variable.
+func standardGlobals() map[string]int {
+	stdOnce.Do(loadStandard)
+	return stdGlobals
+}
+
+// standardMembers returns the token types of the fields of the library
+// named lib: function or variable.
+func standardMembers(lib string) map[string]int {
+	stdOnce.Do(loadStandard)
+	return stdMembers[lib]
+}
+
+func loadStandard() {
+	stdGlobals, stdMembers = map[string]int{}, map[string]map[string]int{}
+	kinds := func(t *Table, add func(name string, typ int)) {
+		t.ForEach(func(k, v any) {
+			name, ok := k.(string)
+			if !ok {
+				return
+			}
+			switch v.(type) {
+			case *Table:
+				add(name, semNamespace)
+			case *Closure:
+				add(name, semFunction)
+			default:
+				add(name, semVariable)
+			}
+		})
+	}
+	globals := NewState().Globals()
+	kinds(globals, func(name string, typ int) {
+		stdGlobals[name] = typ
+		if lib, ok := globals.GetString(name).(*Table); ok && typ == semNamespace && lib != globals {
+			members := map[string]int{}
+			kinds(lib, func(name string, typ int) { members[name] = typ })
+			stdMembers[name] = members
+		}
+	})
+}
+
+// semanticTokens classifies the tokens of src: those of the lexer, with
+// the identifiers classified from chunk and res when the source parses.
+func semanticTokens(src string, chunk *Chunk, res *Resolution) []semToken {
+	var names map[int]semToken
+	if chunk != nil {
+		names = classifyNames(chunk, res)
+	}
+	var toks []semToken
+	l := NewLexer(src)
+	for {
+		tok := l.NextToken()
+		if tok.Type == EOF || tok.End.Offset <= tok.Pos.Offset {
+			break
+		}
+		st := semToken{start: tok.Pos.Offset, end: tok.End.Offset, typ: -1}
+		switch {
+		case tok.Type == Comment || tok.Type == CommentBlock:
+			st.typ = semComment
+		case tok.Type == StringDelim:
+			st.typ = semString
+		case tok.Type == Literal && isDigit(tok.Literal[0]):
+			st.typ = semNumber
+		case tok.Type == Literal:
+			if n, ok := names[st.start]; ok {
+				st = n
+			} else if LookupContextual(tok.Literal) != Literal || tok.Literal == "type" {
+				if chunk == nil || contextualKeyword(chunk, st.start) {
+					st.typ = semKeyword
+				}
+			}
+		case isLetter(tok.Literal[0]):
+			st.typ = semKeyword
+		}
+		if st.typ >= 0 {
+			toks = append(toks, st)
+		}
+	}
+	return toks
+}
+
+// contextualKeyword reports whether the import, export, from, as or
+// type at offset is a keyword: it starts or sits in a statement that
+// uses it.
+func contextualKeyword(chunk *Chunk, offset int) bool {
+	found := false
+	Inspect(chunk.Block, func(n Node) bool {
+		if found || n.Pos().Offset > offset || n.End().Offset <= offset {
+			return false
+		}
+		switch n.(type) {
+		case *ImportStmt, *ExportStmt, *TypeStmt:
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// classifyNames classifies the identifiers of chunk by offset.
+func classifyNames(chunk *Chunk, res *Resolution) map[int]semToken {
+	names := map[int]semToken{}
+	mark := func(id *Ident, typ, mods int) {
+		if id == nil || id.End().Offset-id.Pos().Offset != len(id.Name) {
+			return // implicit, like the self of methods
+		}
+		names[id.Pos().Offset] = semToken{start: id.Pos().Offset, end: id.End().Offset, typ: typ, mods: mods}
+	}
+	std := standardGlobals()
+
+	// variables holding functions
+	funcs := map[*Variable]bool{}
+	Inspect(chunk.Block, func(n Node) bool {
+		if s, ok := n.(*LocalStmt); ok {
+			for i, b := range s.Names {
+				if i < len(s.Values) {
+					if _, isFunc := s.Values[i].(*FunctionExpr); isFunc {
+						if v := res.VariableOf(b.Name); v != nil {
+							funcs[v] = true
+						}
+					}
+				}
+			}
+		}
+		return true
+	})
+
+	// names that are not variables
+	called := map[*Ident]bool{}
+	Inspect(chunk.Block, func(n Node) bool {
+		switch n := n.(type) {
+		case *CallExpr:
+			switch fn := n.Fn.(type) {
+			case *Ident:
+				called[fn] = true
+			case *FieldExpr:
+				mods := 0
+				if lib, ok := fn.X.(*Ident); ok && res.VariableOf(lib) == nil && std[lib.Name] == semNamespace {
+					mods = modDefaultLibrary
+				}
+				mark(fn.Name, semFunction, mods)
+			}
+		case *FieldExpr:
+			if _, done := names[n.Name.Pos().Offset]; !done {
+				mark(n.Name, semProperty, 0)
+			}
+		case *MethodCallExpr:
+			mark(n.Name, semMethod, 0)
+		case *FunctionStmt:
+			if n.Method != nil {
+				mark(n.Method, semMethod, modDeclaration)
+			} else if f, ok := n.Name.(*FieldExpr); ok {
+				mark(f.Name, semFunction, modDeclaration)
+			}
+			if id, ok := n.Name.(*Ident); ok {
+				called[id] = true
+			}
+		case *TableField:
+			if id, ok := n.Key.(*Ident); ok && n.Named {
+				mark(id, semProperty, modDeclaration)
+			}
+		case *TypeStmt:
+			mark(n.Name, semType, modDeclaration)
+		case *TableTypeField:
+			mark(n.Name, semProperty, modDeclaration)
+		case *NamedType:
+			markTypeName(names, n)
+		case *ImportSpec:
+			if n.Alias != nil {
+				mark(n.Name, semProperty, 0)
+			}
+		}
+		return true
+	})
+
+	// variables
+	for _, v := range res.Vars {
+		typ := semVariable
+		switch {
+		case v.Kind == VarParam || v.Kind == VarSelf:
+			typ = semParameter
+		case v.Kind == VarFunction || funcs[v]:
+			typ = semFunction
+		}
+		mark(v.Name, typ, modDeclaration)
+		for _, id := range v.Reads {
+			mark(id, typ, 0)
+		}
+		for _, id := range v.Writes {
+			mark(id, typ, modModification)
+		}
+	}
+	for _, g := range res.Globals {
+		typ, mods := semVariable, modGlobal
+		if t, ok := std[g.Name.Name]; ok {
+			typ = t
+			mods |= modDefaultLibrary
+		}
+		if called[g.Name] {
+			typ = semFunction
+		}
+		if g.Write {
+			mods |= modModification
+		}
+		mark(g.Name, typ, mods)
+	}
+	return names
+}
+
+// markTypeName classifies the name of a named type: mod.Type is a
+// namespace and a type.
+func markTypeName(names map[int]semToken, t *NamedType) {
+	offset := t.Pos().Offset
+	parts := strings.Split(t.Name, ".")
+	for i, part := range parts {
+		st := semToken{start: offset, end: offset + len(part), typ: semType}
+		switch {
+		case i < len(parts)-1:
+			st.typ = semNamespace
+		case len(parts) == 1 && builtinTypes[part]:
+			st.mods = modDefaultLibrary
+		}
+		names[offset] = st
+		offset += len(part) + 1
+	}
+}
+
+// encodeSemanticTokens encodes toks in the relative format of the
+// protocol, splitting the tokens that span lines.
+func (doc *document) encodeSemanticTokens(toks []semToken) []uint32 {
+	data := make([]uint32, 0, 5*len(toks))
+	var prev lspPosition
+	emit := func(start, end int, t semToken) {
+		p := doc.position(start)
+		length := utf16Count(doc.text[start:end])
+		if length == 0 {
+			return
+		}
+		delta := p.Character
+		if p.Line == prev.Line {
+			delta -= prev.Character
+		}
+		data = append(data, uint32(p.Line-prev.Line), uint32(delta), uint32(length), uint32(t.typ), uint32(t.mods))
+		prev = p
+	}
+	for _, t := range toks {
+		start := t.start
+		for {
+			nl := strings.IndexByte(doc.text[start:t.end], '\n')
+			if nl < 0 {
+				emit(start, t.end, t)
+				break
+			}
+			end := start + nl
+			if end > start && doc.text[end-1] == '\r' {
+				end--
+			}
+			emit(start, end, t)
+			start += nl + 1
+		}
+	}
+	return data
+}
+
+// semanticTokens returns the encoded semantic tokens of doc.
+func (doc *document) semanticTokens() []uint32 {
+	doc.analyze()
+	return doc.encodeSemanticTokens(semanticTokens(doc.text, doc.chunk, doc.res))
+}
+
+// semanticTokensEdits returns the edit turning the token data prev into
+// data: the middle between their common prefix and suffix.
+func semanticTokensEdits(prev, data []uint32) []map[string]any {
+	i := 0
+	for i < len(prev) && i < len(data) && prev[i] == data[i] {
+		i++
+	}
+	j := 0
+	for j < len(prev)-i && j < len(data)-i && prev[len(prev)-1-j] == data[len(data)-1-j] {
+		j++
+	}
+	if i == len(prev) && i == len(data) {
+		return []map[string]any{}
+	}
+	return []map[string]any{{
+		"start":       i,
+		"deleteCount": len(prev) - i - j,
+		"data":        data[i : len(data)-j],
+	}}
+}
@@ -0,0 +1,359 @@
+package luanova
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	pathpkg "path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SourceMap is a source map in the version 3 format, as written next to
+// generated code in a .map file. Columns are counted in bytes.
+type SourceMap struct {
+	Version        int      `json:"version"`
+	File           string   `json:"file,omitempty"`
+	SourceRoot     string   `json:"sourceRoot,omitempty"`
+	Sources        []string `json:"sources"`
+	SourcesContent []string `json:"sourcesContent,omitempty"`
+	Names          []string `json:"names"`
+	Mappings       string   `json:"mappings"`
+
+	lines [][]segment // decoded Mappings, by generated line
+}
+
+// segment is a decoded mapping; all fields are 0-based and name is -1
+// when the mapping has none.
+type segment struct {
+	col, source, line, srcCol, name int
+}
+
+// NewSourceMap builds the source map of file, generated from source
+// with the given mappings.
+func NewSourceMap(file, source string, mappings []Mapping) *SourceMap {
+	m := &SourceMap{Version: 3, File: file, Sources: []string{source}, Names: []string{}}
+	names := map[string]int{}
+	for _, mp := range mappings {
+		seg := segment{col: mp.Column - 1, line: mp.Source.Line - 1, srcCol: mp.Source.Column - 1, name: -1}
+		if mp.Name != "" {
+			idx, ok := names[mp.Name]
+			if !ok {
+				idx = len(m.Names)
+				names[mp.Name] = idx
+				m.Names = append(m.Names, mp.Name)
+			}
+			seg.name = idx
+		}
+		for len(m.lines) < mp.Line {
+			m.lines = append(m.lines, nil)
+		}
+		m.lines[mp.Line-1] = append(m.lines[mp.Line-1], seg)
+	}
+	m.Mappings = encodeMappings(m.lines)
+	return m
+}
+
+// SourceMap returns the source map of the output written as file from
+// the chunk named source.
+func (t *Transpiled) SourceMap(file, source string) *SourceMap {
+	return NewSourceMap(file, source, t.Mappings)
+}
+
+// ParseSourceMap decodes a version 3 source map.
+func ParseSourceMap(data []byte) (*SourceMap, error) {
+	m := &SourceMap{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if m.Version != 3 {
+		return nil, fmt.Errorf("source map version %d is not supported", m.Version)
+	}
+	lines, err := decodeMappings(m.Mappings)
+	if err != nil {
+		return nil, err
+	}
+	for _, segs := range lines {
+		for _, seg := range segs {
+			if seg.source < 0 || seg.source >= len(m.Sources) || seg.name >= len(m.Names) {
+				return nil, errors.New("source map refers to a missing source or name")
+			}
+		}
+	}
+	m.lines = lines
+	return m, nil
+}
+
+// Lookup returns where the generated code at line and column comes
+// from; both are 1-based and a column of 0 stands for the start of the
+// line. Code without a mapping of its own belongs to the closest mapping
+// before it. The source is the name as listed in the map.
+func (m *SourceMap) Lookup(line, column int) (source string, pos Position, name string, ok bool) {
+	if line < 1 || line > len(m.lines) {
+		return "", Position{}, "", false
+	}
+	for l := line - 1; l >= 0; l-- {
+		segs := m.lines[l]
+		if len(segs) == 0 {
+			continue
+		}
+		i := 0
+		if l == line-1 && column > 0 {
+			i = max(sort.Search(len(segs), func(i int) bool { return segs[i].col > column-1 })-1, 0)
+		} else if l < line-1 {
+			i = len(segs) - 1
+		}
+		seg := segs[i]
+		source = m.Sources[seg.source]
+		if m.SourceRoot != "" {
+			source = pathpkg.Join(m.SourceRoot, source)
+		}
+		if seg.name >= 0 {
+			name = m.Names[seg.name]
+		}
+		return source, Position{Line: seg.line + 1, Column: seg.srcCol + 1}, name, true
+	}
+	return "", Position{}, "", false
+}
+
+func encodeMappings(lines [][]segment) string {
+	var b strings.Builder
+	var prev segment
+	for l, segs := range lines {
+		if l > 0 {
+			b.WriteByte(';')
+		}
+		prev.col = 0
+		for i, seg := range segs {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeVLQ(&b, seg.col-prev.col)
+			writeVLQ(&b, seg.source-prev.source)
+			writeVLQ(&b, seg.line-prev.line)
+			writeVLQ(&b, seg.srcCol-prev.srcCol)
+			if seg.name >= 0 {
+				writeVLQ(&b, seg.name-prev.name)
+				prev.name = seg.name
+			}
+			prev.col, prev.source, prev.line, prev.srcCol = seg.col, seg.source, seg.line, seg.srcCol
+		}
+	}
+	return b.String()
+}
+
+func decodeMappings(s string) ([][]segment, error) {
+	var lines [][]segment
+	var prev segment
+	for _, line := range strings.Split(s, ";") {
+		var segs []segment
+		prev.col = 0
+		for _, field := range strings.Split(line, ",") {
+			if field == "" {
+				continue
+			}
+			var vals []int
+			for field != "" {
+				n, rest, err := readVLQ(field)
+				if err != nil {
+					return nil, err
+				}
+				vals, field = append(vals, n), rest
+			}
+			seg := segment{col: prev.col + vals[0], name: -1}
+			if seg.col < 0 {
+				return nil, errors.New("source map value out of range")
+			}
+			switch len(vals) {
+			case 1:
+				// a generated position without a source
+				prev.col = seg.col
+				continue
+			case 4, 5:
+				seg.source = prev.source + vals[1]
+				seg.line = prev.line + vals[2]
+				seg.srcCol = prev.srcCol + vals[3]
+				if seg.line < 0 || seg.srcCol < 0 {
+					return nil, errors.New("source map value out of range")
+				}
+				if len(vals) == 5 {
+					seg.name = prev.name + vals[4]
+					prev.name = seg.name
+				}
+			default:
+				return nil, fmt.Errorf("invalid source map segment with %d fields", len(vals))
+			}
+			prev.col, prev.source, prev.line, prev.srcCol = seg.col, seg.source, seg.line, seg.srcCol
+			segs = append(segs, seg)
+		}
+		sort.SliceStable(segs, func(i, j int) bool { return segs[i].col < segs[j].col })
+		lines = append(lines, segs)
+	}
+	return lines, nil
+}
+
+const base64Digits = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// writeVLQ writes n as a base64 variable-length quantity: the sign in
+// the lowest bit, then five bits per digit with a continuation bit.
+func writeVLQ(b *strings.Builder, n int) {
+	v := n << 1
+	if n < 0 {
+		v = -n<<1 | 1
+	}
+	for {
+		digit := v & 31
+		v >>= 5
+		if v > 0 {
+			digit |= 32
+		}
+		b.WriteByte(base64Digits[digit])
+		if v == 0 {
+			return
+		}
+	}
+}
+
+func readVLQ(s string) (int, string, error) {
+	v, shift := 0, 0
+	for i := 0; i < len(s); i++ {
+		digit := strings.IndexByte(base64Digits, s[i])
+		if digit < 0 {
+			return 0, "", fmt.Errorf("invalid character %q in source map", s[i])
+		}
+		if shift > 56 {
+			return 0, "", errors.New("source map value out of range")
+		}
+		v |= digit & 31 << shift
+		shift += 5
+		if digit&32 == 0 {
+			n := v >> 1
+			if v&1 != 0 {
+				n = -n
+			}
+			return n, s[i+1:], nil
+		}
+	}
+	return 0, "", errors.New("truncated value in source map")
+}
+
+// Rewriter maps positions in generated files back to the sources they
+// were generated from, using their source maps.
+type Rewriter struct {
+	// Find returns the source map of a generated file, or nil if it has
+	// none. It is called once per file not added with Add; it may be nil.
+	Find func(file string) *SourceMap
+
+	maps map[string]*SourceMap
+}
+
+// Add registers the source map of a generated file, named as it
+// appears in error messages. Sources in the map are relative to the
+// directory of file.
+func (r *Rewriter) Add(file string, m *SourceMap) {
+	if r.maps == nil {
+		r.maps = map[string]*SourceMap{}
+	}
+	r.maps[file] = m
+}
+
+func (r *Rewriter) sourceMap(file string) *SourceMap {
+	m, ok := r.maps[file]
+	if !ok && r.Find != nil {
+		m = r.Find(file)
+		r.Add(file, m)
+	}
+	return m
+}
+
+// Position returns the source file and position of line and column in
+// a generated file; column is 0 when unknown.
+func (r *Rewriter) Position(file string, line, column int) (string, Position, bool) {
+	m := r.sourceMap(file)
+	if m == nil {
+		return "", Position{}, false
+	}
+	source, pos, _, ok := m.Lookup(line, column)
+	if !ok {
+		return "", Position{}, false
+	}
+	if !pathpkg.IsAbs(source) {
+		source = pathpkg.Join(pathpkg.Dir(file), source)
+	}
+	return source, pos, true
+}
+
+// positionPattern matches file:line and file:line:col in messages and
+// tracebacks.
+var positionPattern = regexp.MustCompile(`([^\s:'"()<>\[\]]+):(\d+)(?::(\d+))?`)
+
+// Rewrite replaces the positions in generated files found in s, such
+// as "out.lua:12:" in an error message or a traceback, by their source
+// positions as file:line:col.
+func (r *Rewriter) Rewrite(s string) string {
+	return positionPattern.ReplaceAllStringFunc(s, func(match string) string {
+		sub := positionPattern.FindStringSubmatch(match)
+		line, _ := strconv.Atoi(sub[2])
+		col, _ := strconv.Atoi(sub[3])
+		source, pos, ok := r.Position(sub[1], line, col)
+		if !ok {
+			return match
+		}
+		return fmt.Sprintf("%s:%d:%d", source, pos.Line, pos.Column)
+	})
+}
+
+// RewriteError returns err with the positions in generated files
+// replaced by their source positions. Errors of this package keep their
+// type; other errors are wrapped so that errors.Is and errors.As still
+// see them.
+func (r *Rewriter) RewriteError(err error) error {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *Error:
+		c := *e
+		if msg, ok := e.Value.(string); ok {
+			c.Value = r.Rewrite(msg)
+		}
+		c.Stack = make([]StackFrame, len(e.Stack))
+		for i, f := range e.Stack {
+			if source, pos, ok := r.Position(f.Source, f.Line, 0); ok {
+				f.Source, f.Line = source, pos.Line
+				if _, def, ok := r.Position(e.Stack[i].Source, f.DefLine, 0); ok {
+					f.DefLine = def.Line
+				}
+			}
+			c.Stack[i] = f
+		}
+		return &c
+	case *SyntaxError:
+		c := *e
+		if source, pos, ok := r.Position(e.Source, e.Pos.Line, e.Pos.Column); ok {
+			// the end and the notes are positions in the generated file
+			c.Source, c.Pos, c.End, c.Notes = source, pos, Position{}, nil
+		}
+		return &c
+	case ErrorList:
+		l := make(ErrorList, len(e))
+		for i, se := range e {
+			l[i] = r.RewriteError(se).(*SyntaxError)
+		}
+		return l
+	}
+	msg := r.Rewrite(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return &rewrittenError{msg, err}
+}
+
+type rewrittenError struct {
+	msg string
+	err error
+}
+
+func (e *rewrittenError) Error() string { return e.msg }
+func (e *rewrittenError) Unwrap() error { return e.err }
@@ -0,0 +1,157 @@
+package luanova
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSourceMap(t *testing.T) {
+	chunk, err := Parse("src.lunv", "local x: number = 1\n\nx += f(x)\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := Transpile(chunk, TargetLua51)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the arguments of f(x) are at 3:10 in the output, the call at 3:6
+	// in the source
+	var found bool
+	for _, m := range out.Mappings {
+		if m.Line == 3 && m.Column == 10 && m.Source.Line == 3 && m.Source.Column == 6 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("no mapping for the call in %v", out.Mappings)
+	}
+
+	m := NewSourceMap("out.lua", "src.lunv", []Mapping{
+		{Line: 1, Column: 1, Source: Position{Line: 1, Column: 1}},
+		{Line: 1, Column: 7, Source: Position{Line: 1, Column: 7}, Name: "x"},
+		{Line: 2, Column: 2, Source: Position{Line: 3, Column: 1}},
+		{Line: 4, Column: 5, Source: Position{Line: 4, Column: 3}, Name: "x"},
+	})
+	if m.Mappings != "AAAA,MAAMA;CAEN;;IACEA" || len(m.Names) != 1 {
+		t.Errorf("got mappings %q, names %v", m.Mappings, m.Names)
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseSourceMap(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		line, col int
+		expected  string
+	}{
+		{1, 0, "src.lunv 1:1 "},
+		{1, 6, "src.lunv 1:1 "},
+		{1, 9, "src.lunv 1:7 x"},
+		{2, 1, "src.lunv 3:1 "},
+		{3, 4, "src.lunv 3:1 "}, // no mapping of its own
+		{4, 0, "src.lunv 4:3 x"},
+		{5, 0, ""},
+	}
+	for _, tt := range tests {
+		source, pos, name, ok := parsed.Lookup(tt.line, tt.col)
+		got := ""
+		if ok {
+			got = source + " " + pos.String() + " " + name
+		}
+		if got != tt.expected {
+			t.Errorf("%d:%d: got %q, expected %q", tt.line, tt.col, got, tt.expected)
+		}
+	}
+}
+
+func TestParseSourceMapErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"version": 2, "mappings": ""}`, "source map version 2 is not supported"},
+		{`{"version": 3, "sources": ["a"], "mappings": "AA!A"}`, "invalid character '!' in source map"},
+		{`{"version": 3, "sources": ["a"], "mappings": "AAg"}`, "truncated value in source map"},
+		{`{"version": 3, "sources": ["a"], "mappings": "AA"}`, "invalid source map segment with 2 fields"},
+		{`{"version": 3, "sources": ["a"], "mappings": "ACAA"}`, "source map refers to a missing source or name"},
+		{`{"version": 3, "sources": ["a"], "mappings": "AAAD"}`, "source map value out of range"},
+		{`{"version": 3, "sources": ["a"], "mappings": "AAAA;;;;AADA"}`, "source map value out of range"},
+		{`{"version": 3, "sources": ["a"], "mappings": "D"}`, "source map value out of range"},
+	}
+	for _, tt := range tests {
+		_, err := ParseSourceMap([]byte(tt.input))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: got %v, expected %q", tt.input, err, tt.expected)
+		}
+	}
+}
+
+func TestRewriteErrors(t *testing.T) {
+	src := `local function check(n: number)
+  if n > 2 then
+    error("too big")
+  end
+  return n
+end
+
+local total = 0
+for i = 1, 5 do
+  total += check(i)
+end
+`
+	chunk, err := Parse("src/main.lunv", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := Transpile(chunk, TargetLua51)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Rewriter{}
+	r.Add("out/main.lua", out.SourceMap("main.lua", "../src/main.lunv"))
+
+	s := NewState()
+	s.SetSourceMaps(r)
+	fn, err := s.Load(out.Code, "out/main.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.PCall(fn)
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("got %v", err)
+	}
+	if e.Error() != "src/main.lunv:3:5: too big" {
+		t.Errorf("got %q", e.Error())
+	}
+	expected := `stack traceback:
+	[Go]: in function 'error'
+	src/main.lunv:3: in function 'check'
+	src/main.lunv:10: in main chunk`
+	if e.Traceback() != expected {
+		t.Errorf("got traceback:\n%s\nexpected:\n%s", e.Traceback(), expected)
+	}
+
+	// files without a map are left alone, Find is asked once per file
+	asked := 0
+	r.Find = func(string) *SourceMap { asked++; return nil }
+	msg := "other.lua:3: x\n\tother.lua:4: in main chunk\n\tout/main.lua:10: in main chunk"
+	if got := r.Rewrite(msg); got != "other.lua:3: x\n\tother.lua:4: in main chunk\n\tsrc/main.lunv:10:3: in main chunk" || asked != 1 {
+		t.Errorf("got %q, Find called %d times", got, asked)
+	}
+
+	syntax := ErrorList{{Source: "out/main.lua", Pos: Position{Line: 10, Column: 3}, Msg: "oops"}}
+	if got := r.RewriteError(syntax).Error(); got != "src/main.lunv:10:3: oops" {
+		t.Errorf("got %q", got)
+	}
+	wrapped := r.RewriteError(errors.New("at out/main.lua:1"))
+	if !strings.HasPrefix(wrapped.Error(), "at src/main.lunv:1:") {
+		t.Errorf("got %q", wrapped)
+	}
+}
@@ -0,0 +1,419 @@
+package luanova
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// DefaultMaxCallDepth is the number of nested calls after which a script
+// fails with a stack overflow.
+const DefaultMaxCallDepth = 20000
+
+// State is an interpreter instance. A State must not be used by more
+// than one goroutine at a time.
+type State struct {
+	// Stdout receives the output of print.
+	Stdout io.Writer
+
+	globals    *Table
+	stringMeta *Table
+	causes     []caughtCause // of the last Go errors caught by scripts
+	next       *Closure
+	stack      []any
+	base       int
+	calls      []*callInfo
+	maxDepth   int
+	rand       *rand.Rand
+	bindings   map[bindingKey]*binding
+	pkg        *Table
+	loaded     *Table
+	fsys       fs.FS
+	dir        string // of fsys in chunk names, set by SetDir
+	loading    []loadingModule
+	require    *Closure
+	exports    map[*Table]map[string]bool // declared by module tables, true for types
+
+	limits Limits
+	ctx    context.Context
+	steps  int64 // steps of the current execution
+	mem    int64 // bytes allocated by the current execution
+	ticks  int64 // steps left until the next check of the limits
+	chunk  int64 // ticks at the last refill
+
+	sourceMaps *Rewriter
+	hooks      *hooks
+	countEvery int // statements between count events, 0 for the default
+	profiler   *Profiler
+	coverage   *Coverage
+}
+
+// callInfo is an active call. fr is nil for Go functions.
+type callInfo struct {
+	fn *Closure
+	fr *frame
+}
+
+// frame holds the locals of a running Lua function.
+type frame struct {
+	s       *State
+	fn      *Closure
+	slots   []any
+	varargs []any
+	line    int
+	at      *stmtInfo // running statement
+	ret     []any
+}
+
+// NewState returns a State with the standard library loaded.
+func NewState() *State {
+	s := &State{
+		Stdout:   os.Stdout,
+		globals:  NewTable(),
+		maxDepth: DefaultMaxCallDepth,
+	}
+	openBase(s)
+	openString(s)
+	openTable(s)
+	openMath(s)
+	openDebug(s)
+	openPackage(s)
+	s.refill()
+	return s
+}
+
+// Globals returns the table of global variables.
+func (s *State) Globals() *Table {
+	return s.globals
+}
+
+// Register sets the global name to the Go function fn, which is either
+// a GoFunction or any Go function wrapped with NewFunc.
+func (s *State) Register(name string, fn any) {
+	s.globals.Set(name, s.NewFunc(name, fn))
+}
+
+// SetGlobal sets the global name to the Go value v converted with
+// ToValue. Functions are named after the global in error messages.
+func (s *State) SetGlobal(name string, v any) {
+	if reflect.TypeOf(v) != nil && reflect.TypeOf(v).Kind() == reflect.Func {
+		s.Register(name, v)
+		return
+	}
+	s.globals.Set(name, s.ToValue(v))
+}
+
+// GetGlobal returns the value of the global name.
+func (s *State) GetGlobal(name string) any {
+	return s.globals.GetString(name)
+}
+
+// NewFunction wraps fn as a function value; name is used in error
+// messages and stack traces.
+func NewFunction(name string, fn GoFunction) *Closure {
+	return &Closure{gofn: fn, name: name}
+}
+
+// setFuncs stores Go functions in t; the functions are named prefix+key.
+func setFuncs(t *Table, prefix string, funcs map[string]GoFunction) {
+	for name, fn := range funcs {
+		t.Set(name, NewFunction(prefix+name, fn))
+	}
+}
+
+// Load compiles src without running it. name is the chunk name used in
+// error messages.
+func (s *State) Load(src, name string) (*Closure, error) {
+	chunk, err := Parse(name, src)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := compileChunk(chunk, s.coverage.file(name, src))
+	if err != nil {
+		return nil, err
+	}
+	return &Closure{proto: proto, env: s.globals}, nil
+}
+
+// DoString runs src as a chunk.
+func (s *State) DoString(src string) error {
+	fn, err := s.Load(src, chunkName(src))
+	if err != nil {
+		return err
+	}
+	_, err = s.PCall(fn)
+	return err
+}
+
+// DoFile runs the file at path as a chunk named after the path.
+func (s *State) DoFile(path string) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	fn, err := s.Load(string(src), path)
+	if err != nil {
+		return err
+	}
+	_, err = s.PCall(fn)
+	return err
+}
+
+// chunkName names a chunk loaded from a string like Lua does:
+// [string "first line..."].
+func chunkName(src string) string {
+	line, _, cut := strings.Cut(src, "\n")
+	if len(line) > 40 {
+		line, cut = line[:40], true
+	}
+	if cut {
+		line += "..."
+	}
+	return `[string "` + line + `"]`
+}
+
+// call calls f with args and returns its results.
+func (s *State) call(f any, args []any) []any {
+	return s.callDesc(f, args, "")
+}
+
+// callDesc is call with a description of f for error messages, such as
+// "global 'f'".
+func (s *State) callDesc(f any, args []any, desc string) []any {
+	fn, ok := f.(*Closure)
+	if !ok {
+		h := s.metaField(f, "__call")
+		if h == nil {
+			s.runtimeError("attempt to call a %s value%s", luaTypeName(f), varInfo(desc))
+		}
+		nargs := make([]any, len(args)+1)
+		nargs[0] = f
+		copy(nargs[1:], args)
+		return s.call(h, nargs)
+	}
+	if len(s.calls) >= s.maxDepth {
+		s.limitError(ErrStackOverflow)
+	}
+	s.step()
+	if fn.gofn != nil {
+		return s.callGo(fn, args)
+	}
+	return s.callLua(fn, args)
+}
+
+func (s *State) callLua(fn *Closure, args []any) []any {
+	p := fn.proto
+	fr := &frame{s: s, fn: fn, slots: make([]any, p.nslots), line: p.line}
+	for i, lv := range p.params {
+		var v any
+		if i < len(args) {
+			v = args[i]
+		}
+		if lv.captured {
+			v = &cell{v}
+		}
+		fr.slots[lv.slot] = v
+	}
+	if p.isVararg && len(args) > len(p.params) {
+		fr.varargs = args[len(p.params):]
+	}
+	s.calls = append(s.calls, &callInfo{fn: fn, fr: fr})
+	if s.hooks != nil {
+		s.hooks.call(s, HookCall, fn, fr)
+	}
+	p.body(fr)
+	if s.hooks != nil {
+		s.hooks.call(s, HookReturn, fn, fr)
+	}
+	s.calls[len(s.calls)-1] = nil
+	s.calls = s.calls[:len(s.calls)-1]
+	return fr.ret
+}
+
+func (s *State) callGo(fn *Closure, args []any) []any {
+	base, oldBase := len(s.stack), s.base
+	s.stack = append(s.stack, args...)
+	s.base = base
+	s.calls = append(s.calls, &callInfo{fn: fn})
+	if s.hooks != nil {
+		s.hooks.call(s, HookCall, fn, nil)
+	}
+	n := fn.gofn(s)
+	if s.hooks != nil {
+		s.hooks.call(s, HookReturn, fn, nil)
+	}
+	s.calls[len(s.calls)-1] = nil
+	s.calls = s.calls[:len(s.calls)-1]
+	top := len(s.stack)
+	n = min(n, top-base)
+	var res []any
+	if n > 0 {
+		res = make([]any, n)
+		copy(res, s.stack[top-n:])
+	}
+	clear(s.stack[base:])
+	s.stack = s.stack[:base]
+	s.base = oldBase
+	return res
+}
+
+// Stack API for Go functions. Indices are 1-based from the first
+// argument; negative indices count from the top.
+
+// Top returns the number of values on the stack of the running function.
+func (s *State) Top() int {
+	return len(s.stack) - s.base
+}
+
+// Get returns the value at index i, or nil.
+func (s *State) Get(i int) any {
+	if i < 0 {
+		i = s.Top() + i + 1
+	}
+	if i < 1 || s.base+i > len(s.stack) {
+		return nil
+	}
+	return s.stack[s.base+i-1]
+}
+
+// Push pushes values on the stack.
+func (s *State) Push(vs ...any) {
+	s.stack = append(s.stack, vs...)
+}
+
+// SetTop sets the number of values on the stack, filling with nil.
+func (s *State) SetTop(n int) {
+	top := s.base + n
+	for len(s.stack) < top {
+		s.stack = append(s.stack, nil)
+	}
+	clear(s.stack[top:])
+	s.stack = s.stack[:top]
+}
+
+// args returns a copy of the arguments from index i on.
+func (s *State) args(i int) []any {
+	if i > s.Top() {
+		return nil
+	}
+	return append([]any(nil), s.stack[s.base+i-1:]...)
+}
+
+func (s *State) CheckAny(i int) any {
+	if i > s.Top() {
+		s.ArgError(i, "value expected")
+	}
+	return s.Get(i)
+}
+
+func (s *State) CheckString(i int) string {
+	str, ok := toStringCoerce(s.Get(i))
+	if !ok {
+		s.TypeError(i, "string")
+	}
+	return str
+}
+
+func (s *State) CheckNumber(i int) float64 {
+	f, ok := toFloat(s.Get(i))
+	if !ok {
+		s.TypeError(i, "number")
+	}
+	return f
+}
+
+func (s *State) CheckInteger(i int) int64 {
+	v := s.Get(i)
+	n, ok := toInteger(v)
+	if !ok {
+		if _, isNum := toNumber(v); isNum {
+			s.ArgError(i, "number has no integer representation")
+		}
+		s.TypeError(i, "number")
+	}
+	return n
+}
+
+func (s *State) CheckTable(i int) *Table {
+	t, ok := s.Get(i).(*Table)
+	if !ok {
+		s.TypeError(i, "table")
+	}
+	return t
+}
+
+func (s *State) CheckFunction(i int) *Closure {
+	f, ok := s.Get(i).(*Closure)
+	if !ok {
+		s.TypeError(i, "function")
+	}
+	return f
+}
+
+func (s *State) OptString(i int, def string) string {
+	if s.Get(i) == nil {
+		return def
+	}
+	return s.CheckString(i)
+}
+
+func (s *State) OptInteger(i int, def int64) int64 {
+	if s.Get(i) == nil {
+		return def
+	}
+	return s.CheckInteger(i)
+}
+
+func (s *State) OptNumber(i int, def float64) float64 {
+	if s.Get(i) == nil {
+		return def
+	}
+	return s.CheckNumber(i)
+}
+
+// PCall calls fn with args in protected mode. Errors raised by the
+// script are returned as *Error.
+func (s *State) PCall(fn any, args ...any) ([]any, error) {
+	if len(s.calls) == 0 {
+		if err := s.startExecution(); err != nil {
+			return nil, err
+		}
+	}
+	var rs []any
+	if err := s.protect(func() { rs = s.call(fn, args) }, nil); err != nil {
+		if len(s.calls) == 0 && s.sourceMaps != nil {
+			return nil, s.sourceMaps.RewriteError(err)
+		}
+		return nil, err
+	}
+	return rs, nil
+}
+
+// SetSourceMaps makes the errors returned to Go report positions in
+// the sources of generated chunks, such as the output of Transpile,
+// instead of positions in the chunks themselves. Scripts still see the
+// original messages. A nil r turns rewriting off.
+func (s *State) SetSourceMaps(r *Rewriter) {
+	s.sourceMaps = r
+}
+
+// Call calls fn with args converted by ToValue and returns the results.
+// fn is a function value or the name of a global function.
+func (s *State) Call(fn any, args ...any) ([]any, error) {
+	if name, ok := fn.(string); ok {
+		fn = s.GetGlobal(name)
+		if fn == nil {
+			return nil, fmt.Errorf("luanova: global '%s' is not defined", name)
+		}
+	}
+	vs := make([]any, len(args))
+	for i, a := range args {
+		vs[i] = s.ToValue(a)
+	}
+	return s.PCall(fn, vs...)
+}
@@ -0,0 +1,265 @@
+package luanova
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runScript runs src as chunk "test" and returns what it printed.
+func runScript(t *testing.T, src string) string {
+	t.Helper()
+	var out strings.Builder
+	s := NewState()
+	s.Stdout = &out
+	fn, err := s.Load(src, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PCall(fn); err != nil {
+		t.Fatalf("%v\n%s", err, err.(*Error).Traceback())
+	}
+	return out.String()
+}
+
+func TestInterpreter(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"arithmetic", `print(1 + 2, 7 - 10, 3 * 4, 7 / 2, 7 % 3, -7 % 3, 7 % -3, 2 ^ 10, -2 ^ 2)`,
+			"3\t-3\t12\t3.5\t1\t2\t-2\t1024.0\t-4.0"},
+		{"float arithmetic", `print(1.5 + 1, 10 / 2, 5.5 % 2, 1e300 * 1e10, -(0/0) ~= -(0/0))`,
+			"2.5\t5.0\t1.5\tinf\ttrue"},
+		{"integer overflow", `print(math.maxinteger + 1 == math.mininteger, math.mininteger % -1)`,
+			"true\t0"},
+		{"coercion", `print("10" + 1, "3" * "4", "0x10" + 0, 10 .. 20, 1.5 .. "")`,
+			"11\t12\t16\t1020\t1.5"},
+		{"comparison", `print(1 < 2, 1 <= 1.0, 2 > 1.5, "a" < "b", "abc" >= "abd", 1 == 1.0, "1" == 1)`,
+			"true\ttrue\ttrue\ttrue\tfalse\ttrue\tfalse"},
+		{"logic", `print(nil or "d", false and 1, 1 and 2, not nil, not 0)`,
+			"d\tfalse\t2\ttrue\tfalse"},
+		{"strings", `local s = "hello" print(#s, s:upper(), s:sub(2, 3), s:rep(2, "-"), ("%d-%s"):format(5, "x"))`,
+			"5\tHELLO\tel\thello-hello\t5-x"},
+		{"closures", `
+local function counter()
+  local n = 0
+  return function() n += 1; return n end
+end
+local a, b = counter(), counter()
+a(); a()
+print(a(), b())`, "3\t1"},
+		{"loop closures", `
+local fs = {}
+for i = 1, 3 do fs[i] = function() return i end end
+local ws = {}
+local j = 0
+while j < 3 do j += 1; local k = j; ws[j] = function() return k end end
+print(fs[1](), fs[3](), ws[1](), ws[3]())`, "1\t3\t1\t3"},
+		{"shared upvalue", `
+local x = 1
+local function get() return x end
+local function set(v) x = v end
+set(5)
+print(get(), x)`, "5\t5"},
+		{"varargs", `
+local function f(...) return select("#", ...), ... end
+print(f(1, nil, 3))
+local function g(a, ...) local t = {...}; return a, #t end
+print(g(1, 2, 3))
+print((f(1, 2)))`, "3\t1\tnil\t3\n1\t2\n2"},
+		{"multiple assignment", `
+local a, b, c = 1, 2
+a, b = b, a
+print(a, b, c)
+local t = {}
+t.x, t.y = (function() return 1, 2 end)()
+print(t.x, t.y)`, "2\t1\tnil\n1\t2"},
+		{"numeric for", `
+for i = 1, 3 do io_write(i) end
+for i = 3, 1, -1 do io_write(i) end
+for i = 1, 2, 0.5 do io_write(i) end
+for i = 1, 0 do io_write("never") end
+for i = math.maxinteger - 1, math.maxinteger do io_write("m") end
+print()`, "123321" + "1.01.52.0" + "mm"},
+		{"generic for", `
+local t = {10, 20, 30, x = 1}
+local n = 0
+for k, v in pairs(t) do n += 1 end
+for i, v in ipairs(t) do io_write(i, "=", v, " ") end
+print(n)`, "1=10 2=20 3=30 4"},
+		{"break and continue", `
+for i = 1, 10 do
+  if i % 2 == 0 then continue end
+  if i > 7 then break end
+  io_write(i)
+end
+local i = 0
+repeat
+  i += 1
+  if i == 2 then continue end
+  io_write(i)
+until i >= 4
+print()`, "1357" + "134"},
+		{"compound assignment", `
+local t = {n = 1, s = "a"}
+t.n += 2; t.n -= 1; t.n *= 10; t.n /= 4; t.s ..= "b"
+local m = 10
+m %= 3
+print(t.n, t.s, m)`, "5.0\tab\t1"},
+		{"methods", `
+local Account = {}
+Account.__index = Account
+function Account.new(b) return setmetatable({balance = b}, Account) end
+function Account:deposit(v) self.balance += v; return self end
+print(Account.new(10):deposit(5):deposit(1).balance)`, "16"},
+		{"metamethods", `
+local V = {}
+V.__index = V
+V.__add = function(a, b) return setmetatable({x = a.x + b.x}, V) end
+V.__eq = function(a, b) return a.x == b.x end
+V.__lt = function(a, b) return a.x < b.x end
+V.__le = function(a, b) return a.x <= b.x end
+V.__len = function(a) return a.x end
+V.__concat = function(a, b) return "V" .. (type(a) == "table" and a.x or a) .. (type(b) == "table" and b.x or b) end
+V.__call = function(self, y) return self.x + y end
+V.__tostring = function(a) return "V(" .. a.x .. ")" end
+V.__unm = function(a) return setmetatable({x = -a.x}, V) end
+local a, b = setmetatable({x = 1}, V), setmetatable({x = 2}, V)
+print((a + b).x, a == setmetatable({x = 1}, V), a < b, b <= a, #b, a .. "!", a(10), tostring(-a))`,
+			"3\ttrue\ttrue\tfalse\t2\tV1!\t11\tV(-1)"},
+		{"index chain", `
+local base = {greet = function() return "hi" end}
+local mid = setmetatable({}, {__index = base})
+local obj = setmetatable({}, {__index = mid})
+local log = {}
+local proxy = setmetatable({}, {__newindex = function(t, k, v) rawset(t, k, v * 2) end,
+  __index = function(t, k) return k .. "?" end})
+proxy.a = 2
+print(obj.greet(), proxy.a, proxy.b)`, "hi\t4\tb?"},
+		{"tables", `
+local t = {}
+for i = 1, 5 do t[#t + 1] = i end
+table.insert(t, 1, 0)
+print(#t, table.remove(t), table.remove(t, 1), table.concat(t, ","))
+t[#t] = nil
+print(#t, select("#", table.unpack(t)))
+local p = table.pack(1, nil, 3)
+print(p.n)
+local words = {"pear", "apple", "fig"}
+table.sort(words)
+print(table.concat(words, " "))
+table.sort(words, function(a, b) return #a < #b end)
+print(words[1])`, "6\t5\t0\t1,2,3,4\n3\t3\n3\napple fig pear\nfig"},
+		{"next during clear", `
+local t = {a = 1, b = 2, c = 3, 1, 2, 3}
+for k in pairs(t) do t[k] = nil end
+print(next(t))`, "nil"},
+		{"float keys", `
+local t = {}
+t[1.0] = "one"; t[2] = "two"
+print(t[1], t[2.0], #t)`, "one\ttwo\t2"},
+		{"tostring and tonumber", `print(tostring(1e100), tostring(-0.0), 2^63, math.tointeger(3.0), tonumber("  12  "), tonumber("z", 36), tonumber("1e"))`,
+			"1e+100\t-0.0\t9.2233720368548e+18\t3\t12\t35\tnil"},
+		{"string library", `
+print(("hello world"):find("o w"), ("hello"):find("l+"), ("key=val"):match("(%w+)=(%w+)"))
+print(("abc"):gsub("%w", "%0%0"), ("hello world"):gsub("o", {o = "0"}), ("abc"):gsub(".", function(c) return c:byte() end))
+for w in ("one two"):gmatch("%a+") do io_write(w, ";") end
+print(("x"):byte(), string.char(72, 105), ("abc"):reverse(), ("ABC"):lower(), ("%5s|"):format("ab"))`,
+			"5\t3\tkey\tval\naabbcc\thell0 w0rld\t979899\t3\none;two;120\tHi\tcba\tabc\t   ab|"},
+		{"math library", `print(math.floor(3.7), math.ceil(-3.7), math.abs(-4), math.max(3, 7.5, 1), math.min(2, -1), math.sqrt(16), math.fmod(7, 3), math.type(1), math.type(1.0), math.type("1"))`,
+			"3\t-3\t4\t7.5\t-1\t4.0\t1\tinteger\tfloat\tnil"},
+		{"load", `
+local f = load("return 1 + ...")
+print(f(41))
+print(load("syntax error here"))
+local env = {x = 5}
+print(load("return x", "chunk", "t", env)())`, "42\nnil\t[string \"syntax error here\"]:1:8: syntax error near 'error'\n5"},
+		{"globals", `x = 1; _G.y = 2; print(x + y, _G.x, rawget(_G, "y"), _VERSION)`, "3\t1\t2\tLua 5.4"},
+		{"type annotations", `
+type Point = {x: number, y: number}
+local function dist(p: Point): number return p.x + p.y end
+local n: number? = nil
+print(dist({x = 1, y = 2}), n)`, "3\tnil"},
+		{"recursion", `
+local function fib(n) if n < 2 then return n end return fib(n - 1) + fib(n - 2) end
+print(fib(20))`, "6765"},
+		{"escapes", `print("a\tb\65\u{263A}\x21")`, "a\tbA☺!"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.TrimSuffix(runScript(t, withWriter(tt.input)), "\n")
+			if got != tt.expected {
+				t.Errorf("got:\n%s\nexpected:\n%s", got, tt.expected)
+			}
+		})
+	}
+}
+
+// withWriter defines io_write, which buffers its arguments until the
+// next print.
+func withWriter(src string) string {
+	return `local __buf = {}
+local __print = print
+local function io_write(...) for _, v in ipairs({...}) do __buf[#__buf + 1] = tostring(v) end end
+local function print(...)
+  local prefix = table.concat(__buf)
+  __buf = {}
+  if select("#", ...) == 0 then __print(prefix) return end
+  local args = table.pack(...)
+  args[1] = prefix .. tostring(args[1])
+  __print(table.unpack(args, 1, args.n))
+end
+` + src
+}
+
+func TestEmbedding(t *testing.T) {
+	s := NewState()
+	s.SetGlobal("limit", 10)
+	s.SetGlobal("names", []string{"a", "b"})
+	s.SetGlobal("double", func(x int) int { return x * 2 })
+	if err := s.DoString(`
+result = double(limit) + #names
+function sum(...)
+  local n = 0
+  for _, v in ipairs({...}) do n = n + v end
+  return n
+end`); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.GetGlobal("result"); got != int64(22) {
+		t.Errorf("result: got %v", got)
+	}
+
+	rs, err := s.Call("sum", 1, 2.5, uint8(3))
+	if err != nil || len(rs) != 1 || rs[0] != 6.5 {
+		t.Errorf("Call: got %v, %v", rs, err)
+	}
+	if _, err := s.Call("missing"); err == nil {
+		t.Errorf("Call of an undefined global should fail")
+	}
+	_, err = s.Call(s.GetGlobal("double"), "x")
+	if err == nil || err.Error() != "bad argument #1 to 'double' (number expected, got string)" {
+		t.Errorf("got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "script.lunv")
+	if err := os.WriteFile(path, []byte("x = 1\nerror(\"bad\")"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err = s.DoFile(path)
+	if err == nil || err.Error() != path+":2: bad" {
+		t.Errorf("DoFile: got %v", err)
+	}
+	if s.GetGlobal("x") != int64(1) {
+		t.Errorf("DoFile did not run the chunk")
+	}
+	if err := s.DoFile(filepath.Join(t.TempDir(), "missing.lunv")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("DoFile of a missing file: got %v", err)
+	}
+}
@@ -0,0 +1,292 @@
+package luanova
+
+import (
+	"errors"
+	"strings"
+)
+
+func openString(s *State) {
+	lib := NewTable()
+	setFuncs(lib, "string.", map[string]GoFunction{
+		"byte":    strByte,
+		"char":    strChar,
+		"find":    strFind,
+		"format":  strFormat,
+		"gmatch":  strGmatch,
+		"gsub":    strGsub,
+		"len":     strLen,
+		"lower":   strLower,
+		"match":   strMatch,
+		"rep":     strRep,
+		"reverse": strReverse,
+		"sub":     strSub,
+		"upper":   strUpper,
+	})
+	s.globals.Set("string", lib)
+	s.stringMeta = NewTable()
+	s.stringMeta.Set("__index", lib)
+}
+
+// strRange converts Lua string indices i and j to a Go slice range.
+func strRange(i, j int64, n int) (int, int) {
+	l := int64(n)
+	if i < 0 {
+		i = max(l+i+1, 1)
+	} else if i == 0 {
+		i = 1
+	}
+	if j < 0 {
+		j = l + j + 1
+	} else if j > l {
+		j = l
+	}
+	if i > j {
+		return 0, 0
+	}
+	return int(i - 1), int(j)
+}
+
+func strLen(s *State) int {
+	s.Push(int64(len(s.CheckString(1))))
+	return 1
+}
+
+func strSub(s *State) int {
+	str := s.CheckString(1)
+	i, j := strRange(s.OptInteger(2, 1), s.OptInteger(3, -1), len(str))
+	s.Push(str[i:j])
+	return 1
+}
+
+func strUpper(s *State) int {
+	b := []byte(s.CheckString(1))
+	for i, c := range b {
+		if islower(c) {
+			b[i] = c - 'a' + 'A'
+		}
+	}
+	s.allocString(len(b))
+	s.Push(string(b))
+	return 1
+}
+
+func strLower(s *State) int {
+	b := []byte(s.CheckString(1))
+	for i, c := range b {
+		if isupper(c) {
+			b[i] = c - 'A' + 'a'
+		}
+	}
+	s.allocString(len(b))
+	s.Push(string(b))
+	return 1
+}
+
+func strRep(s *State) int {
+	str := s.CheckString(1)
+	n := s.CheckInteger(2)
+	sep := s.OptString(3, "")
+	if n <= 0 {
+		s.Push("")
+		return 1
+	}
+	total := (int64(len(str)) + int64(len(sep))) * n
+	if total/n != int64(len(str))+int64(len(sep)) || total > maxStringSize {
+		s.RaiseError("resulting string too large")
+	}
+	s.allocString(int(total))
+	if total > 0 {
+		s.charge(n)
+	}
+	if sep == "" {
+		s.Push(strings.Repeat(str, int(n)))
+		return 1
+	}
+	var b strings.Builder
+	b.Grow(int(total))
+	for i := int64(0); i < n; i++ {
+		if i > 0 {
+			b.WriteString(sep)
+		}
+		b.WriteString(str)
+	}
+	s.Push(b.String())
+	return 1
+}
+
+// maxStringSize bounds the strings built by the string library.
+const maxStringSize = 1 << 31
+
+func strReverse(s *State) int {
+	str := s.CheckString(1)
+	b := make([]byte, len(str))
+	for i := range b {
+		b[i] = str[len(str)-1-i]
+	}
+	s.allocString(len(b))
+	s.Push(string(b))
+	return 1
+}
+
+func strByte(s *State) int {
+	str := s.CheckString(1)
+	i := s.OptInteger(2, 1)
+	i0, j0 := strRange(i, s.OptInteger(3, i), len(str))
+	for k := i0; k < j0; k++ {
+		s.Push(int64(str[k]))
+	}
+	return max(j0-i0, 0)
+}
+
+func strChar(s *State) int {
+	n := s.Top()
+	b := make([]byte, n)
+	for i := 1; i <= n; i++ {
+		c := s.CheckInteger(i)
+		if c < 0 || c > 255 {
+			s.ArgError(i, "value out of range")
+		}
+		b[i-1] = byte(c)
+	}
+	s.allocString(len(b))
+	s.Push(string(b))
+	return 1
+}
+
+func strFormat(s *State) int {
+	format := s.CheckString(1)
+	out, err := formatString(format, s.args(2), func(v any) (string, error) {
+		return s.tostring(v), nil
+	})
+	if err != nil {
+		s.RaiseError("%s", err)
+	}
+	s.allocString(len(out))
+	s.Push(out)
+	return 1
+}
+
+// patternError raises err from the pattern engine.
+func (s *State) patternError(err error) {
+	if errors.Is(err, ErrPatternTooComplex) {
+		s.Raise(err)
+	}
+	s.RaiseError("%s", err)
+}
+
+func pushCaptures(s *State, caps []patternCapture) int {
+	for _, c := range caps {
+		if c.IsPos {
+			s.Push(int64(c.Position))
+		} else {
+			s.Push(c.Value)
+		}
+	}
+	return len(caps)
+}
+
+func captureValues(caps []patternCapture) []any {
+	vs := make([]any, len(caps))
+	for i, c := range caps {
+		if c.IsPos {
+			vs[i] = int64(c.Position)
+		} else {
+			vs[i] = c.Value
+		}
+	}
+	return vs
+}
+
+func strFind(s *State) int {
+	str := s.CheckString(1)
+	pat := s.CheckString(2)
+	init := s.OptInteger(3, 1)
+	plain := truthy(s.Get(4))
+	start, end, caps, err := patternFind(str, pat, int(init), plain, patternLimits{state: s})
+	if err != nil {
+		s.patternError(err)
+	}
+	if start == 0 {
+		s.Push(nil)
+		return 1
+	}
+	s.Push(int64(start), int64(end))
+	return 2 + pushCaptures(s, caps)
+}
+
+func strMatch(s *State) int {
+	str := s.CheckString(1)
+	pat := s.CheckString(2)
+	caps, err := patternMatch(str, pat, int(s.OptInteger(3, 1)), patternLimits{state: s})
+	if err != nil {
+		s.patternError(err)
+	}
+	if caps == nil {
+		s.Push(nil)
+		return 1
+	}
+	return pushCaptures(s, caps)
+}
+
+func strGmatch(s *State) int {
+	str := s.CheckString(1)
+	pat := s.CheckString(2)
+	it := newPatternIter(str, pat, int(s.OptInteger(3, 1)), patternLimits{state: s})
+	s.Push(NewFunction("gmatch_iter", func(s *State) int {
+		caps, err := it.next()
+		if err != nil {
+			s.patternError(err)
+		}
+		if caps == nil {
+			s.Push(nil)
+			return 1
+		}
+		return pushCaptures(s, caps)
+	}))
+	return 1
+}
+
+func strGsub(s *State) int {
+	str := s.CheckString(1)
+	pat := s.CheckString(2)
+	maxN := max(s.OptInteger(4, int64(len(str))+1), 0)
+	var repl patternReplacer
+	switch r := s.Get(3).(type) {
+	case string, int64, float64:
+		rs, _ := toStringCoerce(r)
+		repl = stringReplacer(rs)
+	case *Table:
+		repl = func(whole string, caps []patternCapture) (string, bool, error) {
+			return s.replacement(s.index(r, captureValues(caps)[0], ""))
+		}
+	case *Closure:
+		repl = func(whole string, caps []patternCapture) (string, bool, error) {
+			return s.replacement(first(s.call(r, captureValues(caps))))
+		}
+	default:
+		s.TypeError(3, "string/function/table")
+	}
+	budget := s.stringBudget()
+	out, n, err := patternGsub(str, pat, int(maxN), repl, patternLimits{state: s}, budget)
+	if errors.Is(err, errResultTooLong) {
+		s.allocString(budget + 1)
+	}
+	if err != nil {
+		s.patternError(err)
+	}
+	s.allocString(len(out))
+	s.Push(out, int64(n))
+	return 2
+}
+
+// replacement converts the value returned by a gsub table or function.
+func (s *State) replacement(v any) (string, bool, error) {
+	if !truthy(v) {
+		return "", true, nil
+	}
+	str, ok := toStringCoerce(v)
+	if !ok {
+		return "", false, errors.New("invalid replacement value (a " + luaTypeName(v) + ")")
+	}
+	return str, false, nil
+}
@@ -0,0 +1,242 @@
+package luanova
+
+// Table is a Lua table. Integer keys 1..n live in an array part; the
+// remaining entries are kept in insertion order so that next is stable
+// while fields are cleared during a traversal.
+type Table struct {
+	arr     []any
+	index   map[any]int
+	entries []tableEntry
+	dead    int
+	meta    *Table
+}
+
+type tableEntry struct {
+	key   any
+	value any
+}
+
+func NewTable() *Table {
+	return &Table{}
+}
+
+// newTableSize preallocates room for narr array items and nhash entries.
+func newTableSize(narr, nhash int) *Table {
+	t := &Table{}
+	if narr > 0 {
+		t.arr = make([]any, 0, narr)
+	}
+	if nhash > 0 {
+		t.index = make(map[any]int, nhash)
+		t.entries = make([]tableEntry, 0, nhash)
+	}
+	return t
+}
+
+// Metatable returns the metatable of t, or nil.
+func (t *Table) Metatable() *Table { return t.meta }
+
+// SetMetatable sets the metatable of t.
+func (t *Table) SetMetatable(m *Table) { t.meta = m }
+
+// Get returns t[k] without invoking metamethods.
+func (t *Table) Get(k any) any {
+	switch key := k.(type) {
+	case int64:
+		if key >= 1 && key <= int64(len(t.arr)) {
+			return t.arr[key-1]
+		}
+	case float64:
+		k = normKey(k)
+		if i, ok := k.(int64); ok {
+			return t.Get(i)
+		}
+	case string:
+		// fast path
+		if t.index == nil {
+			return nil
+		}
+		if i, ok := t.index[key]; ok {
+			return t.entries[i].value
+		}
+		return nil
+	}
+	if t.index == nil {
+		return nil
+	}
+	if i, ok := t.index[k]; ok {
+		return t.entries[i].value
+	}
+	return nil
+}
+
+// GetString returns t[k] for a string key.
+func (t *Table) GetString(k string) any {
+	return t.Get(k)
+}
+
+// Set assigns t[k] = v without invoking metamethods. The key must not
+// be nil or NaN; callers check that first.
+func (t *Table) Set(k, v any) {
+	k = normKey(k)
+	if i, ok := k.(int64); ok && i >= 1 && i <= int64(len(t.arr))+1 {
+		t.setArray(i, v)
+		return
+	}
+	if t.index == nil {
+		if v == nil {
+			return
+		}
+		t.index = make(map[any]int)
+	}
+	if i, ok := t.index[k]; ok {
+		if t.entries[i].value == nil && v != nil {
+			t.dead--
+		} else if t.entries[i].value != nil && v == nil {
+			t.dead++
+		}
+		t.entries[i].value = v
+		return
+	}
+	if v == nil {
+		return
+	}
+	if t.dead > 8 && t.dead > len(t.entries)/2 {
+		t.compact()
+	}
+	t.index[k] = len(t.entries)
+	t.entries = append(t.entries, tableEntry{k, v})
+}
+
+func (t *Table) setArray(i int64, v any) {
+	n := int64(len(t.arr))
+	if i <= n {
+		t.arr[i-1] = v
+		if i == n && v == nil {
+			j := len(t.arr) - 1
+			for j > 0 && t.arr[j-1] == nil {
+				j--
+			}
+			t.arr = t.arr[:j]
+		}
+		return
+	}
+	// i == n+1
+	if t.index != nil {
+		if j, ok := t.index[i]; ok {
+			t.removeEntry(j)
+		}
+	}
+	if v == nil {
+		return
+	}
+	t.arr = append(t.arr, v)
+	t.migrate()
+}
+
+// migrate moves n+1, n+2, ... from the hash part into the array part.
+func (t *Table) migrate() {
+	if t.index == nil {
+		return
+	}
+	for {
+		k := int64(len(t.arr)) + 1
+		j, ok := t.index[k]
+		if !ok || t.entries[j].value == nil {
+			return
+		}
+		t.arr = append(t.arr, t.entries[j].value)
+		t.removeEntry(j)
+	}
+}
+
+func (t *Table) removeEntry(j int) {
+	if t.entries[j].value != nil {
+		t.dead++
+	}
+	t.entries[j].value = nil
+}
+
+func (t *Table) compact() {
+	live := t.entries[:0]
+	for _, e := range t.entries {
+		if e.value != nil {
+			t.index[e.key] = len(live)
+			live = append(live, e)
+		} else {
+			delete(t.index, e.key)
+		}
+	}
+	for i := len(live); i < len(t.entries); i++ {
+		t.entries[i] = tableEntry{}
+	}
+	t.entries = live
+	t.dead = 0
+}
+
+// Len returns the border of t used by the # operator.
+func (t *Table) Len() int64 {
+	n := int64(len(t.arr))
+	if n > 0 || t.index == nil {
+		return n
+	}
+	for t.Get(n+1) != nil {
+		n++
+	}
+	return n
+}
+
+// Append adds v at position #t+1.
+func (t *Table) Append(v any) {
+	t.Set(t.Len()+1, v)
+}
+
+// Next returns the entry following key k, for the next function. ok is
+// false when k is not a key of t.
+func (t *Table) Next(k any) (key, value any, ok bool) {
+	i := 0
+	if k != nil {
+		k = normKey(k)
+		found := false
+		n, isInt := k.(int64)
+		if isInt && n >= 1 && n <= int64(len(t.arr)) {
+			i = int(n)
+			found = true
+		} else if j, exists := t.index[k]; exists {
+			i = len(t.arr) + j + 1
+			found = true
+		} else if isInt && n > int64(len(t.arr)) {
+			// the array part shrank after t[n] = nil during the traversal
+			i = len(t.arr)
+			found = true
+		}
+		if !found {
+			return nil, nil, false
+		}
+	}
+	for ; i < len(t.arr); i++ {
+		if t.arr[i] != nil {
+			return int64(i + 1), t.arr[i], true
+		}
+	}
+	for j := i - len(t.arr); j < len(t.entries); j++ {
+		if e := t.entries[j]; e.value != nil {
+			return e.key, e.value, true
+		}
+	}
+	return nil, nil, true
+}
+
+// ForEach calls f for every entry of t in traversal order.
+func (t *Table) ForEach(f func(k, v any)) {
+	for i, v := range t.arr {
+		if v != nil {
+			f(int64(i+1), v)
+		}
+	}
+	for _, e := range t.entries {
+		if e.value != nil {
+			f(e.key, e.value)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package luanova
+
+import (
+	"sort"
+	"strings"
+)
+
+func openTable(s *State) {
+	lib := NewTable()
+	setFuncs(lib, "table.", map[string]GoFunction{
+		"concat": tabConcat,
+		"insert": tabInsert,
+		"pack":   tabPack,
+		"remove": tabRemove,
+		"sort":   tabSort,
+		"unpack": tabUnpack,
+	})
+	s.globals.Set("table", lib)
+}
+
+func tabInsert(s *State) int {
+	t := s.CheckTable(1)
+	n := t.Len()
+	switch s.Top() {
+	case 2:
+		s.tableSet(t, n+1, s.Get(2))
+	case 3:
+		pos := s.CheckInteger(2)
+		if uint64(pos)-1 >= uint64(n)+1 {
+			s.ArgError(2, "position out of bounds")
+		}
+		s.charge(n + 1 - pos)
+		for i := n + 1; i > pos; i-- {
+			t.Set(i, t.Get(i-1))
+		}
+		t.Set(pos, s.Get(3))
+	default:
+		s.RaiseError("wrong number of arguments to 'insert'")
+	}
+	return 0
+}
+
+func tabRemove(s *State) int {
+	t := s.CheckTable(1)
+	n := t.Len()
+	pos := s.OptInteger(2, n)
+	if pos != n && uint64(pos)-1 > uint64(n) {
+		s.ArgError(2, "position out of bounds")
+	}
+	v := t.Get(pos)
+	s.charge(max(n-pos, 0))
+	for ; pos < n; pos++ {
+		t.Set(pos, t.Get(pos+1))
+	}
+	t.Set(pos, nil)
+	s.Push(v)
+	return 1
+}
+
+func tabConcat(s *State) int {
+	t := s.CheckTable(1)
+	sep := s.OptString(2, "")
+	i := s.OptInteger(3, 1)
+	j := s.OptInteger(4, t.Len())
+	budget := s.stringBudget()
+	var b strings.Builder
+	for k := i; k <= j; k++ {
+		s.step()
+		str, ok := toStringCoerce(t.Get(k))
+		if !ok {
+			s.RaiseError("invalid value (at index %d) in table for 'concat'", k)
+		}
+		b.WriteString(str)
+		if k < j {
+			b.WriteString(sep)
+		}
+		if budget >= 0 && b.Len() > budget {
+			s.allocString(budget + 1)
+		}
+	}
+	s.allocString(b.Len())
+	s.Push(b.String())
+	return 1
+}
+
+func tabPack(s *State) int {
+	n := s.Top()
+	t := newTableSize(n, 1)
+	for i := 1; i <= n; i++ {
+		t.Set(int64(i), s.Get(i))
+	}
+	t.Set("n", int64(n))
+	s.Push(t)
+	return 1
+}
+
+func tabUnpack(s *State) int {
+	v := s.Get(1)
+	i := s.OptInteger(2, 1)
+	var j int64
+	if s.Get(3) == nil {
+		j = toInt(s.length(v, ""))
+	} else {
+		j = s.CheckInteger(3)
+	}
+	if i > j {
+		return 0
+	}
+	if uint64(j-i) >= 1<<20 {
+		s.RaiseError("too many results to unpack")
+	}
+	s.charge(j - i + 1)
+	t, plain := v.(*Table)
+	plain = plain && t.meta == nil
+	for k := i; k <= j; k++ {
+		if plain {
+			s.Push(t.Get(k))
+		} else {
+			s.Push(s.index(v, k, ""))
+		}
+	}
+	return int(j - i + 1)
+}
+
+func toInt(v any) int64 {
+	n, _ := toInteger(v)
+	return n
+}
+
+type tableSorter struct {
+	s    *State
+	vals []any
+	less any
+}
+
+func (ts *tableSorter) Len() int      { return len(ts.vals) }
+func (ts *tableSorter) Swap(i, j int) { ts.vals[i], ts.vals[j] = ts.vals[j], ts.vals[i] }
+func (ts *tableSorter) Less(i, j int) bool {
+	ts.s.step()
+	if ts.less == nil {
+		return ts.s.lessThan(ts.vals[i], ts.vals[j])
+	}
+	return truthy(first(ts.s.call(ts.less, []any{ts.vals[i], ts.vals[j]})))
+}
+
+func tabSort(s *State) int {
+	t := s.CheckTable(1)
+	var less any
+	if s.Get(2) != nil {
+		less = s.CheckFunction(2)
+	}
+	n := t.Len()
+	vals := make([]any, n)
+	for i := range vals {
+		vals[i] = t.Get(int64(i + 1))
+	}
+	sort.Sort(&tableSorter{s: s, vals: vals, less: less})
+	for i, v := range vals {
+		t.Set(int64(i+1), v)
+	}
+	return 0
+}
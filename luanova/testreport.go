@@ -0,0 +1,167 @@
+package luanova
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// WriteTestResults writes results in the format of go test: the tests
+// that failed with their output and their subtests that failed, or with
+// verbose a RUN line for every test and the outcome of all of them.
+func WriteTestResults(w io.Writer, results []*TestResult, verbose bool) error {
+	bw := bufio.NewWriter(w)
+	for _, r := range results {
+		if verbose {
+			writeRunLines(bw, r)
+		}
+		writeResult(bw, r, "", verbose)
+	}
+	return bw.Flush()
+}
+
+func writeRunLines(w *bufio.Writer, r *TestResult) {
+	fmt.Fprintf(w, "=== RUN   %s\n", r.Name)
+	for _, sub := range r.Subtests {
+		writeRunLines(w, sub)
+	}
+}
+
+func writeResult(w *bufio.Writer, r *TestResult, indent string, verbose bool) {
+	if !verbose && r.Status != TestFail {
+		return
+	}
+	fmt.Fprintf(w, "%s--- %s: %s (%.2fs)\n", indent, r.Status, r.Name, r.Duration.Seconds())
+	for _, out := range r.Output {
+		// continuation lines are indented further, as go test does
+		out = strings.ReplaceAll(out, "\n", "\n"+indent+"        ")
+		fmt.Fprintf(w, "%s    %s\n", indent, out)
+	}
+	for _, sub := range r.Subtests {
+		writeResult(w, sub, indent+"    ", verbose)
+	}
+}
+
+// TestSuite is the outcome of a test file.
+type TestSuite struct {
+	Name     string
+	Duration time.Duration
+	Results  []*TestResult
+	Err      error // error of the file itself, which ran no tests
+}
+
+type (
+	junitSuites struct {
+		XMLName  xml.Name     `xml:"testsuites"`
+		Tests    int          `xml:"tests,attr"`
+		Failures int          `xml:"failures,attr"`
+		Errors   int          `xml:"errors,attr"`
+		Skipped  int          `xml:"skipped,attr"`
+		Time     string       `xml:"time,attr"`
+		Suites   []junitSuite `xml:"testsuite"`
+	}
+	junitSuite struct {
+		Name     string      `xml:"name,attr"`
+		Tests    int         `xml:"tests,attr"`
+		Failures int         `xml:"failures,attr"`
+		Errors   int         `xml:"errors,attr"`
+		Skipped  int         `xml:"skipped,attr"`
+		Time     string      `xml:"time,attr"`
+		Cases    []junitCase `xml:"testcase"`
+	}
+	junitCase struct {
+		Name      string        `xml:"name,attr"`
+		Classname string        `xml:"classname,attr"`
+		Time      string        `xml:"time,attr"`
+		Failure   *junitMessage `xml:"failure,omitempty"`
+		Error     *junitMessage `xml:"error,omitempty"`
+		Skipped   *junitMessage `xml:"skipped,omitempty"`
+		SystemOut string        `xml:"system-out,omitempty"`
+	}
+	junitMessage struct {
+		Message string `xml:"message,attr"`
+		Text    string `xml:",chardata"`
+	}
+)
+
+// WriteJUnit writes suites as a JUnit XML report. Every test, subtests
+// included, is a test case; a suite that failed to run has an error case
+// named after it.
+func WriteJUnit(w io.Writer, suites []TestSuite) error {
+	var report junitSuites
+	var total time.Duration
+	for _, suite := range suites {
+		js := junitSuite{Name: suite.Name, Time: junitTime(suite.Duration)}
+		if suite.Err != nil {
+			js.Errors++
+			js.Cases = append(js.Cases, junitCase{
+				Name:      suite.Name,
+				Classname: suite.Name,
+				Time:      junitTime(suite.Duration),
+				Error:     &junitMessage{Message: firstLine(suite.Err.Error()), Text: suite.Err.Error()},
+			})
+		}
+		var add func(r *TestResult)
+		add = func(r *TestResult) {
+			c := junitCase{Name: r.Name, Classname: suite.Name, Time: junitTime(r.Duration)}
+			out := strings.Join(r.Output, "\n")
+			switch r.Status {
+			case TestFail:
+				js.Failures++
+				msg := "failed"
+				if len(r.Output) > 0 {
+					msg = firstLine(r.Output[0])
+				}
+				c.Failure = &junitMessage{Message: msg, Text: out}
+			case TestSkip:
+				js.Skipped++
+				msg := "skipped"
+				if len(r.Output) > 0 {
+					msg = firstLine(r.Output[len(r.Output)-1])
+				}
+				c.Skipped = &junitMessage{Message: msg}
+				c.SystemOut = out
+			default:
+				c.SystemOut = out
+			}
+			js.Cases = append(js.Cases, c)
+			for _, sub := range r.Subtests {
+				add(sub)
+			}
+		}
+		for _, r := range suite.Results {
+			add(r)
+		}
+		js.Tests = len(js.Cases)
+		report.Tests += js.Tests
+		report.Failures += js.Failures
+		report.Errors += js.Errors
+		report.Skipped += js.Skipped
+		total += suite.Duration
+		report.Suites = append(report.Suites, js)
+	}
+	report.Time = junitTime(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
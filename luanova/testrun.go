@@ -0,0 +1,428 @@
+package luanova
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TestOptions configures RunTests.
+type TestOptions struct {
+	// Run selects the tests to run like go test -run does: a regular
+	// expression per level of subtests, separated by slashes.
+	Run string
+	// Timeout bounds each top-level test with its subtests; zero means
+	// no bound. The timeout option of test() overrides it.
+	Timeout time.Duration
+}
+
+// TestStatus is the outcome of a test.
+type TestStatus int
+
+const (
+	TestPass TestStatus = iota
+	TestFail
+	TestSkip
+)
+
+func (st TestStatus) String() string {
+	switch st {
+	case TestFail:
+		return "FAIL"
+	case TestSkip:
+		return "SKIP"
+	}
+	return "PASS"
+}
+
+// TestResult is the outcome of a test and of its subtests.
+type TestResult struct {
+	Name     string // with the names of its parents, separated by slashes
+	Status   TestStatus
+	Duration time.Duration
+	Output   []string // logged messages, each prefixed with its position
+	Subtests []*TestResult
+}
+
+// Errors raised through a test function to end it early.
+var (
+	errSkipTest = errors.New("test skipped")
+	errFailTest = errors.New("test failed")
+)
+
+// testRunner holds the tests of a test file.
+type testRunner struct {
+	s       *State
+	match   []*regexp.Regexp
+	tests   []registeredTest
+	running map[*Table]*scriptTest
+	meta    *Table // metatable of the tables t
+}
+
+type registeredTest struct {
+	name    string
+	fn      *Closure
+	timeout time.Duration
+}
+
+// scriptTest is a running test; scripts see it as the table t.
+type scriptTest struct {
+	result *TestResult
+	level  int
+	names  map[string]int // subtest names used so far
+}
+
+// RunTests runs the chunk fn of a test file, then the tests it
+// registered, in order. The chunk registers tests with the global
+// function test(name, fn [, options]); fn is called with a table t whose
+// methods report the outcome:
+//
+//	t:log(...)          records a message
+//	t:error(...)        records a message and marks the test failed
+//	t:fatal(...)        same, and ends the test
+//	t:eq(got, want [, msg])
+//	                    fails the test unless got and want are equal,
+//	                    comparing tables by contents; returns whether
+//	                    they are
+//	t:skip(...)         records a message if given and ends the test as
+//	                    skipped
+//	t:run(name, fn)     runs fn(t) as a subtest and returns whether it
+//	                    passed; a failed subtest fails its parent
+//	t:each(cases, fn)   runs fn(t, case) as a subtest for each case in
+//	                    the array cases, named by case.name or its index
+//	t:name(), t:failed()
+//
+// options.timeout bounds the test in seconds. A test fails when it raises
+// an error, which ends it. The error returned is that of the chunk.
+func (s *State) RunTests(fn *Closure, opts TestOptions) ([]*TestResult, error) {
+	var match []*regexp.Regexp
+	if opts.Run != "" {
+		for _, expr := range strings.Split(opts.Run, "/") {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid test pattern %q: %v", opts.Run, err)
+			}
+			match = append(match, re)
+		}
+	}
+	r := &testRunner{s: s, match: match, running: map[*Table]*scriptTest{}}
+	methods := NewTable()
+	setFuncs(methods, "t:", map[string]GoFunction{
+		"log":    r.log,
+		"error":  r.error,
+		"fatal":  r.fatal,
+		"eq":     r.eq,
+		"skip":   r.skip,
+		"run":    r.run,
+		"each":   r.each,
+		"name":   r.name,
+		"failed": r.failed,
+	})
+	r.meta = NewTable()
+	r.meta.Set("__index", methods)
+	s.SetGlobal("test", NewFunction("test", r.register))
+	if _, err := s.PCall(fn); err != nil {
+		return nil, err
+	}
+
+	var results []*TestResult
+	names := map[string]int{}
+	for _, t := range r.tests {
+		if !r.matches(0, t.name) {
+			continue
+		}
+		timeout := opts.Timeout
+		if t.timeout > 0 {
+			timeout = t.timeout
+		}
+		results = append(results, r.runTop(uniqueName(names, t.name), t.fn, timeout))
+	}
+	return results, nil
+}
+
+// register implements test(name, fn [, options]).
+func (r *testRunner) register(s *State) int {
+	if len(r.running) > 0 {
+		s.RaiseError("test called from a running test; use t:run for subtests")
+	}
+	t := registeredTest{name: s.CheckString(1), fn: s.CheckFunction(2)}
+	if s.Top() >= 3 && s.Get(3) != nil {
+		opts := s.CheckTable(3)
+		if v := opts.GetString("timeout"); v != nil {
+			secs, ok := toFloat(v)
+			if !ok || secs <= 0 {
+				s.ArgError(3, "timeout must be a positive number of seconds")
+			}
+			t.timeout = time.Duration(secs * float64(time.Second))
+		}
+	}
+	r.tests = append(r.tests, t)
+	return 0
+}
+
+// matches reports whether a test at level is selected by its name.
+func (r *testRunner) matches(level int, name string) bool {
+	return level >= len(r.match) || r.match[level].MatchString(name)
+}
+
+// uniqueName numbers the repeated names of a test's subtests like go
+// test does: name, name#01, name#02 and so on.
+func uniqueName(names map[string]int, name string) string {
+	n := names[name]
+	names[name] = n + 1
+	if n == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s#%02d", name, n)
+}
+
+// runTop runs a top-level test as a separate execution bounded by
+// timeout.
+func (r *testRunner) runTop(name string, fn *Closure, timeout time.Duration) *TestResult {
+	s := r.s
+	prev := s.ctx
+	ctx := prev
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	s.SetContext(ctx)
+	defer s.SetContext(prev)
+	t := &scriptTest{result: &TestResult{Name: name}}
+	r.call(t, fn)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) && t.result.Status == TestFail {
+		t.result.Output = append(t.result.Output, fmt.Sprintf("test timed out after %v", timeout))
+	}
+	return t.result
+}
+
+// call runs fn(t, args...) as the body of t.
+func (r *testRunner) call(t *scriptTest, fn any, args ...any) {
+	self := NewTable()
+	self.SetMetatable(r.meta)
+	r.running[self] = t
+	defer delete(r.running, self)
+	start := time.Now()
+	_, err := r.s.PCall(fn, append([]any{self}, args...)...)
+	t.result.Duration = time.Since(start)
+	switch {
+	case err == nil, errors.Is(err, errFailTest):
+	case errors.Is(err, errSkipTest):
+		if t.result.Status != TestFail {
+			t.result.Status = TestSkip
+		}
+	case errors.Is(err, context.DeadlineExceeded):
+		t.result.Status = TestFail
+	default:
+		msg := err.Error()
+		var lerr *Error
+		if errors.As(err, &lerr) {
+			msg += "\n" + lerr.Traceback()
+		}
+		t.result.Output = append(t.result.Output, msg)
+		t.result.Status = TestFail
+	}
+}
+
+// self returns the test a method is called on.
+func (r *testRunner) self(s *State) *scriptTest {
+	tbl, _ := s.Get(1).(*Table)
+	t := r.running[tbl]
+	if t == nil {
+		s.RaiseError("bad self to '%s' (running test expected)", s.funcName())
+	}
+	return t
+}
+
+// message formats the arguments from i on like print, prefixed with
+// the position of the caller.
+func (r *testRunner) message(s *State, i int) string {
+	parts := make([]string, 0, s.Top())
+	for ; i <= s.Top(); i++ {
+		parts = append(parts, s.tostring(s.Get(i)))
+	}
+	return strings.TrimSuffix(s.where(1)+strings.Join(parts, " "), " ")
+}
+
+func (r *testRunner) log(s *State) int {
+	t := r.self(s)
+	t.result.Output = append(t.result.Output, r.message(s, 2))
+	return 0
+}
+
+func (r *testRunner) error(s *State) int {
+	t := r.self(s)
+	t.result.Output = append(t.result.Output, r.message(s, 2))
+	t.result.Status = TestFail
+	return 0
+}
+
+func (r *testRunner) fatal(s *State) int {
+	r.error(s)
+	s.Raise(errFailTest)
+	return 0
+}
+
+func (r *testRunner) eq(s *State) int {
+	t := r.self(s)
+	got, want := s.Get(2), s.Get(3)
+	if deepEqual(got, want, nil) {
+		s.Push(true)
+		return 1
+	}
+	msg := fmt.Sprintf("got %s, want %s", testRepr(got, 0), testRepr(want, 0))
+	if s.Top() >= 4 && s.Get(4) != nil {
+		msg = s.tostring(s.Get(4)) + ": " + msg
+	}
+	t.result.Output = append(t.result.Output, s.where(1)+msg)
+	t.result.Status = TestFail
+	s.Push(false)
+	return 1
+}
+
+func (r *testRunner) skip(s *State) int {
+	t := r.self(s)
+	if s.Top() >= 2 {
+		t.result.Output = append(t.result.Output, r.message(s, 2))
+	}
+	s.Raise(errSkipTest)
+	return 0
+}
+
+func (r *testRunner) run(s *State) int {
+	t := r.self(s)
+	name, fn := s.CheckString(2), s.CheckFunction(3)
+	s.Push(r.subtest(t, name, fn))
+	return 1
+}
+
+func (r *testRunner) each(s *State) int {
+	t := r.self(s)
+	cases, fn := s.CheckTable(2), s.CheckFunction(3)
+	ok := true
+	for i := int64(1); i <= cases.Len(); i++ {
+		c := cases.Get(i)
+		name := strconv.FormatInt(i, 10)
+		if ct, isTable := c.(*Table); isTable {
+			if n, isString := ct.GetString("name").(string); isString {
+				name = n
+			}
+		}
+		if !r.subtest(t, name, fn, c) {
+			ok = false
+		}
+	}
+	s.Push(ok)
+	return 1
+}
+
+// subtest runs fn as the subtest name of t and reports whether it
+// passed or was skipped. Subtests not selected by the run pattern count
+// as passed.
+func (r *testRunner) subtest(t *scriptTest, name string, fn *Closure, args ...any) bool {
+	if !r.matches(t.level+1, name) {
+		return true
+	}
+	if t.names == nil {
+		t.names = map[string]int{}
+	}
+	sub := &scriptTest{
+		result: &TestResult{Name: t.result.Name + "/" + uniqueName(t.names, name)},
+		level:  t.level + 1,
+	}
+	r.call(sub, fn, args...)
+	t.result.Subtests = append(t.result.Subtests, sub.result)
+	if ctx := r.s.ctx; ctx != nil && ctx.Err() != nil {
+		// the timeout of the top-level test ends its parents too
+		r.s.limitError(ctx.Err())
+	}
+	if sub.result.Status == TestFail {
+		t.result.Status = TestFail
+		return false
+	}
+	return true
+}
+
+func (r *testRunner) name(s *State) int {
+	s.Push(r.self(s).result.Name)
+	return 1
+}
+
+func (r *testRunner) failed(s *State) int {
+	s.Push(r.self(s).result.Status == TestFail)
+	return 1
+}
+
+// deepEqual compares values like ==, without metamethods, and tables by
+// their contents. seen holds the pairs of tables being compared.
+func deepEqual(a, b any, seen map[[2]*Table]bool) bool {
+	if rawEqual(a, b) {
+		return true
+	}
+	ta, ok := a.(*Table)
+	tb, ok2 := b.(*Table)
+	if !ok || !ok2 {
+		return false
+	}
+	pair := [2]*Table{ta, tb}
+	if seen[pair] {
+		return true
+	}
+	if seen == nil {
+		seen = map[[2]*Table]bool{}
+	}
+	seen[pair] = true
+	n := 0
+	equal := true
+	ta.ForEach(func(k, v any) {
+		n++
+		if equal && !deepEqual(v, tb.Get(k), seen) {
+			equal = false
+		}
+	})
+	if !equal {
+		return false
+	}
+	tb.ForEach(func(any, any) { n-- })
+	return n == 0
+}
+
+// testRepr renders v for messages: strings quoted and tables with their
+// contents, the array part first.
+func testRepr(v any, depth int) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case *Table:
+		if depth >= 3 {
+			return "{...}"
+		}
+		var items, fields []string
+		n := v.Len()
+		for i := int64(1); i <= n; i++ {
+			items = append(items, testRepr(v.Get(i), depth+1))
+		}
+		v.ForEach(func(k, val any) {
+			if i, ok := k.(int64); ok && i >= 1 && i <= n {
+				return
+			}
+			key := "[" + testRepr(k, depth+1) + "]"
+			if name, ok := k.(string); ok && isIdentifier(name) {
+				key = name
+			}
+			fields = append(fields, key+" = "+testRepr(val, depth+1))
+		})
+		sort.Strings(fields)
+		return "{" + strings.Join(append(items, fields...), ", ") + "}"
+	}
+	return tostringBasic(v)
+}
@@ -0,0 +1,256 @@
+package luanova
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// durations matches the run times in test output.
+var durations = regexp.MustCompile(`\(\d+\.\d+s\)`)
+
+func TestRunTests(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		opts     TestOptions
+		expected string
+	}{
+		{"pass and fail", `test("ok", function(t) t:eq(1 + 1, 2) end)
+test("bad", function(t)
+	t:eq({1, {x = "y"}}, {1, {x = "z"}}, "nested")
+	t:log("still running")
+end)`, TestOptions{}, `=== RUN   ok
+--- PASS: ok
+=== RUN   bad
+--- FAIL: bad
+    test:3: nested: got {1, {x = "y"}}, want {1, {x = "z"}}
+    test:4: still running
+`},
+		{"fatal and skip", `test("fatal", function(t)
+	t:fatal("stop")
+	t:log("not reached")
+end)
+test("skip", function(t)
+	t:skip("later")
+end)
+test("fail then skip", function(t)
+	t:error("broken")
+	t:skip()
+end)`, TestOptions{}, `=== RUN   fatal
+--- FAIL: fatal
+    test:2: stop
+=== RUN   skip
+--- SKIP: skip
+    test:6: later
+=== RUN   fail then skip
+--- FAIL: fail then skip
+    test:9: broken
+`},
+		{"subtests", `test("parent", function(t)
+	print(t:run("a", function(t) t:eq(t:name(), "parent/a") end))
+	print(t:run("a", function(t) t:error("twice") end))
+	print(t:failed())
+end)`, TestOptions{}, `=== RUN   parent
+=== RUN   parent/a
+=== RUN   parent/a#01
+--- FAIL: parent
+    --- PASS: parent/a
+    --- FAIL: parent/a#01
+        test:3: twice
+`},
+		{"table driven", `test("double", function(t)
+	t:each({{name = "one", n = 1, want = 2}, {n = 2, want = 5}}, function(t, c)
+		t:eq(c.n * 2, c.want)
+	end)
+end)`, TestOptions{}, `=== RUN   double
+=== RUN   double/one
+=== RUN   double/2
+--- FAIL: double
+    --- PASS: double/one
+    --- FAIL: double/2
+        test:3: got 4, want 5
+`},
+		{"errors", `test("raises", function(t)
+	error("boom")
+end)`, TestOptions{}, `=== RUN   raises
+--- FAIL: raises
+    test:2: boom
+        stack traceback:
+        	[Go]: in function 'error'
+        	test:2: in function <test:1>
+`},
+		{"run filter", `test("alpha", function(t)
+	t:run("one", function() end)
+	t:run("two", function() end)
+end)
+test("beta", function() end)`, TestOptions{Run: "alpha/tw"}, `=== RUN   alpha
+=== RUN   alpha/two
+--- PASS: alpha
+    --- PASS: alpha/two
+`},
+		{"timeout", `test("slow", function(t)
+	t:run("loop", function() while true do end end)
+	t:log("not reached")
+end, {timeout = 0.05})
+test("fast", function() end)`, TestOptions{Timeout: time.Minute}, `=== RUN   slow
+=== RUN   slow/loop
+--- FAIL: slow
+    test timed out after 50ms
+    --- FAIL: slow/loop
+=== RUN   fast
+--- PASS: fast
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewState()
+			var out strings.Builder
+			s.Stdout = &out
+			fn, err := s.Load(tt.input, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			results, err := s.RunTests(fn, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var b bytes.Buffer
+			if err := WriteTestResults(&b, results, true); err != nil {
+				t.Fatal(err)
+			}
+			got := durations.ReplaceAllString(b.String(), "")
+			got = strings.ReplaceAll(got, " \n", "\n")
+			if got != tt.expected {
+				t.Errorf("results:\n%s\nwant:\n%s", got, tt.expected)
+			}
+			if tt.name == "subtests" && out.String() != "true\nfalse\ntrue\n" {
+				t.Errorf("printed %q", out.String())
+			}
+		})
+	}
+}
+
+func TestRunTestsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"chunk error", `error("setup")`, "test:1: setup"},
+		{"test in a test", `test("outer", function() test("inner", function() end) end)`, ""},
+		{"bad timeout", `test("x", function() end, {timeout = -1})`, "timeout must be a positive number"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewState()
+			fn, err := s.Load(tt.input, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			results, err := s.RunTests(fn, TestOptions{})
+			if tt.expected == "" {
+				if err != nil || len(results) != 1 || results[0].Status != TestFail ||
+					!strings.Contains(results[0].Output[0], "use t:run for subtests") {
+					t.Errorf("results = %v, err = %v", results, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("err = %v, want %q", err, tt.expected)
+			}
+		})
+	}
+	if _, err := NewState().RunTests(NewFunction("f", func(*State) int { return 0 }), TestOptions{Run: "("}); err == nil {
+		t.Error("no error for an invalid pattern")
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	suites := []TestSuite{
+		{Name: "a_test.lunv", Duration: 1500 * time.Millisecond, Results: []*TestResult{
+			{Name: "ok", Duration: time.Second, Output: []string{"a_test.lunv:2: note"}},
+			{Name: "bad", Status: TestFail, Output: []string{"a_test.lunv:5: got 1, want 2"}, Subtests: []*TestResult{
+				{Name: "bad/skipped", Status: TestSkip},
+			}},
+		}},
+		{Name: "b_test.lunv", Err: os.ErrNotExist},
+	}
+	var b bytes.Buffer
+	if err := WriteJUnit(&b, suites); err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites tests="4" failures="1" errors="1" skipped="1" time="1.500">
+	<testsuite name="a_test.lunv" tests="3" failures="1" errors="0" skipped="1" time="1.500">
+		<testcase name="ok" classname="a_test.lunv" time="1.000">
+			<system-out>a_test.lunv:2: note</system-out>
+		</testcase>
+		<testcase name="bad" classname="a_test.lunv" time="0.000">
+			<failure message="a_test.lunv:5: got 1, want 2">a_test.lunv:5: got 1, want 2</failure>
+		</testcase>
+		<testcase name="bad/skipped" classname="a_test.lunv" time="0.000">
+			<skipped message="skipped"></skipped>
+		</testcase>
+	</testsuite>
+	<testsuite name="b_test.lunv" tests="1" failures="0" errors="1" skipped="0" time="0.000">
+		<testcase name="b_test.lunv" classname="b_test.lunv" time="0.000">
+			<error message="file does not exist">file does not exist</error>
+		</testcase>
+	</testsuite>
+</testsuites>
+`
+	if b.String() != want {
+		t.Errorf("report:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+// TestScripts runs the script tests under testdata as subtests.
+func TestScripts(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*_test.lunv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := NewState()
+			s.SetFS(os.DirFS("testdata"))
+			fn, err := s.Load(string(src), filepath.Base(file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			results, err := s.RunTests(fn, TestOptions{Timeout: time.Minute})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range results {
+				reportScriptTest(t, r)
+			}
+		})
+	}
+}
+
+func reportScriptTest(t *testing.T, r *TestResult) {
+	t.Run(r.Name[strings.LastIndex(r.Name, "/")+1:], func(t *testing.T) {
+		for _, out := range r.Output {
+			t.Log(out)
+		}
+		for _, sub := range r.Subtests {
+			reportScriptTest(t, sub)
+		}
+		switch r.Status {
+		case TestFail:
+			t.Fail()
+		case TestSkip:
+			t.SkipNow()
+		}
+	})
+}
@@ -16,6 +16,8 @@ func TokenName(tok int) string {
 		return "True"
 	case False:
 		return "False"
+	case Nil:
+		return "Nil"
 	case Literal:
 		return "Literal"
 	case Function:
@@ -38,6 +40,8 @@ func TokenName(tok int) string {
 		return "Then"
 	case Repeat:
 		return "Repeat"
+	case Until:
+		return "Until"
 	case Continue:
 		return "Continue"
 	case Break:
@@ -48,6 +52,14 @@ func TokenName(tok int) string {
 		return "Return"
 	case Do:
 		return "Do"
+	case Import:
+		return "Import"
+	case Export:
+		return "Export"
+	case From:
+		return "From"
+	case As:
+		return "As"
 	case Not:
 		return "Not"
 	case NotEqual:
@@ -94,6 +106,8 @@ func TokenName(tok int) string {
 		return "Po"
 	case Concat:
 		return "Concat"
+	case Len:
+		return "Len"
 	case StringDelim:
 		return "StringDelim"
 	case LParen:
@@ -4,6 +4,8 @@
 
 package luanova
 
+//go:generate go run gengrammar.go
+
 const (
 	Illegal = iota
 	EOF
@@ -12,6 +14,7 @@ const (
 	CommentBlock // -**-
 	True         // true
 	False        // false
+	Nil          // nil
 
 	Literal // Literal
 
@@ -26,20 +29,27 @@ const (
 	End      // End
 	Then     // Then
 	Repeat   // Repeat
+	Until    // Until
 	Continue // Continue
 	Break    // Break
 	In       // In
 	Return   // Return
 	Do       // Do
 
+	// Contextual keywords: lexed as Literal, see LookupContextual
+	Import // import
+	Export // export
+	From   // from
+	As     // as
+
 	// Operators
 	Not          // Not
 	NotEqual     // ~=
 	Or           // Or
-	Greater      // <
-	Less         // >
-	GreaterEqual // <=
-	LessEqual    // >=
+	Greater      // >
+	Less         // <
+	GreaterEqual // >=
+	LessEqual    // <=
 	And          // And
 	Assign       // =
 	Equal        // ==
@@ -56,6 +66,7 @@ const (
 	Mod          // %
 	Po           // ^
 	Concat       // ..
+	Len          // #
 
 	//Delimiters
 	StringDelim // "
@@ -80,3 +91,77 @@ const (
 	Float  // float
 
 )
+
+// keywords maps the reserved words to their tokens.
+var keywords = map[string]int{
+	"function": Function,
+	"local":    Local,
+	"if":       If,
+	"elseif":   ElseIf,
+	"else":     Else,
+	"while":    While,
+	"for":      For,
+	"end":      End,
+	"then":     Then,
+	"repeat":   Repeat,
+	"until":    Until,
+	"continue": Continue,
+	"break":    Break,
+	"in":       In,
+	"return":   Return,
+	"do":       Do,
+	"and":      And,
+	"or":       Or,
+	"not":      Not,
+	"true":     True,
+	"false":    False,
+	"nil":      Nil,
+}
+
+// contextualKeywords maps the words that are keywords only where a
+// module declaration can start; see LookupContextual.
+var contextualKeywords = map[string]int{
+	"import": Import,
+	"export": Export,
+	"from":   From,
+	"as":     As,
+}
+
+// operators maps the spellings of the operators and delimiters the
+// lexer produces to their tokens. The lexer also produces Comment,
+// CommentBlock and StringDelim from "--", "-*" and a quote.
+var operators = map[string]int{
+	"~=":  NotEqual,
+	">":   Greater,
+	"<":   Less,
+	">=":  GreaterEqual,
+	"<=":  LessEqual,
+	"=":   Assign,
+	"==":  Equal,
+	"+=":  PlusAssign,
+	"-=":  SubAssign,
+	"*=":  MultiAssign,
+	"/=":  DivAssign,
+	"%=":  ModAssign,
+	"+":   Plus,
+	"-":   Sub,
+	"*":   Multi,
+	"/":   Div,
+	"%":   Mod,
+	"^":   Po,
+	"..":  Concat,
+	"#":   Len,
+	"(":   LParen,
+	")":   RParen,
+	"{":   LBrace,
+	"}":   RBrace,
+	"[":   LBrack,
+	"]":   RBrack,
+	",":   Comma,
+	";":   Semi,
+	":":   Colom,
+	".":   Dot,
+	"...": Dots,
+	"->":  Arrow,
+	"?":   Question,
+}
@@ -0,0 +1,976 @@
+package luanova
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Target is a Lua dialect Transpile emits.
+type Target int
+
+const (
+	TargetLua51 Target = iota
+	TargetLua54
+	TargetLuau
+)
+
+var targetNames = []string{"lua51", "lua54", "luau"}
+
+func (t Target) String() string {
+	if int(t) < len(targetNames) {
+		return targetNames[t]
+	}
+	return fmt.Sprintf("Target(%d)", int(t))
+}
+
+// ParseTarget returns the target named s: lua51, lua54 or luau.
+func ParseTarget(s string) (Target, error) {
+	for i, name := range targetNames {
+		if s == name {
+			return Target(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown target '%s' (want %s)", s, strings.Join(targetNames, ", "))
+}
+
+// Mapping links a position in generated code to the source position it
+// was generated from. Line and Column are 1-based, like Position.
+type Mapping struct {
+	Line   int
+	Column int
+	Source Position
+	Name   string // the source identifier, if any
+}
+
+// Transpiled is the output of Transpile.
+type Transpiled struct {
+	Code     string
+	Mappings []Mapping // ordered by generated position
+}
+
+// Transpile turns a chunk into plain source for target. Type annotations
+// and type aliases are removed, except for Luau which has the same
+// syntax for them. Compound assignments and continue are rewritten for
+// the Lua targets, and import and export become require calls and a
+// returned table of exports. Comments are kept.
+//
+// Numbers are written so that every target reads the same values, but
+// library calls are kept as they are: Lua 5.1 has no integer subtype, so
+// the lua51 output prints 3.0 and 10/2 as 3 and 5, and math.maxinteger
+// and the other Lua 5.3 additions are nil there.
+//
+// The chunk is checked like Load does first; errors are returned as an
+// ErrorList.
+func Transpile(chunk *Chunk, target Target) (*Transpiled, error) {
+	if _, err := compileChunk(chunk, nil); err != nil {
+		return nil, err
+	}
+	t := &transpiler{target: target, source: chunk.Name, comments: chunk.Comments, names: map[string]bool{}}
+	Inspect(chunk.Block, func(n Node) bool {
+		if id, ok := n.(*Ident); ok {
+			t.names[id.Name] = true
+		}
+		return true
+	})
+	t.chunk(chunk)
+	if err := t.errors.Err(); err != nil {
+		return nil, err
+	}
+	return &Transpiled{Code: t.buf.String(), Mappings: t.mappings}, nil
+}
+
+type transpiler struct {
+	target   Target
+	source   string
+	buf      strings.Builder
+	line     int // generated position of the next byte, 0-based
+	col      int
+	indent   int
+	pending  bool // a statement starts: write a newline before the next byte
+	srcLine  int  // last source line written, to keep blank lines
+	mappings []Mapping
+	comments []Token
+	names    map[string]bool // identifiers of the chunk, avoided by temporaries
+	loops    []*loweredLoop
+	errors   ErrorList
+}
+
+// loweredLoop is a loop whose body is wrapped in `repeat ... until
+// true` so that continue can break out of it. A break then sets the flag
+// named brk and the loop checks it after the wrapper.
+type loweredLoop struct {
+	lowered bool
+	brk     string
+}
+
+func (t *transpiler) errorAt(pos Position, format string, args ...any) {
+	t.errors = append(t.errors, &SyntaxError{Source: t.source, Pos: pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+// write appends code, starting a new line first if a statement is
+// pending.
+func (t *transpiler) write(s string) {
+	if t.pending {
+		t.pending = false
+		if t.buf.Len() > 0 {
+			t.buf.WriteByte('\n')
+			t.line++
+		}
+		t.buf.WriteString(strings.Repeat("\t", t.indent))
+		t.col = t.indent
+	}
+	t.buf.WriteString(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		t.line += strings.Count(s, "\n")
+		t.col = len(s) - i - 1
+	} else {
+		t.col += len(s)
+	}
+}
+
+// mark maps the next byte written to pos.
+func (t *transpiler) mark(pos Position, name string) {
+	t.write("")
+	m := Mapping{Line: t.line + 1, Column: t.col + 1, Source: pos, Name: name}
+	if n := len(t.mappings); n > 0 && t.mappings[n-1].Line == m.Line && t.mappings[n-1].Column == m.Column {
+		t.mappings[n-1] = m
+		return
+	}
+	t.mappings = append(t.mappings, m)
+}
+
+// newline makes the next write start a new line. A blank line in the
+// source before the statement at pos is kept.
+func (t *transpiler) newline(pos Position) {
+	if t.buf.Len() > 0 && t.srcLine > 0 && pos.Line > t.srcLine+1 && !t.pending {
+		t.buf.WriteByte('\n')
+		t.line++
+	}
+	t.pending = true
+}
+
+// temp returns a name for a temporary that no identifier of the chunk
+// uses.
+func (t *transpiler) temp(base string) string {
+	name := "__" + base
+	for i := 2; t.names[name]; i++ {
+		name = fmt.Sprintf("__%s%d", base, i)
+	}
+	return name
+}
+
+// Comments
+
+// flushComments writes the comments that start before offset, each on
+// its own line.
+func (t *transpiler) flushComments(offset int) {
+	for len(t.comments) > 0 && t.comments[0].Pos.Offset < offset {
+		c := t.comments[0]
+		t.comments = t.comments[1:]
+		t.newline(c.Pos)
+		t.write(luaComment(c))
+		t.srcLine = c.End.Line
+	}
+}
+
+// trailingComment writes a comment that follows the statement ending
+// at end on the same line.
+func (t *transpiler) trailingComment(end Position) {
+	if len(t.comments) > 0 && t.comments[0].Pos.Line == end.Line && t.comments[0].Pos.Offset >= end.Offset {
+		c := t.comments[0]
+		t.comments = t.comments[1:]
+		t.write(" " + luaComment(c))
+		t.srcLine = c.End.Line
+	}
+}
+
+// luaComment renders a comment for Lua: block comments become long
+// comments, and a line comment that would open one is split.
+func luaComment(c Token) string {
+	text := strings.TrimRight(c.Literal, " \t\r\n")
+	if c.Type == Comment {
+		if rest, ok := strings.CutPrefix(text, "--["); ok && strings.HasPrefix(strings.TrimLeft(rest, "="), "[") {
+			return "-- " + text[2:]
+		}
+		return text
+	}
+	if i := strings.Index(text, "-*"); i >= 0 {
+		text = text[i+2:]
+	}
+	text = strings.TrimSuffix(text, "*-")
+	level := ""
+	for strings.Contains(text, "]"+level+"]") {
+		level += "="
+	}
+	return "--[" + level + "[" + text + "]" + level + "]"
+}
+
+// Statements
+
+func (t *transpiler) chunk(chunk *Chunk) {
+	stmts := chunk.Block.Stmts
+	t.stmts(stmts)
+	if isModule(chunk) {
+		if n := len(stmts); n == 0 || !isReturn(stmts[n-1]) {
+			t.newline(Position{Line: t.srcLine + 2})
+			t.exportTable(chunk)
+		}
+	}
+	t.flushComments(math.MaxInt)
+	if t.buf.Len() > 0 {
+		t.buf.WriteByte('\n')
+	}
+}
+
+func isReturn(s Stmt) bool {
+	_, ok := s.(*ReturnStmt)
+	return ok
+}
+
+// exportTable returns the exports of a module chunk.
+func (t *transpiler) exportTable(chunk *Chunk) {
+	t.write("return {")
+	t.indent++
+	for _, e := range ChunkExports(chunk) {
+		if e.Type {
+			continue
+		}
+		local := e.Name
+		if spec, ok := e.Node.(*ImportSpec); ok {
+			local = spec.Name.Name
+		}
+		t.pending = true
+		t.mark(e.Node.Pos(), local)
+		t.write(e.Name + " = " + local + ",")
+	}
+	t.indent--
+	t.pending = true
+	t.write("}")
+}
+
+func (t *transpiler) block(b *Block, end Position) {
+	t.indent++
+	t.stmts(b.Stmts)
+	t.flushComments(end.Offset)
+	t.indent--
+	t.pending = true
+}
+
+func (t *transpiler) stmts(stmts []Stmt) {
+	emitted := false
+	for i, s := range stmts {
+		if t.stripped(s) {
+			t.flushComments(s.Pos().Offset)
+			continue
+		}
+		// Lua 5.1 reads a line starting with ( as a call of the previous
+		// line, so end the previous statement explicitly
+		if emitted && startsWithParen(s) {
+			t.write(";")
+		}
+		emitted = true
+		t.flushComments(s.Pos().Offset)
+		t.newline(s.Pos())
+		t.srcLine = s.Pos().Line
+		t.stmt(s, i == len(stmts)-1)
+		t.srcLine = max(t.srcLine, s.End().Line)
+		t.trailingComment(s.End())
+	}
+}
+
+// stripped reports whether s has no code for the target.
+func (t *transpiler) stripped(s Stmt) bool {
+	switch s := s.(type) {
+	case *TypeStmt:
+		return t.target != TargetLuau
+	case *ExportStmt:
+		_, isType := s.Decl.(*TypeStmt)
+		return s.Decl == nil || isType && t.target != TargetLuau
+	}
+	return false
+}
+
+// startsWithParen reports whether the code of s starts with '('.
+func startsWithParen(s Stmt) bool {
+	var e Expr
+	switch s := s.(type) {
+	case *CallStmt:
+		e = s.Call
+	case *AssignStmt:
+		e = s.Targets[0]
+	case *CompoundAssignStmt:
+		e = s.Target
+	default:
+		return false
+	}
+	for {
+		switch x := e.(type) {
+		case *ParenExpr:
+			return true
+		case *CallExpr:
+			e = x.Fn
+		case *MethodCallExpr:
+			e = x.Recv
+		case *FieldExpr:
+			e = x.X
+		case *IndexExpr:
+			e = x.X
+		default:
+			return false
+		}
+	}
+}
+
+// stmt writes s. Lua requires return and break to end their block, so
+// they are wrapped in do ... end when last is false.
+func (t *transpiler) stmt(s Stmt, last bool) {
+	t.mark(s.Pos(), "")
+	switch s := s.(type) {
+	case *LocalStmt:
+		t.write("local ")
+		t.bindings(s.Names)
+		if len(s.Values) > 0 {
+			t.write(" = ")
+			t.exprList(s.Values)
+		}
+	case *LocalFunctionStmt:
+		t.write("local function ")
+		t.ident(s.Name)
+		t.funcBody(s.Func, false)
+	case *FunctionStmt:
+		t.write("function ")
+		t.expr(s.Name)
+		if s.Method != nil {
+			t.write(":")
+			t.ident(s.Method)
+		}
+		t.funcBody(s.Func, s.Method != nil)
+	case *AssignStmt:
+		t.exprList(s.Targets)
+		t.write(" = ")
+		t.exprList(s.Values)
+	case *CompoundAssignStmt:
+		t.compoundAssign(s)
+	case *CallStmt:
+		t.expr(s.Call)
+	case *DoStmt:
+		t.write("do")
+		t.block(s.Body, s.End())
+		t.write("end")
+	case *WhileStmt:
+		t.write("while ")
+		t.expr(s.Cond)
+		t.write(" do")
+		t.loopBody(s.Body, s.End())
+		t.write("end")
+	case *RepeatStmt:
+		t.write("repeat")
+		if t.loopBody(s.Body, Position{Offset: s.Cond.Pos().Offset}) {
+			t.checkUntil(s)
+		}
+		t.write("until ")
+		t.expr(s.Cond)
+	case *IfStmt:
+		for i, c := range s.Clauses {
+			if i > 0 {
+				t.mark(c.Pos(), "")
+				t.write("elseif ")
+			} else {
+				t.write("if ")
+			}
+			t.expr(c.Cond)
+			t.write(" then")
+			end := s.End()
+			if i+1 < len(s.Clauses) {
+				end = s.Clauses[i+1].Pos()
+			} else if s.Else != nil {
+				end = s.Else.Pos()
+			}
+			t.block(c.Body, end)
+		}
+		if s.Else != nil {
+			t.write("else")
+			t.block(s.Else, s.End())
+		}
+		t.write("end")
+	case *NumericForStmt:
+		t.write("for ")
+		t.binding(s.Var)
+		t.write(" = ")
+		t.expr(s.Start)
+		t.write(", ")
+		t.expr(s.Limit)
+		if s.Step != nil {
+			t.write(", ")
+			t.expr(s.Step)
+		}
+		t.write(" do")
+		t.loopBody(s.Body, s.End())
+		t.write("end")
+	case *GenericForStmt:
+		t.write("for ")
+		t.bindings(s.Names)
+		t.write(" in ")
+		t.exprList(s.Exprs)
+		t.write(" do")
+		t.loopBody(s.Body, s.End())
+		t.write("end")
+	case *ReturnStmt:
+		t.jump("return", last, func() {
+			if len(s.Values) > 0 {
+				t.write(" ")
+				t.exprList(s.Values)
+			}
+		})
+	case *BreakStmt:
+		loop := t.loops[len(t.loops)-1]
+		if loop.lowered && loop.brk != "" {
+			t.write(loop.brk + " = true")
+			t.pending = true
+			t.jump("break", true, nil)
+			break
+		}
+		t.jump("break", last, nil)
+	case *ContinueStmt:
+		if t.target == TargetLuau {
+			t.jump("continue", last, nil)
+			break
+		}
+		t.jump("break", last, nil)
+	case *TypeStmt:
+		if t.target == TargetLuau {
+			t.typeStmt(s)
+		}
+	case *ImportStmt:
+		t.importStmt(s)
+	case *ExportStmt:
+		if s.Decl == nil {
+			break
+		}
+		if decl, ok := s.Decl.(*TypeStmt); ok {
+			if t.target == TargetLuau {
+				t.write("export ")
+				t.typeStmt(decl)
+			}
+			break
+		}
+		t.stmt(s.Decl, last)
+	}
+}
+
+func (t *transpiler) jump(keyword string, last bool, values func()) {
+	if !last {
+		t.write("do ")
+	}
+	t.write(keyword)
+	if values != nil {
+		values()
+	}
+	if !last {
+		t.write(" end")
+	}
+}
+
+func (t *transpiler) typeStmt(s *TypeStmt) {
+	t.write("type " + s.Name.Name + " = " + t.typeString(s.Type))
+}
+
+// loopBody writes the body of a loop and reports whether continue was
+// lowered in it.
+func (t *transpiler) loopBody(body *Block, end Position) bool {
+	loop := &loweredLoop{}
+	if t.target != TargetLuau && jumps(body, func(s Stmt) bool { _, ok := s.(*ContinueStmt); return ok }) {
+		loop.lowered = true
+		if jumps(body, func(s Stmt) bool { _, ok := s.(*BreakStmt); return ok }) {
+			loop.brk = t.temp("break")
+		}
+	}
+	t.loops = append(t.loops, loop)
+	defer func() { t.loops = t.loops[:len(t.loops)-1] }()
+	if !loop.lowered {
+		t.block(body, end)
+		return false
+	}
+
+	t.indent++
+	if loop.brk != "" {
+		t.pending = true
+		t.write("local " + loop.brk + " = false")
+	}
+	t.pending = true
+	t.write("repeat")
+	t.block(body, end)
+	t.write("until true")
+	if loop.brk != "" {
+		t.pending = true
+		t.write("if " + loop.brk + " then break end")
+	}
+	t.indent--
+	t.pending = true
+	return true
+}
+
+// jumps reports whether a statement of body matching f, outside nested
+// loops and functions, jumps to the loop of body.
+func jumps(body *Block, f func(Stmt) bool) bool {
+	found := false
+	Inspect(body, func(n Node) bool {
+		switch n := n.(type) {
+		case *WhileStmt, *RepeatStmt, *NumericForStmt, *GenericForStmt, *FunctionExpr, Expr:
+			return false
+		case Stmt:
+			if f(n) {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// checkUntil reports a repeat loop whose continue was lowered while its
+// condition uses a local of the body: the wrapper hides those locals.
+func (t *transpiler) checkUntil(s *RepeatStmt) {
+	locals := map[string]bool{}
+	for _, stmt := range s.Body.Stmts {
+		switch stmt := stmt.(type) {
+		case *LocalStmt:
+			for _, b := range stmt.Names {
+				locals[b.Name.Name] = true
+			}
+		case *LocalFunctionStmt:
+			locals[stmt.Name.Name] = true
+		}
+	}
+	Inspect(s.Cond, func(n Node) bool {
+		if id, ok := n.(*Ident); ok && locals[id.Name] {
+			t.errorAt(id.Pos(), "cannot lower continue: the until condition uses local '%s' of the loop body", id.Name)
+			delete(locals, id.Name)
+		}
+		return true
+	})
+}
+
+// compoundAssign writes x op= v as x = x op v. Luau has compound
+// assignment; for Lua an indexed target whose object or key is not a
+// plain name or constant is evaluated once into temporaries.
+func (t *transpiler) compoundAssign(s *CompoundAssignStmt) {
+	if t.target == TargetLuau {
+		t.expr(s.Target)
+		t.write(" " + binaryOps[s.Op] + "= ")
+		t.expr(s.Value)
+		return
+	}
+	target := s.Target
+	var names []string
+	var values []Expr
+	switch x := target.(type) {
+	case *FieldExpr:
+		if !isSimple(x.X) {
+			obj := t.temp("obj")
+			names, values = append(names, obj), append(values, x.X)
+			target = &FieldExpr{Span: x.Span, X: &Ident{Span: Span{x.X.Pos(), x.X.End()}, Name: obj}, Name: x.Name}
+		}
+	case *IndexExpr:
+		if !isSimple(x.X) || !isSimple(x.Key) {
+			obj, key := t.temp("obj"), t.temp("key")
+			names, values = append(names, obj, key), append(values, x.X, x.Key)
+			target = &IndexExpr{
+				Span: x.Span,
+				X:    &Ident{Span: Span{x.X.Pos(), x.X.End()}, Name: obj},
+				Key:  &Ident{Span: Span{x.Key.Pos(), x.Key.End()}, Name: key},
+			}
+		}
+	}
+	if len(names) > 0 {
+		t.write("do local " + strings.Join(names, ", ") + " = ")
+		t.exprList(values)
+		t.write("; ")
+	}
+	t.expr(target)
+	t.write(" = ")
+	t.expr(&BinaryExpr{Span: s.Span, Op: s.Op, OpPos: s.Pos(), Left: target, Right: s.Value})
+	if len(names) > 0 {
+		t.write(" end")
+	}
+}
+
+// isSimple reports whether evaluating e twice is the same as evaluating
+// it once.
+func isSimple(e Expr) bool {
+	switch e.(type) {
+	case *Ident, *NumberExpr, *StringExpr, *BoolExpr, *NilExpr:
+		return true
+	}
+	return false
+}
+
+// importStmt writes an import as require calls.
+func (t *transpiler) importStmt(s *ImportStmt) {
+	name, err := ResolveImport("", t.source, s.Path.Value)
+	if err != nil {
+		t.errorAt(s.Path.Pos(), "%v", err)
+		return
+	}
+	require := "require(" + quote(name) + ")"
+	switch {
+	case s.Namespace != nil:
+		t.write("local ")
+		t.ident(s.Namespace)
+		t.write(" = " + require)
+	case len(s.Names) == 1:
+		t.write("local ")
+		t.ident(s.Names[0].LocalName())
+		t.write(" = " + require + "." + s.Names[0].Name.Name)
+	default:
+		mod := t.temp(name[strings.LastIndexByte(name, '.')+1:])
+		t.write("local " + mod + " = " + require)
+		t.pending = true
+		t.write("local ")
+		for i, spec := range s.Names {
+			if i > 0 {
+				t.write(", ")
+			}
+			t.ident(spec.LocalName())
+		}
+		t.write(" = ")
+		for i, spec := range s.Names {
+			if i > 0 {
+				t.write(", ")
+			}
+			t.mark(spec.Name.Pos(), spec.Name.Name)
+			t.write(mod + "." + spec.Name.Name)
+		}
+	}
+}
+
+func (t *transpiler) bindings(bs []*Binding) {
+	for i, b := range bs {
+		if i > 0 {
+			t.write(", ")
+		}
+		t.binding(b)
+	}
+}
+
+func (t *transpiler) binding(b *Binding) {
+	t.ident(b.Name)
+	if b.Type != nil && t.target == TargetLuau {
+		t.write(": " + t.typeString(b.Type))
+	}
+}
+
+// funcBody writes the parameters and body of fn; method functions skip
+// their implicit self.
+func (t *transpiler) funcBody(fn *FunctionExpr, method bool) {
+	params := fn.Params
+	if method {
+		params = params[1:]
+	}
+	t.write("(")
+	t.bindings(params)
+	if fn.IsVararg {
+		if len(params) > 0 {
+			t.write(", ")
+		}
+		t.write("...")
+		if fn.VarargType != nil && t.target == TargetLuau {
+			t.write(": " + t.typeString(fn.VarargType))
+		}
+	}
+	t.write(")")
+	if fn.ReturnType != nil && t.target == TargetLuau {
+		t.write(": " + t.typeString(fn.ReturnType))
+	}
+	if len(fn.Body.Stmts) == 0 && !t.hasComments(fn.End().Offset) {
+		t.write(" end")
+		return
+	}
+	saved := t.loops
+	t.loops = nil
+	t.srcLine = fn.Pos().Line
+	t.block(fn.Body, fn.End())
+	t.loops = saved
+	t.write("end")
+}
+
+func (t *transpiler) hasComments(before int) bool {
+	return len(t.comments) > 0 && t.comments[0].Pos.Offset < before
+}
+
+// Expressions
+
+var binaryOps = map[int]string{
+	Or:           "or",
+	And:          "and",
+	Less:         "<",
+	Greater:      ">",
+	LessEqual:    "<=",
+	GreaterEqual: ">=",
+	NotEqual:     "~=",
+	Equal:        "==",
+	Concat:       "..",
+	Plus:         "+",
+	Sub:          "-",
+	Multi:        "*",
+	Div:          "/",
+	Mod:          "%",
+	Po:           "^",
+}
+
+var unaryOps = map[int]string{
+	Not: "not ",
+	Sub: "-",
+	Len: "#",
+}
+
+func (t *transpiler) exprList(exprs []Expr) {
+	for i, e := range exprs {
+		if i > 0 {
+			t.write(", ")
+		}
+		t.expr(e)
+	}
+}
+
+func (t *transpiler) ident(id *Ident) {
+	t.mark(id.Pos(), id.Name)
+	t.write(id.Name)
+}
+
+// operand writes e in parentheses when paren is true.
+func (t *transpiler) operand(e Expr, paren bool) {
+	if paren {
+		t.write("(")
+		t.expr(e)
+		t.write(")")
+		return
+	}
+	t.expr(e)
+}
+
+func (t *transpiler) expr(e Expr) {
+	switch e := e.(type) {
+	case *NilExpr:
+		t.write("nil")
+	case *BoolExpr:
+		t.write(strconv.FormatBool(e.Value))
+	case *NumberExpr:
+		t.write(luaNumber(e.Value))
+	case *StringExpr:
+		t.write(quote(e.Value))
+	case *VarargExpr:
+		t.write("...")
+	case *Ident:
+		t.ident(e)
+	case *FunctionExpr:
+		t.mark(e.Pos(), "")
+		t.write("function")
+		t.funcBody(e, false)
+	case *TableExpr:
+		t.table(e)
+	case *BinaryExpr:
+		prio := binaryPriority[e.Op]
+		switch x := e.Left.(type) {
+		case *BinaryExpr:
+			t.operand(x, prio[0] > binaryPriority[x.Op][1])
+		case *UnaryExpr:
+			t.operand(x, prio[0] > unaryPriority)
+		default:
+			t.expr(x)
+		}
+		t.write(" ")
+		t.mark(e.OpPos, "")
+		t.write(binaryOps[e.Op] + " ")
+		x, ok := e.Right.(*BinaryExpr)
+		t.operand(e.Right, ok && binaryPriority[x.Op][0] <= prio[1])
+	case *UnaryExpr:
+		t.mark(e.Pos(), "")
+		t.write(unaryOps[e.Op])
+		if u, ok := e.X.(*UnaryExpr); ok && e.Op == Sub && u.Op == Sub {
+			t.write(" ") // not a comment
+		}
+		x, ok := e.X.(*BinaryExpr)
+		t.operand(e.X, ok && binaryPriority[x.Op][0] <= unaryPriority)
+	case *ParenExpr:
+		t.write("(")
+		t.expr(e.X)
+		t.write(")")
+	case *FieldExpr:
+		t.expr(e.X)
+		t.write(".")
+		t.ident(e.Name)
+	case *IndexExpr:
+		t.expr(e.X)
+		t.mark(e.Key.Pos(), "")
+		t.write("[")
+		t.expr(e.Key)
+		t.write("]")
+	case *CallExpr:
+		t.expr(e.Fn)
+		t.mark(e.Pos(), "")
+		t.args(e.Args)
+	case *MethodCallExpr:
+		t.expr(e.Recv)
+		t.write(":")
+		t.ident(e.Name)
+		t.args(e.Args)
+	}
+}
+
+func (t *transpiler) args(args []Expr) {
+	t.write("(")
+	t.exprList(args)
+	t.write(")")
+}
+
+// table writes a table constructor, one field per line when the source
+// spreads it over several lines.
+func (t *transpiler) table(e *TableExpr) {
+	t.mark(e.Pos(), "")
+	multiline := len(e.Fields) > 0 && e.Pos().Line != e.End().Line
+	t.write("{")
+	if multiline {
+		t.indent++
+	}
+	for i, f := range e.Fields {
+		if multiline {
+			t.pending = true
+		} else if i > 0 {
+			t.write(", ")
+		}
+		switch {
+		case f.Named:
+			t.ident(f.Key.(*Ident))
+			t.write(" = ")
+		case f.Key != nil:
+			t.write("[")
+			t.expr(f.Key)
+			t.write("] = ")
+		}
+		t.expr(f.Value)
+		if multiline {
+			t.write(",")
+		}
+	}
+	if multiline {
+		t.indent--
+		t.pending = true
+	}
+	t.write("}")
+}
+
+// luaNumber renders a number so that every target reads back the same
+// value; floats keep a decimal point so Lua 5.4 reads them as floats.
+func luaNumber(v any) string {
+	switch v := v.(type) {
+	case int64:
+		if v == math.MinInt64 {
+			return "(-9223372036854775807 - 1)"
+		}
+		return strconv.FormatInt(v, 10)
+	case float64:
+		switch {
+		case math.IsInf(v, 1):
+			return "math.huge"
+		case math.IsInf(v, -1):
+			return "-math.huge"
+		case math.IsNaN(v):
+			return "(0/0)"
+		}
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s
+	}
+	return "nil"
+}
+
+// quote renders s as a double-quoted string using only the escapes
+// Lua 5.1 knows.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if c < ' ' || c == 0x7f {
+				fmt.Fprintf(&b, "\\%03d", c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Types, for Luau
+
+var luauTypeNames = map[string]string{
+	"int":   "number",
+	"float": "number",
+	"bool":  "boolean",
+}
+
+func (t *transpiler) typeString(typ TypeExpr) string {
+	switch typ := typ.(type) {
+	case *NamedType:
+		name := typ.Name
+		if n, ok := luauTypeNames[name]; ok {
+			name = n
+		}
+		if len(typ.Args) == 0 {
+			return name
+		}
+		return name + "<" + t.typeList(typ.Args, false) + ">"
+	case *OptionalType:
+		if _, ok := typ.Inner.(*FunctionType); ok {
+			return "(" + t.typeString(typ.Inner) + ")?"
+		}
+		return t.typeString(typ.Inner) + "?"
+	case *FunctionType:
+		return "(" + t.typeList(typ.Params, typ.Variadic) + ") -> " + t.typeString(typ.Return)
+	case *TupleType:
+		return "(" + t.typeList(typ.Types, typ.Variadic) + ")"
+	case *TableType:
+		fields := make([]string, len(typ.Fields))
+		for i, f := range typ.Fields {
+			switch {
+			case f.Name != nil:
+				fields[i] = f.Name.Name + ": " + t.typeString(f.Value)
+			case f.Key != nil:
+				fields[i] = "[" + t.typeString(f.Key) + "]: " + t.typeString(f.Value)
+			default:
+				fields[i] = t.typeString(f.Value)
+			}
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	}
+	return "any"
+}
+
+// typeList joins types; with variadic the last one is written ...T.
+func (t *transpiler) typeList(types []TypeExpr, variadic bool) string {
+	s := make([]string, len(types))
+	for i, typ := range types {
+		s[i] = t.typeString(typ)
+	}
+	if variadic && len(s) > 0 {
+		s[len(s)-1] = "..." + s[len(s)-1]
+	}
+	return strings.Join(s, ", ")
+}
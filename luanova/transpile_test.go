@@ -0,0 +1,333 @@
+package luanova
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+var targets = []Target{TargetLua51, TargetLua54, TargetLuau}
+
+// transpile parses src as chunk name and transpiles it for target.
+func transpile(t *testing.T, name, src string, target Target) string {
+	t.Helper()
+	chunk, err := Parse(name, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := Transpile(chunk, target)
+	if err != nil {
+		t.Fatalf("%s: %v", target, err)
+	}
+	return out.Code
+}
+
+// runLua51 runs code under gopher-lua, an independent Lua 5.1, and
+// returns what it printed. Numbers are printed with the "%.14g" of
+// Lua 5.1 rather than the formatting of gopher-lua.
+func runLua51(t *testing.T, code string) string {
+	t.Helper()
+	L := lua.NewState()
+	defer L.Close()
+	var out strings.Builder
+	L.SetGlobal("print", L.NewFunction(func(L *lua.LState) int {
+		for i := 1; i <= L.GetTop(); i++ {
+			if i > 1 {
+				out.WriteByte('\t')
+			}
+			v := L.Get(i)
+			if n, ok := v.(lua.LNumber); ok {
+				out.WriteString(lua51Number(float64(n)))
+			} else {
+				out.WriteString(L.ToStringMeta(v).String())
+			}
+		}
+		out.WriteByte('\n')
+		return 0
+	}))
+	if err := L.DoString(code); err != nil {
+		t.Fatalf("lua 5.1: %v\n%s", err, code)
+	}
+	return out.String()
+}
+
+func lua51Number(n float64) string {
+	switch {
+	case math.IsInf(n, 1):
+		return "inf"
+	case math.IsInf(n, -1):
+		return "-inf"
+	case math.IsNaN(n):
+		return "nan"
+	}
+	return fmt.Sprintf("%.14g", n)
+}
+
+// checkPlainLua fails if code uses syntax Lua 5.1 does not have.
+func checkPlainLua(t *testing.T, code string) {
+	t.Helper()
+	chunk, err := Parse("out", code)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, code)
+	}
+	Inspect(chunk.Block, func(n Node) bool {
+		switch n := n.(type) {
+		case *CompoundAssignStmt, *ContinueStmt, *TypeStmt, *ImportStmt, *ExportStmt, TypeExpr:
+			t.Errorf("%s: %T left in output:\n%s", n.Pos(), n, code)
+		case *FunctionExpr:
+			if n.ReturnType != nil {
+				t.Errorf("%s: return type left in output", n.Pos())
+			}
+		}
+		return true
+	})
+}
+
+func TestTranspileRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		lua51 string // the output under Lua 5.1 when it differs
+	}{
+		{"compound assignment", `
+local x, s, t = 10, "a", {n = 1, list = {5}}
+x += 2; x -= 1; x *= 3; x /= 2; x %= 7; s ..= "b" .. "c"
+t.n += 1 + 1; t.list[1] *= 2 + 1
+local calls = 0
+local function key() calls += 1; return 1 end
+t.list[key()] += 1
+local function get() calls += 1; return t end
+get().n ..= "!"
+print(x, s, t.n, t.list[1], calls)`, ""},
+		{"continue", `
+local out = {}
+for i = 1, 10 do
+  if i % 2 == 0 then continue end
+  if i > 7 then break end
+  out[#out + 1] = i
+end
+local i = 0
+while i < 6 do
+  i += 1
+  for j = 1, 3 do
+    if j == 2 then continue end
+    out[#out + 1] = i * 10 + j
+  end
+  if i == 2 then continue end
+  local f = function() for k = 1, 2 do if k == 1 then continue end return k end end
+  out[#out + 1] = f()
+end
+local n = 0
+repeat
+  n += 1
+  if n == 2 then continue end
+  out[#out + 1] = -n
+until n >= 4
+for _, v in ipairs({1, 2, 3}) do
+  if v == 2 then continue elseif v == 3 then break end
+  out[#out + 1] = v
+end
+print(table.concat(out, " "))`, ""},
+		{"types", `
+type Pair = {first: int, second: string?}
+local function pair(a: int, b: string?): Pair
+  return {first = a, second = b}
+end
+local p: Pair = pair(1, nil)
+local f: (int) -> int = function(x: int): int return x + 1 end
+print(p.first, p.second, f(1))`, ""},
+		{"literals", `
+print("tab\tquote\"back\\slash\0nul\127", "\u{48}\x49\z
+      J", 0x10, 1e3, 2^53, 1.5, 3.0, 1/0, -1/0, math.maxinteger, -(2^63))`,
+			"tab\tquote\"back\\slash\x00nul\x7f\tHIJ\t16\t1000\t9.007199254741e+15\t1.5\t3\tinf\t-inf\tnil\t-9.2233720368548e+18\n"},
+		{"precedence", `
+local a, b, c = 2, 3, 4
+print(a + b * c, (a + b) * c, a - (b - c), a - b - c, -a ^ 2, (-a) ^ 2, 2 ^ 3 ^ 2, (2 ^ 3) ^ 2,
+  not (a == b), #"abc" + 1, - -a, "x" .. 1 .. 2, a < b and b < c or false)`,
+			"14\t20\t3\t-5\t-4\t4\t512\t64\ttrue\t4\t2\tx12\ttrue\n"},
+		{"functions and methods", `
+local obj = {n = 0}
+function obj:add(k) self.n += k; return self end
+function obj.twice(x) return 2 * x end
+local function va(...) return select("#", ...), ... end
+obj:add(2):add(3)
+;(print)(obj.n, obj.twice(4), va(1, nil, 3))`, ""},
+		{"comments", `
+-- leading
+local x = 1 -- trailing
+-* block ]] with brackets *-
+--[[ not a long comment in LuaNova
+print(x) --[==[ nor this
+`, ""},
+	}
+
+	for _, tt := range tests {
+		expected := runScript(t, tt.input)
+		for _, target := range targets {
+			code := transpile(t, "test", tt.input, target)
+			if target != TargetLuau {
+				checkPlainLua(t, code)
+			}
+			if got := runScript(t, code); got != expected {
+				t.Errorf("%s, %s: got %q, expected %q\n%s", tt.name, target, got, expected, code)
+			}
+			if target == TargetLua51 {
+				want := expected
+				if tt.lua51 != "" {
+					want = tt.lua51
+				}
+				if got := runLua51(t, code); got != want {
+					t.Errorf("%s, under Lua 5.1: got %q, expected %q\n%s", tt.name, got, want, code)
+				}
+			}
+		}
+	}
+}
+
+func TestTranspileModules(t *testing.T) {
+	sources := map[string]string{
+		"app/main.lunv": `
+import { double, version as v } from "./lib/math"
+import * as util from "../shared/util"
+import { name } from "../shared/util"
+print(double(21), v, util.name, name)`,
+		"app/lib/math.lunv": `
+export function double(x: number): number return 2 * x end
+export local version = "1.0"
+export type Num = number
+local answer = 42
+export { answer as theAnswer }
+version = "1.1"`,
+		"shared/util.lunv": `export local name = "util"`,
+	}
+	fsys := fstest.MapFS{}
+	for name, src := range sources {
+		fsys[name] = &fstest.MapFile{Data: []byte(src)}
+	}
+	s := NewState()
+	s.SetFS(fsys)
+	expected := runModules(t, s, `require("app.main")`)
+
+	// Luau keeps export type, which LuaNova would read as a module
+	// declaration again
+	for _, target := range []Target{TargetLua51, TargetLua54} {
+		out := fstest.MapFS{}
+		for name, src := range sources {
+			code := transpile(t, name, src, target)
+			checkPlainLua(t, code)
+			out[strings.TrimSuffix(name, ".lunv")+".lua"] = &fstest.MapFile{Data: []byte(code)}
+		}
+		s := NewState()
+		s.SetFS(out)
+		s.SetPath("?.lua")
+		if got := runModules(t, s, `require("app.main")`); got != expected {
+			t.Errorf("%s: got %q, expected %q", target, got, expected)
+		}
+		rs, err := s.Call("require", "app.lib.math")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mod := rs[0].(*Table); mod.GetString("theAnswer") != int64(42) || mod.GetString("answer") != nil {
+			t.Errorf("%s: wrong exports", target)
+		}
+	}
+}
+
+func TestTranspileOutput(t *testing.T) {
+	input := `local total: number = 0
+for i = 1, 10 do
+  if i % 2 == 0 then continue end -- odd only
+  total += i
+end
+
+print(total)
+`
+	expected := map[Target]string{
+		TargetLua51: `local total = 0
+for i = 1, 10 do
+	repeat
+		if i % 2 == 0 then
+			break -- odd only
+		end
+		total = total + i
+	until true
+end
+
+print(total)
+`,
+		TargetLuau: `local total: number = 0
+for i = 1, 10 do
+	if i % 2 == 0 then
+		continue -- odd only
+	end
+	total += i
+end
+
+print(total)
+`,
+	}
+	for target, want := range expected {
+		if got := transpile(t, "test", input, target); got != want {
+			t.Errorf("%s: got:\n%s\nexpected:\n%s", target, got, want)
+		}
+	}
+}
+
+// TestTranspileLua51 checks the forms the lua51 output relies on, since
+// the round trips run it with the Lua 5.4 semantics of the interpreter.
+func TestTranspileLua51(t *testing.T) {
+	input := "local a, b, c = 3.0, 10 / 2, 2^53\n" +
+		"local max, min = 9223372036854775807, -9223372036854775807 - 1\n" +
+		"print(a, b, c, max, min, 1e300 * 1e10, math.maxinteger, \"\\u{48}\\x49\\z\n    J\\0\")\n"
+	expected := "local a, b, c = 3.0, 10 / 2, 2 ^ 53\n" +
+		"local max, min = 9223372036854775807, -9223372036854775807 - 1\n" +
+		"print(a, b, c, max, min, 1e+300 * 1e+10, math.maxinteger, \"HIJ\\000\")\n"
+	code := transpile(t, "test", input, TargetLua51)
+	if code != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", code, expected)
+	}
+	want := "3\t5\t9.007199254741e+15\t9.2233720368548e+18\t-9.2233720368548e+18\tinf\tnil\tHIJ\x00\n"
+	if got := runLua51(t, code); got != want {
+		t.Errorf("under Lua 5.1: got %q, expected %q", got, want)
+	}
+	for _, v := range []struct {
+		value any
+		lua   string
+	}{
+		{3.0, "3.0"},
+		{1e100, "1e+100"},
+		{int64(math.MinInt64), "(-9223372036854775807 - 1)"},
+		{math.Inf(1), "math.huge"},
+		{math.NaN(), "(0/0)"},
+	} {
+		if got := luaNumber(v.value); got != v.lua {
+			t.Errorf("luaNumber(%v) = %s, expected %s", v.value, got, v.lua)
+		}
+	}
+}
+
+func TestTranspileErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`repeat local done = true if x then continue end until done`,
+			"test:1:55: cannot lower continue: the until condition uses local 'done' of the loop body"},
+		{`if x then continue end`, "test:1:11: continue outside a loop"},
+		{`import { a } from "../up"`, "test:1:19: import path '../up' is outside the module root"},
+	}
+	for _, tt := range tests {
+		chunk, err := Parse("test", tt.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Transpile(chunk, TargetLua51)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: got %v, expected %q", tt.input, err, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package luanova
+
+import (
+	"math"
+	"strconv"
+)
+
+// Values are represented by Go values: nil, bool, int64, float64, string,
+// *Table, *Closure and *Userdata.
+
+// GoFunction is a function implemented in Go. It reads its arguments
+// from the stack of s and returns the number of results it pushed.
+type GoFunction func(s *State) int
+
+// Closure is a Lua function or a Go function.
+type Closure struct {
+	proto  *funcProto
+	upvals []*cell
+	gofn   GoFunction
+	name   string
+	env    *Table
+}
+
+// Name returns the name the function was declared with, if any.
+func (f *Closure) Name() string {
+	if f.proto != nil {
+		return f.proto.name
+	}
+	return f.name
+}
+
+// Userdata wraps an arbitrary Go value.
+type Userdata struct {
+	Value any
+	Meta  *Table
+}
+
+// cell holds a local variable captured by a closure.
+type cell struct {
+	v any
+}
+
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	return true
+}
+
+// toNumber converts v to a number following Lua's coercion rules.
+func toNumber(v any) (any, bool) {
+	switch v := v.(type) {
+	case int64, float64:
+		return v, true
+	case string:
+		return parseNumber(trimSpace(v))
+	}
+	return nil, false
+}
+
+func toFloat(v any) (float64, bool) {
+	n, ok := toNumber(v)
+	if !ok {
+		return 0, false
+	}
+	switch n := n.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// toInteger converts v to an integer if it has an exact representation.
+func toInteger(v any) (int64, bool) {
+	n, ok := toNumber(v)
+	if !ok {
+		return 0, false
+	}
+	switch n := n.(type) {
+	case int64:
+		return n, true
+	case float64:
+		return floatToInteger(n)
+	}
+	return 0, false
+}
+
+func trimSpace(s string) string {
+	i, j := 0, len(s)
+	for i < j && isspace(s[i]) {
+		i++
+	}
+	for j > i && isspace(s[j-1]) {
+		j--
+	}
+	return s[i:j]
+}
+
+// toStringCoerce converts numbers to strings for concatenation and the
+// string library.
+func toStringCoerce(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		return numberToString(v), true
+	}
+	return "", false
+}
+
+// normKey converts float keys with an integer value to integers, so
+// t[1] and t[1.0] are the same entry.
+func normKey(k any) any {
+	if f, ok := k.(float64); ok {
+		if i, ok := floatToInteger(f); ok {
+			return i
+		}
+	}
+	return k
+}
+
+func isNaN(v any) bool {
+	f, ok := v.(float64)
+	return ok && math.IsNaN(f)
+}
+
+func rawEqual(a, b any) bool {
+	switch a := a.(type) {
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return a == b
+		case float64:
+			return float64(a) == b && b == math.Trunc(b) && !(b >= 0x1p63 || b < -0x1p63)
+		}
+		return false
+	case float64:
+		switch b := b.(type) {
+		case float64:
+			return a == b
+		case int64:
+			return rawEqual(b, a)
+		}
+		return false
+	}
+	return a == b
+}
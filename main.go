@@ -1,5 +1,72 @@
+// Command luanova is the LuaNova toolchain.
+//
+// Usage:
+//
+//	luanova build [--target lua51|lua54|luau] [-o dir] [--no-map] files or directories...
+//	luanova trace [files...]
+//	luanova fmt [-w] [-l] [--check] [--range from:to] [files or directories...]
+//	luanova lint [--fix] [--severity rule=level] [--rules] [--format text|color|json|sarif] files or directories...
+//	luanova dap [--listen address]
+//	luanova lsp [--listen address]
+//	luanova run [--cpuprofile file] [--memprofile file] [--instrument] script [arguments...]
+//	luanova test [-v] [--run regexp] [--timeout d] [--junit file] [--cover] [--coverprofile file] [--coverformat text|html|lcov] [files or directories...]
 package main
 
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const usage = `usage: luanova <command> [arguments]
+
+commands:
+	build    transpile .lunv files to plain Lua
+	trace    rewrite positions in generated Lua files to .lunv positions
+	fmt      format .lunv files in the canonical style
+	lint     report suspicious code in .lunv files
+	dap      run a debug adapter for editors
+	lsp      run a language server for editors
+	run      run a .lunv script, optionally profiling it
+	test     run the tests of *_test.lunv files
+`
+
 func main() {
-	
-}
\ No newline at end of file
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "build":
+		err = runBuild(args)
+	case "trace":
+		err = runTrace(args)
+	case "fmt":
+		err = runFmt(args)
+	case "lint":
+		err = runLint(args)
+	case "dap":
+		err = runDap(args)
+	case "lsp":
+		err = runLsp(args)
+	case "run":
+		err = runRun(args)
+	case "test":
+		err = runTest(args)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "luanova: unknown command %q\n%s", cmd, usage)
+		os.Exit(2)
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
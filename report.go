@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Herograme/LuaNova/luanova"
+	"github.com/Herograme/LuaNova/luanova/diag"
+)
+
+// printError writes err to standard error. Syntax errors are shown with
+// the source lines they point at, read from their files under dir.
+func printError(err error, dir string) {
+	var diags []diag.Diagnostic
+	var list luanova.ErrorList
+	var syntax *luanova.SyntaxError
+	switch {
+	case errors.As(err, &list):
+		diags = list.Diagnostics()
+	case errors.As(err, &syntax):
+		diags = []diag.Diagnostic{syntax.Diagnostic()}
+	default:
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	p := &diag.Printer{Color: isTerminal(os.Stderr), Source: readSource(dir)}
+	p.FprintAll(os.Stderr, diags)
+}
+
+// readSource returns a function reading sources from files under dir.
+func readSource(dir string) func(name string) []byte {
+	return func(name string) []byte {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return nil
+		}
+		return data
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
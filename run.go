@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/Herograme/LuaNova/luanova"
+)
+
+// runRun implements `luanova run`: it runs a script with the remaining
+// arguments in the global table arg, arg[0] being the script, and
+// optionally profiles it.
+func runRun(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	cpuProfile := flags.String("cpuprofile", "", "write a pprof time profile of the script to `file`")
+	memProfile := flags.String("memprofile", "", "write a pprof allocation profile of the script to `file`")
+	instrument := flags.Bool("instrument", false, "measure every call and statement instead of sampling")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("luanova run: no script given")
+	}
+	path := flags.Arg(0)
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	s := luanova.NewState()
+	s.SetDir(filepath.Dir(path))
+	argv := luanova.NewTable()
+	for i, a := range flags.Args() {
+		argv.Set(int64(i), a)
+	}
+	s.SetGlobal("arg", argv)
+	fn, err := s.Load(string(src), path)
+	if err != nil {
+		printError(err, ".")
+		return errors.New("luanova run: failed")
+	}
+
+	var p *luanova.Profiler
+	if *cpuProfile != "" || *memProfile != "" {
+		p = s.StartProfiler(luanova.ProfileOptions{Instrument: *instrument})
+	}
+	_, runErr := s.PCall(fn)
+	if p != nil {
+		p.Stop()
+		if err := writeProfile(*cpuProfile, p.WriteCPUProfile); err != nil {
+			return err
+		}
+		if err := writeProfile(*memProfile, p.WriteMemProfile); err != nil {
+			return err
+		}
+	}
+	var lerr *luanova.Error
+	if errors.As(runErr, &lerr) {
+		return fmt.Errorf("%v\n%s", lerr, lerr.Traceback())
+	}
+	return runErr
+}
+
+func writeProfile(path string, write func(w io.Writer) error) error {
+	if path == "" {
+		return nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
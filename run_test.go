@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunImports(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"proj/main.lunv":      `import { greet } from "./lib/greet" assert(greet() == "hello world")`,
+		"proj/lib/greet.lunv": `import { name } from "../name" export function greet() return "hello " .. name end`,
+		"proj/name.lunv":      `export local name = "world"`,
+	}
+	for name, src := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	for _, path := range []string{"proj/main.lunv", filepath.Join(dir, "proj", "main.lunv")} {
+		if err := runRun([]string{path}); err != nil {
+			t.Errorf("run %s: %v", path, err)
+		}
+	}
+}
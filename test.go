@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Herograme/LuaNova/luanova"
+)
+
+// runTest implements `luanova test`: it runs the tests of the
+// *_test.lunv files given or found in the directories given, the current
+// one by default, and reports them in the format of go test. As with
+// build, files found in a directory are named relative to it, which is
+// the module root their requires and imports resolve from. --junit also
+// writes the results as JUnit XML.
+//
+// With --cover the statements and branches of the other chunks the tests
+// load are counted, and a summary is printed; --coverprofile writes a
+// report as text, HTML or LCOV, chosen by --coverformat or else by the
+// extension of the file (.html, .info or .lcov).
+func runTest(args []string) error {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	verbose := flags.Bool("v", false, "list every test and its output")
+	run := flags.String("run", "", "run only the tests and subtests matching `regexp`, one per level separated by slashes")
+	timeout := flags.Duration("timeout", 10*time.Minute, "fail a test running longer than `d`, 0 for no limit")
+	junit := flags.String("junit", "", "write the results as JUnit XML to `file`")
+	cover := flags.Bool("cover", false, "report the coverage of the code the tests run")
+	coverProfile := flags.String("coverprofile", "", "write a coverage report to `file`; implies --cover")
+	coverFormat := flags.String("coverformat", "", "format of the coverage report: text, html or lcov")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	write, err := coverWriter(*coverFormat, *coverProfile)
+	if err != nil {
+		return err
+	}
+	roots := flags.Args()
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+
+	c := &testConfig{opts: luanova.TestOptions{Run: *run, Timeout: *timeout}, verbose: *verbose}
+	if *cover || *coverProfile != "" {
+		c.coverage = luanova.NewCoverage()
+		c.coverage.Include = func(name string) bool {
+			return !strings.HasPrefix(name, "[") && !strings.HasSuffix(name, "_test.lunv")
+		}
+	}
+	var suites []luanova.TestSuite
+	var failed bool
+	for _, arg := range roots {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			suite, ok := c.testFile(".", filepath.ToSlash(filepath.Clean(arg)))
+			suites = append(suites, suite)
+			failed = failed || !ok
+			continue
+		}
+		err = fs.WalkDir(os.DirFS(arg), ".", func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.HasSuffix(name, "_test.lunv") {
+				return err
+			}
+			suite, ok := c.testFile(arg, name)
+			suites = append(suites, suite)
+			failed = failed || !ok
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if *junit != "" {
+		err := writeProfile(*junit, func(w io.Writer) error { return luanova.WriteJUnit(w, suites) })
+		if err != nil {
+			return err
+		}
+	}
+	if c.coverage != nil {
+		if err := c.coverage.WriteText(os.Stdout); err != nil {
+			return err
+		}
+		if *coverProfile != "" {
+			err := writeProfile(*coverProfile, func(w io.Writer) error { return write(c.coverage, w) })
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if failed {
+		return errors.New("luanova test: failed")
+	}
+	return nil
+}
+
+// coverWriter returns the writer of the coverage report format, given
+// or guessed from the report file name.
+func coverWriter(format, path string) (func(*luanova.Coverage, io.Writer) error, error) {
+	if format == "" {
+		switch filepath.Ext(path) {
+		case ".html", ".htm":
+			format = "html"
+		case ".info", ".lcov":
+			format = "lcov"
+		default:
+			format = "text"
+		}
+	}
+	switch format {
+	case "text":
+		return (*luanova.Coverage).WriteText, nil
+	case "html":
+		return (*luanova.Coverage).WriteHTML, nil
+	case "lcov":
+		return (*luanova.Coverage).WriteLCOV, nil
+	}
+	return nil, fmt.Errorf("luanova test: unknown coverage format %q", format)
+}
+
+type testConfig struct {
+	opts     luanova.TestOptions
+	verbose  bool
+	coverage *luanova.Coverage
+}
+
+// testFile runs the tests of the file name under root and reports them
+// like go test reports a package. ok is false if a test failed or the
+// file could not run.
+func (c *testConfig) testFile(root, name string) (suite luanova.TestSuite, ok bool) {
+	start := time.Now()
+	results, err := c.runTestFile(root, name)
+	suite = luanova.TestSuite{Name: name, Duration: time.Since(start), Results: results, Err: err}
+	elapsed := suite.Duration.Seconds()
+	if err != nil {
+		fmt.Printf("# %s\n", name)
+		var lerr *luanova.Error
+		if errors.As(err, &lerr) {
+			fmt.Printf("%v\n%s\n", lerr, lerr.Traceback())
+		} else {
+			printError(err, root)
+		}
+		fmt.Printf("FAIL\t%s\t[setup failed]\n", name)
+		return suite, false
+	}
+	luanova.WriteTestResults(os.Stdout, results, c.verbose)
+	for _, r := range results {
+		if r.Status == luanova.TestFail {
+			fmt.Printf("FAIL\nFAIL\t%s\t%.3fs\n", name, elapsed)
+			return suite, false
+		}
+	}
+	if c.verbose {
+		fmt.Println("PASS")
+	}
+	if len(results) == 0 {
+		fmt.Printf("ok  \t%s\t%.3fs [no tests to run]\n", name, elapsed)
+	} else {
+		fmt.Printf("ok  \t%s\t%.3fs\n", name, elapsed)
+	}
+	return suite, true
+}
+
+func (c *testConfig) runTestFile(root, name string) ([]*luanova.TestResult, error) {
+	src, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, err
+	}
+	s := luanova.NewState()
+	s.SetFS(os.DirFS(root))
+	s.SetCoverage(c.coverage)
+	fn, err := s.Load(string(src), name)
+	if err != nil {
+		return nil, err
+	}
+	return s.RunTests(fn, c.opts)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/Herograme/LuaNova/luanova"
+)
+
+// runTrace implements `luanova trace`: it copies the files, or standard
+// input, to standard output with the positions in generated files, as
+// found in error messages and tracebacks, mapped back to the sources.
+// The source map of out.lua is read from out.lua.map.
+func runTrace(args []string) error {
+	flags := flag.NewFlagSet("trace", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	r := &luanova.Rewriter{Find: findSourceMap}
+	if flags.NArg() == 0 {
+		return rewriteLines(r, os.Stdin)
+	}
+	for _, name := range flags.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		err = rewriteLines(r, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rewriteLines(r *luanova.Rewriter, in io.Reader) error {
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	sc := bufio.NewScanner(in)
+	for sc.Scan() {
+		w.WriteString(r.Rewrite(sc.Text()))
+		w.WriteByte('\n')
+	}
+	return sc.Err()
+}
+
+// findSourceMap reads the map written by luanova build next to file.
+func findSourceMap(file string) *luanova.SourceMap {
+	data, err := os.ReadFile(filepath.FromSlash(file) + ".map")
+	if err != nil {
+		return nil
+	}
+	m, err := luanova.ParseSourceMap(data)
+	if err != nil {
+		return nil
+	}
+	return m
+}